@@ -0,0 +1,96 @@
+// allow.go - targets of intentionally dead links
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go-progs/internal/vfs"
+)
+
+// allowList holds glob patterns of link targets that are known to be
+// dead and must not be reported.
+type allowList struct {
+	pats []string
+}
+
+// newAllowList builds the list from the patterns in 'globs' and those
+// in file 'fn' (one per line; blank lines and '#' comments are
+// ignored).
+func newAllowList(globs []string, fn string) (*allowList, error) {
+	a := &allowList{
+		pats: make([]string, 0, len(globs)),
+	}
+
+	for _, p := range globs {
+		if err := a.add(p); err != nil {
+			return nil, fmt.Errorf("allow-target: %w", err)
+		}
+	}
+
+	if len(fn) == 0 {
+		return a, nil
+	}
+
+	fd, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	rd := bufio.NewScanner(fd)
+	for n := 1; rd.Scan(); n++ {
+		p := strings.TrimSpace(rd.Text())
+		if len(p) == 0 || p[0] == '#' {
+			continue
+		}
+		if err := a.add(p); err != nil {
+			return nil, fmt.Errorf("%s: %d: %w", fn, n, err)
+		}
+	}
+
+	if err := rd.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	return a, nil
+}
+
+func (a *allowList) add(p string) error {
+	if _, err := filepath.Match(p, ""); err != nil {
+		return fmt.Errorf("'%s': %w", p, err)
+	}
+	a.pats = append(a.pats, p)
+	return nil
+}
+
+// allowed returns true if the target of 'r' matches one of the
+// patterns. Relative targets are also tried in their resolved form.
+func (a *allowList) allowed(r Result) bool {
+	if len(a.pats) == 0 {
+		return false
+	}
+
+	// the targets of links in URLs are matched as they're stored
+	names := []string{r.Target}
+	if !r.Abs && !vfs.IsURL(r.Link) {
+		if dir, err := filepath.Abs(filepath.Dir(r.Link)); err == nil {
+			names = append(names, filepath.Join(dir, r.Target))
+		}
+	}
+
+	for _, p := range a.pats {
+		for _, nm := range names {
+			if ok, _ := filepath.Match(p, nm); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -6,12 +6,22 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
+	"time"
+
+	"go-progs/internal/glob"
+	"go-progs/internal/report"
 
 	"github.com/opencoff/go-fio"
 	"github.com/opencoff/go-fio/walk"
@@ -23,25 +33,135 @@ var Z string = path.Base(os.Args[0])
 type Result struct {
 	Link   string
 	Target string
+
+	// true if the target is an absolute path
+	Abs bool
+}
+
+// kind returns "abs" or "rel" for the target of 'r'
+func (r *Result) kind() string {
+	if r.Abs {
+		return "abs"
+	}
+	return "rel"
 }
 
 func main() {
 	var version, zero, showTarget bool
+	var classify, dryRun bool
+	var prefixes, search []string
+	var match string
+	var fromFile string
+	var jobs int
+	var allowTargets []string
+	var allowFrom string
 	var ignores []string = []string{".git", ".hg"}
+	var includes []string
+	var fold, jsonErrs bool
+	var byTarget, summarize bool
+	var format string
+	var watch bool
+	var every time.Duration
 
 	flag.BoolVarP(&version, "version", "", false, "Show version info and quit")
 	flag.BoolVarP(&zero, "null", "0", false, "use \\0 as the output 'line separator'")
 	flag.BoolVarP(&showTarget, "show-dead-target", "t", false, "Show dead symlink target")
-	flag.StringSliceVarP(&ignores, "ignore", "i", ignores, "Ignore names that match these patterns")
+	flag.StringSliceVarP(&ignores, "ignore", "i", ignores, "Ignore entries that match these globs")
+	flag.StringSliceVarP(&includes, "include", "", nil, "Re-include entries matching glob `G` that were ignored")
+	flag.BoolVarP(&fold, "ignore-case", "", false, "Match --ignore and --include globs ignoring case")
+	flag.BoolVarP(&classify, "classify", "c", false, "Mark each dead link as 'abs' or 'rel'ative")
+	flag.StringArrayVarP(&prefixes, "rewrite-prefix", "", nil, "Retarget dead links from prefix `OLD=NEW`")
+	flag.StringArrayVarP(&search, "relink-search", "", nil, "Retarget dead links to a file of the same name under dir `D`")
+	flag.StringVarP(&match, "relink-match", "", matchName, "Choose among equally close --relink-search candidates by `M` (name, size, hash)")
+	flag.BoolVarP(&dryRun, "dry-run", "n", false, "Show what --rewrite-prefix and --relink-search would do")
+	flag.StringVarP(&fromFile, "from-file", "f", "", "Check the symlinks listed in file `F` ('-' for stdin)")
+	flag.StringArrayVarP(&allowTargets, "allow-target", "", nil, "Don't report dead links whose target matches glob `G`")
+	flag.StringVarP(&allowFrom, "allow-from", "", "", "Read --allow-target globs from file `F`")
+	flag.IntVarP(&jobs, "jobs", "j", runtime.NumCPU(), "Resolve up to `N` symlinks in parallel")
+	flag.BoolVarP(&byTarget, "group-by-target", "g", false, "Group dead links by their missing target")
+	flag.BoolVarP(&summarize, "summary", "", false, "Also count the dead links by where their targets are")
+	flag.StringVarP(&format, "format", "", "text", "Write the dead links in format `F` (text, csv)")
+	flag.BoolVarP(&watch, "watch", "w", false, "Keep watching the dirs and report links that die or come back to life")
+	flag.DurationVarP(&every, "watch-interval", "", 30*time.Second, "With --watch, also rescan every `T`")
+	flag.BoolVarP(&jsonErrs, "json-errors", "", false, "Write errors to stderr as JSON records")
 
 	flag.Usage = func() {
 		fmt.Printf(
 			`%s - find dead symlinks in one or more dir trees
 
-Usage: %s [options] dir [dir...]
+Usage: %s [options] [dir|symlink...]
+
+The --ignore and --include globs follow gitignore(5): a glob without a
+'/' matches names at any depth, others are anchored to the dir they're
+under; '**' matches any number of dirs. The last matching glob wins.
+
+Each dir is walked recursively; symlinks named on the command line or
+in --from-file are checked directly. Names in --from-file are one per
+line (NUL separated with -0), e.g. the output of find(1).
+
+With --rewrite-prefix, dead links whose target (resolved relative to
+the link's dir) starts with OLD are retargeted to NEW - provided the
+new target exists. Relative links stay relative.
+
+With --relink-search, the dead links that --rewrite-prefix didn't fix
+are retargeted to the file (or dir) with the name of their target in
+one of the dirs D; e.g. after a tree was moved one level up. If there
+are several such files, the one closest to the old target (the longest
+common dir) is chosen. The dead links with more than one equally close
+candidate are reported and left alone - unless --relink-match is 'size'
+or 'hash' and the candidates all have the same size or content. D is
+indexed once before the links are checked.
+
+Links that are dead on purpose (e.g. placeholders for /dev/null or
+alternatives scaffolding) can be excluded with --allow-target; it
+matches the link target - and relative targets in their resolved form
+too. --allow-from reads these globs from a file, one per line.
+
+With --group-by-target, dead links are grouped by the topmost missing
+component of their target and the largest groups are shown first; so
+the hundreds of links broken by one deleted dir are shown together
+with their count.
+
+With --summary, the dead links are also counted by where their targets
+are: in the trees that were scanned (the breakage is self-contained),
+outside them, or on volumes that aren't there - a missing top level
+dir, a missing dir right under /mnt, /media, /Volumes or
+/run/media/USER, or a file system in /etc/fstab that isn't mounted
+(Linux only). The counts follow the report as '#' comments; with -0
+and --format=csv, they're written to stderr. It can't be used with
+URLs.
+
+With -0, the output has just the names of the dead links - each ending
+in a NUL; so 'deadlinks -0 DIR | xargs -0 rm' only ever removes dead
+links. Links fixed by --rewrite-prefix are reported on stderr.
+
+With --format=csv, each dead link is a CSV record of: the link, its
+target, 'abs' or 'rel', the action ('dead', 'relinked' or
+'would-relink') and the new target.
+
+With --watch, the dead links are reported and then the dirs are
+watched (inotify on Linux) until %s is killed: each link that dies
+is reported as 'dead', each that comes back to life as 'alive' and a
+dead link that's deleted as 'removed' - every line starts with the
+time. The dirs of the link targets are watched too; and the trees are
+rescanned every --watch-interval in case a change isn't seen (e.g. on
+NFS). It can't be combined with --from-file, --rewrite-prefix,
+--relink-search, --group-by-target, --summary, -0 or --format=csv.
+
+The dirs can be URLs of other trees instead: tar://FILE.tar, zip://FILE
+(a dir in them is FILE::DIR), sftp://[USER@]HOST/PATH or file://PATH.
+Each link is resolved in the same tree - an absolute target in an
+archive is relative to its root. URLs and local names can't be mixed;
+and they can't be used with --rewrite-prefix, --relink-search, --watch,
+--group-by-target or --summary.
+
+Errors exit with 2 if a file vanished, 3 on I/O errors and 4 if
+permission was denied; the largest applies. Bad options and arguments
+exit with 64. With --json-errors, each error is a JSON record: {"prog",
+"kind", "op", "path", "error"}.
 
 Options:
-`, Z, Z)
+`, Z, Z, Z)
 		flag.PrintDefaults()
 		os.Stdout.Sync()
 		os.Exit(0)
@@ -54,18 +174,100 @@ Options:
 	}
 
 	args := flag.Args()
-	if len(args) == 0 {
+	if len(args) == 0 && len(fromFile) == 0 {
 		Die("Insufficient args. Try %s --help", Z)
 	}
 
+	if jobs <= 0 {
+		Die("--jobs must be positive")
+	}
+
+	remote, err := urlArgs(args)
+	if err != nil {
+		Die("%s", err)
+	}
+	if remote && (len(prefixes) > 0 || len(search) > 0 || watch || byTarget || summarize) {
+		Die("URLs can't be used with --rewrite-prefix, --relink-search, --watch, --group-by-target or --summary")
+	}
+
+	if byTarget && zero {
+		Die("--group-by-target can't be used with --null")
+	}
+
+	// -0 output is fed to xargs; it must have nothing but names
+	if zero && (showTarget || classify) {
+		Die("--null can't be used with --show-dead-target or --classify")
+	}
+
+	var asCSV bool
+	switch format {
+	case "text":
+	case "csv":
+		if zero || byTarget {
+			Die("--format=csv can't be used with --null or --group-by-target")
+		}
+		asCSV = true
+	default:
+		Die("unknown format '%s'; try one of: text, csv", format)
+	}
+
+	var rw *rewriter
+	if len(prefixes) > 0 {
+		r, err := newRewriter(prefixes, dryRun)
+		if err != nil {
+			Die("%s", err)
+		}
+		rw = r
+	}
+
+	var rs *searcher
+	if len(search) > 0 {
+		s, err := newSearcher(search, match, dryRun)
+		if err != nil {
+			Die("%s", err)
+		}
+		rs = s
+	}
+
+	var sum *summary
+	if summarize {
+		if sum, err = newSummary(args); err != nil {
+			Die("%s", err)
+		}
+	}
+
+	allow, err := newAllowList(allowTargets, allowFrom)
+	if err != nil {
+		Die("%s", err)
+	}
+
 	opt := walk.Options{
 		FollowSymlinks: false,
 		Type:           walk.SYMLINK,
-		Excludes:       ignores,
+	}
+
+	excl, err := glob.Excludes(ignores, includes, fold)
+	if err != nil {
+		Die("%s", err)
+	}
+	opt.Filter = excl.Filter(args)
+
+	if watch {
+		if len(fromFile) > 0 || rw != nil || len(search) > 0 || byTarget || summarize || zero || asCSV {
+			Die("--watch can't be used with --from-file, --rewrite-prefix, --relink-search, --group-by-target, --summary, --null or --format=csv")
+		}
+		if len(args) == 0 {
+			Die("--watch needs one or more dirs")
+		}
+		if every <= 0 {
+			Die("--watch-interval must be positive")
+		}
+		doWatch(args, opt, allow, every)
 	}
 
 	out := make(chan Result, 1)
 	var dead strings.Builder
+	groups := make(targetGroups)
 	var wg sync.WaitGroup
 
 	var sep = "\n"
@@ -73,43 +275,231 @@ Options:
 		sep = "\000"
 	}
 
+	cw := csv.NewWriter(&dead)
+	if asCSV {
+		cw.Write([]string{"link", "target", "kind", "action", "new_target"})
+	}
+
 	wg.Add(1)
 	go func(ch chan Result) {
-		if showTarget {
-			for r := range ch {
-				dead.WriteString(fmt.Sprintf("%s -> %s%s", r.Link, r.Target, sep))
+		for r := range ch {
+			if allow.allowed(r) {
+				continue
 			}
-		} else {
-			for r := range ch {
+
+			if targ := fix(r, rw, rs); len(targ) > 0 {
+				verb := "relinked"
+				if dryRun {
+					verb = "would relink"
+				}
+				switch {
+				case asCSV:
+					cw.Write([]string{r.Link, r.Target, r.kind(), strings.Replace(verb, " ", "-", 1), targ})
+				case zero:
+					Warn("%s %s: %s -> %s", verb, r.Link, r.Target, targ)
+				default:
+					dead.WriteString(fmt.Sprintf("%s %s: %s -> %s%s", verb, r.Link, r.Target, targ, sep))
+				}
+				continue
+			}
+
+			if sum != nil {
+				sum.add(r)
+			}
+
+			if asCSV {
+				cw.Write([]string{r.Link, r.Target, r.kind(), "dead", ""})
+				continue
+			}
+
+			if byTarget {
+				groups.add(r)
+				continue
+			}
+
+			if classify {
+				dead.WriteString(r.kind() + " ")
+			}
+
+			if showTarget {
+				dead.WriteString(fmt.Sprintf("%s -> %s%s", r.Link, r.Target, sep))
+			} else {
 				dead.WriteString(fmt.Sprintf("%s%s", r.Link, sep))
 			}
 		}
 		wg.Done()
 	}(out)
 
-	err := walk.WalkFunc(args, opt, func(fi *fio.Info) error {
-		// we know nm is a symlink; we read the link and eval it
-		nm := fi.Path()
+	// we know nm is a symlink; we read the link and eval it
+	check := func(nm string) error {
 		_, err := filepath.EvalSymlinks(nm)
 		if err != nil {
 			targ, err := os.Readlink(nm)
 			if err != nil {
 				return err
 			}
-			out <- Result{nm, targ}
+			out <- Result{nm, targ, filepath.IsAbs(targ)}
 		}
 		return nil
-	})
+	}
 
-	if err != nil {
-		Die("%s", err)
+	// resolving links can be slow on network filesystems; so the
+	// walker just queues candidates for a pool of workers.
+	work := make(chan string, jobs)
+	var werrs []error
+	var emu sync.Mutex
+	var jwg sync.WaitGroup
+
+	jwg.Add(jobs)
+	for i := 0; i < jobs; i++ {
+		go func() {
+			for nm := range work {
+				if err := check(nm); err != nil {
+					emu.Lock()
+					werrs = append(werrs, err)
+					emu.Unlock()
+				}
+			}
+			jwg.Done()
+		}()
+	}
+
+	enq := func(nm string) error {
+		work <- nm
+		return nil
+	}
+
+	var errs []error
+	if len(fromFile) > 0 {
+		if err := checkList(fromFile, zero, enq); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(args) > 0 && remote {
+		err := checkURLs(args, excl, jobs, func(r Result) {
+			out <- r
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	} else if len(args) > 0 {
+		err := walk.WalkFunc(args, opt, func(fi *fio.Info) error {
+			return enq(fi.Path())
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	close(work)
+	jwg.Wait()
+	errs = append(errs, werrs...)
+
+	if err := errors.Join(errs...); err != nil {
+		rep := report.New(os.Args[0], jsonErrs)
+		rep.Error(err)
+		os.Exit(rep.Code())
 	}
 
 	close(out)
 	wg.Wait()
+	cw.Flush()
 	if dead.Len() > 0 {
-		fmt.Printf(dead.String())
+		fmt.Print(dead.String())
+	}
+	if len(groups) > 0 {
+		fmt.Printf("%s", groups.String(classify))
+	}
+
+	// -0 and csv output must have nothing but the records
+	if sum != nil {
+		if zero || asCSV {
+			fmt.Fprintf(os.Stderr, "%s", sum)
+		} else {
+			fmt.Printf("%s", sum)
+		}
+	}
+}
+
+// fix retargets the dead link 'r' by its prefix or else by a search;
+// it returns the new target or "" if the link is still dead.
+func fix(r Result, rw *rewriter, rs *searcher) string {
+	if rw != nil {
+		targ, err := rw.rewrite(r)
+		if err != nil {
+			Warn("%s", err)
+		} else if len(targ) > 0 {
+			return targ
+		}
+	}
+
+	if rs != nil {
+		targ, err := rs.relink(r)
+		if err != nil {
+			Warn("%s", err)
+			return ""
+		}
+		return targ
+	}
+	return ""
+}
+
+// checkList calls 'check' for each symlink named in file 'fn'
+func checkList(fn string, zero bool, check func(nm string) error) error {
+	var fd io.ReadCloser = os.Stdin
+	if fn != "-" {
+		fx, err := os.Open(fn)
+		if err != nil {
+			return err
+		}
+		fd = fx
+	}
+	defer fd.Close()
+
+	rd := bufio.NewScanner(fd)
+	if zero {
+		rd.Split(splitNul)
+	}
+
+	var errs []error
+	for rd.Scan() {
+		nm := rd.Text()
+		if len(nm) == 0 {
+			continue
+		}
+
+		fi, err := os.Lstat(nm)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if (fi.Mode() & os.ModeSymlink) == 0 {
+			Warn("%s: not a symlink; skipping ..", nm)
+			continue
+		}
+
+		if err = check(nm); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := rd.Err(); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", fn, err))
+	}
+	return errors.Join(errs...)
+}
+
+// bufio.SplitFunc for NUL terminated records
+func splitNul(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
 	}
+	return 0, nil, nil
 }
 
 // This will be filled in by "build"
@@ -10,12 +10,14 @@ package main
 import (
 	"fmt"
 	"os"
+
+	"go-progs/internal/report"
 )
 
-// die with error
+// die with a usage error
 func Die(f string, v ...interface{}) {
 	Warn(f, v...)
-	os.Exit(1)
+	os.Exit(report.ExitUsage)
 }
 
 func Warn(f string, v ...interface{}) {
@@ -0,0 +1,84 @@
+// group.go - group dead links by their missing target
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// targetGroups collects dead links by the path that is missing: the
+// topmost component of the resolved target that doesn't exist. So all
+// the links broken by one deleted dir end up in the same group.
+type targetGroups map[string][]Result
+
+func (g targetGroups) add(r Result) {
+	k := missingPath(r)
+	g[k] = append(g[k], r)
+}
+
+// String returns the groups - the largest first - each followed by its
+// links.
+func (g targetGroups) String(classify bool) string {
+	keys := make([]string, 0, len(g))
+	for k := range g {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if len(g[a]) != len(g[b]) {
+			return len(g[a]) > len(g[b])
+		}
+		return a < b
+	})
+
+	var b strings.Builder
+	for _, k := range keys {
+		v := g[k]
+		sort.Slice(v, func(i, j int) bool {
+			return v[i].Link < v[j].Link
+		})
+
+		links := "links"
+		if len(v) == 1 {
+			links = "link"
+		}
+		fmt.Fprintf(&b, "%s: %d %s\n", k, len(v), links)
+		for _, r := range v {
+			kind := ""
+			if classify {
+				kind = r.kind() + " "
+			}
+			fmt.Fprintf(&b, "    %s%s -> %s\n", kind, r.Link, r.Target)
+		}
+	}
+	return b.String()
+}
+
+// missingPath returns the topmost missing component of the target of
+// 'r' resolved relative to the link's dir.
+func missingPath(r Result) string {
+	targ := r.Target
+	if !r.Abs {
+		targ = filepath.Join(filepath.Dir(r.Link), targ)
+	}
+	targ = filepath.Clean(targ)
+
+	for {
+		up := filepath.Dir(targ)
+		if up == targ {
+			return targ
+		}
+		if _, err := os.Lstat(up); err == nil {
+			return targ
+		}
+		targ = up
+	}
+}
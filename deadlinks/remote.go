@@ -0,0 +1,82 @@
+// remote.go - find dead symlinks in trees named by URLs (e.g. tar://x.tar)
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+
+	"go-progs/internal/glob"
+	"go-progs/internal/vfs"
+)
+
+// urlArgs returns true if every name in 'args' is a URL; URLs and local
+// names can't be mixed. The trailing '/' of each URL is removed.
+func urlArgs(args []string) (bool, error) {
+	var n int
+	for i, nm := range args {
+		if vfs.IsURL(nm) {
+			args[i] = strings.TrimSuffix(nm, "/")
+			n++
+		}
+	}
+
+	if n > 0 && n != len(args) {
+		return false, errors.New("can't mix URLs and local names")
+	}
+	return n > 0, nil
+}
+
+// checkURLs calls 'dead' for each symlink under the URLs in 'args' whose
+// target doesn't exist in the same tree; 'jobs' links are resolved at a
+// time.
+func checkURLs(args []string, excl *glob.Matcher, jobs int, dead func(r Result)) error {
+	work := make(chan *vfs.Entry, jobs)
+
+	var errs []error
+	var emu sync.Mutex
+	var wg sync.WaitGroup
+
+	wg.Add(jobs)
+	for i := 0; i < jobs; i++ {
+		go func() {
+			for e := range work {
+				_, err := vfs.Stat(e.Name)
+				switch {
+				case err == nil:
+				case errors.Is(err, fs.ErrNotExist):
+					dead(Result{e.Name, e.Link, path.IsAbs(e.Link)})
+				default:
+					emu.Lock()
+					errs = append(errs, err)
+					emu.Unlock()
+				}
+			}
+			wg.Done()
+		}()
+	}
+
+	var werrs []error
+	for _, nm := range args {
+		err := vfs.Walk(nm, func(e *vfs.Entry) error {
+			rel := strings.TrimPrefix(e.Name, nm+"/")
+			if e.IsLink() && (rel == e.Name || !excl.MatchPath(rel)) {
+				work <- e
+			}
+			return nil
+		})
+		if err != nil {
+			werrs = append(werrs, err)
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	return errors.Join(append(werrs, errs...)...)
+}
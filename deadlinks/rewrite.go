@@ -0,0 +1,104 @@
+// rewrite.go - retarget dead symlinks whose target prefix has moved
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type prefixMap struct {
+	old, new string
+}
+
+// rewriter retargets dead links using a list of OLD=NEW prefix maps
+type rewriter struct {
+	maps   []prefixMap
+	dryRun bool
+}
+
+func newRewriter(v []string, dryRun bool) (*rewriter, error) {
+	rw := &rewriter{
+		maps:   make([]prefixMap, 0, len(v)),
+		dryRun: dryRun,
+	}
+
+	for _, s := range v {
+		i := strings.IndexByte(s, '=')
+		if i <= 0 || i == len(s)-1 {
+			return nil, fmt.Errorf("rewrite-prefix: malformed '%s'; expected OLD=NEW", s)
+		}
+
+		old, err := filepath.Abs(s[:i])
+		if err != nil {
+			return nil, fmt.Errorf("rewrite-prefix %s: %w", s, err)
+		}
+		new, err := filepath.Abs(s[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("rewrite-prefix %s: %w", s, err)
+		}
+		rw.maps = append(rw.maps, prefixMap{old, new})
+	}
+	return rw, nil
+}
+
+// rewrite tries to fix a dead link; it returns the new target (if
+// the link was rewritten) and any error encountered. An empty target
+// and no error means none of the prefixes apply.
+func (rw *rewriter) rewrite(r Result) (string, error) {
+	dir, err := filepath.Abs(filepath.Dir(r.Link))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", r.Link, err)
+	}
+
+	// relative targets are matched in their resolved form
+	abs := r.Target
+	if !r.Abs {
+		abs = filepath.Join(dir, r.Target)
+	}
+
+	for _, pm := range rw.maps {
+		if abs != pm.old && !strings.HasPrefix(abs, pm.old+"/") {
+			continue
+		}
+
+		targ := pm.new + abs[len(pm.old):]
+		if _, err := os.Stat(targ); err != nil {
+			return "", fmt.Errorf("%s: new target %s: %w", r.Link, targ, err)
+		}
+
+		// preserve the relative-ness of the original link
+		if !r.Abs {
+			rel, err := filepath.Rel(dir, targ)
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", r.Link, err)
+			}
+			targ = rel
+		}
+
+		if rw.dryRun {
+			return targ, nil
+		}
+		return targ, relink(r.Link, targ)
+	}
+	return "", nil
+}
+
+// relink atomically replaces the symlink 'nm' with one pointing to 'targ'
+func relink(nm, targ string) error {
+	tmp := fmt.Sprintf("%s.tmp.%d", nm, os.Getpid())
+	if err := os.Symlink(targ, tmp); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, nm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
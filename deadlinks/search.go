@@ -0,0 +1,213 @@
+// search.go - retarget dead symlinks to a file of the same name elsewhere
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/opencoff/go-fio"
+	"github.com/opencoff/go-fio/walk"
+)
+
+// how candidates that are equally close to the old target are told
+// apart; set by --relink-match
+const (
+	matchName = "name"
+	matchSize = "size"
+	matchHash = "hash"
+)
+
+// searcher retargets each dead link to the file (or dir) with the name
+// of its target in one of the search dirs. If there are several, the
+// one closest to the old target (the longest common dir) is chosen;
+// e.g. a target that moved one level up.
+type searcher struct {
+	match  string
+	dryRun bool
+
+	// abs paths of the search dirs' entries by name
+	names map[string][]*fio.Info
+}
+
+func newSearcher(dirs []string, match string, dryRun bool) (*searcher, error) {
+	switch match {
+	case matchName, matchSize, matchHash:
+	default:
+		return nil, fmt.Errorf("unknown relink match '%s'; try one of: name, size, hash", match)
+	}
+
+	s := &searcher{
+		match:  match,
+		dryRun: dryRun,
+		names:  make(map[string][]*fio.Info),
+	}
+
+	v := make([]string, 0, len(dirs))
+	for _, d := range dirs {
+		a, err := filepath.Abs(d)
+		if err != nil {
+			return nil, fmt.Errorf("relink-search %s: %w", d, err)
+		}
+		v = append(v, a)
+	}
+
+	var mu sync.Mutex
+	opt := walk.Options{
+		Type: walk.FILE | walk.DIR,
+	}
+	err := walk.WalkFunc(v, opt, func(fi *fio.Info) error {
+		nm := filepath.Base(fi.Path())
+		mu.Lock()
+		s.names[nm] = append(s.names[nm], fi)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// relink tries to fix a dead link; it returns the new target (if the
+// link was retargeted) and any error encountered. An empty target and
+// no error means nothing by that name was found.
+func (s *searcher) relink(r Result) (string, error) {
+	dir, err := filepath.Abs(filepath.Dir(r.Link))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", r.Link, err)
+	}
+
+	abs := r.Target
+	if !r.Abs {
+		abs = filepath.Join(dir, r.Target)
+	}
+
+	cands := s.closest(filepath.Dir(abs), s.names[filepath.Base(abs)])
+	if len(cands) == 0 {
+		return "", nil
+	}
+
+	if len(cands) > 1 {
+		same, err := s.same(cands)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", r.Link, err)
+		}
+		if !same {
+			return "", fmt.Errorf("%s: %d candidates for %s: %s", r.Link, len(cands), r.Target, paths(cands))
+		}
+	}
+
+	targ := cands[0].Path()
+
+	// preserve the relative-ness of the original link
+	if !r.Abs {
+		rel, err := filepath.Rel(dir, targ)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", r.Link, err)
+		}
+		targ = rel
+	}
+
+	if s.dryRun {
+		return targ, nil
+	}
+	return targ, relink(r.Link, targ)
+}
+
+// closest returns the candidates that share the longest dir with
+// 'dir' - sorted by path.
+func (s *searcher) closest(dir string, v []*fio.Info) []*fio.Info {
+	var best []*fio.Info
+
+	n := -1
+	for _, fi := range v {
+		k := commonDirs(dir, filepath.Dir(fi.Path()))
+		switch {
+		case k > n:
+			n, best = k, []*fio.Info{fi}
+		case k == n:
+			best = append(best, fi)
+		}
+	}
+
+	sort.Slice(best, func(i, j int) bool {
+		return best[i].Path() < best[j].Path()
+	})
+	return best
+}
+
+// same returns true if the candidates 'v' are interchangeable: files
+// of the same size - or the same content - as --relink-match asks.
+func (s *searcher) same(v []*fio.Info) (bool, error) {
+	if s.match == matchName {
+		return false, nil
+	}
+
+	for _, fi := range v {
+		if !fi.Mode().IsRegular() || fi.Size() != v[0].Size() {
+			return false, nil
+		}
+	}
+
+	if s.match == matchSize {
+		return true, nil
+	}
+
+	var sum []byte
+	for _, fi := range v {
+		h, err := fileHash(fi.Path())
+		if err != nil {
+			return false, err
+		}
+		if sum != nil && !bytes.Equal(h, sum) {
+			return false, nil
+		}
+		sum = h
+	}
+	return true, nil
+}
+
+// number of leading dirs 'a' and 'b' have in common
+func commonDirs(a, b string) int {
+	x := strings.Split(a, "/")
+	y := strings.Split(b, "/")
+
+	var n int
+	for n < len(x) && n < len(y) && x[n] == y[n] {
+		n++
+	}
+	return n
+}
+
+func fileHash(fn string) ([]byte, error) {
+	fd, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, fd); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	return h.Sum(nil), nil
+}
+
+func paths(v []*fio.Info) string {
+	s := make([]string, 0, len(v))
+	for _, fi := range v {
+		s = append(s, fi.Path())
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,155 @@
+// summary.go - count the dead links by where their targets are
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dirs that removable and network volumes are mounted under
+var volumeDirs = []string{"/mnt", "/media", "/Volumes", "/run/media"}
+
+// where a dead link points
+const (
+	bucketInternal = iota
+	bucketExternal
+	bucketVolume
+	nBuckets
+)
+
+// summary counts the dead links by where their targets are: inside
+// the trees that were scanned, outside them - or on a volume that
+// isn't there: a missing top level dir, a missing dir under /mnt,
+// /media etc., or a file system in /etc/fstab that isn't mounted.
+type summary struct {
+	roots []string
+
+	// fstab mount points that aren't mounted now
+	unmounted []string
+
+	n [nBuckets]int
+}
+
+func newSummary(roots []string) (*summary, error) {
+	s := &summary{
+		roots: make([]string, 0, len(roots)),
+	}
+
+	for _, r := range roots {
+		a, err := filepath.Abs(r)
+		if err != nil {
+			return nil, fmt.Errorf("summary: %s: %w", r, err)
+		}
+		s.roots = append(s.roots, a)
+	}
+
+	// either may not exist on this platform
+	fstab, _ := mountPoints("/etc/fstab")
+	mounted, _ := mountPoints("/proc/self/mounts")
+	if mounted != nil {
+		for mp := range fstab {
+			if !mounted[mp] && mp != "/" {
+				s.unmounted = append(s.unmounted, mp)
+			}
+		}
+	}
+	return s, nil
+}
+
+// add the dead link 'r'
+func (s *summary) add(r Result) {
+	s.n[s.bucket(r)]++
+}
+
+func (s *summary) bucket(r Result) int {
+	miss, err := filepath.Abs(missingPath(r))
+	if err != nil {
+		return bucketExternal
+	}
+
+	if underAny(miss, s.roots) {
+		return bucketInternal
+	}
+	if underAny(miss, s.unmounted) {
+		return bucketVolume
+	}
+
+	if filepath.Dir(miss) == "/" {
+		return bucketVolume
+	}
+
+	// the volume is a dir right below these; /run/media has a dir per
+	// user.
+	for _, d := range volumeDirs {
+		rel, ok := strings.CutPrefix(miss, d)
+		if !ok || (len(rel) > 0 && rel[0] != '/') {
+			continue
+		}
+
+		depth := 1
+		if d == "/run/media" {
+			depth = 2
+		}
+		if strings.Count(rel, "/") <= depth {
+			return bucketVolume
+		}
+	}
+	return bucketExternal
+}
+
+// String returns the counts, one per line - each a '#' comment
+func (s *summary) String() string {
+	tot := s.n[bucketInternal] + s.n[bucketExternal] + s.n[bucketVolume]
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# dead links: %d\n", tot)
+	fmt.Fprintf(&b, "#   in the scanned trees: %d\n", s.n[bucketInternal])
+	fmt.Fprintf(&b, "#   outside them:         %d\n", s.n[bucketExternal])
+	fmt.Fprintf(&b, "#   on missing volumes:   %d\n", s.n[bucketVolume])
+	return b.String()
+}
+
+// return true if 'nm' is one of 'dirs' or is below one of them
+func underAny(nm string, dirs []string) bool {
+	for _, d := range dirs {
+		if nm == d || strings.HasPrefix(nm, strings.TrimSuffix(d, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// mountPoints returns the mount points (the 2nd field) in the fstab(5)
+// formatted file 'fn'
+func mountPoints(fn string) (map[string]bool, error) {
+	fd, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	m := make(map[string]bool)
+	rd := bufio.NewScanner(fd)
+	for rd.Scan() {
+		line := rd.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		f := strings.Fields(line)
+		if len(f) < 2 || !strings.HasPrefix(f[1], "/") {
+			continue
+		}
+
+		// spaces in names are octal escapes
+		m[filepath.Clean(strings.ReplaceAll(f[1], "\\040", " "))] = true
+	}
+	return m, rd.Err()
+}
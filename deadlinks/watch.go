@@ -0,0 +1,135 @@
+// watch.go - report symlinks as they die or come back to life
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/opencoff/go-fio"
+	"github.com/opencoff/go-fio/walk"
+)
+
+// events in a burst (e.g. a log rotation) are coalesced into one rescan
+const _WatchSettle = 250 * time.Millisecond
+
+// watcher rescans the trees whenever a watched dir changes - and every
+// 'every' regardless; so targets on file systems without change
+// notifications are caught too.
+type watcher struct {
+	args  []string
+	opt   walk.Options
+	allow *allowList
+	every time.Duration
+	ev    *dirEvents
+
+	// dead links and their targets as of the last scan
+	dead map[string]string
+}
+
+// doWatch reports the dead links in 'args' and then each link that
+// dies or comes back to life - until killed.
+func doWatch(args []string, opt walk.Options, allow *allowList, every time.Duration) {
+	ev, err := newDirEvents()
+	if err != nil {
+		Warn("can't watch for changes; rescanning every %s: %s", every, err)
+	}
+
+	// dirs are only walked to be watched
+	if ev != nil {
+		opt.Type |= walk.DIR
+	}
+
+	w := &watcher{
+		args:  args,
+		opt:   opt,
+		allow: allow,
+		every: every,
+		ev:    ev,
+		dead:  make(map[string]string),
+	}
+
+	for {
+		w.scan()
+
+		select {
+		case <-w.ev.changed():
+			w.ev.settle(_WatchSettle)
+		case <-time.After(every):
+		}
+	}
+}
+
+// scan walks the trees and prints the changes since the last scan
+func (w *watcher) scan() {
+	var mu sync.Mutex
+	dead := make(map[string]string)
+
+	err := walk.WalkFunc(w.args, w.opt, func(fi *fio.Info) error {
+		nm := fi.Path()
+		if fi.IsDir() {
+			w.ev.add(nm)
+			return nil
+		}
+
+		targ, err := os.Readlink(nm)
+		if err != nil {
+			return err
+		}
+
+		resolved, err := filepath.EvalSymlinks(nm)
+		if err == nil {
+			// a change to the target's dir may kill the link
+			w.ev.add(filepath.Dir(resolved))
+			return nil
+		}
+
+		r := Result{nm, targ, filepath.IsAbs(targ)}
+		if w.allow.allowed(r) {
+			return nil
+		}
+
+		// the target may come back below its topmost missing dir
+		w.ev.add(filepath.Dir(missingPath(r)))
+
+		mu.Lock()
+		dead[nm] = targ
+		mu.Unlock()
+		return nil
+	})
+
+	// a watch keeps going; the next scan may well succeed
+	if err != nil {
+		Warn("%s", err)
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	for nm, targ := range dead {
+		if _, ok := w.dead[nm]; !ok {
+			fmt.Printf("%s dead %s -> %s\n", now, nm, targ)
+		}
+	}
+
+	for nm, targ := range w.dead {
+		if _, ok := dead[nm]; ok {
+			continue
+		}
+
+		// a dead link that's deleted didn't come back to life
+		if fi, err := os.Lstat(nm); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			if t, err := os.Readlink(nm); err == nil {
+				targ = t
+			}
+			fmt.Printf("%s alive %s -> %s\n", now, nm, targ)
+		} else {
+			fmt.Printf("%s removed %s -> %s\n", now, nm, targ)
+		}
+	}
+	w.dead = dead
+}
@@ -0,0 +1,108 @@
+// watch_linux.go - inotify based change notifications
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build linux
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const _WatchMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM |
+	unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF | unix.IN_ONLYDIR
+
+// dirEvents signals a change to any of the dirs added to it. A nil
+// dirEvents never signals.
+type dirEvents struct {
+	sync.Mutex
+	fd   int
+	dirs map[string]bool
+	ch   chan struct{}
+
+	// true once we've warned about running out of watches
+	full bool
+}
+
+func newDirEvents() (*dirEvents, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &dirEvents{
+		fd:   fd,
+		dirs: make(map[string]bool),
+		ch:   make(chan struct{}, 1),
+	}
+	go d.read()
+	return d, nil
+}
+
+// the events themselves don't matter; each read is a change
+func (d *dirEvents) read() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := unix.Read(d.fd, buf)
+		if err != nil && err != unix.EINTR {
+			return
+		}
+		if n > 0 {
+			select {
+			case d.ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// add watches dir 'nm'; a dir that's since been deleted is watched
+// again if it's re-created and seen by a later scan.
+func (d *dirEvents) add(nm string) {
+	if d == nil {
+		return
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	if d.dirs[nm] {
+		return
+	}
+	if _, err := unix.InotifyAddWatch(d.fd, nm, _WatchMask); err != nil {
+		if err == unix.ENOSPC && !d.full {
+			Warn("out of inotify watches; some changes are only seen by the periodic rescan")
+			d.full = true
+		}
+		return
+	}
+	d.dirs[nm] = true
+}
+
+func (d *dirEvents) changed() <-chan struct{} {
+	if d == nil {
+		return nil
+	}
+	return d.ch
+}
+
+// settle waits for a burst of changes to end; the rescan after it
+// sees all of them.
+func (d *dirEvents) settle(quiet time.Duration) {
+	for {
+		select {
+		case <-d.ch:
+		case <-time.After(quiet):
+			// deleted dirs lose their watch; re-add them as seen
+			d.Lock()
+			clear(d.dirs)
+			d.Unlock()
+			return
+		}
+	}
+}
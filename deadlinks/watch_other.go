@@ -0,0 +1,27 @@
+// watch_other.go - platforms without inotify
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build !linux
+
+package main
+
+import (
+	"time"
+)
+
+// dirEvents never signals here; --watch just rescans periodically
+type dirEvents struct{}
+
+func newDirEvents() (*dirEvents, error) {
+	return nil, nil
+}
+
+func (d *dirEvents) add(nm string) {}
+
+func (d *dirEvents) changed() <-chan struct{} {
+	return nil
+}
+
+func (d *dirEvents) settle(quiet time.Duration) {}
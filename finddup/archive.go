@@ -0,0 +1,39 @@
+// archive.go - compare the members of tar and zip files with other files
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"strings"
+
+	"go-progs/internal/vfs"
+
+	"github.com/opencoff/go-fio"
+)
+
+// scanArchive calls 'fp' with the checksum of each file in the archive
+// 'fn' if it's a tar or zip file; each is named ARCHIVE::MEMBER.
+// Symlinks are skipped.
+func scanArchive(fn string, fp func(fi *fio.Info, sum string)) error {
+	u := vfs.ArchiveURL(fn)
+	if len(u) == 0 {
+		return nil
+	}
+
+	return vfs.Walk(u, func(e *vfs.Entry) error {
+		if e.IsLink() {
+			return nil
+		}
+
+		sum, err := objectSum(e, false)
+		if err != nil {
+			return err
+		}
+
+		fi := entryInfo(e)
+		fi.SetPath(fn + strings.TrimPrefix(e.Name, u))
+		fp(fi, sum)
+		return nil
+	})
+}
@@ -5,16 +5,21 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"hash"
 	"os"
 	"path"
-	"sort"
 	"strings"
 
+	"go-progs/internal/cachetag"
+	"go-progs/internal/glob"
+	"go-progs/internal/report"
+
 	"github.com/opencoff/go-fio"
 	"github.com/opencoff/go-fio/walk"
 	"github.com/opencoff/go-mmap"
+	"github.com/opencoff/go-utils"
 	flag "github.com/opencoff/pflag"
 	"github.com/puzpuzpuz/xsync/v3"
 	"github.com/zeebo/blake3"
@@ -22,6 +27,13 @@ import (
 
 var Z string = path.Base(os.Args[0])
 
+// VCS metadata and the usual build, package and virtualenv trees; they
+// are ignored unless --no-default-ignores is given.
+var defaultIgnores = []string{
+	".git", ".hg", ".svn",
+	"node_modules/", "__pycache__/", ".venv/", "target/",
+}
+
 type csum struct {
 	name string
 	sum  string
@@ -29,13 +41,58 @@ type csum struct {
 }
 
 func main() {
-	var version, shell, follow bool
-	var ignores []string = []string{".git", ".hg"}
+	var version, shell, follow, fuzzy, stream, lowmem, clone bool
+	var tmpdir, emit string
+	var fuzzDist int
+	var orderBy string
+	var prefer []string
+	var ignores []string
+	var noDefaults, noCaches bool
+	var includes []string
+	var fold, jsonErrs bool
+	var media bool
+	var db, queryOf string
+	var dupes bool
+	var skipOpen bool
+	var sameFS bool
+	var sampleBytes string
+	var noETags bool
+	var scanArchives bool
+	var protect []string
+	var bwRate string
+	var idle bool
 
 	flag.BoolVarP(&version, "version", "", false, "Show version info and quit")
 	flag.BoolVarP(&follow, "follow-symlinks", "L", false, "Follow symlinks")
 	flag.BoolVarP(&shell, "shell", "s", false, "Generate shell commands")
-	flag.StringSliceVarP(&ignores, "ignore", "i", ignores, "Ignore names that match these patterns")
+	flag.StringSliceVarP(&ignores, "ignore", "i", nil, "Also ignore entries that match glob `G`")
+	flag.BoolVarP(&noDefaults, "no-default-ignores", "", false, "Don't ignore the VCS, build and package dirs ignored by default")
+	flag.BoolVarP(&noCaches, "exclude-caches", "", false, "Skip dirs tagged with a CACHEDIR.TAG file")
+	flag.StringSliceVarP(&includes, "include", "", nil, "Re-include entries matching glob `G` that were ignored")
+	flag.BoolVarP(&fold, "ignore-case", "", false, "Match --ignore and --include globs ignoring case")
+	flag.StringVarP(&orderBy, "order", "", _DefaultOrder, "Order files in a group by the keys `K,..` (mtime, ctime, path, depth, name-length)")
+	flag.StringSliceVarP(&prefer, "prefer-dir", "", nil, "Prefer to keep files under dir `D` ahead of --order")
+	flag.StringSliceVarP(&protect, "protect", "", nil, "Always keep the files matching glob `G`; never remove or replace them")
+	flag.BoolVarP(&clone, "reflink", "", false, "Replace duplicates with copy-on-write clones of the kept file")
+	flag.StringVarP(&emit, "emit-manifest", "", "", "Also write every checksum to file `F` in ghash format")
+	flag.BoolVarP(&stream, "stream", "", false, "Print each duplicate group as soon as it's found")
+	flag.BoolVarP(&lowmem, "low-memory", "", false, "Use an on-disk index to bound memory use on huge trees")
+	flag.StringVarP(&tmpdir, "tmpdir", "", os.TempDir(), "Put the --low-memory index in dir `D`")
+	flag.BoolVarP(&fuzzy, "fuzzy", "", false, "Also find near-duplicate images (jpeg, png, gif)")
+	flag.IntVarP(&fuzzDist, "fuzzy-distance", "", 5, "Images whose perceptual hashes differ by at most `N` bits are similar")
+	flag.BoolVarP(&media, "media-content", "", false, "Compare just the pixels or audio of JPEG, PNG, MP3 and FLAC files")
+	flag.StringVarP(&db, "db", "", "", "Use the index in file `F` for the index and query commands")
+	flag.BoolVarP(&dupes, "dupes", "", false, "Query all the duplicate groups in the index (default)")
+	flag.StringVarP(&queryOf, "of", "", "", "Query the files in the index identical to file `F`")
+	flag.BoolVarP(&skipOpen, "skip-open", "", false, "Skip files open for writing or modified while they're hashed")
+	flag.BoolVarP(&sameFS, "same-fs-groups", "", false, "Split each group by the device its files are on")
+	flag.IntVarP(&maxMembers, "max-group-members", "", 0, "List at most `N` files of each group and a count of the rest")
+	flag.StringVarP(&sampleBytes, "sample-bytes", "", "", "First hash just `N` bytes at the start, middle and end of large files")
+	flag.BoolVarP(&scanArchives, "scan-archives", "", false, "Also compare the members of tar and zip files with the other files")
+	flag.StringVarP(&bwRate, "bwlimit", "", "", "Read files at most `R` bytes/sec in all (e.g. 50M)")
+	flag.BoolVarP(&idle, "idle-io", "", false, "Read files only when the disks are otherwise idle and run at the lowest CPU priority")
+	flag.BoolVarP(&noETags, "no-etags", "", false, "Download and hash remote objects instead of comparing their ETags")
+	flag.BoolVarP(&jsonErrs, "json-errors", "", false, "Write errors to stderr as JSON records")
 
 	flag.Usage = func() {
 		fmt.Printf(
@@ -43,12 +100,142 @@ func main() {
 
 Files that have the same strong-hash (blake3) are considered to be
 identical. The names of the identical files are sorted on modification
-time - with the most recent file at the top.
+time - with the most recent file at the top; ties are broken by the
+inode change time, the path depth and the path. The first file in each
+group is the one that is kept; use --order and --prefer-dir to change
+how the keeper is chosen.
+
+--order takes a comma separated list of keys; each key breaks the ties
+of the ones before it and a leading '-' reverses a key (e.g.
+'--order=-mtime,depth' keeps the oldest and then the shallowest). The
+path is always the last key; so the shell commands are the same on
+every run.
+
+Files in the system dirs (%s)
+and those matching a --protect glob are protected: they're kept ahead
+of the other files in their group - whatever --order and --prefer-dir
+say - and they're never removed by the shell commands (a protected
+file that isn't the keeper is commented out) or replaced by --reflink.
+The globs are matched against absolute paths: '/srv/photos' protects
+everything under it and '*.pdf' protects PDFs anywhere.
+
+With --fuzzy, images that are visually similar (resized or re-encoded
+copies) are grouped using a perceptual hash (dHash). Similar images
+are only ever reported; they're never part of the shell commands.
+
+With --reflink, every duplicate is replaced with a copy-on-write clone
+of the kept file (FICLONE on btrfs/XFS, clonefile on APFS). The space
+is reclaimed now, yet each file can still be modified independently.
+Clones keep the mode, owner and times of the file they replace.
+
+With --emit-manifest, the checksum of every file scanned is written in
+ghash(1) format ('ghash -v F' verifies it); so one scan yields both
+the duplicates and an integrity baseline.
+
+With --stream, a group is printed as soon as its second member is
+found and every line is tagged with a group ID. The first file found
+is the one kept; --order and --prefer-dir can't be used with --stream.
+With --shell, a protected file found later is kept instead: the file
+kept until then is removed.
+
+With --low-memory, the first pass records just the name and size of
+each file in an on-disk index under --tmpdir; the second pass hashes
+only files whose sizes collide. It can't be combined with --stream or
+--fuzzy.
+
+With --media-content, JPEG, PNG, MP3 and FLAC files are compared by
+their payload (pixels or audio frames) ignoring EXIF, ID3 and other
+tags; so the same photo or track with different tags is a duplicate.
+Such groups are marked 'media:'. It can't be combined with --reflink,
+--low-memory or --emit-manifest since the files aren't byte identical.
+
+The hashing pass can be decoupled from the queries with an index:
+'%s index --db F dir...' records the checksum of every file under the
+dirs in F; re-indexing only hashes files whose size or times changed.
+'%s query --db F' prints the duplicate groups in F and
+'%s query --db F --of FILE' lists the indexed files identical to FILE.
+The paths in the index are absolute. Use './index' for a dir named
+'index'. An index made with --media-content is only used with it (and
+the other way round). Files that can't be read are reported and left
+out of the index.
+
+With --skip-open, files that are open for writing when the scan starts
+(found in /proc on Linux; run as root to see every process) and files
+whose size or times change while they're hashed are skipped with a
+warning; so live logs and databases never end up in a group or in the
+shell commands.
+
+With --same-fs-groups, each group is split into one group per device
+(file system) - tagged 'dev 0xN' (hex); only files on the same device
+can be hard linked or cloned. A file without a duplicate on its own
+device isn't reported. It can't be combined with --stream or the index
+and query commands.
+
+The --ignore and --include globs follow gitignore(5): a glob without a
+'/' matches names at any depth, others are anchored to the dir they're
+under; '**' matches any number of dirs. The last matching glob wins.
+
+These are ignored by default:
+
+	%s
+
+--ignore adds to the list, --include re-includes what's in it (e.g.
+--include target) and --no-default-ignores starts with an empty list.
+With --exclude-caches, dirs with a CACHEDIR.TAG file (the Cache
+Directory Tagging Standard, https://bford.info/cachedir/) are skipped
+too.
+
+With --max-group-members=N, each group lists just its first N files
+(the keeper first) followed by a count of the rest; '--shell' comments
+out the rest - they aren't removed. The rest are still cloned by
+--reflink.
+
+With --sample-bytes=N, files larger than 3N bytes are first checksummed
+by their size and N bytes at their start, middle and end; only the
+files whose samples match are then hashed in full. N can have a suffix
+of k, M, G etc. It can't be used with --stream, --fuzzy, --media-content,
+--emit-manifest or the index and query commands; they need the checksum
+of every file.
+
+With --scan-archives, the members of tar (optionally gzip or bzip2
+compressed) and zip files are compared too: each is named
+ARCHIVE::MEMBER (e.g. dist/x.tar::bin/x); so an extracted copy next to
+its archive is found. The archives are read in place - nothing is
+extracted. Archive members are only reported; so it can't be used with
+--shell, --reflink, --low-memory, --media-content, --same-fs-groups,
+--sample-bytes, --emit-manifest or the index and query commands.
+
+The dirs can be URLs of object stores instead: e.g. 'finddup
+s3://B1/dir s3://B2' finds the objects duplicated across both buckets.
+Objects are compared by the checksum the store keeps (the S3 ETag);
+so nothing is downloaded. The ETag of an object uploaded in parts
+depends on the part size: such copies are only found if they were
+uploaded alike. With --no-etags, every object is downloaded and hashed.
+The groups are only reported; URLs can't be used with --shell,
+--reflink and the options that read local files. S3 is configured by
+the usual AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN
+and AWS_REGION; set AWS_ENDPOINT_URL for compatible stores. The other
+URLs are tar://FILE.tar, zip://FILE, sftp://[USER@]HOST/PATH and
+file://PATH; they have no ETags, so their files are always hashed.
+
+For scheduled scans on busy servers, --bwlimit=R caps the rate at
+which files are read (and hashed) across all the workers - R can have
+a suffix of k, M, G etc. --idle-io puts the scan in the idle I/O class
+(Linux only): its reads are served only when no one else needs the
+disk; it also runs at the lowest CPU priority (nice 19).
+
+Errors exit with 2 if a file vanished, 3 on I/O errors and 4 if
+permission was denied; the largest applies. Bad options and arguments
+exit with 64; 'query --of FILE' exits with 1 if FILE has no
+duplicates. With --json-errors, each error is a JSON record: {"prog",
+"kind", "op", "path", "error"}.
 
 Usage: %s [options] dir [dir...]
+       %s index --db F [options] dir [dir...]
+       %s query --db F [--dupes | --of FILE] [options]
 
 Options:
-`, Z, Z)
+`, Z, strings.Join(defaultProtects, " "), Z, Z, Z, strings.Join(defaultIgnores, " "), Z, Z, Z)
 		flag.PrintDefaults()
 		os.Stdout.Sync()
 		os.Exit(0)
@@ -65,29 +252,264 @@ Options:
 		Die("Insufficient args. Try %s --help", Z)
 	}
 
+	remote, err := urlArgs(args)
+	if err != nil {
+		Die("%s", err)
+	}
+	if remote {
+		if shell || clone || lowmem || fuzzy || media || skipOpen || sameFS || follow || noCaches || len(sampleBytes) > 0 || len(emit) > 0 {
+			Die("URLs can't be used with --shell, --reflink, --low-memory, --fuzzy, --media-content, --skip-open, --same-fs-groups, -L, --exclude-caches, --sample-bytes or --emit-manifest")
+		}
+	}
+
+	if scanArchives {
+		if remote {
+			Die("--scan-archives can't be used with URLs")
+		}
+		if shell || clone || lowmem || media || sameFS || len(sampleBytes) > 0 || len(emit) > 0 {
+			Die("--scan-archives can't be used with --shell, --reflink, --low-memory, --media-content, --same-fs-groups, --sample-bytes or --emit-manifest")
+		}
+	}
+
+	if len(bwRate) > 0 {
+		n, err := utils.ParseSize(bwRate)
+		if err != nil || n == 0 {
+			Die("invalid bandwidth limit '%s'", bwRate)
+		}
+		bwlimit = newRateLimit(n)
+	}
+
+	if idle {
+		if err := idleIO(); err != nil {
+			Die("--idle-io: %s", err)
+		}
+	}
+
+	if protects, err = newProtects(protect, fold); err != nil {
+		Die("%s", err)
+	}
+
+	ord, err := newOrder(orderBy, prefer)
+	if err != nil {
+		Die("%s; try one of: %s", err, orderNames())
+	}
+
+	if clone && (stream || shell) {
+		Die("--reflink can't be used with --stream or --shell")
+	}
+
+	if media && (clone || lowmem || len(emit) > 0) {
+		Die("--media-content can't be used with --reflink, --low-memory or --emit-manifest")
+	}
+
+	if maxMembers < 0 {
+		Die("--max-group-members must be positive")
+	}
+
+	var samples *sampler
+	var sampleN int64
+	if len(sampleBytes) > 0 {
+		if stream || fuzzy || media || len(emit) > 0 {
+			Die("--sample-bytes can't be used with --stream, --fuzzy, --media-content or --emit-manifest")
+		}
+
+		n, err := utils.ParseSize(sampleBytes)
+		if err != nil || n == 0 {
+			Die("invalid sample size '%s'", sampleBytes)
+		}
+		sampleN = int64(n)
+		samples = newSampler(sampleN)
+	}
+
+	var st *streamer
+	if stream {
+		if flag.Lookup("order").Changed || len(prefer) > 0 {
+			Die("--stream can't be used with --order or --prefer-dir")
+		}
+		if sameFS {
+			Die("--stream can't be used with --same-fs-groups")
+		}
+		st = newStreamer(shell)
+	}
+
 	opt := walk.Options{
 		FollowSymlinks: follow,
 		Type:           walk.FILE,
-		Excludes:       ignores,
 	}
 
+	if !noDefaults {
+		ignores = append(defaultIgnores, ignores...)
+	}
+	excl, err := glob.Excludes(ignores, includes, fold)
+	if err != nil {
+		Die("%s", err)
+	}
+
+	// the globs are relative to the dirs walked
+	filter := func(roots []string) func(fi *fio.Info) (bool, error) {
+		f := excl.Filter(roots)
+		if !noCaches {
+			return f
+		}
+
+		isCache := cachetag.Filter()
+		return func(fi *fio.Info) (bool, error) {
+			if skip, err := f(fi); skip || err != nil {
+				return skip, err
+			}
+			return isCache(fi)
+		}
+	}
+	opt.Filter = filter(args)
+
+	rep := report.New(os.Args[0], jsonErrs)
+
+	var live *liveFiles
+	if skipOpen {
+		live = newLiveFiles()
+	}
+
+	// subcommands
+	switch args[0] {
+	case "index", "query":
+		if len(db) == 0 {
+			Die("%s needs --db", args[0])
+		}
+		if stream || lowmem || fuzzy || clone || sameFS || scanArchives || samples != nil || len(emit) > 0 {
+			Die("%s can't be used with --stream, --low-memory, --fuzzy, --reflink, --same-fs-groups, --scan-archives, --sample-bytes or --emit-manifest", args[0])
+		}
+
+		if args[0] == "index" {
+			if len(args) < 2 {
+				Die("index: Insufficient args. Try %s --help", Z)
+			}
+			err = doIndex(db, args[1:], opt, filter, media, live, rep)
+		} else {
+			if len(args) > 1 {
+				Die("query takes no args")
+			}
+			if dupes && len(queryOf) > 0 {
+				Die("--dupes can't be used with --of")
+			}
+			err = doQuery(db, queryOf, ord, shell, media)
+			// like grep, nothing found isn't an error
+			if errors.Is(err, errNoDupes) {
+				Warn("%s", err)
+				Exit(1)
+			}
+		}
+
+		if errors.Is(err, errIndexMode) {
+			Die("%s", err)
+		}
+		rep.Error(err)
+		Exit(rep.Code())
+
+	default:
+		if len(db) > 0 || dupes || len(queryOf) > 0 {
+			Die("--db, --dupes and --of only work with the index and query commands")
+		}
+	}
+
+	if lowmem {
+		if stream || fuzzy || len(emit) > 0 {
+			Die("--low-memory can't be used with --stream, --fuzzy or --emit-manifest")
+		}
+		if err := findLowMem(args, &opt, tmpdir, ord, shell, clone, sameFS, sampleN, live); err != nil {
+			rep.Error(err)
+			Exit(rep.Code())
+		}
+		return
+	}
+
+	var mf *manifest
+	if len(emit) > 0 {
+		if mf, err = newManifest(emit); err != nil {
+			rep.Error(err)
+			Exit(rep.Code())
+		}
+	}
+
+	var sim similar
 	dups := xsync.NewMapOf[string, *[]*fio.Info]()
-	err := walk.WalkFunc(args, opt, func(fi *fio.Info) error {
-		nm := fi.Path()
-		cs, err := checksum(nm)
-		if err != nil {
-			return err
+	group := func(fi *fio.Info, sum string) {
+		if st != nil {
+			st.add(fi.Path(), sum)
+			return
 		}
 
-		sum := fmt.Sprintf("%x", cs)
-		empty := []*fio.Info{}
-		x, _ := dups.LoadOrStore(sum, &empty)
-		*x = append(*x, fi)
-		return nil
-	})
+		dups.Compute(sum, func(v *[]*fio.Info, ok bool) (*[]*fio.Info, bool) {
+			if !ok {
+				v = &[]*fio.Info{}
+			}
+			*v = append(*v, fi)
+			return v, false
+		})
+	}
+
+	if remote {
+		err = findURLs(args, excl, !noETags, group)
+	} else {
+		err = walk.WalkFunc(args, opt, func(fi *fio.Info) error {
+			nm := fi.Path()
+			if live.busy(fi) {
+				return nil
+			}
+			if samples.wants(fi) {
+				return samples.add(fi)
+			}
+
+			sum, err := fileSum(nm, media)
+			if err != nil {
+				return err
+			}
+			if live.changed(fi) {
+				return nil
+			}
+
+			if mf != nil {
+				mf.add(nm, fi.Size(), sum)
+			}
+			if fuzzy && isImage(nm) {
+				// undecodable images are not fatal
+				if err := sim.add(fi, sum); err != nil {
+					Warn("%s", err)
+				}
+			}
+
+			group(fi, sum)
+			if scanArchives {
+				return scanArchive(nm, group)
+			}
+			return nil
+		})
+	}
 
 	if err != nil {
-		Die("%s", err)
+		rep.Error(err)
+		Exit(rep.Code())
+	}
+
+	if mf != nil {
+		if err := mf.close(); err != nil {
+			rep.Error(err)
+			Exit(rep.Code())
+		}
+	}
+
+	if st != nil {
+		st.close()
+	}
+
+	// the sampled files that are duplicates join the rest
+	if samples != nil {
+		err = samples.each(live, func(k string, v []*fio.Info) {
+			dups.Store(k, &v)
+		})
+		if err != nil {
+			rep.Error(err)
+			Exit(rep.Code())
+		}
 	}
 
 	dups.Range(func(k string, pv *[]*fio.Info) bool {
@@ -96,22 +518,79 @@ Options:
 			return true
 		}
 
-		sort.Sort(byMtime(v))
-
-		fmt.Printf("\n# %s\n", k)
-		if shell {
-			fmt.Printf("# rm -f '%s'\n", v[0].Path())
-			for _, r := range v[1:] {
-				fmt.Printf("rm -f '%s'\n", r.Path())
+		ord.sort(v)
+		for _, g := range splitGroup(k, v, sameFS) {
+			printGroup(g.key, g.v, shell)
+			if clone {
+				if err := reflinkGroup(g.v); err != nil {
+					Warn("%s", err)
+				}
 			}
-		} else {
-			fmt.Printf("    %s\n", names(v))
 		}
-
 		return true
 	})
+
+	if fuzzy {
+		for _, v := range sim.groups(fuzzDist) {
+			ord.sort(v)
+			fmt.Printf("\n# similar images\n")
+			if shell {
+				for _, r := range v {
+					fmt.Printf("# '%s'\n", r.Path())
+				}
+			} else {
+				fmt.Printf("    %s\n", names(v))
+			}
+		}
+	}
+}
+
+// fileSum returns the hex checksum of file 'fn'; with 'media', media
+// files have the checksum of just their payload.
+func fileSum(fn string, media bool) (string, error) {
+	if media {
+		if ms, ok := mediaChecksum(fn); ok {
+			return mediaKey(ms), nil
+		}
+	}
+
+	cs, err := checksum(fn)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", cs), nil
+}
+
+// print a sorted group of identical files with checksum 'k'
+func printGroup(k string, v []*fio.Info, shell bool) {
+	var more int
+	if maxMembers > 0 && len(v) > maxMembers {
+		v, more = v[:maxMembers], len(v)-maxMembers
+	}
+
+	fmt.Printf("\n# %s\n", k)
+	if shell {
+		fmt.Printf("# rm -f '%s'\n", v[0].Path())
+		for _, r := range v[1:] {
+			if isProtected(r.Path()) {
+				fmt.Printf("# protected: rm -f '%s'\n", r.Path())
+				continue
+			}
+			fmt.Printf("rm -f '%s'\n", r.Path())
+		}
+	} else {
+		fmt.Printf("    %s\n", names(v))
+	}
+
+	if more > 0 {
+		fmt.Printf("# %d more...\n", more)
+	}
 }
 
+// if non-zero, each group lists at most this many files followed by a
+// count of the rest; set by --max-group-members.
+var maxMembers int
+
 func names(v []*fio.Info) string {
 	var b strings.Builder
 
@@ -131,6 +610,9 @@ func hasher() hash.Hash {
 	if err != nil {
 		panic(fmt.Sprintf("blake3: %s", err))
 	}
+	if bwlimit != nil {
+		return &throttledHash{h, bwlimit}
+	}
 	return h
 }
 
@@ -138,7 +620,7 @@ func hasher() hash.Hash {
 func checksum(fn string) ([]byte, error) {
 	fd, err := os.Open(fn)
 	if err != nil {
-		return nil, fmt.Errorf("%s: %s", fn, err)
+		return nil, err
 	}
 
 	defer fd.Close()
@@ -148,27 +630,11 @@ func checksum(fn string) ([]byte, error) {
 		h.Write(buf)
 		return nil
 	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
 
-	return h.Sum(nil)[:], err
-}
-
-type byMtime []*fio.Info
-
-func (r byMtime) Len() int {
-	return len(r)
-}
-
-func (r byMtime) Swap(i, j int) {
-	r[i], r[j] = r[j], r[i]
-}
-
-func (r byMtime) Less(i, j int) bool {
-	a, b := r[i], r[j]
-
-	x := a.ModTime().Compare(b.ModTime())
-
-	// we want to keep the most recent mtime at the top.
-	return x > 0
+	return h.Sum(nil)[:], nil
 }
 
 // This will be filled in by "build"
@@ -0,0 +1,50 @@
+// fsgroup.go - split duplicate groups by the device they're on
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/opencoff/go-fio"
+)
+
+// a group of identical files; 'key' is the header it's printed with
+type dupGroup struct {
+	key string
+	v   []*fio.Info
+}
+
+// splitGroup returns the sorted group 'v' with checksum 'k' as is or -
+// with 'sameFS' - as one group per device: only files on the same file
+// system can be hard linked or cloned. A file without a duplicate on
+// its own device isn't in any group.
+func splitGroup(k string, v []*fio.Info, sameFS bool) []dupGroup {
+	if !sameFS {
+		return []dupGroup{{k, v}}
+	}
+
+	// each sub-group keeps the order of 'v'
+	devs := make(map[uint64][]*fio.Info)
+	for _, fi := range v {
+		devs[fi.Dev] = append(devs[fi.Dev], fi)
+	}
+
+	keys := make([]uint64, 0, len(devs))
+	for d, dv := range devs {
+		if len(dv) > 1 {
+			keys = append(keys, d)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+
+	g := make([]dupGroup, 0, len(keys))
+	for _, d := range keys {
+		g = append(g, dupGroup{fmt.Sprintf("%s dev %#x", k, d), devs[d]})
+	}
+	return g
+}
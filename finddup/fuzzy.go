@@ -0,0 +1,172 @@
+// fuzzy.go - perceptual hashing to find near-duplicate images
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/opencoff/go-fio"
+)
+
+// image formats we can decode with the stdlib
+var imageExt = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+}
+
+type phash struct {
+	fi   *fio.Info
+	sum  string
+	hash uint64
+}
+
+// similar collects the perceptual hashes of all images seen in the walk
+type similar struct {
+	sync.Mutex
+	v []phash
+}
+
+func isImage(nm string) bool {
+	return imageExt[strings.ToLower(filepath.Ext(nm))]
+}
+
+// add computes the dhash of 'fi' and records it; 'sum' is the
+// strong checksum of the file.
+func (s *similar) add(fi *fio.Info, sum string) error {
+	h, err := dhash(fi.Path())
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	s.v = append(s.v, phash{fi, sum, h})
+	s.Unlock()
+	return nil
+}
+
+// groups returns sets of images whose dhash differ by at most 'dist'
+// bits. Sets made entirely of byte-identical files are left out;
+// they're already reported as exact duplicates.
+func (s *similar) groups(dist int) [][]*fio.Info {
+	n := len(s.v)
+	uf := make([]int, n)
+	for i := range uf {
+		uf[i] = i
+	}
+
+	var find func(i int) int
+	find = func(i int) int {
+		if uf[i] != i {
+			uf[i] = find(uf[i])
+		}
+		return uf[i]
+	}
+
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			if bits.OnesCount64(s.v[i].hash^s.v[j].hash) <= dist {
+				uf[find(i)] = find(j)
+			}
+		}
+	}
+
+	sets := make(map[int][]int)
+	for i := range s.v {
+		r := find(i)
+		sets[r] = append(sets[r], i)
+	}
+
+	var g [][]*fio.Info
+	for _, idx := range sets {
+		if len(idx) < 2 {
+			continue
+		}
+
+		sums := make(map[string]bool)
+		v := make([]*fio.Info, 0, len(idx))
+		for _, i := range idx {
+			sums[s.v[i].sum] = true
+			v = append(v, s.v[i].fi)
+		}
+		if len(sums) > 1 {
+			g = append(g, v)
+		}
+	}
+
+	sort.Slice(g, func(i, j int) bool {
+		return g[i][0].Path() < g[j][0].Path()
+	})
+	return g
+}
+
+// dhash computes the 64-bit difference hash of an image: the image
+// is scaled to 9x8 grayscale and each bit records whether a pixel is
+// brighter than its right neighbour. Resized or re-encoded copies of
+// an image yield hashes that differ in only a few bits.
+func dhash(fn string) (uint64, error) {
+	fd, err := os.Open(fn)
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+
+	img, _, err := image.Decode(fd)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	const W, H = 9, 8
+	var g [H][W]uint64
+
+	// box-filter the image into a WxH grid of average luminance
+	b := img.Bounds()
+	dx, dy := b.Dx(), b.Dy()
+	if dx == 0 || dy == 0 {
+		return 0, fmt.Errorf("%s: empty image", fn)
+	}
+
+	for y := 0; y < H; y++ {
+		y0 := b.Min.Y + y*dy/H
+		y1 := max(b.Min.Y+(y+1)*dy/H, y0+1)
+		for x := 0; x < W; x++ {
+			x0 := b.Min.X + x*dx/W
+			x1 := max(b.Min.X+(x+1)*dx/W, x0+1)
+
+			var sum, n uint64
+			for py := y0; py < y1; py++ {
+				for px := x0; px < x1; px++ {
+					r, gg, bb, _ := img.At(px, py).RGBA()
+					sum += (299*uint64(r) + 587*uint64(gg) + 114*uint64(bb)) / 1000
+					n++
+				}
+			}
+			g[y][x] = sum / n
+		}
+	}
+
+	var h uint64
+	for y := 0; y < H; y++ {
+		for x := 0; x < W-1; x++ {
+			h <<= 1
+			if g[y][x] > g[y][x+1] {
+				h |= 1
+			}
+		}
+	}
+	return h, nil
+}
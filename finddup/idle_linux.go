@@ -0,0 +1,48 @@
+// idle_linux.go - run in the idle I/O class
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// see ioprio_set(2)
+const (
+	_IOPRIO_WHO_PROCESS = 1
+	_IOPRIO_CLASS_IDLE  = 3
+	_IOPRIO_CLASS_SHIFT = 13
+)
+
+// idleIO puts every thread of the process in the idle I/O class and
+// at the lowest CPU priority; the priorities apply per thread and new
+// threads inherit them from the thread that starts them.
+func idleIO() error {
+	tids, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+
+	prio := uintptr(_IOPRIO_CLASS_IDLE << _IOPRIO_CLASS_SHIFT)
+	for _, t := range tids {
+		tid, err := strconv.Atoi(t.Name())
+		if err != nil {
+			continue
+		}
+
+		_, _, e := syscall.Syscall(syscall.SYS_IOPRIO_SET, _IOPRIO_WHO_PROCESS, uintptr(tid), prio)
+		if e != 0 {
+			return os.NewSyscallError("ioprio_set", e)
+		}
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, 19); err != nil {
+			return os.NewSyscallError("setpriority", err)
+		}
+	}
+	return nil
+}
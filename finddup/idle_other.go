@@ -0,0 +1,17 @@
+// idle_other.go - run in the idle I/O class
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+// idleIO isn't supported on this platform
+func idleIO() error {
+	return errors.New("idle I/O priority isn't supported on this platform")
+}
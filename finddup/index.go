@@ -0,0 +1,300 @@
+// index.go - a persistent index of checksums and queries against it
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-progs/internal/report"
+
+	"github.com/opencoff/go-fio"
+	"github.com/opencoff/go-fio/walk"
+)
+
+// The index is a text file; after the header each line is a record:
+//
+//	sum|size|mtime|ctime|path
+//
+// The times are in nanoseconds since the epoch and the paths are
+// absolute; so the index can be queried from anywhere. The header ends
+// in _IndexMedia if the sums are of the media content.
+const (
+	_IndexHdr   = "#!finddup-index 1"
+	_IndexMedia = "media"
+)
+
+// the index sums and --media-content disagree
+var errIndexMode = errors.New("index mode mismatch")
+
+type indexEntry struct {
+	sum   string
+	size  int64
+	mtime int64
+	ctime int64
+}
+
+// index maps the absolute path of each file to its entry
+type index map[string]*indexEntry
+
+// loadIndex reads the index in 'fn' that was made with --media-content
+// if 'media' is true; a missing index is empty.
+func loadIndex(fn string, media bool) (index, error) {
+	idx := make(index)
+
+	fd, err := os.Open(fn)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return idx, nil
+		}
+		return nil, err
+	}
+	defer fd.Close()
+
+	rd := bufio.NewScanner(fd)
+	rd.Buffer(make([]byte, 0, 65536), 1024*1024)
+	if !rd.Scan() || !strings.HasPrefix(rd.Text(), _IndexHdr) {
+		return nil, fmt.Errorf("%s: not a finddup index", fn)
+	}
+
+	// the version comes first
+	var withMedia bool
+	if v := strings.Fields(rd.Text()[len(_IndexHdr):]); len(v) > 1 {
+		withMedia = v[len(v)-1] == _IndexMedia
+	}
+	switch {
+	case withMedia && !media:
+		return nil, fmt.Errorf("%s: %w; it's of the media content, use --media-content", fn, errIndexMode)
+	case !withMedia && media:
+		return nil, fmt.Errorf("%s: %w; it's of the whole files, use it without --media-content", fn, errIndexMode)
+	}
+
+	for n := 2; rd.Scan(); n++ {
+		v := strings.SplitN(rd.Text(), "|", 5)
+		if len(v) != 5 {
+			return nil, fmt.Errorf("%s: %d: malformed record", fn, n)
+		}
+
+		var e indexEntry
+		var err [3]error
+		e.sum = v[0]
+		e.size, err[0] = strconv.ParseInt(v[1], 10, 64)
+		e.mtime, err[1] = strconv.ParseInt(v[2], 10, 64)
+		e.ctime, err[2] = strconv.ParseInt(v[3], 10, 64)
+		if err := errors.Join(err[:]...); err != nil {
+			return nil, fmt.Errorf("%s: %d: malformed record: %w", fn, n, err)
+		}
+		idx[v[4]] = &e
+	}
+	if err := rd.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	return idx, nil
+}
+
+// save writes the index to 'fn'; it's replaced only if all of it is
+// written. 'media' is true if the sums are of the media content.
+func (idx index) save(fn string, media bool) error {
+	fd, err := fio.NewSafeFile(fn, fio.OPT_OVERWRITE, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	AtExit(fd.Abort)
+	defer fd.Abort()
+
+	names := make([]string, 0, len(idx))
+	for nm := range idx {
+		names = append(names, nm)
+	}
+	sort.Strings(names)
+
+	wr := bufio.NewWriter(fd)
+	hdr := fmt.Sprintf("%s %s", _IndexHdr, ProductVersion)
+	if media {
+		hdr += " " + _IndexMedia
+	}
+	fmt.Fprintf(wr, "%s\n", hdr)
+	for _, nm := range names {
+		e := idx[nm]
+		fmt.Fprintf(wr, "%s|%d|%d|%d|%s\n", e.sum, e.size, e.mtime, e.ctime, nm)
+	}
+	if err := wr.Flush(); err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+	return fd.Close()
+}
+
+// info returns the entry for 'nm' as if it were just walked; so the
+// duplicate groups can be ordered and printed as usual.
+func (e *indexEntry) info(nm string) *fio.Info {
+	fi := &fio.Info{
+		Siz:  e.size,
+		Mod:  0644,
+		Mtim: time.Unix(0, e.mtime),
+		Ctim: time.Unix(0, e.ctime),
+	}
+	fi.SetPath(nm)
+	return fi
+}
+
+// doIndex walks 'dirs' and records the checksum of every file in the
+// index 'db'. Files whose size and times haven't changed since they
+// were last indexed aren't hashed again. The entries under 'dirs' that
+// no longer exist are dropped; the rest of the index is kept - so the
+// index can be built up one dir at a time. Files that can't be read are
+// reported to 'rep' and left out.
+func doIndex(db string, dirs []string, opt walk.Options, filter func([]string) func(*fio.Info) (bool, error), media bool, live *liveFiles, rep *report.Reporter) error {
+	old, err := loadIndex(db, media)
+	if err != nil {
+		return err
+	}
+
+	roots := make([]string, 0, len(dirs))
+	for _, nm := range dirs {
+		a, err := filepath.Abs(nm)
+		if err != nil {
+			return err
+		}
+		roots = append(roots, a)
+	}
+
+	idx := make(index)
+	for nm, e := range old {
+		if !underAny(nm, roots) {
+			idx[nm] = e
+		}
+	}
+
+	// the globs are relative to the dirs walked
+	opt.Filter = filter(roots)
+
+	var mu sync.Mutex
+	err = walk.WalkFunc(roots, opt, func(fi *fio.Info) error {
+		nm := fi.Path()
+		if strings.ContainsRune(nm, '\n') {
+			Warn("%q: skipping name with a newline", nm)
+			return nil
+		}
+		if live.busy(fi) {
+			return nil
+		}
+
+		e := &indexEntry{
+			size:  fi.Size(),
+			mtime: fi.Mtim.UnixNano(),
+			ctime: fi.Ctim.UnixNano(),
+		}
+
+		if o, ok := old[nm]; ok && o.size == e.size && o.mtime == e.mtime && o.ctime == e.ctime {
+			e.sum = o.sum
+		} else {
+			sum, err := fileSum(nm, media)
+			if err != nil {
+				rep.Error(err)
+				return nil
+			}
+			if live.changed(fi) {
+				return nil
+			}
+			e.sum = sum
+		}
+
+		mu.Lock()
+		idx[nm] = e
+		mu.Unlock()
+		return nil
+	})
+
+	// an incomplete walk would drop the entries we couldn't read
+	if err != nil {
+		return err
+	}
+	return idx.save(db, media)
+}
+
+var errNoDupes = errors.New("no duplicates")
+
+// doQuery prints the duplicate groups in the index 'db'; with 'of',
+// just the files identical to it.
+func doQuery(db, of string, ord *order, shell, media bool) error {
+	idx, err := loadIndex(db, media)
+	if err != nil {
+		return err
+	}
+	if len(idx) == 0 {
+		return fmt.Errorf("%s: empty index", db)
+	}
+
+	groups := make(map[string][]*fio.Info)
+	for nm, e := range idx {
+		groups[e.sum] = append(groups[e.sum], e.info(nm))
+	}
+
+	if len(of) == 0 {
+		keys := make([]string, 0, len(groups))
+		for k, v := range groups {
+			if len(v) > 1 {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			v := groups[k]
+			ord.sort(v)
+			printGroup(k, v, shell)
+		}
+		return nil
+	}
+
+	// a file that's indexed needn't be hashed again
+	nm, err := filepath.Abs(of)
+	if err != nil {
+		return err
+	}
+
+	var sum string
+	if e, ok := idx[nm]; ok {
+		sum = e.sum
+	} else if sum, err = fileSum(of, media); err != nil {
+		return err
+	}
+
+	var v []*fio.Info
+	for _, fi := range groups[sum] {
+		if fi.Path() != nm {
+			v = append(v, fi)
+		}
+	}
+	if len(v) == 0 {
+		return fmt.Errorf("%s: %w in %s", of, errNoDupes, db)
+	}
+
+	ord.sort(v)
+	for _, fi := range v {
+		fmt.Printf("%s\n", fi.Path())
+	}
+	return nil
+}
+
+// return true if 'nm' is one of 'dirs' or is below one of them
+func underAny(nm string, dirs []string) bool {
+	for _, d := range dirs {
+		d = strings.TrimSuffix(d, "/")
+		if nm == d || strings.HasPrefix(nm, d+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+// live.go - skip files that are being written during the scan
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"github.com/opencoff/go-fio"
+)
+
+type fileID struct {
+	dev, ino uint64
+}
+
+// liveFiles tracks the files that mustn't be reported: those open for
+// writing when the scan started and those whose size or times change
+// while they're hashed. A nil liveFiles skips nothing.
+type liveFiles struct {
+	open map[fileID]bool
+}
+
+func newLiveFiles() *liveFiles {
+	l := &liveFiles{
+		open: openForWrite(),
+	}
+	return l
+}
+
+// busy returns true if 'fi' was open for writing when we started
+func (l *liveFiles) busy(fi *fio.Info) bool {
+	if l == nil {
+		return false
+	}
+	if l.open[fileID{fi.Dev, fi.Ino}] {
+		Warn("%s: skipping; open for writing", fi.Path())
+		return true
+	}
+	return false
+}
+
+// changed returns true if 'fi' no longer matches the file on disk; it
+// must be called after the file is hashed.
+func (l *liveFiles) changed(fi *fio.Info) bool {
+	if l == nil {
+		return false
+	}
+
+	now, err := fio.Stat(fi.Path())
+	if err != nil || now.Size() != fi.Size() || !now.Mtim.Equal(fi.Mtim) || !now.Ctim.Equal(fi.Ctim) {
+		Warn("%s: skipping; modified while it was hashed", fi.Path())
+		return true
+	}
+	return false
+}
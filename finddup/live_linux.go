@@ -0,0 +1,72 @@
+// live_linux.go - files open for writing by any process
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// openForWrite returns the files open for writing by the processes we
+// can see in /proc; run as root to see all of them.
+func openForWrite() map[fileID]bool {
+	m := make(map[fileID]bool)
+
+	pids, _ := filepath.Glob("/proc/[0-9]*")
+	for _, p := range pids {
+		fds, err := os.ReadDir(p + "/fd")
+		if err != nil {
+			continue
+		}
+
+		for _, de := range fds {
+			fd := de.Name()
+			if !writable(p + "/fdinfo/" + fd) {
+				continue
+			}
+
+			var st syscall.Stat_t
+			if err := syscall.Stat(p+"/fd/"+fd, &st); err != nil {
+				continue
+			}
+			if st.Mode&syscall.S_IFMT == syscall.S_IFREG {
+				m[fileID{uint64(st.Dev), st.Ino}] = true
+			}
+		}
+	}
+	return m
+}
+
+// return true if the fd described by 'fdinfo' is open for writing
+func writable(fdinfo string) bool {
+	fd, err := os.Open(fdinfo)
+	if err != nil {
+		return false
+	}
+	defer fd.Close()
+
+	sc := bufio.NewScanner(fd)
+	for sc.Scan() {
+		v, ok := strings.CutPrefix(sc.Text(), "flags:")
+		if !ok {
+			continue
+		}
+
+		fl, err := strconv.ParseUint(strings.TrimSpace(v), 8, 32)
+		if err != nil {
+			return false
+		}
+		acc := fl & syscall.O_ACCMODE
+		return acc == syscall.O_WRONLY || acc == syscall.O_RDWR
+	}
+	return false
+}
@@ -0,0 +1,14 @@
+// live_other.go - platforms without /proc
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build !linux
+
+package main
+
+// openForWrite can't tell which files are open; so --skip-open only
+// skips the files modified while they're hashed.
+func openForWrite() map[fileID]bool {
+	return nil
+}
@@ -0,0 +1,284 @@
+// lowmem.go - memory-bounded duplicate detection for huge corpora
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/opencoff/go-fio"
+	"github.com/opencoff/go-fio/walk"
+)
+
+// Number of on-disk buckets; the first pass spills (size, name)
+// records into these - grouped by size. The second pass loads one
+// bucket at a time; so peak memory is roughly 1/_Buckets of the index.
+const _Buckets = 256
+
+type bucket struct {
+	sync.Mutex
+	fd *os.File
+	wr *bufio.Writer
+}
+
+// spill is the on-disk index of the first pass
+type spill struct {
+	dir string
+	b   [_Buckets]bucket
+}
+
+func newSpill(tmpdir string) (*spill, error) {
+	dir, err := os.MkdirTemp(tmpdir, "finddup")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &spill{dir: dir}
+	for i := range s.b {
+		fn := fmt.Sprintf("%s/%03d", dir, i)
+		fd, err := os.OpenFile(fn, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0600)
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+
+		b := &s.b[i]
+		b.fd = fd
+		b.wr = bufio.NewWriterSize(fd, 16384)
+	}
+	return s, nil
+}
+
+// add records a file of 'sz' bytes; safe for concurrent use
+func (s *spill) add(nm string, sz int64) error {
+	var hdr [2 * binary.MaxVarintLen64]byte
+
+	p := binary.AppendUvarint(hdr[:0], uint64(sz))
+	p = binary.AppendUvarint(p, uint64(len(nm)))
+
+	b := &s.b[bucketOf(sz)]
+	b.Lock()
+	defer b.Unlock()
+
+	if _, err := b.wr.Write(p); err != nil {
+		return fmt.Errorf("index: %w", err)
+	}
+	if _, err := b.wr.WriteString(nm); err != nil {
+		return fmt.Errorf("index: %w", err)
+	}
+	return nil
+}
+
+// each calls 'fp' with the names of every group of same-sized files;
+// it must be called after all the adds are done.
+func (s *spill) each(fp func(names []string) error) error {
+	for i := range s.b {
+		b := &s.b[i]
+		if err := b.wr.Flush(); err != nil {
+			return fmt.Errorf("index: %w", err)
+		}
+		if _, err := b.fd.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("index: %w", err)
+		}
+
+		bysz, err := readBucket(bufio.NewReader(b.fd))
+		if err != nil {
+			return fmt.Errorf("index %s: %w", b.fd.Name(), err)
+		}
+
+		for _, v := range bysz {
+			if len(v) < 2 {
+				continue
+			}
+			if err := fp(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *spill) close() {
+	for i := range s.b {
+		if fd := s.b[i].fd; fd != nil {
+			fd.Close()
+		}
+	}
+	os.RemoveAll(s.dir)
+}
+
+func readBucket(rd *bufio.Reader) (map[int64][]string, error) {
+	bysz := make(map[int64][]string)
+	for {
+		sz, err := binary.ReadUvarint(rd)
+		if err == io.EOF {
+			return bysz, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := binary.ReadUvarint(rd)
+		if err != nil {
+			return nil, err
+		}
+
+		nm := make([]byte, n)
+		if _, err := io.ReadFull(rd, nm); err != nil {
+			return nil, err
+		}
+
+		k := int64(sz)
+		bysz[k] = append(bysz[k], string(nm))
+	}
+}
+
+// spread sizes evenly across the buckets
+func bucketOf(sz int64) int {
+	return int((uint64(sz) * 0x9e3779b97f4a7c15) >> 56)
+}
+
+// hashAll checksums 'names' in parallel and groups them by their sum;
+// the files skipped by 'live' are left out.
+func hashAll(names []string, live *liveFiles) (map[string][]string, error) {
+	type res struct {
+		nm, sum string
+		err     error
+		skip    bool
+	}
+
+	ch := make(chan string)
+	rch := make(chan res)
+
+	var wg sync.WaitGroup
+	n := min(runtime.NumCPU(), len(names))
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			for nm := range ch {
+				r := res{nm: nm}
+
+				// the pass that found 'nm' recorded only its size
+				var fi *fio.Info
+				if live != nil {
+					if fi, r.err = fio.Stat(nm); r.err == nil {
+						r.skip = live.busy(fi)
+					}
+				}
+
+				if r.err == nil && !r.skip {
+					var cs []byte
+					cs, r.err = checksum(nm)
+					r.sum = fmt.Sprintf("%x", cs)
+					r.skip = r.err == nil && live.changed(fi)
+				}
+				rch <- r
+			}
+			wg.Done()
+		}()
+	}
+
+	go func() {
+		for _, nm := range names {
+			ch <- nm
+		}
+		close(ch)
+		wg.Wait()
+		close(rch)
+	}()
+
+	var err error
+	bysum := make(map[string][]string)
+	for r := range rch {
+		if r.err != nil {
+			if err == nil {
+				err = r.err
+			}
+			continue
+		}
+		if r.skip {
+			continue
+		}
+		bysum[r.sum] = append(bysum[r.sum], r.nm)
+	}
+	return bysum, err
+}
+
+// findLowMem does a two pass scan: the first records only the name &
+// size of every file in an on-disk index; the second hashes just the
+// files whose sizes collide - and if 'sample' is non-zero, whose
+// samples of that many bytes collide too.
+func findLowMem(args []string, opt *walk.Options, tmpdir string, ord *order, shell, clone, sameFS bool, sample int64, live *liveFiles) error {
+	sp, err := newSpill(tmpdir)
+	if err != nil {
+		return err
+	}
+
+	// we can't defer the cleanup if we Die()
+	AtExit(sp.close)
+	defer sp.close()
+
+	err = walk.WalkFunc(args, *opt, func(fi *fio.Info) error {
+		return sp.add(fi.Path(), fi.Size())
+	})
+	if err != nil {
+		return err
+	}
+
+	return sp.each(func(names []string) error {
+		groups := [][]string{names}
+		if sample > 0 {
+			if groups, err = sampleGroups(names, sample); err != nil {
+				return err
+			}
+		}
+
+		for _, g := range groups {
+			if err := findGroups(g, ord, shell, clone, sameFS, live); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// findGroups hashes 'names' and prints the duplicate groups among them
+func findGroups(names []string, ord *order, shell, clone, sameFS bool, live *liveFiles) error {
+	bysum, err := hashAll(names, live)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range bysum {
+		if len(v) < 2 {
+			continue
+		}
+
+		fv := make([]*fio.Info, 0, len(v))
+		for _, nm := range v {
+			fi, err := fio.Lstat(nm)
+			if err != nil {
+				return err
+			}
+			fv = append(fv, fi)
+		}
+
+		ord.sort(fv)
+		for _, g := range splitGroup(k, fv, sameFS) {
+			printGroup(g.key, g.v, shell)
+			if clone {
+				if err := reflinkGroup(g.v); err != nil {
+					Warn("%s", err)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+// manifest.go - record every digest in a ghash compatible manifest
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/opencoff/go-fio"
+)
+
+// Our checksum is blake3 with an all-zero key - the same as ghash's
+// "blake3"; so 'ghash -v' can verify the manifest.
+const _ManifestHdr = "#!ghash blake3"
+
+// manifest is written by concurrent walkers; the first write error
+// sticks and is returned by close().
+type manifest struct {
+	sync.Mutex
+	fn  string
+	fd  *fio.SafeFile
+	wr  *bufio.Writer
+	err error
+}
+
+func newManifest(fn string) (*manifest, error) {
+	fd, err := fio.NewSafeFile(fn, fio.OPT_OVERWRITE, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &manifest{
+		fn: fn,
+		fd: fd,
+		wr: bufio.NewWriter(fd),
+	}
+
+	AtExit(fd.Abort)
+	_, m.err = fmt.Fprintf(m.wr, "%s %s\n", _ManifestHdr, ProductVersion)
+	return m, nil
+}
+
+func (m *manifest) add(nm string, sz int64, sum string) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.err == nil {
+		_, m.err = fmt.Fprintf(m.wr, "%s|%d|%s\n", sum, sz, nm)
+	}
+}
+
+// close commits the manifest if there were no errors
+func (m *manifest) close() error {
+	if m.err == nil {
+		m.err = m.wr.Flush()
+	}
+	if m.err != nil {
+		m.fd.Abort()
+		return fmt.Errorf("%s: %w", m.fn, m.err)
+	}
+	return m.fd.Close()
+}
@@ -0,0 +1,270 @@
+// media.go - hash the payload of media files ignoring their tags
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var errNotMedia = errors.New("not a recognized media file")
+
+// payload hashers for each media format
+var mediaExt = map[string]func(h hash.Hash, fd *os.File, sz int64) error{
+	".jpg":  jpegPayload,
+	".jpeg": jpegPayload,
+	".png":  pngPayload,
+	".mp3":  mp3Payload,
+	".flac": flacPayload,
+}
+
+// mediaChecksum returns the checksum of just the pixels or audio frames
+// of 'fn' - so that the same photo or track with different tags has the
+// same checksum. It returns false if 'fn' isn't a media file we can
+// parse; the caller must then use the checksum of the whole file.
+func mediaChecksum(fn string) ([]byte, bool) {
+	payload, ok := mediaExt[strings.ToLower(filepath.Ext(fn))]
+	if !ok {
+		return nil, false
+	}
+
+	fd, err := os.Open(fn)
+	if err != nil {
+		return nil, false
+	}
+	defer fd.Close()
+
+	st, err := fd.Stat()
+	if err != nil {
+		return nil, false
+	}
+
+	h := hasher()
+	if err := payload(h, fd, st.Size()); err != nil {
+		return nil, false
+	}
+	return h.Sum(nil), true
+}
+
+// JPEG: every segment except APPn (EXIF, XMP, ICC etc.) and comments;
+// the entropy coded data follows the first SOS.
+func jpegPayload(h hash.Hash, fd *os.File, sz int64) error {
+	rd := bufio.NewReader(fd)
+
+	var soi [2]byte
+	if _, err := io.ReadFull(rd, soi[:]); err != nil || soi != [2]byte{0xff, 0xd8} {
+		return errNotMedia
+	}
+	h.Write(soi[:])
+
+	for {
+		b, err := rd.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b != 0xff {
+			return errNotMedia
+		}
+
+		// skip fill bytes
+		m := byte(0xff)
+		for m == 0xff {
+			if m, err = rd.ReadByte(); err != nil {
+				return err
+			}
+		}
+
+		switch {
+		case m == 0xda:
+			h.Write([]byte{0xff, m})
+			_, err := io.Copy(h, rd)
+			return err
+
+		case m == 0xd9:
+			h.Write([]byte{0xff, m})
+			return nil
+
+		case m == 0x01 || (m >= 0xd0 && m <= 0xd7):
+			h.Write([]byte{0xff, m})
+			continue
+		}
+
+		var lb [2]byte
+		if _, err := io.ReadFull(rd, lb[:]); err != nil {
+			return err
+		}
+		n := int64(binary.BigEndian.Uint16(lb[:]))
+		if n < 2 {
+			return errNotMedia
+		}
+
+		if (m >= 0xe0 && m <= 0xef) || m == 0xfe {
+			if _, err := rd.Discard(int(n - 2)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		h.Write([]byte{0xff, m})
+		h.Write(lb[:])
+		if _, err := io.CopyN(h, rd, n-2); err != nil {
+			return err
+		}
+	}
+}
+
+// PNG: just the critical chunks (IHDR, PLTE, IDAT, IEND); ancillary
+// chunks hold text, timestamps, EXIF etc.
+func pngPayload(h hash.Hash, fd *os.File, sz int64) error {
+	rd := bufio.NewReader(fd)
+
+	var sig [8]byte
+	if _, err := io.ReadFull(rd, sig[:]); err != nil || string(sig[:]) != "\x89PNG\r\n\x1a\n" {
+		return errNotMedia
+	}
+
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(rd, hdr[:]); err != nil {
+			return err
+		}
+
+		n := int64(binary.BigEndian.Uint32(hdr[:4]))
+		typ := hdr[4:]
+
+		// ancillary chunks have a lower case first letter; skip
+		// the data and crc.
+		if typ[0]&0x20 != 0 {
+			if _, err := io.CopyN(io.Discard, rd, n+4); err != nil {
+				return err
+			}
+			continue
+		}
+
+		h.Write(typ)
+		if _, err := io.CopyN(h, rd, n); err != nil {
+			return err
+		}
+		if _, err := rd.Discard(4); err != nil {
+			return err
+		}
+		if string(typ) == "IEND" {
+			return nil
+		}
+	}
+}
+
+// MP3: the frames between a leading ID3v2 tag and trailing APEv2 and
+// ID3v1 tags.
+func mp3Payload(h hash.Hash, fd *os.File, sz int64) error {
+	start, end := int64(0), sz
+
+	var hdr [10]byte
+	if _, err := fd.ReadAt(hdr[:], 0); err != nil {
+		return errNotMedia
+	}
+
+	if string(hdr[:3]) == "ID3" {
+		n := syncsafe(hdr[6:10])
+		start = 10 + n
+		if hdr[5]&0x10 != 0 {
+			start += 10
+		}
+	}
+
+	var v1 [3]byte
+	if end-start >= 128 {
+		if _, err := fd.ReadAt(v1[:], end-128); err != nil {
+			return err
+		}
+		if string(v1[:]) == "TAG" {
+			end -= 128
+		}
+	}
+
+	var ape [32]byte
+	if end-start >= 32 {
+		if _, err := fd.ReadAt(ape[:], end-32); err != nil {
+			return err
+		}
+		if string(ape[:8]) == "APETAGEX" {
+			end -= int64(binary.LittleEndian.Uint32(ape[12:16]))
+			if binary.LittleEndian.Uint32(ape[20:24])&(1<<31) != 0 {
+				end -= 32
+			}
+		}
+	}
+
+	// mp3 has no magic of its own; so we insist on an mpeg frame sync
+	// at the start of the payload.
+	var fs [2]byte
+	if start >= end || end > sz {
+		return errNotMedia
+	}
+	if _, err := fd.ReadAt(fs[:], start); err != nil || fs[0] != 0xff || fs[1]&0xe0 != 0xe0 {
+		return errNotMedia
+	}
+
+	_, err := io.Copy(h, io.NewSectionReader(fd, start, end-start))
+	return err
+}
+
+// FLAC: the STREAMINFO block and the audio frames; the other metadata
+// blocks hold tags, pictures and padding.
+func flacPayload(h hash.Hash, fd *os.File, sz int64) error {
+	rd := bufio.NewReader(fd)
+
+	var magic [4]byte
+	if _, err := io.ReadFull(rd, magic[:]); err != nil || string(magic[:]) != "fLaC" {
+		return errNotMedia
+	}
+
+	for last := false; !last; {
+		var hdr [4]byte
+		if _, err := io.ReadFull(rd, hdr[:]); err != nil {
+			return err
+		}
+
+		last = hdr[0]&0x80 != 0
+		typ := hdr[0] & 0x7f
+		n := int64(hdr[1])<<16 | int64(hdr[2])<<8 | int64(hdr[3])
+
+		w := io.Discard
+		if typ == 0 {
+			w = h
+		}
+		if _, err := io.CopyN(w, rd, n); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.Copy(h, rd)
+	return err
+}
+
+// decode the 28-bit size of an ID3v2 tag
+func syncsafe(b []byte) int64 {
+	var n int64
+	for _, c := range b {
+		n = n<<7 | int64(c&0x7f)
+	}
+	return n
+}
+
+// prefix of the group key of files matched by their media payload
+const _MediaKey = "media:"
+
+// media group keys are distinct from those of whole files
+func mediaKey(sum []byte) string {
+	return fmt.Sprintf("%s%x", _MediaKey, sum)
+}
@@ -0,0 +1,152 @@
+// order.go - ordering policies for files in a duplicate group
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/opencoff/go-fio"
+)
+
+// compare two entries; returns < 0 if a should be kept in preference to b
+type cmpFunc func(a, b *fio.Info) int
+
+var orderings = map[string]cmpFunc{
+	// most recent first
+	"mtime": func(a, b *fio.Info) int {
+		return b.ModTime().Compare(a.ModTime())
+	},
+
+	// most recent inode change first
+	"ctime": func(a, b *fio.Info) int {
+		return b.Ctim.Compare(a.Ctim)
+	},
+
+	// lexical order of full path
+	"path": func(a, b *fio.Info) int {
+		return strings.Compare(a.Path(), b.Path())
+	},
+
+	// shallowest first
+	"depth": func(a, b *fio.Info) int {
+		return depth(a.Path()) - depth(b.Path())
+	},
+
+	// shortest basename first
+	"name-length": func(a, b *fio.Info) int {
+		return len(a.Name()) - len(b.Name())
+	},
+}
+
+// default order; each key breaks the ties of the ones before it
+const _DefaultOrder = "mtime,ctime,depth,path"
+
+// order sorts the members of a duplicate group such that the first
+// entry is the one to keep.
+type order struct {
+	cmp    []cmpFunc
+	prefer []string
+}
+
+// newOrder returns an order for the comma separated 'keys'; a key
+// with a leading '-' is reversed (e.g. '-mtime' is oldest first). The
+// path is always the last key; so the order is the same on every run.
+func newOrder(keys string, prefer []string) (*order, error) {
+	o := &order{
+		prefer: make([]string, 0, len(prefer)),
+	}
+
+	var havePath bool
+	for _, k := range strings.Split(keys, ",") {
+		k = strings.TrimSpace(k)
+		rev := strings.HasPrefix(k, "-")
+		k = strings.TrimPrefix(k, "-")
+
+		cmp, ok := orderings[k]
+		if !ok {
+			return nil, fmt.Errorf("unknown order '%s'", k)
+		}
+		if rev {
+			fwd := cmp
+			cmp = func(a, b *fio.Info) int {
+				return fwd(b, a)
+			}
+		}
+		o.cmp = append(o.cmp, cmp)
+		havePath = havePath || k == "path"
+	}
+	if !havePath {
+		o.cmp = append(o.cmp, orderings["path"])
+	}
+
+	for _, d := range prefer {
+		a, err := filepath.Abs(d)
+		if err != nil {
+			return nil, fmt.Errorf("prefer-dir %s: %w", d, err)
+		}
+		o.prefer = append(o.prefer, a)
+	}
+	return o, nil
+}
+
+// sort orders 'v' in place
+func (o *order) sort(v []*fio.Info) {
+	sort.SliceStable(v, func(i, j int) bool {
+		a, b := v[i], v[j]
+		if pa, pb := isProtected(a.Path()), isProtected(b.Path()); pa != pb {
+			return pa
+		}
+		if pa, pb := o.rank(a), o.rank(b); pa != pb {
+			return pa < pb
+		}
+		for _, cmp := range o.cmp {
+			if c := cmp(a, b); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+// rank returns the index of the preferred dir that holds 'fi';
+// entries outside all preferred dirs rank last.
+func (o *order) rank(fi *fio.Info) int {
+	if len(o.prefer) == 0 {
+		return 0
+	}
+
+	nm, err := filepath.Abs(fi.Path())
+	if err != nil {
+		return len(o.prefer)
+	}
+
+	for i, d := range o.prefer {
+		if nm == d || strings.HasPrefix(nm, strings.TrimSuffix(d, "/")+"/") {
+			return i
+		}
+	}
+	return len(o.prefer)
+}
+
+// depth of the absolute path of 'nm'; so names relative to different
+// dirs compare alike.
+func depth(nm string) int {
+	if a, err := filepath.Abs(nm); err == nil {
+		nm = a
+	}
+	return strings.Count(filepath.Clean(nm), "/")
+}
+
+func orderNames() string {
+	v := make([]string, 0, len(orderings))
+	for k := range orderings {
+		v = append(v, k)
+	}
+	sort.Strings(v)
+	return strings.Join(v, ", ")
+}
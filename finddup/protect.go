@@ -0,0 +1,54 @@
+// protect.go - paths that are always kept and never removed
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"go-progs/internal/glob"
+)
+
+// system dirs that are always protected; --protect adds to these
+var defaultProtects = []string{
+	"/etc", "/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/boot",
+}
+
+// set by --protect; nil protects nothing
+var protects *glob.Matcher
+
+// newProtects compiles the globs of the protected paths; they're
+// matched against absolute paths - so a leading '/' anchors a glob at
+// the root and a glob without '/' matches a name at any depth.
+func newProtects(pats []string, fold bool) (*glob.Matcher, error) {
+	v := make([]string, 0, len(defaultProtects)+len(pats))
+	v = append(v, defaultProtects...)
+	v = append(v, pats...)
+	return glob.New(v, fold)
+}
+
+// isProtected returns true if the file 'nm' or a dir it's in matches
+// a protected glob; such a file is kept ahead of the others in its
+// group and is never removed or replaced. The dirs are matched as
+// named and with their symlinks resolved; so a link to /etc doesn't
+// hide it.
+func isProtected(nm string) bool {
+	if protects == nil {
+		return false
+	}
+
+	// err on the side of caution
+	a, err := filepath.Abs(nm)
+	if err != nil {
+		return true
+	}
+	d, err := filepath.EvalSymlinks(filepath.Dir(a))
+	if err != nil {
+		return true
+	}
+
+	real := filepath.Join(d, filepath.Base(a))
+	return protects.MatchPath(strings.TrimPrefix(a, "/")) || protects.MatchPath(strings.TrimPrefix(real, "/"))
+}
@@ -0,0 +1,87 @@
+// reflink.go - replace duplicates with copy-on-write clones
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/opencoff/go-fio"
+)
+
+// reflinkGroup replaces every duplicate in the sorted group 'v' with
+// a clone of the keeper v[0]. Each clone shares the keeper's extents
+// but is otherwise an independent file - with the mode, owner and
+// times of the duplicate it replaces.
+func reflinkGroup(v []*fio.Info) error {
+	var errs []error
+
+	keep := v[0]
+	for _, dup := range v[1:] {
+		switch {
+		case dup.Dev != keep.Dev:
+			Warn("%s: not on the same filesystem as %s; skipping ..", dup.Path(), keep.Path())
+			continue
+		case dup.Ino == keep.Ino:
+			// hardlinks already share everything
+			continue
+		case isProtected(dup.Path()):
+			Warn("%s: protected; not replaced by a clone of %s", dup.Path(), keep.Path())
+			continue
+		}
+
+		if err := reflink(keep, dup); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fmt.Printf("# cloned '%s' -> '%s'\n", keep.Path(), dup.Path())
+	}
+	return errors.Join(errs...)
+}
+
+// reflink replaces 'dup' with a clone of 'keep'
+func reflink(keep, dup *fio.Info) error {
+	nm := dup.Path()
+
+	// neither file must have changed since we hashed them
+	for _, fi := range []*fio.Info{keep, dup} {
+		st, err := fio.Lstat(fi.Path())
+		if err != nil {
+			return err
+		}
+		if st.Size() != fi.Size() || !st.ModTime().Equal(fi.ModTime()) {
+			return fmt.Errorf("%s: modified since it was hashed; skipping", fi.Path())
+		}
+		if st.Uid != fi.Uid || st.Gid != fi.Gid || st.Mode() != fi.Mode() {
+			return fmt.Errorf("%s: owner or mode changed since it was hashed; skipping", fi.Path())
+		}
+	}
+
+	tmp := fmt.Sprintf("%s.reflink.%d", nm, os.Getpid())
+	if err := cloneFile(keep.Path(), tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("%s: clone: %w", nm, err)
+	}
+
+	// chown clears the setuid and setgid bits; so the mode is set after
+	err := os.Lchown(tmp, int(dup.Uid), int(dup.Gid))
+	if err == nil {
+		err = os.Chmod(tmp, dup.Mode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky))
+	}
+	if err == nil {
+		err = os.Chtimes(tmp, dup.Atim, dup.Mtim)
+	}
+	if err == nil {
+		err = os.Rename(tmp, nm)
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("%s: %w", nm, err)
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+// reflink_darwin.go - clonefile(2) based clones (APFS)
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build darwin
+
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile creates 'dst' as a copy-on-write clone of 'src'
+func cloneFile(src, dst string) error {
+	return unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW)
+}
@@ -0,0 +1,34 @@
+// reflink_linux.go - FICLONE based clones (btrfs, XFS etc.)
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build linux
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile creates 'dst' as a copy-on-write clone of 'src'
+func cloneFile(src, dst string) error {
+	s, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	d, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = unix.IoctlFileClone(int(d.Fd()), int(s.Fd()))
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
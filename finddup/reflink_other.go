@@ -0,0 +1,16 @@
+// reflink_other.go - platforms without file clones
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+)
+
+func cloneFile(src, dst string) error {
+	return errors.New("reflinks are not supported on this platform")
+}
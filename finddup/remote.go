@@ -0,0 +1,134 @@
+// remote.go - find duplicate objects in object stores (e.g. s3://bucket/dir)
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+
+	"go-progs/internal/glob"
+	"go-progs/internal/vfs"
+
+	"github.com/opencoff/go-fio"
+)
+
+// urlArgs returns true if every name in 'args' is a URL; URLs and local
+// dirs can't be mixed. The trailing '/' of each URL is removed.
+func urlArgs(args []string) (bool, error) {
+	var n int
+	for i, nm := range args {
+		if vfs.IsURL(nm) {
+			args[i] = strings.TrimSuffix(nm, "/")
+			n++
+		}
+	}
+
+	if n > 0 && n != len(args) {
+		return false, errors.New("can't mix URLs and local dirs")
+	}
+	return n > 0, nil
+}
+
+// findURLs calls 'fp' with the checksum of each object under the URLs
+// in 'args' that isn't excluded by 'excl'; the args are listed in
+// parallel. If 'etags' is false, every object is downloaded and hashed.
+func findURLs(args []string, excl *glob.Matcher, etags bool, fp func(fi *fio.Info, sum string)) error {
+	nw := runtime.NumCPU() * 2
+	ch := make(chan *vfs.Entry, nw)
+	errch := make(chan error, 1)
+
+	var wg, ewg sync.WaitGroup
+	var errs []error
+
+	ewg.Add(1)
+	go func() {
+		for err := range errch {
+			errs = append(errs, err)
+		}
+		ewg.Done()
+	}()
+
+	// list all the args at once
+	wg.Add(len(args))
+	for _, nm := range args {
+		go func(nm string) {
+			err := vfs.Walk(nm, func(e *vfs.Entry) error {
+				rel := strings.TrimPrefix(e.Name, nm+"/")
+				if e.IsLink() || (rel != e.Name && excl.MatchPath(rel)) {
+					return nil
+				}
+				ch <- e
+				return nil
+			})
+			if err != nil {
+				errch <- err
+			}
+			wg.Done()
+		}(nm)
+	}
+
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+
+	var hwg sync.WaitGroup
+	hwg.Add(nw)
+	for i := 0; i < nw; i++ {
+		go func() {
+			for e := range ch {
+				sum, err := objectSum(e, etags)
+				if err != nil {
+					errch <- err
+					continue
+				}
+				fp(entryInfo(e), sum)
+			}
+			hwg.Done()
+		}()
+	}
+
+	hwg.Wait()
+	close(errch)
+	ewg.Wait()
+	return errors.Join(errs...)
+}
+
+// objectSum returns the group key of object 'e': its ETag or the hash
+// of its contents. Equal ETags always mean equal contents; but copies
+// uploaded in different sized parts have different ETags.
+func objectSum(e *vfs.Entry, etags bool) (string, error) {
+	if etags && len(e.ETag) > 0 {
+		return "etag:" + e.ETag, nil
+	}
+
+	rd, err := vfs.Open(e.Name)
+	if err != nil {
+		return "", err
+	}
+	defer rd.Close()
+
+	h := hasher()
+	if _, err := io.Copy(h, rd); err != nil {
+		return "", fmt.Errorf("%s: %w", e.Name, err)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// entryInfo returns the remote object 'e' as a file
+func entryInfo(e *vfs.Entry) *fio.Info {
+	fi := &fio.Info{
+		Siz:  e.Size,
+		Mod:  0644,
+		Mtim: e.Mtime,
+		Ctim: e.Mtime,
+	}
+	fi.SetPath(e.Name)
+	return fi
+}
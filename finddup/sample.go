@@ -0,0 +1,149 @@
+// sample.go - a first pass that hashes just a sample of large files
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/opencoff/go-fio"
+)
+
+// sampler groups large files by the checksum of their size and 'n'
+// bytes at the start, middle and end; only the files whose samples
+// collide are hashed in full.
+type sampler struct {
+	sync.Mutex
+	n int64
+
+	groups map[string][]*fio.Info
+}
+
+func newSampler(n int64) *sampler {
+	s := &sampler{
+		n:      n,
+		groups: make(map[string][]*fio.Info),
+	}
+	return s
+}
+
+// wants returns true if 'fi' is large enough to be sampled; smaller
+// files are read in full anyway. A nil sampler wants nothing.
+func (s *sampler) wants(fi *fio.Info) bool {
+	return s != nil && fi.Size() > 3*s.n
+}
+
+// add records the sample of 'fi'; safe for concurrent use
+func (s *sampler) add(fi *fio.Info) error {
+	sum, err := sampleSum(fi.Path(), s.n)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	s.groups[sum] = append(s.groups[sum], fi)
+	s.Unlock()
+	return nil
+}
+
+// each calls 'fp' with the full checksum and files of every group
+// that is confirmed by hashing the files whose samples collide; the
+// files skipped by 'live' are left out.
+func (s *sampler) each(live *liveFiles, fp func(sum string, v []*fio.Info)) error {
+	for _, v := range s.groups {
+		if len(v) < 2 {
+			continue
+		}
+
+		byName := make(map[string]*fio.Info, len(v))
+		names := make([]string, 0, len(v))
+		for _, fi := range v {
+			byName[fi.Path()] = fi
+			names = append(names, fi.Path())
+		}
+
+		bysum, err := hashAll(names, live)
+		if err != nil {
+			return err
+		}
+
+		for k, nv := range bysum {
+			fv := make([]*fio.Info, 0, len(nv))
+			for _, nm := range nv {
+				fv = append(fv, byName[nm])
+			}
+			fp(k, fv)
+		}
+	}
+	return nil
+}
+
+// sampleGroups splits 'names' - files of the same size - into the
+// groups whose samples of 'n' bytes collide; singletons are dropped.
+// Files too small to sample stay together.
+func sampleGroups(names []string, n int64) ([][]string, error) {
+	var small []string
+	bysum := make(map[string][]string)
+	for _, nm := range names {
+		fi, err := os.Stat(nm)
+		if err != nil {
+			return nil, err
+		}
+		if fi.Size() <= 3*n {
+			small = append(small, nm)
+			continue
+		}
+
+		sum, err := sampleSum(nm, n)
+		if err != nil {
+			return nil, err
+		}
+		bysum[sum] = append(bysum[sum], nm)
+	}
+
+	var groups [][]string
+	if len(small) > 1 {
+		groups = append(groups, small)
+	}
+	for _, v := range bysum {
+		if len(v) > 1 {
+			groups = append(groups, v)
+		}
+	}
+	return groups, nil
+}
+
+// sampleSum returns the hex checksum of the size of 'fn' and of 'n'
+// bytes at its start, middle and end.
+func sampleSum(fn string, n int64) (string, error) {
+	fd, err := os.Open(fn)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+
+	st, err := fd.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	sz := st.Size()
+	h := hasher()
+	binary.Write(h, binary.LittleEndian, sz)
+
+	buf := make([]byte, min(n, sz))
+	for _, off := range []int64{0, (sz - n) / 2, sz - n} {
+		off = max(off, 0)
+		m, err := fd.ReadAt(buf, off)
+		if err != nil && err != io.EOF {
+			return "", fmt.Errorf("%s: %w", fn, err)
+		}
+		h.Write(buf[:m])
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
@@ -0,0 +1,129 @@
+// stream.go - report duplicate groups as soon as they're confirmed
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// streamer prints a duplicate group the moment its second member is
+// hashed; later members are printed as they're found. Each group gets
+// an ID in the order it's confirmed and every line carries that ID so
+// interleaved groups can be told apart.
+//
+// The keeper of a group is the first member found; --order and
+// --prefer-dir don't apply to streamed output. With --shell, the keeper
+// isn't settled until a protected member shows up: the first protected
+// member takes its place and the file kept so far is removed. With
+// --max-group-members, the members past the limit are just counted;
+// the counts are printed at the end.
+type streamer struct {
+	sync.Mutex
+	shell bool
+
+	// first file with a given sum - until a second one shows up
+	first map[string]string
+
+	// group ID of confirmed sums
+	ids  map[string]int
+	next int
+
+	// keeper of each group in shell mode - until a protected
+	// member takes its place
+	keeper map[int]string
+
+	// members printed and not printed of each group
+	shown map[int]int
+	more  map[int]int
+}
+
+func newStreamer(shell bool) *streamer {
+	s := &streamer{
+		shell:  shell,
+		first:  make(map[string]string),
+		ids:    make(map[string]int),
+		next:   1,
+		keeper: make(map[int]string),
+		shown:  make(map[int]int),
+		more:   make(map[int]int),
+	}
+	return s
+}
+
+func (s *streamer) add(nm, sum string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if id, ok := s.ids[sum]; ok {
+		s.member(id, nm)
+		return
+	}
+
+	keep, ok := s.first[sum]
+	if !ok {
+		s.first[sum] = nm
+		return
+	}
+
+	id := s.next
+	s.next++
+	s.ids[sum] = id
+	delete(s.first, sum)
+
+	fmt.Printf("\n# %d %s\n", id, sum)
+	if s.shell {
+		// a protected file is always the keeper
+		if !isProtected(keep) && isProtected(nm) {
+			keep, nm = nm, keep
+		}
+		fmt.Printf("# rm -f '%s'\n", keep)
+		if !isProtected(keep) {
+			s.keeper[id] = keep
+		}
+	} else {
+		fmt.Printf("%d %s\n", id, keep)
+	}
+	s.shown[id] = 1
+	s.member(id, nm)
+}
+
+// print a duplicate member of group 'id'
+func (s *streamer) member(id int, nm string) {
+	// the first protected member replaces an unprotected keeper
+	if s.shell && isProtected(nm) {
+		if keep, ok := s.keeper[id]; ok {
+			delete(s.keeper, id)
+			fmt.Printf("# protected: keep '%s' # %d\n", nm, id)
+			fmt.Printf("rm -f '%s' # %d\n", keep, id)
+			return
+		}
+	}
+
+	if maxMembers > 0 && s.shown[id] >= maxMembers {
+		s.more[id]++
+		return
+	}
+	s.shown[id]++
+
+	if s.shell {
+		if isProtected(nm) {
+			fmt.Printf("# protected: rm -f '%s' # %d\n", nm, id)
+			return
+		}
+		fmt.Printf("rm -f '%s' # %d\n", nm, id)
+	} else {
+		fmt.Printf("%d %s\n", id, nm)
+	}
+}
+
+// close prints the number of members of each group that weren't shown
+func (s *streamer) close() {
+	for id := 1; id < s.next; id++ {
+		if n := s.more[id]; n > 0 {
+			fmt.Printf("# %d: %d more...\n", id, n)
+		}
+	}
+}
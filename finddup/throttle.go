@@ -0,0 +1,71 @@
+// throttle.go - limit the rate at which files are read
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"hash"
+	"sync"
+	"time"
+)
+
+// bytes hashed between waits; small enough to keep the reads of a
+// mapped file smooth.
+const _ThrottleChunk = 1024 * 1024
+
+// rateLimit spreads the bytes read by all the workers so that they
+// average at most 'rate' bytes/sec; an idle spell earns no burst.
+type rateLimit struct {
+	sync.Mutex
+	rate float64
+
+	// when the next chunk may be read
+	next time.Time
+}
+
+// set by --bwlimit; nil reads at full speed
+var bwlimit *rateLimit
+
+func newRateLimit(rate uint64) *rateLimit {
+	r := &rateLimit{
+		rate: float64(rate),
+	}
+	return r
+}
+
+// wait till 'n' more bytes can be read
+func (r *rateLimit) wait(n int) {
+	r.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	d := r.next.Sub(now)
+	r.next = r.next.Add(time.Duration(float64(n) / r.rate * float64(time.Second)))
+	r.Unlock()
+
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// throttledHash waits for its turn before hashing each chunk; the
+// file is read (or its pages faulted in) as it's hashed - so this
+// limits the reads.
+type throttledHash struct {
+	hash.Hash
+	r *rateLimit
+}
+
+func (t *throttledHash) Write(b []byte) (int, error) {
+	var n int
+	for len(b) > 0 {
+		m := min(len(b), _ThrottleChunk)
+		t.r.wait(m)
+		t.Hash.Write(b[:m])
+		n += m
+		b = b[m:]
+	}
+	return n, nil
+}
@@ -0,0 +1,172 @@
+// append.go -- append records to an existing manifest
+//
+// (c) 2023 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"go-progs/internal/manifest"
+
+	"github.com/opencoff/go-fio"
+)
+
+// manifest header options that must match to append
+type manifestOpts struct {
+	halgo    string
+	bits     int
+	meta     bool
+	archives bool
+	urls     bool
+}
+
+// openAppend opens the manifest 'fn' for appending records made with
+// 'want'; it returns the writer (compressed like the manifest), the
+// safe file under it and the names already in it. The records are
+// appended to a copy of the manifest that replaces it when the writer
+// is closed; so a failed or interrupted run leaves it as it was.
+func openAppend(fn string, want manifestOpts) (io.WriteCloser, *fio.SafeFile, map[string]bool, error) {
+	fd, err := os.Open(fn)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer fd.Close()
+
+	var magic [4]byte
+	n, _ := io.ReadFull(fd, magic[:])
+	var algo string
+	switch {
+	case bytes.HasPrefix(magic[:n], manifest.GzipMagic):
+		algo = "gzip"
+	case bytes.HasPrefix(magic[:n], manifest.ZstdMagic):
+		algo = "zstd"
+	}
+	if _, err := fd.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, nil, err
+	}
+
+	in, err := manifest.Decompress(fd)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	rd := bufio.NewScanner(in)
+	rd.Buffer(make([]byte, 0, 65536), 1024*1024)
+	if zeroTerm {
+		rd.Split(manifest.SplitNul)
+	}
+	if !rd.Scan() {
+		return nil, nil, nil, fmt.Errorf("%s: possibly corrupt; can't read first line", fn)
+	}
+
+	if err := checkHeader(rd.Text(), want); err != nil {
+		return nil, nil, nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	names := make(map[string]bool)
+	for rd.Scan() {
+		nm := recordKey(rd.Text(), want.meta)
+		if strings.HasPrefix(nm, `"`) {
+			if s, err := strconv.Unquote(nm); err == nil {
+				nm = s
+			}
+		}
+		names[nm] = true
+	}
+	if err := rd.Err(); err != nil {
+		return nil, nil, nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	st, err := fd.Stat()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	sf, err := fio.NewSafeFile(fn, fio.OPT_OVERWRITE, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, st.Mode().Perm())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// the copy is of the bytes as they are - compressed or not
+	if _, err := fd.Seek(0, io.SeekStart); err != nil {
+		sf.Abort()
+		return nil, nil, nil, err
+	}
+	if _, err := io.Copy(sf, fd); err != nil {
+		sf.Abort()
+		return nil, nil, nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	// concatenated gzip streams (and zstd frames) are read as one
+	wr, err := compressWriter(sf, algo)
+	if err != nil {
+		sf.Abort()
+		return nil, nil, nil, err
+	}
+	return wr, sf, names, nil
+}
+
+// checkHeader verifies that the manifest header 'hdr' matches 'want'
+func checkHeader(hdr string, want manifestOpts) error {
+	if strings.ContainsAny(hdr, "\n\x00") {
+		if zeroTerm {
+			return fmt.Errorf("records end in newline; append without -z")
+		}
+		return fmt.Errorf("records end in NUL; append with -z")
+	}
+
+	subs := strings.Split(hdr, " ")
+	if len(subs) < 3 || subs[0] != MAGIC {
+		return fmt.Errorf("not a ghash file")
+	}
+
+	var meta, archives, urls bool
+	var bits int
+	for _, o := range subs[3:] {
+		switch {
+		case o == _MetaOpt:
+			meta = true
+		case o == _TreeOpt:
+			return fmt.Errorf("can't append to a manifest with a tree hash")
+		case o == _ArchiveOpt:
+			archives = true
+		case o == _URLOpt:
+			urls = true
+		case strings.HasPrefix(o, _BitsOpt):
+			n, err := parseBits(o)
+			if err != nil {
+				return err
+			}
+			bits = n
+		}
+	}
+
+	switch {
+	case subs[1] != want.halgo:
+		return fmt.Errorf("manifest uses %s, not %s", subs[1], want.halgo)
+	case bits != want.bits:
+		return fmt.Errorf("manifest has %d bit digests, not %d", bits, want.bits)
+	case meta != want.meta:
+		return fmt.Errorf("manifest metadata doesn't match --with-metadata")
+	case archives != want.archives:
+		return fmt.Errorf("manifest archive members don't match --descend-archives")
+	case urls != want.urls:
+		return fmt.Errorf("manifest URLs don't match the names; URLs and local names can't be mixed")
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// archive.go -- hash the members of tar and zip files
+//
+// (c) 2023 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/opencoff/go-fio"
+	"go-progs/internal/vfs"
+)
+
+// manifest header option if the records name archive members
+const _ArchiveOpt = "archives"
+
+// separates an archive and its member in a name: ARCHIVE::MEMBER
+const _MemberSep = "::"
+
+// set by --descend-archives; or by the manifest header when verifying
+var descendArchives bool
+
+// isArchive returns true if 'fn' is named like a tar or zip file
+func isArchive(fn string) bool {
+	return len(vfs.ArchiveURL(fn)) > 0
+}
+
+// memberURL returns the URL of the archive member 'nm' (ARCHIVE::MEMBER)
+// if archives are descended.
+func memberURL(nm string) (string, bool) {
+	if !descendArchives {
+		return "", false
+	}
+
+	fn, m, ok := strings.Cut(nm, _MemberSep)
+	if !ok {
+		return "", false
+	}
+	u := vfs.ArchiveURL(fn)
+	if len(u) == 0 {
+		return "", false
+	}
+	return u + _MemberSep + m, true
+}
+
+// walkArchive calls 'fp' for each file in the archive 'fn'; each is
+// named ARCHIVE::MEMBER. Symlinks are skipped.
+func walkArchive(fn string, fp func(fi *fio.Info) error) error {
+	u := vfs.ArchiveURL(fn)
+	return vfs.Walk(u, func(e *vfs.Entry) error {
+		if e.IsLink() {
+			return nil
+		}
+
+		fi := entryInfo(e)
+		fi.SetPath(fn + strings.TrimPrefix(e.Name, u))
+		return fp(fi)
+	})
+}
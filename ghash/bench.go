@@ -0,0 +1,104 @@
+// bench.go -- benchmark the hash algorithms on this machine
+//
+// (c) 2023 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"hash"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// how long each algorithm is run in each mode
+const _BenchTime = 300 * time.Millisecond
+
+// size of each write to the hash
+const _BenchBufSize = 1024 * 1024
+
+type benchResult struct {
+	name   string
+	single float64
+	multi  float64
+}
+
+// doBench measures the throughput (MB/s) of every supported algorithm
+// on a single core and on all the cores, and prints a recommendation.
+// ghash hashes one file per worker; so the single core rate is what
+// bounds the speed of hashing a large file.
+func doBench() {
+	buf := make([]byte, _BenchBufSize)
+	if _, err := rand.Read(buf); err != nil {
+		Fatal(fmt.Errorf("bench: %w", err))
+	}
+
+	ncpu := runtime.NumCPU()
+	res := make([]benchResult, 0, len(Hashes))
+	for nm, hgen := range Hashes {
+		r := benchResult{
+			name:   nm,
+			single: benchRate(hgen, buf, 1),
+			multi:  benchRate(hgen, buf, ncpu),
+		}
+		res = append(res, r)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].single > res[j].single
+	})
+
+	fmt.Printf("%-12s %12s %12s\n", "algorithm", "1 cpu MB/s", fmt.Sprintf("%d cpu MB/s", ncpu))
+	for i := range res {
+		r := &res[i]
+		fmt.Printf("%-12s %12.1f %12.1f\n", r.name, r.single, r.multi)
+	}
+
+	if len(res) > 0 {
+		fmt.Printf("\nRecommended: --hash=%s\n", res[0].name)
+	}
+}
+
+// benchRate returns the aggregate MB/s of 'n' goroutines each hashing
+// 'buf' repeatedly for _BenchTime.
+func benchRate(hgen func() hash.Hash, buf []byte, n int) float64 {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var total int64
+
+	start := time.Now()
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			var done int64
+
+			h := hgen()
+			for time.Since(start) < _BenchTime {
+				h.Write(buf)
+				done += int64(len(buf))
+			}
+			h.Sum(nil)
+
+			mu.Lock()
+			total += done
+			mu.Unlock()
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	secs := time.Since(start).Seconds()
+	return float64(total) / (1024 * 1024) / secs
+}
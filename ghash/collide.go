@@ -0,0 +1,66 @@
+// collide.go -- warn about files with the same digest and different sizes
+//
+// (c) 2023 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"encoding/hex"
+	"sync"
+)
+
+// the first file seen with a digest
+type digestFile struct {
+	name string
+	size int64
+}
+
+// digestSet remembers the digest of every file hashed; two files with
+// the same digest and different sizes point to a short read or a bug
+// rather than a real collision. A nil digestSet checks nothing.
+type digestSet struct {
+	sync.Mutex
+	seen map[string]digestFile
+}
+
+// set by --check-collisions
+var collisions *digestSet
+
+func newDigestSet() *digestSet {
+	d := &digestSet{
+		seen: make(map[string]digestFile),
+	}
+	return d
+}
+
+// add records that 'fn' of 'sz' bytes has digest 'sum' and warns if
+// a file of a different size had the same digest.
+func (d *digestSet) add(fn string, sz int64, sum []byte) {
+	if d == nil {
+		return
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	k := string(sum)
+	x, ok := d.seen[k]
+	if !ok {
+		d.seen[k] = digestFile{fn, sz}
+		return
+	}
+
+	if x.size != sz {
+		Warn("digest collision: '%s' (%d bytes) and '%s' (%d bytes) have the same digest %s",
+			x.name, x.size, fn, sz, hex.EncodeToString(sum))
+	}
+}
@@ -0,0 +1,65 @@
+// compress.go -- compressed manifests
+//
+// (c) 2023 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressWriter returns a writer that compresses to 'fd' with 'algo';
+// closing it flushes the compressor and then closes 'fd'.
+func compressWriter(fd io.WriteCloser, algo string) (io.WriteCloser, error) {
+	switch algo {
+	case "", "none":
+		return fd, nil
+	case "gzip":
+		return &gzipWriter{gzip.NewWriter(fd), fd}, nil
+	case "zstd":
+		zw, err := zstd.NewWriter(fd)
+		if err != nil {
+			return nil, err
+		}
+		return &zstdWriter{zw, fd}, nil
+	default:
+		return nil, fmt.Errorf("unknown compression '%s'; try gzip or zstd", algo)
+	}
+}
+
+type gzipWriter struct {
+	*gzip.Writer
+	fd io.WriteCloser
+}
+
+func (w *gzipWriter) Close() error {
+	if err := w.Writer.Close(); err != nil {
+		return err
+	}
+	return w.fd.Close()
+}
+
+type zstdWriter struct {
+	*zstd.Encoder
+	fd io.WriteCloser
+}
+
+func (w *zstdWriter) Close() error {
+	if err := w.Encoder.Close(); err != nil {
+		return err
+	}
+	return w.fd.Close()
+}
@@ -16,16 +16,38 @@ package main
 import (
 	"fmt"
 	"os"
+
+	"go-progs/internal/report"
+)
+
+// Exit codes; when there are several kinds of errors, the largest
+// applies.
+const (
+	_ExitOK       = report.ExitOK
+	_ExitMismatch = report.ExitMismatch
+	_ExitMissing  = report.ExitNotFound
+	_ExitIO       = report.ExitIO
+	_ExitPerm     = report.ExitPerm
+	_ExitUsage    = report.ExitUsage
 )
 
 var atExit []func()
 
 // Die prints an error message to stderr
 // and exits the program after calling all the registered
-// at-exit functions.
+// at-exit functions. It's for usage errors: bad options,
+// arguments etc.
 func Die(f string, v ...interface{}) {
 	Warn(f, v...)
-	Exit(1)
+	Exit(_ExitUsage)
+}
+
+// Fatal reports 'err' and exits the program with the exit code of its
+// kind (and that of the errors before it).
+func Fatal(err error) {
+	rep.Error(err)
+	sendNotify()
+	Exit(rep.Code())
 }
 
 // Warn prints an error message to stderr
@@ -0,0 +1,95 @@
+// digest.go -- truncated digests
+//
+// (c) 2023 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// header option recording the digest length: "bits=N"
+const _BitsOpt = "bits="
+
+// truncHash keeps the leftmost 'n' bytes of the digest
+type truncHash struct {
+	hash.Hash
+	n int
+}
+
+func (t *truncHash) Sum(b []byte) []byte {
+	s := t.Hash.Sum(nil)
+	return append(b, s[:t.n]...)
+}
+
+func (t *truncHash) Size() int {
+	return t.n
+}
+
+// resolveHash returns the canonical algorithm name, digest length and
+// constructor for 'algo' truncated to 'bits' (0 for the full digest).
+// A name of the form ALGO-BITS that isn't a known algorithm (e.g.
+// blake3-128) is ALGO truncated to BITS.
+func resolveHash(algo string, bits int) (string, int, func() hash.Hash, error) {
+	hgen, ok := Hashes[algo]
+	if !ok {
+		i := strings.LastIndexByte(algo, '-')
+		if i < 0 {
+			return "", 0, nil, fmt.Errorf("unknown hash algorithm '%s'", algo)
+		}
+
+		n, err := strconv.Atoi(algo[i+1:])
+		if err != nil {
+			return "", 0, nil, fmt.Errorf("unknown hash algorithm '%s'", algo)
+		}
+		if bits > 0 && bits != n {
+			return "", 0, nil, fmt.Errorf("%s: conflicting digest bits %d", algo, bits)
+		}
+
+		algo, bits = algo[:i], n
+		if hgen, ok = Hashes[algo]; !ok {
+			return "", 0, nil, fmt.Errorf("unknown hash algorithm '%s'", algo)
+		}
+	}
+
+	if bits == 0 {
+		return algo, 0, hgen, nil
+	}
+
+	full := hgen().Size() * 8
+	if bits < 0 || bits%8 != 0 || bits > full {
+		return "", 0, nil, fmt.Errorf("%s: digest bits must be a multiple of 8 and at most %d", algo, full)
+	}
+
+	// the full digest is just the algorithm itself
+	if bits == full {
+		return algo, 0, hgen, nil
+	}
+
+	n := bits / 8
+	trunc := func() hash.Hash {
+		return &truncHash{hgen(), n}
+	}
+	return algo, bits, trunc, nil
+}
+
+// parse the "bits=N" header option
+func parseBits(s string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(s, _BitsOpt))
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("malformed digest length '%s'", s)
+	}
+	return n, nil
+}
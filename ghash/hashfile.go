@@ -14,33 +14,66 @@
 package main
 
 import (
-	"fmt"
+	"hash"
+	"io"
 	"os"
+	"time"
 
-	"github.com/opencoff/go-mmap"
-	"hash"
+	"go-progs/internal/vfs"
 )
 
+// set to false to always use buffered reads (--no-mmap)
+var useMmap = true
+
 // hash a file and return the checksum, file-size and error
 func hashFile(fn string, hgen func() hash.Hash) ([]byte, int64, error) {
+	if u, ok := memberURL(fn); ok {
+		return hashURL(u, hgen)
+	}
+	if vfs.IsURL(fn) {
+		return hashURL(fn, hgen)
+	}
+
 	fd, err := os.Open(fn)
 	if err != nil {
 		return nil, 0, err
 	}
 	defer fd.Close()
 
+	start := time.Now()
 	h := hgen()
 	if h == nil {
-		panic(fmt.Sprintf("nil hash!"))
+		panic("nil hash!")
 	}
 
-	sz, err := mmap.Reader(fd, func(b []byte) error {
-		h.Write(b)
-		return nil
-	})
+	if useMmap {
+		sz, err := mmapReader(fd, func(b []byte) error {
+			h.Write(b)
+			return nil
+		})
+		if err == nil {
+			stats.add(sz)
+			timing.add(fn, sz, time.Since(start))
+			return h.Sum(nil)[:], sz, nil
+		}
+
+		// mmap can fail on network mounts, special filesystems or
+		// for very large files on 32-bit platforms; fall back to
+		// reading the file from the start with a fresh hash.
+		if _, err = fd.Seek(0, io.SeekStart); err != nil {
+			return nil, 0, err
+		}
+		h = hgen()
+	}
+
+	sz, err := io.CopyBuffer(h, fd, make([]byte, ioBufSize()))
 	if err != nil {
 		return nil, 0, err
 	}
-
+	stats.add(sz)
+	timing.add(fn, sz, time.Since(start))
 	return h.Sum(nil)[:], sz, nil
 }
+
+// buffer size for non-mmap reads
+const _IOBufSize = 1024 * 1024
@@ -0,0 +1,103 @@
+// limits.go -- bound the CPUs and memory used to hash files
+//
+// (c) 2023 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/opencoff/go-mmap"
+)
+
+// smallest mmap window (or read buffer) per worker
+const _MinWindow = 1024 * 1024
+
+// if non-zero, files are mapped (or read) at most this many bytes at
+// a time; set by setLimits().
+var ioWindow int64
+
+// setLimits bounds the number of files hashed at once to 'jobs' (and
+// the CPUs used to as many), and the memory mapped or buffered across
+// all of them to 'maxMem' bytes. Zero leaves the limit as is.
+func setLimits(jobs int, maxMem uint64) error {
+	if jobs < 0 {
+		return fmt.Errorf("invalid job count %d", jobs)
+	}
+	if jobs > 0 {
+		nWorkers = jobs
+		if jobs < runtime.GOMAXPROCS(0) {
+			runtime.GOMAXPROCS(jobs)
+		}
+	}
+
+	if maxMem == 0 {
+		return nil
+	}
+	if maxMem < _MinWindow {
+		return fmt.Errorf("max memory %d is less than %d bytes", maxMem, _MinWindow)
+	}
+
+	// each worker holds one window at a time; run fewer workers
+	// rather than use tiny windows.
+	if n := int(maxMem / _MinWindow); n < nWorkers {
+		nWorkers = n
+	}
+
+	// mmap offsets must be page aligned
+	pg := int64(os.Getpagesize())
+	ioWindow = int64(maxMem/uint64(nWorkers)) / pg * pg
+	if ioWindow > mmap.MaxMappingSize {
+		ioWindow = mmap.MaxMappingSize
+	}
+	return nil
+}
+
+// mmapReader is mmap.Reader that maps at most ioWindow bytes at a time
+func mmapReader(fd *os.File, fp func(buf []byte) error) (int64, error) {
+	if ioWindow == 0 {
+		return mmap.Reader(fd, fp)
+	}
+
+	st, err := fd.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("mmap: %w", err)
+	}
+
+	m := mmap.New(fd)
+	fsz := st.Size()
+	for off := int64(0); off < fsz; {
+		sz := min(fsz-off, ioWindow)
+		p, err := m.Map(sz, off, mmap.PROT_READ, mmap.F_READAHEAD)
+		if err != nil {
+			return 0, err
+		}
+
+		err = fp(p.Bytes())
+		p.Unmap()
+		if err != nil {
+			return off, err
+		}
+		off += sz
+	}
+	return fsz, nil
+}
+
+// size of the buffer for regular reads
+func ioBufSize() int {
+	if ioWindow > 0 && ioWindow < _IOBufSize {
+		return int(ioWindow)
+	}
+	return _IOBufSize
+}
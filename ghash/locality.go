@@ -0,0 +1,67 @@
+// locality.go - order the files to verify by their place on disk
+//
+// (c) 2023 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// verify orders
+const (
+	orderManifest = "manifest"
+	orderPath     = "path"
+	orderInode    = "inode"
+)
+
+// the order in which files are verified; set by --verify-order
+var verifyOrder = orderManifest
+
+// parseVerifyOrder validates the --verify-order 's'
+func parseVerifyOrder(s string) (string, error) {
+	switch s {
+	case orderManifest, orderPath, orderInode:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown verify order '%s'; try one of: manifest, path, inode", s)
+}
+
+// sortByLocality sorts 'v' so files that are likely to be near each
+// other on disk are read one after the other:
+//
+//	path:  the files of a dir together; the dirs by name
+//	inode: by inode number on each device; most file systems
+//	       allocate an inode's blocks near it
+func sortByLocality(v []datum, order string) {
+	switch order {
+	case orderPath:
+		sort.SliceStable(v, func(i, j int) bool {
+			a, b := v[i].file, v[j].file
+			if da, db := filepath.Dir(a), filepath.Dir(b); da != db {
+				return da < db
+			}
+			return a < b
+		})
+
+	case orderInode:
+		sort.SliceStable(v, func(i, j int) bool {
+			a, b := &v[i], &v[j]
+			if a.dev != b.dev {
+				return a.dev < b.dev
+			}
+			return a.ino < b.ino
+		})
+	}
+}
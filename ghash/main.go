@@ -14,23 +14,24 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path"
-	"sync"
+
+	"go-progs/internal/glob"
+	"go-progs/internal/hashes"
+	"go-progs/internal/manifest"
+	"go-progs/internal/report"
 
 	"github.com/opencoff/go-fio"
 	"github.com/opencoff/go-fio/walk"
+	"github.com/opencoff/go-utils"
 	flag "github.com/opencoff/pflag"
 
-	"crypto/sha256"
-	"crypto/sha512"
-	"github.com/zeebo/blake3"
-	"golang.org/x/crypto/blake2b"
-	"golang.org/x/crypto/blake2s"
-	"golang.org/x/crypto/sha3"
-	"hash"
+	"golang.org/x/crypto/ssh"
 )
 
 // ghash output file magic
@@ -39,69 +40,305 @@ const MAGIC = "#!ghash"
 // basename of argv[0]
 var Z string = path.Base(os.Args[0])
 
+// classifies and reports errors during hashing and verification
+var rep *report.Reporter
+
 type otuple struct {
-	nm  string
-	sz  int64
-	sum []byte
+	nm   string
+	sz   int64
+	sum  []byte
+	mark string
+	meta string
+
+	// input sequence# and a flag denoting that hashing failed;
+	// used to order the output.
+	seq  int
+	fail bool
 }
 
 func main() {
 	var ver, help, recurse, onefs, follow, force bool
 	var verify, output, halgo string
-	var listHashes bool
-
-	mf := flag.NewFlagSet(Z, flag.ExitOnError)
+	var listHashes, withMeta, noMmap, nullInput, ordered bool
+	var bits int
+	var withTree bool
+	var excludes, includes []string
+	var fold, jsonErrs bool
+	var mismatch []string
+	var bench, showStats, checkCollisions bool
+	var slowest int
+	var jobs, verifyJobs int
+	var vorder string
+	var maxMem, compress string
+	var appendTo, perDir bool
+	var only, skip []string
+	var signKey, allowedSigners string
+	var notifyCmd, webhook string
+
+	mf := flag.NewFlagSet(Z, flag.ContinueOnError)
 	mf.BoolVarP(&ver, "version", "V", false, "Show version info and exit")
 	mf.BoolVarP(&help, "help", "h", false, "Show help info exit")
 	mf.BoolVarP(&recurse, "recurse", "r", false, "Recursively traverse directories")
 	mf.BoolVarP(&onefs, "one-filesystem", "x", false, "Don't cross file system boundaries")
 	mf.BoolVarP(&follow, "follow-symlinks", "L", false, "Follow symlinks")
+	mf.BoolVarP(&descendArchives, "descend-archives", "", false, "Hash the members of tar and zip files instead of the files")
+	mf.StringSliceVarP(&excludes, "exclude", "", nil, "Exclude entries matching glob 'G' with -r")
+	mf.StringSliceVarP(&includes, "include", "", nil, "Re-include entries matching glob 'G'")
+	mf.BoolVarP(&fold, "ignore-case", "", false, "Match globs ignoring case")
 	mf.BoolVarP(&listHashes, "list-hashes", "", false, "List supported hash algorithms")
+	mf.BoolVarP(&bench, "bench", "", false, "Benchmark the hash algorithms on this machine")
+	mf.BoolVarP(&showStats, "stats", "", false, "Show the read throughput at the end of a run")
+	mf.IntVarP(&slowest, "timing", "", 0, "Show the `N` slowest files and dirs to hash at the end of a run")
+	mf.Lookup("timing").NoOptDefVal = "10"
+	mf.BoolVarP(&checkCollisions, "check-collisions", "", false, "Warn if files of different sizes have the same digest")
+	mf.IntVarP(&jobs, "jobs", "j", 0, "Hash at most `N` files at a time")
+	mf.StringVarP(&maxMem, "max-memory", "", "", "Map or buffer at most `M` bytes across all files")
 	mf.BoolVarP(&force, "force-overwrite", "f", false, "Forcibly overwrite output file")
+	mf.BoolVarP(&withMeta, "with-metadata", "m", false, "Record file mode, owner and mtime")
+	mf.BoolVarP(&noMmap, "no-mmap", "", false, "Don't use mmap to read files")
+	mf.BoolVarP(&nullInput, "null", "0", false, "Read NUL separated names to hash from stdin")
+	mf.BoolVarP(&zeroTerm, "zero-terminated", "z", false, "Manifest records end in NUL instead of newline")
+	mf.BoolVarP(&ordered, "ordered", "O", false, "Write records in input order (sorted by name with -r)")
 	mf.StringVarP(&halgo, "hash", "H", "sha256", "Use hash algorithm `H`")
+	mf.BoolVarP(&withTree, "tree-hash", "", false, "Add a digest of all the records as a manifest trailer")
+	mf.IntVarP(&bits, "digest-bits", "", 0, "Truncate digests to `N` bits")
 	mf.StringVarP(&verify, "verify-from", "v", "", "Verify the hashes in file 'F' [stdin]")
+	mf.StringVarP(&verifyLevel, "level", "", levelFull, "Verify at level 'L' (size, quick, full)")
+	mf.IntVarP(&verifyJobs, "verify-jobs", "", 0, "Verify at most `N` files at a time [-j]")
+	mf.StringVarP(&vorder, "verify-order", "", orderManifest, "Verify files in order `O` (manifest, path, inode)")
+	mf.StringSliceVarP(&only, "only", "", nil, "Verify only the entries matching glob 'G'")
+	mf.StringSliceVarP(&skip, "skip", "", nil, "Don't verify the entries matching glob 'G'")
+	mf.StringArrayVarP(&mismatch, "on-mismatch", "", nil, "Act on files that fail verification (report, retry, quarantine:D, exec:C)")
 	mf.StringVarP(&output, "output", "o", "", "Write hashes to file 'F' [stdout]")
-	mf.Parse(os.Args[1:])
+	mf.BoolVarP(&perDir, "per-dir", "", false, "Write a manifest in each dir for its files; with -v, verify those under dir 'F'")
+	mf.BoolVarP(&appendTo, "append", "a", false, "Append the records of new files to the manifest given by -o")
+	mf.StringVarP(&compress, "compress", "", "", "Compress the output with `C` (gzip, zstd)")
+	mf.StringVarP(&signKey, "sign", "", "", "Sign the manifest given by -o with key `K`")
+	mf.StringVarP(&allowedSigners, "verify-sig", "", "", "Verify the manifest signature against the public keys in `P`")
+	mf.StringVarP(&notifyCmd, "notify-cmd", "", "", "With -v, run command `C` with a JSON summary on stdin if verification fails")
+	mf.StringVarP(&webhook, "webhook", "", "", "With -v, POST a JSON summary to `URL` if verification fails")
+	mf.BoolVarP(&allowURLs, "allow-urls", "", false, "With -v, verify the URLs named in the manifest")
+	mf.BoolVarP(&jsonErrs, "json-errors", "", false, "Write errors to stderr as JSON records")
+
+	// the flag set has already said what's wrong
+	if err := mf.Parse(os.Args[1:]); err != nil {
+		Exit(_ExitUsage)
+	}
+
+	rep = report.New(os.Args[0], jsonErrs)
 
 	if ver {
 		fmt.Printf("%s - %s [%s]\n", Z, ProductVersion, RepoVersion)
-		Exit(0)
+		Exit(_ExitOK)
 	}
 
 	if help {
 		usage(0)
 	}
 
+	useMmap = !noMmap
+
+	// verifying is usually bound by the disk rather than the CPUs
+	if len(verify) > 0 && verifyJobs > 0 {
+		jobs = verifyJobs
+	} else if verifyJobs < 0 {
+		Die("invalid verify job count %d", verifyJobs)
+	}
+
+	var memLimit uint64
+	if len(maxMem) > 0 {
+		z, err := utils.ParseSize(maxMem)
+		if err != nil {
+			Die("invalid max memory %s: %s", maxMem, err)
+		}
+		memLimit = z
+	}
+	if err := setLimits(jobs, memLimit); err != nil {
+		Die("%s", err)
+	}
+
 	if listHashes {
 		printHashes()
-		Exit(0)
+		Exit(_ExitOK)
+	}
+
+	if bench {
+		doBench()
+		Exit(_ExitOK)
+	}
+
+	// the stats are printed however we exit after this
+	if showStats {
+		stats.begin()
+		AtExit(stats.print)
+	}
+	if slowest > 0 {
+		timing = newFileTimes(slowest)
+		AtExit(timing.print)
+	} else if slowest < 0 {
+		Die("--timing must be positive")
 	}
 
 	if len(verify) > 0 {
-		exit := doVerify(verify)
+		switch verifyLevel {
+		case levelSize, levelQuick, levelFull:
+		default:
+			Die("unknown verify level '%s'; try one of: size, quick, full", verifyLevel)
+		}
+
+		m, err := parseOnMismatch(mismatch)
+		if err != nil {
+			Die("%s", err)
+		}
+		onMismatch = m
+
+		if verifyOrder, err = parseVerifyOrder(vorder); err != nil {
+			Die("%s", err)
+		}
+
+		if verifyFilter, err = newPathFilter(only, skip, fold); err != nil {
+			Die("%s", err)
+		}
+
+		if perDir && (verify == "-" || len(allowedSigners) > 0) {
+			Die("--per-dir can't verify a manifest on stdin or with --verify-sig")
+		}
+
+		// a manifest that fails before it's verified is reported too;
+		// see Fatal()
+		if len(notifyCmd) > 0 || len(webhook) > 0 {
+			if verifyNotifier, err = newNotifier(notifyCmd, webhook, verify); err != nil {
+				Die("%s", err)
+			}
+		}
+
+		// the signed manifest is verified from the bytes that were signed
+		var body []byte
+		if len(allowedSigners) > 0 {
+			if verify == "-" {
+				Die("--verify-sig can't verify a manifest on stdin")
+			}
+			// a bad allowed-signers file is a usage error
+			keys, err := loadKeys(allowedSigners)
+			if err != nil {
+				Die("%s", err)
+			}
+			if body, _, err = verifyManifestSig(verify, keys, allowedSigners); err != nil {
+				Fatal(err)
+			}
+		}
+
+		var exit int
+		if perDir {
+			exit = doVerifyDirs(verify)
+		} else {
+			exit = doVerify(verify, "", body)
+		}
+
+		sendNotify()
 		Exit(exit)
 	}
 
+	if len(notifyCmd) > 0 || len(webhook) > 0 {
+		Die("--notify-cmd and --webhook only apply to --verify-from")
+	}
+	if allowURLs {
+		Die("--allow-urls only applies to --verify-from")
+	}
+
 	args := mf.Args()
-	if len(args) < 1 {
+	if nullInput {
+		if len(args) > 0 {
+			Die("--null reads names from stdin; no args allowed")
+		}
+
+		names, err := readNames(os.Stdin)
+		if err != nil {
+			Fatal(fmt.Errorf("stdin: %w", err))
+		}
+		args = names
+	} else if len(args) < 1 {
 		Die("Insufficient arguments. Try '%s -h'", Z)
 	}
 
-	h, ok := Hashes[halgo]
-	if !ok {
-		Die("Unknown hash algorithm '%s'. Try '%s --list-hashes'", halgo, Z)
+	remote, err := allURLs(args)
+	if err != nil {
+		Die("%s", err)
+	}
+	if remote && withMeta {
+		Die("--with-metadata can't be used with URLs")
+	}
+	if descendArchives {
+		if remote {
+			Die("--descend-archives can't be used with URLs")
+		}
+		if withMeta {
+			Die("--descend-archives can't be used with --with-metadata")
+		}
+	}
+
+	if perDir {
+		if remote {
+			Die("--per-dir can't be used with URLs")
+		}
+		if len(output) > 0 || appendTo || len(signKey) > 0 || len(compress) > 0 {
+			Die("--per-dir can't be used with -o, --append, --sign or --compress")
+		}
+	}
+
+	if checkCollisions {
+		collisions = newDigestSet()
+	}
+
+	halgo, bits, h, err := resolveHash(halgo, bits)
+	if err != nil {
+		Die("%s; try '%s --list-hashes'", err, Z)
+	}
+
+	// find the key before we spend time hashing
+	var signer ssh.Signer
+	if len(signKey) > 0 {
+		if len(output) == 0 {
+			Die("--sign needs an output file (-o)")
+		}
+		if signer, err = newSigner(signKey); err != nil {
+			Fatal(err)
+		}
 	}
 
 	var fd io.WriteCloser = os.Stdout
 
-	if len(output) > 0 {
+	// names already in the manifest we're appending to
+	var seen map[string]bool
+	if appendTo {
+		if len(output) == 0 {
+			Die("--append needs an output file (-o)")
+		}
+		if withTree || force || len(compress) > 0 {
+			Die("--append can't be used with --tree-hash, --force-overwrite or --compress")
+		}
+
+		if _, err := os.Stat(output); err == nil {
+			want := manifestOpts{halgo, bits, withMeta, descendArchives, remote}
+			wr, sf, names, err := openAppend(output, want)
+			if err != nil {
+				Fatal(fmt.Errorf("can't append: %w", err))
+			}
+			fd, seen = wr, names
+
+			AtExit(sf.Abort)
+			defer sf.Abort()
+		}
+	}
+
+	if len(output) > 0 && seen == nil {
 		var opt uint32
 		if force {
 			opt |= fio.OPT_OVERWRITE
 		}
 		fx, err := fio.NewSafeFile(output, opt, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
 		if err != nil {
-			Die("%s", err)
+			Fatal(err)
 		}
 		fd = fx
 
@@ -109,103 +346,204 @@ func main() {
 		defer fx.Abort()
 	}
 
-	fmt.Fprintf(fd, "%s %s %s\n", MAGIC, halgo, ProductVersion)
+	eol := eolString()
+	hdr := fmt.Sprintf("%s %s %s", MAGIC, halgo, ProductVersion)
+	if bits > 0 {
+		hdr += fmt.Sprintf(" %s%d", _BitsOpt, bits)
+	}
+	if withMeta {
+		hdr += " " + _MetaOpt
+	}
+	if withTree {
+		hdr += " " + _TreeOpt
+	}
+	if descendArchives {
+		hdr += " " + _ArchiveOpt
+	}
+	if remote {
+		hdr += " " + _URLOpt
+	}
+
+	if seen == nil && !perDir {
+		fd, err = compressWriter(fd, compress)
+		if err != nil {
+			Die("%s", err)
+		}
+
+		if _, err := fmt.Fprintf(fd, "%s%s", hdr, eol); err != nil {
+			Fatal(fmt.Errorf("can't write output: %w", err))
+		}
+	}
+
+	order := orderNone
+	if ordered {
+		order = orderSeq
+		if recurse && !remote {
+			order = orderName
+		}
+	}
+
+	// with --per-dir, the records go to the manifest of each dir
+	var pd *dirManifests
+	var tree *treeHash
+	switch {
+	case perDir:
+		var tgen func() hash.Hash
+		if withTree {
+			tgen = h
+		}
+		pd = newDirManifests(hdr, eol, force, tgen)
+	case withTree:
+		tree = newTreeHash(h)
+	}
+
+	out := newManifestWriter(fd, eol, order, tree)
+	put := out.put
+	if pd != nil {
+		put = pd.put
+	}
+
+	action := func(fi *fio.Info, seq int) error {
+		if out.failed() {
+			return errAborted
+		}
 
-	var wg sync.WaitGroup
-	ch := make(chan otuple, 16)
-	action := func(fi *fio.Info) error {
 		nm := fi.Path()
+		if seen[nm] || (pd != nil && path.Base(nm) == _DirManifest) {
+			return put(otuple{seq: seq, fail: true})
+		}
+
+		var meta string
+		if withMeta {
+			meta = metaString(fi)
+		}
+
+		if isSpecial(fi.Mode()) {
+			return put(otuple{nm: nm, mark: typeMarker(fi.Mode(), fi.Rdev), meta: meta, seq: seq})
+		}
+
 		sum, sz, err := hashFile(nm, h)
 		if err != nil {
+			// keep the output sequence intact
+			if err := put(otuple{seq: seq, fail: true}); err != nil {
+				return err
+			}
 			return err
 		}
 
-		ch <- otuple{nm, sz, sum}
-		return nil
+		collisions.add(nm, sz, sum)
+		return put(otuple{nm, sz, sum, "", meta, seq, false})
 	}
 
-	wg.Add(1)
-	go func(ch chan otuple, fd io.WriteCloser, wg *sync.WaitGroup) {
-		defer wg.Done()
-		for o := range ch {
-			_, err := fmt.Fprintf(fd, "%x|%d|%s\n", o.sum, o.sz, o.nm)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "%s\n", err)
-				return
-			}
+	switch {
+	case remote:
+		excl, gerr := glob.Excludes(excludes, includes, fold)
+		if gerr != nil {
+			Die("%s", gerr)
 		}
-		fd.Close()
-	}(ch, fd, &wg)
+		err = processURLs(args, excl, action)
 
-	var err error
-
-	switch recurse {
-	case true:
+	case recurse:
 		opt := walk.Options{
 			FollowSymlinks: follow,
 			OneFS:          onefs,
-			Type:           walk.FILE,
+			Type:           walk.FILE | walk.DEVICE | walk.SPECIAL,
+		}
+		if jobs > 0 || memLimit > 0 {
+			opt.Concurrency = nWorkers
+		}
+
+		excl, gerr := glob.Excludes(excludes, includes, fold)
+		if gerr != nil {
+			Die("%s", gerr)
 		}
+		opt.Filter = excl.Filter(args)
 
-		err = walk.WalkFunc(args, opt, action)
+		err = walk.WalkFunc(args, opt, func(fi *fio.Info) error {
+			if descendArchives && fi.Mode().IsRegular() && isArchive(fi.Path()) {
+				return walkArchive(fi.Path(), func(fi *fio.Info) error {
+					return action(fi, 0)
+				})
+			}
+			return action(fi, 0)
+		})
 
-	case false:
+	default:
 		err = processArgs(args, follow, action)
 	}
 
-	close(ch)
+	// a write error trumps everything else; the workers would've
+	// only reported errAborted after it.
+	if werr := out.close(); werr != nil {
+		Fatal(fmt.Errorf("can't write output: %w", werr))
+	}
 
 	if err != nil {
-		Warn("%s", err)
+		// the records appended are whole; keep them
+		if seen != nil {
+			fd.Close()
+		}
+		Fatal(err)
 	}
 
-	wg.Wait()
-	if err != nil {
-		Exit(1)
+	if pd != nil {
+		if err = pd.close(); err != nil {
+			Fatal(err)
+		}
+		Exit(_ExitOK)
 	}
-	Exit(0)
-}
 
-func printHashes() {
-	fmt.Printf("%s: Available hash algorithms:\n", Z)
-	for k := range Hashes {
-		fmt.Printf("   %s\n", k)
+	if err = fd.Close(); err != nil {
+		Fatal(err)
 	}
-}
 
-var Hashes = map[string]func() hash.Hash{
-	"sha256":   func() hash.Hash { return sha256.New() },
-	"sha512":   func() hash.Hash { return sha512.New() },
-	"sha3":     func() hash.Hash { return sha3.New512() },
-	"sha3-256": func() hash.Hash { return sha3.New256() },
-	"sha3-512": func() hash.Hash { return sha3.New512() },
-	"blake2s":  func() hash.Hash { return keyedHashGen1(blake2s.New256) },
+	if signer != nil {
+		if err = signManifest(output, signer); err != nil {
+			Fatal(err)
+		}
+	}
 
-	"blake2b":     func() hash.Hash { return keyedHashGen1(blake2b.New512) },
-	"blake2b-256": func() hash.Hash { return keyedHashGen1(blake2b.New256) },
-	"blake2b-512": func() hash.Hash { return keyedHashGen1(blake2b.New512) },
+	// the trailer is already on stdout if we're not writing a file
+	if withTree && len(output) > 0 {
+		fmt.Printf("%s\n", out.treeSum)
+	}
+	Exit(_ExitOK)
+}
+
+// true if manifest records are NUL terminated
+var zeroTerm bool
 
-	"blake3": func() hash.Hash { return keyedHashGen2(blake3.NewKeyed) },
+func eolString() string {
+	if zeroTerm {
+		return "\x00"
+	}
+	return "\n"
 }
 
-func keyedHashGen1(hg func(key []byte) (hash.Hash, error)) hash.Hash {
-	var zeroes [32]byte
-	h, err := hg(zeroes[:])
-	if err != nil {
-		panic(fmt.Sprintf("%v: %s", hg, err))
+// readNames returns the NUL separated names in 'fd'
+func readNames(fd io.Reader) ([]string, error) {
+	var v []string
+
+	rd := bufio.NewScanner(fd)
+	rd.Split(manifest.SplitNul)
+	for rd.Scan() {
+		if nm := rd.Text(); len(nm) > 0 {
+			v = append(v, nm)
+		}
 	}
-	return h
+	return v, rd.Err()
 }
 
-func keyedHashGen2(hg func(key []byte) (*blake3.Hasher, error)) hash.Hash {
-	var zeroes [32]byte
-	h, err := hg(zeroes[:])
-	if err != nil {
-		panic(fmt.Sprintf("%v: %s", hg, err))
+func printHashes() {
+	fmt.Printf("%s: Available hash algorithms:\n", Z)
+	for k := range Hashes {
+		fmt.Printf("   %s\n", k)
 	}
-	return h
 }
 
+// the hash algorithms by name
+var Hashes = hashes.Table
+
 func usage(c int) {
 	x := fmt.Sprintf(`%s is a tool to generate and verify various hashes on files
 
@@ -217,11 +555,158 @@ Options:
   -r, --recurse	        Recursively traverse directories
   -x, --one-filesystem  Don't cross file system boundaries
   -L, --follow-symlinks Follow symbolic links
+  --descend-archives    Hash the members of tar and zip files instead of
+                        the files; see below
+  --exclude=G           With -r, skip entries matching glob 'G'. Globs
+                        follow gitignore(5): a glob without a '/' matches
+                        names at any depth, others are anchored to the
+                        dir they're under; '**' matches any number of
+                        dirs. The last matching glob wins.
+  --include=G           Re-include entries matching glob 'G'
+  --ignore-case         Match --exclude, --include, --only and --skip
+                        ignoring case
   -H, --hash=H		Use hash algorithm 'H' [sha256]
   --list-hashes		List supported hash algorithms
+  --bench               Benchmark each hash algorithm on one and on all
+                        CPUs and recommend the fastest
+  --stats               Show the files, bytes and read rate (on stderr)
+                        at the end of the run
+  --timing[=N]          Show the 'N' slowest files and dirs (by the total
+                        time of their files) to hash and their read rates
+                        (on stderr) at the end of the run [10]
+  --check-collisions    Warn (on stderr) if two files of different sizes
+                        have the same digest; that's almost certainly a
+                        short read or a truncated digest. Every digest is
+                        kept in memory for the run
+  --digest-bits=N       Truncate digests to the leftmost 'N' bits; recorded
+                        in the manifest header and honored by verify.
+                        ALGO-N (e.g. blake3-128) is an alias for
+                        --hash=ALGO --digest-bits=N
   -v, --verify-from=F   Verify the hashes in file 'F' [stdin]
+  --level=L             Verify at level 'L' [full]:
+                          size:  only check file sizes
+                          quick: check sizes and mtimes (needs a
+                                 manifest made with --with-metadata)
+                          full:  check sizes and re-hash each file
+  --verify-jobs=N       With -v, verify at most 'N' files at a time; a
+                        few jobs avoid seek thrash on spinning disks [-j]
+  --verify-order=O      With -v, verify the files in order 'O':
+                          manifest: as they're listed (default)
+                          path:     the files of each dir together
+                          inode:    by inode number on each device;
+                                    close to the on-disk order on most
+                                    file systems
+                        path and inode read the manifest before hashing
+  --only=G              With -v, only verify the entries matching glob
+                        'G'; an entry matches if its path (or a dir in
+                        it) matches. Globs follow --exclude and are
+                        anchored to the root of the manifest paths
+  --skip=G              With -v, don't verify the entries matching 'G'
+  --on-mismatch=A       With --level=full, act on files whose content
+                        doesn't match; repeat to combine actions:
+                          report:       just report it (default)
+                          retry:        re-hash once to rule out a
+                                        transient read error
+                          quarantine:D  move the file to the same path
+                                        under dir 'D'
+                          exec:C        run 'sh -c C' with the path,
+                                        expected and actual sums as
+                                        $1, $2 and $3 (before quarantine)
+  --notify-cmd=C        With -v, run 'sh -c C' if the verify fails; a JSON
+                        summary of the failures is on its stdin
+  --webhook=URL         With -v, POST the same JSON summary to 'URL' if
+                        the verify fails
+  --allow-urls          With -v, verify a manifest of URLs; see below
   -o, --output=O        Write output hashes to file 'O' [stdout]
-`, Z, Z)
+  -a, --append          Append the records of files that aren't in the
+                        manifest given by -o (made with the same hash
+                        options); so a growing tree needn't be re-hashed.
+                        The manifest is created if it doesn't exist.
+  --per-dir             Write a manifest named .ghash in each dir for the
+                        files in it instead of one manifest; with -v D,
+                        verify every .ghash manifest at or below dir 'D'
+  --compress=C          Compress the output with 'C': gzip or zstd.
+                        Compressed manifests are detected and
+                        decompressed by --verify-from
+  --sign=K              Sign the manifest given by -o with key 'K'; the
+                        SSHSIG signature (as made by 'ssh-keygen -Y sign
+                        -n ghash') is written to O.sig. 'K' is one of:
+                          agent:      the only key in ssh-agent
+                          FILE.pub:   the key in ssh-agent matching this
+                                      public key (e.g. a FIDO2 token)
+                          FILE:       an unencrypted OpenSSH private key
+  --verify-sig=P        With -v F, first check the signature in F.sig;
+                        it must be made by one of the public keys (one
+                        per line, as in authorized_keys) in file 'P'.
+                        SHA-1 (ssh-rsa) signatures are rejected.
+  -O, --ordered         Write records in input order; with -r, records
+                        are sorted by name
+  -f, --force-overwrite Forcibly overwrite output file
+  -m, --with-metadata   Record file mode, owner and mtime
+  --tree-hash           Combine the records (sorted by name) into one
+                        digest; it's written as the manifest trailer
+                        (and printed with -o) and checked by verify
+  -j, --jobs=N          Hash at most 'N' files at a time and use at most
+                        'N' CPUs [2 x GOMAXPROCS]
+  --max-memory=M        Map (or buffer) at most 'M' bytes at a time
+                        across all the files being hashed; fewer files
+                        are hashed at once if needed. 'M' can have a
+                        suffix of k, M, G etc.
+  --no-mmap             Don't use mmap(2) to read files; mmap failures
+                        always fall back to regular reads
+  -0, --null            Read NUL separated names to hash from stdin
+                        (e.g. find -print0) instead of the command line
+  -z, --zero-terminated Manifest records (incl. the header) end in NUL
+                        instead of newline; applies to --verify-from too
+  --json-errors         Write errors to stderr as JSON records:
+                        {"prog", "kind", "op", "path", "error"}
+
+The names can be URLs of object stores instead of local paths; e.g.
+s3://BUCKET/PREFIX hashes every object under PREFIX (--exclude and
+--include apply) and the manifest records the URLs, so it can be
+verified with -v and --allow-urls. URLs and local names can't be mixed.
+Verifying URLs makes requests with your credentials; so the header of
+such a manifest says so, a manifest of URLs isn't verified without
+--allow-urls and a URL in a manifest of local files is a mismatch.
+S3 is configured by the usual AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+AWS_SESSION_TOKEN and AWS_REGION; set AWS_ENDPOINT_URL for compatible
+stores (e.g. minio). The other URLs are tar://FILE.tar, zip://FILE
+(a member is FILE::NAME), sftp://[USER@]HOST/PATH and file://PATH.
+
+With --descend-archives, files named *.tar, *.tar.gz, *.tgz, *.tar.bz2,
+*.tbz2, *.tbz and *.zip are treated as dirs: each member is a record
+named ARCHIVE::MEMBER (e.g. dist/x.tar::bin/x) and the archive itself
+isn't hashed. Symlinks in archives are skipped. The manifest header
+records this; so -v verifies the members in place. It can't be used
+with URLs or --with-metadata.
+
+With --per-dir, each manifest names its files relative to its dir; so a
+dir can be moved or copied with its manifest and verified on its own.
+The manifests are only written once every file is hashed. It can't be
+used with URLs, -o, --append, --sign or --compress; --tree-hash adds a
+trailer to each manifest. With -v, a manifest that can't be read (or
+is corrupt) is reported and the others are still verified.
+
+With --notify-cmd and --webhook, a scheduled verify can raise an alert
+by itself: if any file or the manifest doesn't match (i.e. the exit
+status isn't 0), a JSON summary is sent once the verify is done:
+{"prog", "host", "time", "manifest", "exit", "errors", "records",
+"truncated"}. The records are the first %d errors as in --json-errors.
+A failed notification is a warning; it doesn't change the exit status.
+A manifest that can't be read or whose signature doesn't verify is
+reported the same way.
+
+Exit status is the same when generating and verifying; when there are
+several kinds of errors, the largest applies:
+
+  0   all is well
+  1   a file or the manifest doesn't match (incl. a malformed
+      manifest or a bad signature)
+  2   a file (or the manifest) is missing
+  3   an I/O error
+  4   permission was denied
+  64  a usage error: bad options or arguments
+`, Z, Z, _NotifyErrors)
 
 	os.Stdout.Write([]byte(x))
 	Exit(c)
@@ -0,0 +1,61 @@
+// meta.go -- file metadata recorded in manifests
+//
+// (c) 2023 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+
+	"github.com/opencoff/go-fio"
+)
+
+// header token denoting that each entry has a metadata field
+const _MetaOpt = "meta"
+
+// metaString returns the metadata field of a manifest entry:
+//
+//	mode:uid:gid:mtime
+//
+// where mode is the octal unix mode (incl. setuid, setgid and sticky
+// bits) and mtime is in nanoseconds since the unix epoch.
+func metaString(fi *fio.Info) string {
+	return fmt.Sprintf("%o:%d:%d:%d", unixMode(fi.Mode()), fi.Uid, fi.Gid, fi.ModTime().UnixNano())
+}
+
+// unixMode converts the permission bits of a go FileMode to the unix
+// representation
+func unixMode(m fs.FileMode) uint32 {
+	v := uint32(m.Perm())
+	if m&fs.ModeSetuid > 0 {
+		v |= 04000
+	}
+	if m&fs.ModeSetgid > 0 {
+		v |= 02000
+	}
+	if m&fs.ModeSticky > 0 {
+		v |= 01000
+	}
+	return v
+}
+
+// parseMtime returns the mtime in a metadata field
+func parseMtime(s string) (int64, error) {
+	v := strings.Split(s, ":")
+	if len(v) != 4 {
+		return 0, fmt.Errorf("expected 4 fields, saw %d", len(v))
+	}
+	return strconv.ParseInt(v[3], 10, 64)
+}
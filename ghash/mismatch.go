@@ -0,0 +1,145 @@
+// mismatch.go -- actions taken when a file fails verification
+//
+// (c) 2023 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/opencoff/go-fio"
+)
+
+// what to do when the content of a file doesn't match its checksum
+type mismatchAction struct {
+	// re-hash the file once before declaring it corrupt
+	retry bool
+
+	// move corrupt files under this dir
+	quarantine string
+
+	// shell command run with the path, expected and actual sums
+	exec string
+}
+
+// actions chosen by --on-mismatch
+var onMismatch mismatchAction
+
+// parse the --on-mismatch values: report, retry, quarantine:DIR and
+// exec:CMD. They can be combined; 'report' alone is the default.
+func parseOnMismatch(v []string) (mismatchAction, error) {
+	var m mismatchAction
+
+	for _, s := range v {
+		act, arg, _ := strings.Cut(s, ":")
+		switch act {
+		case "report":
+		case "retry":
+			m.retry = true
+		case "quarantine":
+			if len(arg) == 0 {
+				return m, fmt.Errorf("--on-mismatch: quarantine needs a dir")
+			}
+			m.quarantine = arg
+		case "exec":
+			if len(arg) == 0 {
+				return m, fmt.Errorf("--on-mismatch: exec needs a command")
+			}
+			m.exec = arg
+		default:
+			return m, fmt.Errorf("--on-mismatch: unknown action '%s'", s)
+		}
+	}
+	return m, nil
+}
+
+// handle a file whose checksum is 'sum' instead of the expected one;
+// returns nil if a re-read shows the file is intact. The mismatch
+// error is returned along with any errors from the actions.
+func (m *mismatchAction) handle(d datum, sum string, hgen func() hash.Hash) error {
+	if m.retry {
+		s, _, err := hashFile(d.file, hgen)
+		if err != nil {
+			return fmt.Errorf("%s: can't re-hash: %w", d.errPrefix, err)
+		}
+
+		if sum = fmt.Sprintf("%x", s); sum == d.expsum {
+			Warn("%s: '%s' transient mismatch; re-read is ok", d.errPrefix, d.file)
+			return nil
+		}
+	}
+
+	errs := []error{errModified(d)}
+	if len(m.exec) > 0 {
+		if err := m.runHook(d, sum); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(m.quarantine) > 0 {
+		if err := m.moveAside(d); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// run the user's hook as: sh -c CMD ghash PATH EXPECTED ACTUAL
+func (m *mismatchAction) runHook(d datum, sum string) error {
+	cmd := exec.Command("/bin/sh", "-c", m.exec, Z, d.file, d.expsum, sum)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: '%s' hook: %w", d.errPrefix, m.exec, err)
+	}
+	return nil
+}
+
+// move the corrupt file to the same relative path under the quarantine
+// dir; an existing file there is never overwritten.
+func (m *mismatchAction) moveAside(d datum) error {
+	abs, err := filepath.Abs(d.file)
+	if err != nil {
+		return fmt.Errorf("%s: quarantine: %w", d.errPrefix, err)
+	}
+
+	rel := strings.TrimPrefix(abs, filepath.VolumeName(abs))
+	dst := filepath.Join(m.quarantine, rel)
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("%s: quarantine: '%s' already exists", d.errPrefix, dst)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return fmt.Errorf("%s: quarantine: %w", d.errPrefix, err)
+	}
+
+	err = os.Rename(d.file, dst)
+	if errors.Is(err, syscall.EXDEV) {
+		// different file system; copy and remove the original
+		if err = fio.CopyFile(dst, d.file, 0600); err == nil {
+			err = os.Remove(d.file)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s: quarantine: %w", d.errPrefix, err)
+	}
+
+	Warn("%s: '%s' quarantined to '%s'", d.errPrefix, d.file, dst)
+	return nil
+}
@@ -0,0 +1,165 @@
+// notify.go -- tell someone when a verify fails
+//
+// (c) 2023 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"time"
+
+	"go-progs/internal/report"
+)
+
+// at most these many errors are in a summary
+const _NotifyErrors = 100
+
+// time allowed for each notification
+const _NotifyTimeout = 30 * time.Second
+
+// summary of a failed verify; it's the input of --notify-cmd and the
+// body of the --webhook request.
+type notifySummary struct {
+	Prog     string          `json:"prog"`
+	Host     string          `json:"host"`
+	Time     string          `json:"time"`
+	Manifest string          `json:"manifest"`
+	Exit     int             `json:"exit"`
+	Errors   int             `json:"errors"`
+	Records  []report.Record `json:"records"`
+
+	// true if there are more errors than records
+	Truncated bool `json:"truncated"`
+}
+
+// notifier runs a command and posts to a webhook when a verify fails
+type notifier struct {
+	cmd      string
+	webhook  string
+	manifest string
+}
+
+// set by --notify-cmd and --webhook; the summary is sent at the end of
+// the verify - or by Fatal() if it stops early.
+var verifyNotifier *notifier
+
+// newNotifier returns a notifier for the failures verifying manifest 'nm'
+func newNotifier(cmd, webhook, nm string) (*notifier, error) {
+	if len(webhook) > 0 {
+		u, err := url.Parse(webhook)
+		if err != nil {
+			return nil, fmt.Errorf("webhook: %w", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("webhook: %s: not a http or https URL", webhook)
+		}
+	}
+
+	n := &notifier{
+		cmd:      cmd,
+		webhook:  webhook,
+		manifest: nm,
+	}
+
+	// the summary needs the errors as they're reported
+	rep.Keep(_NotifyErrors)
+	return n, nil
+}
+
+// sendNotify sends the summary of the errors so far - if any - once
+func sendNotify() {
+	if n := verifyNotifier; n != nil {
+		verifyNotifier = nil
+		n.notify()
+	}
+}
+
+// notify sends the summary of the errors so far - if any. Failed
+// notifications are warned about; they don't change the exit code.
+func (n *notifier) notify() {
+	code := rep.Code()
+	if code == 0 {
+		return
+	}
+
+	host, _ := os.Hostname()
+	s := notifySummary{
+		Prog:     Z,
+		Host:     host,
+		Time:     time.Now().UTC().Format(time.RFC3339),
+		Manifest: n.manifest,
+		Exit:     code,
+		Errors:   rep.Errors(),
+		Records:  rep.Records(),
+	}
+	s.Truncated = s.Errors > len(s.Records)
+
+	b, err := json.Marshal(&s)
+	if err != nil {
+		Warn("notify: %s", err)
+		return
+	}
+	b = append(b, '\n')
+
+	ctx, cancel := context.WithTimeout(context.Background(), _NotifyTimeout)
+	defer cancel()
+
+	if len(n.cmd) > 0 {
+		if err := n.runCmd(ctx, b); err != nil {
+			Warn("notify: %s", err)
+		}
+	}
+	if len(n.webhook) > 0 {
+		if err := n.post(ctx, b); err != nil {
+			Warn("notify: %s", err)
+		}
+	}
+}
+
+// run the user's command as: sh -c CMD ghash - with the summary on stdin
+func (n *notifier) runCmd(ctx context.Context, b []byte) error {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", n.cmd, Z)
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("'%s': %w", n.cmd, err)
+	}
+	return nil
+}
+
+// post the summary to the webhook; anything but a 2xx is an error
+func (n *notifier) post(ctx context.Context, b []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.webhook, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", n.webhook, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+// output.go -- manifest writer
+//
+// (c) 2023 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// output ordering
+const (
+	// records are written as they're computed
+	orderNone = iota
+
+	// records are written in input order (via sequence numbers)
+	orderSeq
+
+	// records are written sorted by name
+	orderName
+)
+
+// errAborted is returned to workers once the writer has failed
+var errAborted = errors.New("output aborted")
+
+// manifestWriter serializes the records computed by concurrent workers.
+// A write error aborts the writer: subsequent put() calls fail fast so
+// that the workers stop, and the error is returned by close().
+type manifestWriter struct {
+	fd    io.Writer
+	eol   string
+	order int
+
+	ch    chan otuple
+	abort chan struct{}
+	wg    sync.WaitGroup
+	err   error
+
+	// pending records for ordered output
+	next int
+	pend map[int]otuple
+	all  []otuple
+
+	// optional tree hash and its final value
+	tree    *treeHash
+	treeSum string
+}
+
+func newManifestWriter(fd io.Writer, eol string, order int, tree *treeHash) *manifestWriter {
+	w := &manifestWriter{
+		fd:    fd,
+		eol:   eol,
+		order: order,
+		tree:  tree,
+		ch:    make(chan otuple, 16),
+		abort: make(chan struct{}),
+		pend:  make(map[int]otuple),
+	}
+
+	w.wg.Add(1)
+	go w.run()
+	return w
+}
+
+// put queues a record; it returns an error if the writer has failed
+func (w *manifestWriter) put(o otuple) error {
+	select {
+	case <-w.abort:
+		return errAborted
+	case w.ch <- o:
+		return nil
+	}
+}
+
+// failed returns true if the writer has failed
+func (w *manifestWriter) failed() bool {
+	select {
+	case <-w.abort:
+		return true
+	default:
+		return false
+	}
+}
+
+// close flushes the pending records and returns the first write error
+func (w *manifestWriter) close() error {
+	close(w.ch)
+	w.wg.Wait()
+	return w.err
+}
+
+func (w *manifestWriter) run() {
+	defer w.wg.Done()
+
+	for o := range w.ch {
+		if w.err != nil {
+			continue
+		}
+
+		switch w.order {
+		case orderSeq:
+			w.pend[o.seq] = o
+			for {
+				p, ok := w.pend[w.next]
+				if !ok {
+					break
+				}
+				delete(w.pend, w.next)
+				w.next++
+				w.write(p)
+			}
+
+		case orderName:
+			w.all = append(w.all, o)
+
+		default:
+			w.write(o)
+		}
+	}
+
+	if w.order == orderName && w.err == nil {
+		sort.Slice(w.all, func(i, j int) bool {
+			return w.all[i].nm < w.all[j].nm
+		})
+		for _, o := range w.all {
+			w.write(o)
+		}
+	}
+
+	if w.tree != nil && w.err == nil {
+		w.treeSum = w.tree.sum()
+		if _, err := fmt.Fprintf(w.fd, "%s%s%s", _TreeMagic, w.treeSum, w.eol); err != nil {
+			w.err = err
+		}
+	}
+}
+
+// write a record; failed entries are placeholders that just
+// advance the sequence.
+func (w *manifestWriter) write(o otuple) {
+	if o.fail || w.err != nil {
+		return
+	}
+
+	sum := o.mark
+	if len(sum) == 0 {
+		sum = fmt.Sprintf("%x", o.sum)
+	}
+
+	var line string
+	if len(o.meta) > 0 {
+		line = fmt.Sprintf("%s|%d|%s|%s", sum, o.sz, o.meta, o.nm)
+	} else {
+		line = fmt.Sprintf("%s|%d|%s", sum, o.sz, o.nm)
+	}
+
+	if w.tree != nil {
+		w.tree.add(o.nm, line)
+	}
+
+	if _, err := fmt.Fprintf(w.fd, "%s%s", line, w.eol); err != nil {
+		w.err = err
+		close(w.abort)
+	}
+}
@@ -0,0 +1,170 @@
+// perdir.go -- a manifest in each dir for the files in it
+//
+// (c) 2023 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/opencoff/go-fio"
+	"github.com/opencoff/go-fio/walk"
+)
+
+// name of the manifest written in each dir by --per-dir
+const _DirManifest = ".ghash"
+
+// dirManifests collects the records of each dir; they're written to
+// the manifest in that dir once all the files are hashed. The names in
+// a manifest are relative to its dir.
+type dirManifests struct {
+	sync.Mutex
+	hdr   string
+	eol   string
+	force bool
+
+	// set with --tree-hash; each manifest has its own trailer
+	tree func() hash.Hash
+
+	dirs map[string][]otuple
+}
+
+func newDirManifests(hdr, eol string, force bool, tree func() hash.Hash) *dirManifests {
+	d := &dirManifests{
+		hdr:   hdr,
+		eol:   eol,
+		force: force,
+		tree:  tree,
+		dirs:  make(map[string][]otuple),
+	}
+	return d
+}
+
+// put queues a record for the manifest of its dir
+func (d *dirManifests) put(o otuple) error {
+	if o.fail {
+		return nil
+	}
+
+	dir, base := splitDir(o.nm)
+	o.nm = base
+
+	d.Lock()
+	d.dirs[dir] = append(d.dirs[dir], o)
+	d.Unlock()
+	return nil
+}
+
+// close writes the manifest of each dir
+func (d *dirManifests) close() error {
+	dirs := make([]string, 0, len(d.dirs))
+	for dir := range d.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	var errs []error
+	for _, dir := range dirs {
+		if err := d.write(dir, d.dirs[dir]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// write the records 'v' to the manifest in 'dir'
+func (d *dirManifests) write(dir string, v []otuple) error {
+	var opt uint32
+	if d.force {
+		opt |= fio.OPT_OVERWRITE
+	}
+
+	fn := filepath.Join(dir, _DirManifest)
+	fd, err := fio.NewSafeFile(fn, opt, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer fd.Abort()
+
+	if _, err := fmt.Fprintf(fd, "%s%s", d.hdr, d.eol); err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	var tree *treeHash
+	if d.tree != nil {
+		tree = newTreeHash(d.tree)
+	}
+
+	w := newManifestWriter(fd, d.eol, orderName, tree)
+	for _, o := range v {
+		if err := w.put(o); err != nil {
+			break
+		}
+	}
+	if err := w.close(); err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+	return fd.Close()
+}
+
+// splitDir returns the dir of the file 'nm' and its name in that dir;
+// an archive member is in the dir of its archive.
+func splitDir(nm string) (string, string) {
+	var member string
+	if _, ok := memberURL(nm); ok {
+		nm, member, _ = strings.Cut(nm, _MemberSep)
+		member = _MemberSep + member
+	}
+	return filepath.Dir(nm), filepath.Base(nm) + member
+}
+
+// doVerifyDirs verifies each per-dir manifest at or below 'dir' and
+// returns the exit code.
+func doVerifyDirs(dir string) int {
+	var mu sync.Mutex
+	var mfs []string
+
+	opt := walk.Options{
+		Type: walk.FILE,
+	}
+	err := walk.WalkFunc([]string{dir}, opt, func(fi *fio.Info) error {
+		if nm := fi.Path(); filepath.Base(nm) == _DirManifest {
+			mu.Lock()
+			mfs = append(mfs, nm)
+			mu.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
+		rep.Error(err)
+	}
+
+	if len(mfs) == 0 {
+		Fatal(fmt.Errorf("%s: no %s manifests: %w", dir, _DirManifest, os.ErrNotExist))
+	}
+
+	// a manifest that can't be verified doesn't stop the others
+	sort.Strings(mfs)
+	for _, nm := range mfs {
+		if err := verifyManifest(nm, filepath.Dir(nm), nil); err != nil {
+			rep.Error(err)
+		}
+	}
+	return rep.Code()
+}
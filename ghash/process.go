@@ -26,21 +26,27 @@ import (
 
 const _parallelism int = 2
 
-var nWorkers = runtime.NumCPU() * _parallelism
+// GOMAXPROCS (from the env) bounds the default
+var nWorkers = runtime.GOMAXPROCS(0) * _parallelism
 
-// iterate over the names
-func processArgs(args []string, followSymlinks bool, apply func(*fio.Info) error) error {
+// a file to process and its input sequence#
+type work struct {
+	fi  *fio.Info
+	seq int
+}
+
+// iterate over the names; each entry that is processed gets a
+// sequence# in the order of 'args'.
+func processArgs(args []string, followSymlinks bool, apply func(*fio.Info, int) error) error {
 	nw := nWorkers
 	if len(args) < nw {
 		nw = len(args)
 	}
 
-	ch := make(chan *fio.Info, nWorkers)
-	errch := make(chan error, 1)
-
 	// iterate in the background and feed the workers
-	go func(ch chan *fio.Info, errch chan error) {
+	feed := func(ch chan<- work, errch chan<- error) {
 		var sr symlinkResolver
+		var seq int
 
 		for _, nm := range args {
 			fi, err := fio.Lstat(nm)
@@ -64,7 +70,7 @@ func processArgs(args []string, followSymlinks bool, apply func(*fio.Info) error
 
 				nm, fi, err = sr.resolve(nm, fi)
 				if err != nil {
-					errch <- fmt.Errorf("%w", nm, err)
+					errch <- err
 					continue
 				}
 
@@ -80,15 +86,38 @@ func processArgs(args []string, followSymlinks bool, apply func(*fio.Info) error
 			case m.IsDir():
 				errch <- fmt.Errorf("skipping dir %s..", nm)
 
-			case m.IsRegular():
-				ch <- fi
+			case m.IsRegular() && descendArchives && isArchive(nm):
+				err := walkArchive(nm, func(fi *fio.Info) error {
+					ch <- work{fi, seq}
+					seq++
+					return nil
+				})
+				if err != nil {
+					errch <- err
+				}
+
+			case m.IsRegular(), isSpecial(m):
+				ch <- work{fi, seq}
+				seq++
 
 			default:
 				errch <- fmt.Errorf("skipping non-file %s..", nm)
 			}
 		}
+	}
+	return runWork(nw, feed, apply)
+}
+
+// runWork calls 'apply' on 'nw' workers for each entry queued by 'feed';
+// the errors of both are joined.
+func runWork(nw int, feed func(ch chan<- work, errch chan<- error), apply func(*fio.Info, int) error) error {
+	ch := make(chan work, nWorkers)
+	errch := make(chan error, 1)
+
+	go func() {
+		feed(ch, errch)
 		close(ch)
-	}(ch, errch)
+	}()
 
 	// now start workers and process entries
 	var wrkWait, errWait sync.WaitGroup
@@ -108,9 +137,9 @@ func processArgs(args []string, followSymlinks bool, apply func(*fio.Info) error
 
 	wrkWait.Add(nw)
 	for i := 0; i < nw; i++ {
-		go func(in chan *fio.Info, errch chan error) {
+		go func(in chan work, errch chan error) {
 			for r := range in {
-				err := apply(r)
+				err := apply(r.fi, r.seq)
 				if err != nil {
 					errch <- err
 				}
@@ -144,7 +173,7 @@ func (s *symlinkResolver) resolve(nm string, fi *fio.Info) (string, *fio.Info, e
 	}
 
 	// If we've seen this inode before, we are done.
-	if s.isEntrySeen(nm, fi) || !fi.Mode().IsRegular() {
+	if s.isEntrySeen(nm, fi) {
 		return "", nil, nil
 	}
 
@@ -0,0 +1,115 @@
+// remote.go -- hash the objects named by URLs (e.g. s3://bucket/dir)
+//
+// (c) 2023 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/opencoff/go-fio"
+	"go-progs/internal/glob"
+	"go-progs/internal/vfs"
+)
+
+// header token denoting that the manifest names URLs
+const _URLOpt = "urls"
+
+// set by --allow-urls; verifying URLs makes requests with the user's
+// credentials (e.g. S3 keys or the ssh agent). So a manifest from
+// elsewhere mustn't make them unasked.
+var allowURLs bool
+
+// set by the manifest header when verifying
+var manifestURLs bool
+
+// allURLs returns true if every name in 'args' is a URL; URLs and local
+// names can't be mixed.
+func allURLs(args []string) (bool, error) {
+	var n int
+	for _, nm := range args {
+		if vfs.IsURL(nm) {
+			n++
+		}
+	}
+
+	if n > 0 && n != len(args) {
+		return false, fmt.Errorf("can't mix URLs and local names")
+	}
+	return n > 0, nil
+}
+
+// processURLs walks each URL in 'args' and calls 'apply' for the objects
+// at or below it that aren't excluded by 'excl'. Each object gets a
+// sequence# in the order they're listed.
+func processURLs(args []string, excl *glob.Matcher, apply func(*fio.Info, int) error) error {
+	feed := func(ch chan<- work, errch chan<- error) {
+		var seq int
+
+		for _, nm := range args {
+			root := strings.TrimSuffix(nm, "/")
+			err := vfs.Walk(nm, func(e *vfs.Entry) error {
+				rel := strings.TrimPrefix(strings.TrimPrefix(e.Name, root), "/")
+				if e.IsLink() || (len(rel) > 0 && excl.MatchPath(rel)) {
+					return nil
+				}
+
+				ch <- work{entryInfo(e), seq}
+				seq++
+				return nil
+			})
+			if err != nil {
+				errch <- err
+			}
+		}
+	}
+	return runWork(nWorkers, feed, apply)
+}
+
+// entryInfo returns the remote object 'e' as a file
+func entryInfo(e *vfs.Entry) *fio.Info {
+	fi := &fio.Info{
+		Siz:  e.Size,
+		Mod:  0644,
+		Mtim: e.Mtime,
+		Ctim: e.Mtime,
+	}
+	if e.Dir {
+		fi.Mod |= fs.ModeDir | 0111
+	}
+	fi.SetPath(e.Name)
+	return fi
+}
+
+// hash the object named by the URL 'nm'
+func hashURL(nm string, hgen func() hash.Hash) ([]byte, int64, error) {
+	rd, err := vfs.Open(nm)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rd.Close()
+
+	start := time.Now()
+	h := hgen()
+	sz, err := io.CopyBuffer(h, rd, make([]byte, ioBufSize()))
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", nm, err)
+	}
+	stats.add(sz)
+	timing.add(nm, sz, time.Since(start))
+	return h.Sum(nil)[:], sz, nil
+}
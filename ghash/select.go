@@ -0,0 +1,72 @@
+// select.go -- verify a subset of a manifest
+//
+// (c) 2023 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"go-progs/internal/glob"
+)
+
+// pathFilter selects the manifest entries to verify: those that match
+// one of the --only globs (all if there are none) and none of the
+// --skip globs. An entry matches a glob if its path - or one of the
+// dirs in its path - matches.
+type pathFilter struct {
+	only *glob.Matcher
+	skip *glob.Matcher
+}
+
+// selected by --only/--skip; nil if all entries are verified
+var verifyFilter *pathFilter
+
+func newPathFilter(only, skip []string, fold bool) (*pathFilter, error) {
+	if len(only) == 0 && len(skip) == 0 {
+		return nil, nil
+	}
+
+	o, err := glob.New(only, fold)
+	if err != nil {
+		return nil, err
+	}
+	s, err := glob.New(skip, fold)
+	if err != nil {
+		return nil, err
+	}
+	return &pathFilter{o, s}, nil
+}
+
+// keep returns true if the entry named 'nm' in a manifest record must
+// be verified.
+func (f *pathFilter) keep(nm string) bool {
+	if f == nil {
+		return true
+	}
+
+	if strings.HasPrefix(nm, `"`) {
+		if s, err := strconv.Unquote(nm); err == nil {
+			nm = s
+		}
+	}
+
+	// globs are anchored to the root of the manifest paths
+	rel := strings.TrimPrefix(nm, "./")
+	rel = strings.TrimLeft(rel, "/")
+
+	if !f.only.Empty() && !f.only.MatchPath(rel) {
+		return false
+	}
+	return !f.skip.MatchPath(rel)
+}
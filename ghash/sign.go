@@ -0,0 +1,350 @@
+// sign.go - sign manifests and verify their signatures
+//
+// (c) 2023 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"go-progs/internal/report"
+
+	"github.com/opencoff/go-fio"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Manifests are signed in the SSHSIG format of ssh-keygen(1); so a
+// signature can also be checked with:
+//
+//	ssh-keygen -Y verify -n ghash -f allowed_signers -I ID -s F.sig < F
+//
+// See PROTOCOL.sshsig in the OpenSSH sources.
+const (
+	_SigMagic     = "SSHSIG"
+	_SigVersion   = 1
+	_SigNamespace = "ghash"
+	_SigHash      = "sha512"
+	_SigBegin     = "-----BEGIN SSH SIGNATURE-----"
+	_SigEnd       = "-----END SSH SIGNATURE-----"
+
+	// ssh-keygen wraps the armored signature at this column
+	_SigWrap = 70
+
+	// --sign=agent uses the only key in ssh-agent
+	_SigAgent = "agent"
+)
+
+// the blob that is signed
+type sigData struct {
+	Namespace string
+	Reserved  string
+	HashAlgo  string
+	Hash      []byte
+}
+
+// the signature file (after the magic)
+type sigFile struct {
+	Version   uint32
+	PublicKey []byte
+	Namespace string
+	Reserved  string
+	HashAlgo  string
+	Signature []byte
+}
+
+// sigName returns the name of the signature of manifest 'nm'
+func sigName(nm string) string {
+	return nm + ".sig"
+}
+
+// newSigner returns the signer for --sign=K. 'K' is one of:
+//
+//	agent        the only key in ssh-agent
+//	a .pub file  the key in ssh-agent matching this public key
+//	a key file   an unencrypted OpenSSH (or PEM) private key
+func newSigner(k string) (ssh.Signer, error) {
+	if k == _SigAgent {
+		return agentSigner(nil)
+	}
+
+	b, err := os.ReadFile(k)
+	if err != nil {
+		return nil, err
+	}
+
+	if pk, _, _, _, err := ssh.ParseAuthorizedKey(b); err == nil {
+		s, err := agentSigner(pk)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		return s, nil
+	}
+
+	s, err := ssh.ParsePrivateKey(b)
+	if err != nil {
+		var pe *ssh.PassphraseMissingError
+		if errors.As(err, &pe) {
+			return nil, fmt.Errorf("%s: key is encrypted; add it to ssh-agent and sign with %s.pub", k, k)
+		}
+		return nil, fmt.Errorf("%s: %w", k, err)
+	}
+	return s, nil
+}
+
+// agentSigner returns the key 'pk' in ssh-agent; if 'pk' is nil, the
+// agent must hold exactly one key.
+func agentSigner(pk ssh.PublicKey) (ssh.Signer, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if len(sock) == 0 {
+		return nil, errors.New("ssh-agent isn't running (SSH_AUTH_SOCK isn't set)")
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, fmt.Errorf("ssh-agent: %w", err)
+	}
+
+	// the connection is needed until the manifest is signed; it's
+	// closed when we exit.
+	v, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ssh-agent: %w", err)
+	}
+
+	if pk == nil {
+		if len(v) != 1 {
+			conn.Close()
+			return nil, fmt.Errorf("ssh-agent has %d keys; name one with --sign=KEY.pub", len(v))
+		}
+		return v[0], nil
+	}
+
+	want := pk.Marshal()
+	for _, s := range v {
+		if bytes.Equal(s.PublicKey().Marshal(), want) {
+			return s, nil
+		}
+	}
+	conn.Close()
+	return nil, fmt.Errorf("key %s isn't in ssh-agent", ssh.FingerprintSHA256(pk))
+}
+
+// signManifest signs the manifest 'nm' with 's' and writes the
+// signature to 'nm.sig'.
+func signManifest(nm string, s ssh.Signer) error {
+	sum, err := manifestSum(nm)
+	if err != nil {
+		return err
+	}
+
+	sig, err := signBlob(s, sum)
+	if err != nil {
+		return fmt.Errorf("%s: can't sign: %w", nm, err)
+	}
+
+	f := sigFile{
+		Version:   _SigVersion,
+		PublicKey: s.PublicKey().Marshal(),
+		Namespace: _SigNamespace,
+		HashAlgo:  _SigHash,
+		Signature: ssh.Marshal(sig),
+	}
+
+	fn := sigName(nm)
+	fd, err := fio.NewSafeFile(fn, fio.OPT_OVERWRITE, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer fd.Abort()
+
+	if _, err := fd.Write(armor(append([]byte(_SigMagic), ssh.Marshal(f)...))); err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+	return fd.Close()
+}
+
+// signBlob signs the SSHSIG blob for the digest 'sum'; RSA keys use
+// rsa-sha2-512 like ssh-keygen.
+func signBlob(s ssh.Signer, sum []byte) (*ssh.Signature, error) {
+	blob := signedData(sum)
+	if s.PublicKey().Type() == ssh.KeyAlgoRSA {
+		as, ok := s.(ssh.AlgorithmSigner)
+		if !ok {
+			return nil, errors.New("RSA key can't make rsa-sha2-512 signatures")
+		}
+		return as.SignWithAlgorithm(rand.Reader, blob, ssh.KeyAlgoRSASHA512)
+	}
+	return s.Sign(rand.Reader, blob)
+}
+
+// verifyManifestSig verifies the signature of manifest 'nm' in 'nm.sig';
+// the signer must be one of 'keys' (read from the file 'allowed'). It
+// returns the contents of the manifest that was verified - so it's
+// not read again - and the key that made the signature. A signature
+// that doesn't verify is a mismatch.
+func verifyManifestSig(nm string, keys []ssh.PublicKey, allowed string) ([]byte, ssh.PublicKey, error) {
+	fn := sigName(nm)
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := parseSig(b)
+	if err != nil {
+		return nil, nil, report.Mismatch(fmt.Errorf("%s: %w", fn, err))
+	}
+
+	pk, err := ssh.ParsePublicKey(f.PublicKey)
+	if err != nil {
+		return nil, nil, report.Mismatch(fmt.Errorf("%s: %w", fn, err))
+	}
+
+	if !hasKey(keys, pk) {
+		return nil, nil, report.Mismatch(fmt.Errorf("%s: signed by %s; it's not in %s", fn, ssh.FingerprintSHA256(pk), allowed))
+	}
+
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(f.Signature, &sig); err != nil {
+		return nil, nil, report.Mismatch(fmt.Errorf("%s: malformed signature: %w", fn, err))
+	}
+
+	// like ssh-keygen, SHA-1 RSA signatures aren't trusted
+	if sig.Format == ssh.KeyAlgoRSA {
+		return nil, nil, report.Mismatch(fmt.Errorf("%s: %s signatures (SHA-1) aren't supported", fn, sig.Format))
+	}
+
+	m, err := os.ReadFile(nm)
+	if err != nil {
+		return nil, nil, err
+	}
+	sum := sha512.Sum512(m)
+	if err := pk.Verify(signedData(sum[:]), &sig); err != nil {
+		return nil, nil, report.Mismatch(fmt.Errorf("%s: bad signature for %s", fn, nm))
+	}
+	return m, pk, nil
+}
+
+// parseSig parses the armored SSHSIG signature in 'b'
+func parseSig(b []byte) (*sigFile, error) {
+	s := strings.TrimSpace(string(b))
+	if !strings.HasPrefix(s, _SigBegin) || !strings.HasSuffix(s, _SigEnd) {
+		return nil, errors.New("not an SSH signature")
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, _SigBegin), _SigEnd)
+
+	raw, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %w", err)
+	}
+	if !bytes.HasPrefix(raw, []byte(_SigMagic)) {
+		return nil, errors.New("not an SSH signature")
+	}
+
+	var f sigFile
+	if err := ssh.Unmarshal(raw[len(_SigMagic):], &f); err != nil {
+		return nil, fmt.Errorf("malformed signature: %w", err)
+	}
+
+	switch {
+	case f.Version != _SigVersion:
+		return nil, fmt.Errorf("unsupported signature version %d", f.Version)
+	case f.Namespace != _SigNamespace:
+		return nil, fmt.Errorf("signature is for namespace '%s', not '%s'", f.Namespace, _SigNamespace)
+	case f.HashAlgo != _SigHash:
+		return nil, fmt.Errorf("unsupported signature hash '%s'", f.HashAlgo)
+	}
+	return &f, nil
+}
+
+// loadKeys returns the public keys in 'fn'; one per line in the
+// authorized_keys(5) format (a .pub file is one such line).
+func loadKeys(fn string) ([]ssh.PublicKey, error) {
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []ssh.PublicKey
+	for len(bytes.TrimSpace(b)) > 0 {
+		pk, _, _, rest, err := ssh.ParseAuthorizedKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn, err)
+		}
+		keys = append(keys, pk)
+		b = rest
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no public keys", fn)
+	}
+	return keys, nil
+}
+
+func hasKey(keys []ssh.PublicKey, pk ssh.PublicKey) bool {
+	want := pk.Marshal()
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), want) {
+			return true
+		}
+	}
+	return false
+}
+
+// signedData returns the SSHSIG blob for the digest 'sum'
+func signedData(sum []byte) []byte {
+	d := sigData{
+		Namespace: _SigNamespace,
+		HashAlgo:  _SigHash,
+		Hash:      sum,
+	}
+	return append([]byte(_SigMagic), ssh.Marshal(d)...)
+}
+
+// manifestSum returns the sha512 of the contents of 'nm'
+func manifestSum(nm string) ([]byte, error) {
+	fd, err := os.Open(nm)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	h := sha512.New()
+	if _, err := io.Copy(h, fd); err != nil {
+		return nil, fmt.Errorf("%s: %w", nm, err)
+	}
+	return h.Sum(nil), nil
+}
+
+// armor wraps the base64 of 'b' in the signature markers
+func armor(b []byte) []byte {
+	s := base64.StdEncoding.EncodeToString(b)
+
+	var w bytes.Buffer
+	w.WriteString(_SigBegin + "\n")
+	for len(s) > _SigWrap {
+		w.WriteString(s[:_SigWrap] + "\n")
+		s = s[_SigWrap:]
+	}
+	w.WriteString(s + "\n")
+	w.WriteString(_SigEnd + "\n")
+	return w.Bytes()
+}
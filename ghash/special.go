@@ -0,0 +1,63 @@
+// special.go -- manifest entries for non-regular files
+//
+// (c) 2023 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// Special files (fifos, sockets and device nodes) have no content to
+// hash; instead their manifest entry records a type marker in place of
+// the checksum and a size of zero:
+//
+//	@fifo|0|name
+//	@sock|0|name
+//	@chr:RDEV|0|name
+//	@blk:RDEV|0|name
+//
+// where RDEV is the raw device number. Verification confirms that the
+// entry still exists with the same type (and device number).
+//
+// Zero length regular files are hashed like any other file; their
+// checksum is the digest of the empty string.
+
+// prefix of a type marker
+const _MarkPrefix = "@"
+
+// return true if the manifest checksum field is a type marker
+func isMarker(s string) bool {
+	return strings.HasPrefix(s, _MarkPrefix)
+}
+
+// isSpecial returns true if 'm' is a file type we record with a marker
+func isSpecial(m fs.FileMode) bool {
+	return m&(fs.ModeNamedPipe|fs.ModeSocket|fs.ModeDevice) > 0
+}
+
+// typeMarker returns the marker for a special file
+func typeMarker(m fs.FileMode, rdev uint64) string {
+	switch {
+	case m&fs.ModeNamedPipe > 0:
+		return "@fifo"
+	case m&fs.ModeSocket > 0:
+		return "@sock"
+	case m&fs.ModeCharDevice > 0:
+		return fmt.Sprintf("@chr:%d", rdev)
+	case m&fs.ModeDevice > 0:
+		return fmt.Sprintf("@blk:%d", rdev)
+	}
+	return "@unknown"
+}
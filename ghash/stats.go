@@ -0,0 +1,58 @@
+// stats.go -- read-rate statistics of a run
+//
+// (c) 2023 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/opencoff/go-utils"
+)
+
+// ioStats counts the files and bytes hashed by all the workers
+type ioStats struct {
+	files atomic.Int64
+	bytes atomic.Int64
+	start time.Time
+}
+
+var stats ioStats
+
+// start the clock
+func (s *ioStats) begin() {
+	s.start = time.Now()
+}
+
+// account for a file of 'sz' bytes that was hashed
+func (s *ioStats) add(sz int64) {
+	s.files.Add(1)
+	s.bytes.Add(sz)
+}
+
+// print the throughput to stderr
+func (s *ioStats) print() {
+	d := time.Since(s.start)
+	n := s.bytes.Load()
+
+	var rate float64
+	if secs := d.Seconds(); secs > 0 {
+		rate = float64(n) / secs
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: %d files, %s in %s (%s/s)\n", Z,
+		s.files.Load(), utils.HumanizeSize(uint64(n)),
+		d.Round(time.Millisecond), utils.HumanizeSize(uint64(rate)))
+}
@@ -0,0 +1,132 @@
+// timing.go -- per-file hash times and the slowest files and dirs
+//
+// (c) 2023 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/opencoff/go-utils"
+)
+
+// time taken to hash a file - or all the files in a dir
+type fileTime struct {
+	name string
+	size int64
+	dur  time.Duration
+}
+
+// rate in bytes/sec
+func (f *fileTime) rate() uint64 {
+	if s := f.dur.Seconds(); s > 0 {
+		return uint64(float64(f.size) / s)
+	}
+	return 0
+}
+
+// a min-heap of the slowest files seen so far
+type slowHeap []fileTime
+
+func (h slowHeap) Len() int           { return len(h) }
+func (h slowHeap) Less(i, j int) bool { return h[i].dur < h[j].dur }
+func (h slowHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *slowHeap) Push(x any)        { *h = append(*h, x.(fileTime)) }
+func (h *slowHeap) Pop() any {
+	v := *h
+	x := v[len(v)-1]
+	*h = v[:len(v)-1]
+	return x
+}
+
+// fileTimes keeps the 'n' slowest files and the total time spent in
+// each dir; a nil fileTimes records nothing.
+type fileTimes struct {
+	sync.Mutex
+	n     int
+	files slowHeap
+	dirs  map[string]*fileTime
+}
+
+// set by --timing
+var timing *fileTimes
+
+func newFileTimes(n int) *fileTimes {
+	t := &fileTimes{
+		n:    n,
+		dirs: make(map[string]*fileTime),
+	}
+	return t
+}
+
+// add records that 'fn' of 'sz' bytes took 'd' to hash
+func (t *fileTimes) add(fn string, sz int64, d time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if len(t.files) < t.n {
+		heap.Push(&t.files, fileTime{fn, sz, d})
+	} else if d > t.files[0].dur {
+		t.files[0] = fileTime{fn, sz, d}
+		heap.Fix(&t.files, 0)
+	}
+
+	dn := filepath.Dir(fn)
+	e, ok := t.dirs[dn]
+	if !ok {
+		e = &fileTime{name: dn}
+		t.dirs[dn] = e
+	}
+	e.size += sz
+	e.dur += d
+}
+
+// print the slowest files and dirs to stderr
+func (t *fileTimes) print() {
+	t.Lock()
+	defer t.Unlock()
+
+	files := append([]fileTime{}, t.files...)
+	dirs := make([]fileTime, 0, len(t.dirs))
+	for _, e := range t.dirs {
+		dirs = append(dirs, *e)
+	}
+
+	slowest := func(v []fileTime) []fileTime {
+		sort.Slice(v, func(i, j int) bool {
+			return v[i].dur > v[j].dur
+		})
+		return v[:min(len(v), t.n)]
+	}
+
+	show := func(what string, v []fileTime) {
+		fmt.Fprintf(os.Stderr, "%s: slowest %d %s:\n", Z, len(v), what)
+		for i := range v {
+			f := &v[i]
+			fmt.Fprintf(os.Stderr, "  %12s %10s %10s/s %s\n", f.dur.Round(time.Microsecond),
+				utils.HumanizeSize(uint64(f.size)), utils.HumanizeSize(f.rate()), f.name)
+		}
+	}
+
+	show("files", slowest(files))
+	show("dirs", slowest(dirs))
+}
@@ -0,0 +1,82 @@
+// tree.go -- a single digest over all the records of a manifest
+//
+// (c) 2023 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+)
+
+// header option denoting a tree hash trailer
+const _TreeOpt = "tree"
+
+// prefix of the trailer line holding the tree hash
+const _TreeMagic = "#!tree "
+
+type treeRec struct {
+	key  string
+	line string
+}
+
+// treeHash combines the manifest records - sorted by name - into one
+// digest. Since it covers the records as written it detects any
+// change to the manifest body: edited sums, sizes, names or dropped
+// and added lines.
+type treeHash struct {
+	hgen func() hash.Hash
+	recs []treeRec
+}
+
+func newTreeHash(hgen func() hash.Hash) *treeHash {
+	t := &treeHash{
+		hgen: hgen,
+	}
+	return t
+}
+
+// add a record 'line' (without its terminator) for file 'key'
+func (t *treeHash) add(key, line string) {
+	t.recs = append(t.recs, treeRec{key, line})
+}
+
+// sum returns the hex digest of all the records added so far
+func (t *treeHash) sum() string {
+	sort.Slice(t.recs, func(i, j int) bool {
+		a, b := &t.recs[i], &t.recs[j]
+		if a.key != b.key {
+			return a.key < b.key
+		}
+		return a.line < b.line
+	})
+
+	h := t.hgen()
+	for i := range t.recs {
+		h.Write([]byte(t.recs[i].line))
+		h.Write([]byte{'\n'})
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// recordKey returns the filename field of a manifest record as written
+func recordKey(line string, meta bool) string {
+	n := 3
+	if meta {
+		n = 4
+	}
+
+	v := strings.SplitN(line, "|", n)
+	return v[len(v)-1]
+}
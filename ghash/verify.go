@@ -15,15 +15,26 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"hash"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"crypto/subtle"
+
+	"go-progs/internal/manifest"
+	"go-progs/internal/report"
+	"go-progs/internal/vfs"
+
+	"github.com/opencoff/go-fio"
 )
 
 type datum struct {
@@ -31,39 +42,117 @@ type datum struct {
 	size      int64
 	expsum    string
 	errPrefix string
+
+	// mtime (ns) from the metadata; zero if the manifest has none
+	mtime int64
+
+	// true if the entry is fully verified when parsed: special
+	// files and entries checked at a --level lighter than full.
+	nohash bool
+
+	// where the file is; to verify in --verify-order=inode
+	dev, ino uint64
+}
+
+// verification levels
+const (
+	levelSize  = "size"
+	levelQuick = "quick"
+	levelFull  = "full"
+)
+
+// verification level chosen by --level
+var verifyLevel = levelFull
+
+// doVerify verifies the manifest 'nm' and returns the exit code; see
+// verifyManifest().
+func doVerify(nm string, dir string, body []byte) int {
+	if err := verifyManifest(nm, dir, body); err != nil {
+		rep.Error(err)
+	}
+	return rep.Code()
 }
 
-func doVerify(nm string) int {
+// verifyManifest verifies the manifest 'nm'; relative names in it are
+// relative to 'dir' if it's not empty. If 'body' isn't nil, it's the
+// contents of 'nm' (whose signature was checked) and 'nm' isn't read.
+// The errors of the files in it are reported to 'rep'; the error
+// returned is one that stops the manifest from being verified at all:
+// e.g. it can't be read or its header is corrupt.
+func verifyManifest(nm string, dir string, body []byte) error {
 	var fd io.ReadCloser = os.Stdin
-	if nm != "-" && len(nm) > 0 {
+	switch {
+	case body != nil:
+		fd = io.NopCloser(bytes.NewReader(body))
+	case nm != "-" && len(nm) > 0:
 		fx, err := os.Open(nm)
 		if err != nil {
-			Die("can't open '%s': %s", err)
+			return fmt.Errorf("can't open '%s': %w", nm, err)
 		}
 		fd = fx
 	}
 
 	defer fd.Close()
 
-	rd := bufio.NewScanner(fd)
+	in, err := manifest.Decompress(fd)
+	if err != nil {
+		return fmt.Errorf("%s: %w", nm, err)
+	}
+
+	rd := bufio.NewScanner(in)
+	if zeroTerm {
+		rd.Split(manifest.SplitNul)
+	}
 	if ok := rd.Scan(); !ok {
-		Die("%s: possibly corrupt; can't read first line", nm)
+		return report.Mismatch(fmt.Errorf("%s: possibly corrupt; can't read first line", nm))
 	}
 
 	subs := strings.Split(rd.Text(), " ")
 	if len(subs) < 3 {
-		Die("%s: possibly corrupt; not enough fields in header", nm)
+		return report.Mismatch(fmt.Errorf("%s: possibly corrupt; not enough fields in header", nm))
 	}
 
 	magic := subs[0]
 	if magic != MAGIC {
-		Die("%s: Not a ghash file", nm)
+		return report.Mismatch(fmt.Errorf("%s: Not a ghash file", nm))
+	}
+
+	// optional header tokens
+	var meta, withTree, archives, urls bool
+	var bits int
+	for _, o := range subs[3:] {
+		switch {
+		case o == _MetaOpt:
+			meta = true
+		case o == _TreeOpt:
+			withTree = true
+		case o == _ArchiveOpt:
+			archives = true
+		case o == _URLOpt:
+			urls = true
+		case strings.HasPrefix(o, _BitsOpt):
+			n, err := parseBits(o)
+			if err != nil {
+				return report.Mismatch(fmt.Errorf("%s: %w", nm, err))
+			}
+			bits = n
+		}
+	}
+	descendArchives = archives
+	manifestURLs = urls
+
+	if urls && !allowURLs {
+		Die("%s: names URLs; verify it with --allow-urls", nm)
+	}
+
+	if verifyLevel == levelQuick && !meta {
+		Die("%s: no metadata for --level=quick; generate it with 'ghash --with-metadata'", nm)
 	}
 
 	halgo := subs[1]
-	hgen, ok := Hashes[halgo]
-	if !ok {
-		Die("%s: unsupported hash algo '%s'", nm, halgo)
+	_, _, hgen, err := resolveHash(halgo, bits)
+	if err != nil {
+		return report.Mismatch(fmt.Errorf("%s: unsupported hash algo: %w", nm, err))
 	}
 
 	var wg sync.WaitGroup
@@ -83,32 +172,78 @@ func doVerify(nm string) int {
 		}(ch, errch)
 	}
 
-	// feed the rest of the input file hash-lines
+	// the tree hash covers the records as written; so it doesn't
+	// depend on the state of the files.
+	var tree *treeHash
+	var trailer string
+	if withTree {
+		tree = newTreeHash(hgen)
+	}
+
+	// feed the rest of the input file hash-lines; unless we verify
+	// in manifest order, the files are queued and sorted first.
 	wg.Add(1)
 	go func(ch chan datum) {
+		var queue []datum
 		num := 2
 		for ; rd.Scan(); num++ {
+			line := rd.Text()
+			if tree != nil {
+				if strings.HasPrefix(line, _TreeMagic) {
+					trailer = strings.TrimSpace(line[len(_TreeMagic):])
+					continue
+				}
+				tree.add(recordKey(line, meta), line)
+			}
+
+			if !verifyFilter.keep(recordKey(line, meta)) {
+				continue
+			}
+
 			errPref := fmt.Sprintf("%s: %d", nm, num)
-			d, err := parseLine(rd.Text(), errPref)
+			d, err := parseLine(line, errPref, meta, dir)
 			if err != nil {
 				errch <- err
 				continue
 			}
 
+			if verifyOrder != orderManifest {
+				queue = append(queue, d)
+				continue
+			}
 			ch <- d
 		}
+
+		sortByLocality(queue, verifyOrder)
+		for _, d := range queue {
+			ch <- d
+		}
+
+		// e.g. a truncated compressed manifest
+		if err := rd.Err(); err != nil {
+			errch <- report.Mismatch(fmt.Errorf("%s: %d: %w", nm, num, err))
+		}
+
+		if tree != nil {
+			switch sum := tree.sum(); {
+			case len(trailer) == 0:
+				errch <- report.Mismatch(fmt.Errorf("%s: missing tree hash trailer; manifest truncated?", nm))
+			case sum != trailer:
+				errch <- report.Mismatch(fmt.Errorf("%s: tree hash mismatch; manifest modified", nm))
+			}
+		}
 		close(ch)
 		wg.Done()
 	}(ch)
 
-	var errs []string
+	var errs []error
 	var ewg sync.WaitGroup
 
 	// harvest errors
 	ewg.Add(1)
 	go func(errch chan error) {
 		for err := range errch {
-			errs = append(errs, fmt.Sprintf("%s", err))
+			errs = append(errs, err)
 		}
 		ewg.Done()
 	}(errch)
@@ -123,15 +258,13 @@ func doVerify(nm string) int {
 	close(errch)
 	ewg.Wait()
 
-	if len(errs) > 0 {
-		Warn("%s", strings.Join(errs, "\n"))
+	for _, err := range errs {
+		rep.Error(err)
 	}
-
-	// return the exit code
-	return 1 & len(errs)
+	return nil
 }
 
-func parseLine(line string, errpref string) (datum, error) {
+func parseLine(line string, errpref string, meta bool, dir string) (datum, error) {
 	var i int
 	var d datum
 	var err error
@@ -142,7 +275,7 @@ func parseLine(line string, errpref string) (datum, error) {
 
 	// Field #1: Checksum
 	if i = strings.IndexRune(line, '|'); i < 0 {
-		err = fmt.Errorf("%s: malformed checksum", errpref)
+		err = report.Mismatch(fmt.Errorf("%s: malformed checksum", errpref))
 		return d, err
 	}
 
@@ -150,50 +283,147 @@ func parseLine(line string, errpref string) (datum, error) {
 
 	// Field #2: File size
 	if i = strings.IndexRune(line, '|'); i < 0 {
-		err = fmt.Errorf("%s: malformed file size", errpref)
+		err = report.Mismatch(fmt.Errorf("%s: malformed file size", errpref))
 		return d, err
 	}
 
 	if sz, err = strconv.ParseInt(line[:i], 10, 64); err != nil {
-		err = fmt.Errorf("%s: malformed line; size %w", errpref, err)
+		err = report.Mismatch(fmt.Errorf("%s: malformed line; size %w", errpref, err))
 		return d, err
 	}
 
+	// Field #3: optional metadata
+	var mtime int64
+	line = line[i+1:]
+	if meta {
+		if i = strings.IndexRune(line, '|'); i < 0 {
+			err = report.Mismatch(fmt.Errorf("%s: malformed metadata", errpref))
+			return d, err
+		}
+
+		if mtime, err = parseMtime(line[:i]); err != nil {
+			err = report.Mismatch(fmt.Errorf("%s: malformed metadata; %w", errpref, err))
+			return d, err
+		}
+		line = line[i+1:]
+	}
+
 	// everything else is the filename
-	if fn = line[i+1:]; fn[0] == '"' {
+	if fn = line; len(fn) == 0 {
+		err = report.Mismatch(fmt.Errorf("%s: missing filename", errpref))
+		return d, err
+	}
+
+	if fn[0] == '"' {
 		if fn, err = strconv.Unquote(fn); err != nil {
-			err = fmt.Errorf("%s: malformed line; filename %w", errpref, err)
+			err = report.Mismatch(fmt.Errorf("%s: malformed line; filename %w", errpref, err))
 			return d, err
 		}
 	}
 
-	var fi os.FileInfo
+	if len(dir) > 0 && !filepath.IsAbs(fn) {
+		fn = filepath.Join(dir, fn)
+	}
+
+	var fi fs.FileInfo
+
+	if isMarker(csum) {
+		return parseSpecial(fn, csum, errpref)
+	}
 
-	if fi, err = os.Stat(fn); err != nil {
+	url := fn
+	if u, ok := memberURL(fn); ok {
+		url = u
+	}
+
+	// an edited manifest of local files mustn't make requests
+	if vfs.IsURL(url) && !manifestURLs {
+		err = report.Mismatch(fmt.Errorf("%s: URL '%s' in a manifest of local files", errpref, fn))
+		return d, err
+	}
+
+	if vfs.IsURL(url) {
+		var e *vfs.Entry
+		if e, err = vfs.Stat(url); err == nil {
+			fi = entryInfo(e)
+		}
+	} else {
+		fi, err = os.Stat(fn)
+	}
+	if err != nil {
 		err = fmt.Errorf("%s: %w", errpref, err)
 		return d, err
 	}
 
 	if !fi.Mode().IsRegular() {
-		err = fmt.Errorf("%s: '%s' not a file", errpref, fn)
+		err = report.Mismatch(fmt.Errorf("%s: '%s' not a file", errpref, fn))
 		return d, err
 	}
 
 	if fi.Size() != sz {
-		err = fmt.Errorf("%s: '%s' size mismatch: exp %d, saw %d",
-			errpref, fn, sz, fi.Size())
+		err = report.Mismatch(fmt.Errorf("%s: '%s' size mismatch: exp %d, saw %d",
+			errpref, fn, sz, fi.Size()))
 		return d, err
 	}
 
+	switch verifyLevel {
+	case levelQuick:
+		if fi.ModTime().UnixNano() != mtime {
+			err = report.Mismatch(fmt.Errorf("%s: '%s' mtime changed: exp %s, saw %s", errpref, fn,
+				time.Unix(0, mtime).Format(time.RFC3339Nano), fi.ModTime().Format(time.RFC3339Nano)))
+			return d, err
+		}
+	}
+
 	d = datum{
 		file:   fn,
 		size:   sz,
 		expsum: csum,
+		mtime:  mtime,
+		nohash: verifyLevel != levelFull,
+
+		errPrefix: errpref,
+	}
+
+	// only the inode order needs them
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && verifyOrder == orderInode {
+		d.dev, d.ino = uint64(st.Dev), st.Ino
+	}
+	return d, nil
+}
+
+// verify a special file entry
+func parseSpecial(fn, mark, errpref string) (datum, error) {
+	var d datum
+
+	// fio's errors don't have the name
+	fi, err := fio.Lstat(fn)
+	if err != nil {
+		return d, fmt.Errorf("%s: %w", errpref, &fs.PathError{Op: "lstat", Path: fn, Err: err})
+	}
+
+	m := fi.Mode()
+	if !isSpecial(m) {
+		return d, report.Mismatch(fmt.Errorf("%s: '%s' not a special file", errpref, fn))
+	}
+
+	if s := typeMarker(m, fi.Rdev); s != mark {
+		return d, report.Mismatch(fmt.Errorf("%s: '%s' type mismatch: exp %s, saw %s", errpref, fn, mark, s))
+	}
+
+	d = datum{
+		file:   fn,
+		expsum: mark,
+		nohash: true,
 	}
 	return d, nil
 }
 
 func verifyFile(d datum, hgen func() hash.Hash) error {
+	if d.nohash {
+		return nil
+	}
+
 	// finally we can hash and compare
 	sum, sz, err := hashFile(d.file, hgen)
 	if err != nil {
@@ -202,14 +432,18 @@ func verifyFile(d datum, hgen func() hash.Hash) error {
 
 	// Account for hashFile() hashing fewer bytes
 	if d.size != sz {
-		return fmt.Errorf("%s: '%s' hash size mismatch: exp %d, saw %d",
-			d.errPrefix, d.file, d.size, sz)
+		return report.Mismatch(fmt.Errorf("%s: '%s' hash size mismatch: exp %d, saw %d",
+			d.errPrefix, d.file, d.size, sz))
 	}
 
 	csum := fmt.Sprintf("%x", sum)
 	if subtle.ConstantTimeCompare([]byte(csum), []byte(d.expsum)) != 1 {
-		return fmt.Errorf("%s: file modified '%s'", d.errPrefix, d.file)
+		return onMismatch.handle(d, csum, hgen)
 	}
 
 	return nil
 }
+
+func errModified(d datum) error {
+	return report.Mismatch(fmt.Errorf("%s: file modified '%s'", d.errPrefix, d.file))
+}
@@ -0,0 +1,180 @@
+// main.go - find the sets of hardlinked files
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"sync"
+
+	"go-progs/internal/glob"
+	"go-progs/internal/report"
+
+	"github.com/opencoff/go-fio"
+	"github.com/opencoff/go-fio/walk"
+	"github.com/opencoff/go-utils"
+	flag "github.com/opencoff/pflag"
+)
+
+var Z string = path.Base(os.Args[0])
+
+// an inode is identified by its device and inode#
+type inode struct {
+	dev, ino uint64
+}
+
+// linkSet is all the names of one inode found in the walk
+type linkSet struct {
+	fi    *fio.Info
+	names []string
+}
+
+func main() {
+	var version, onefs, human bool
+	var excludes, includes []string
+	var fold, jsonErrs bool
+
+	flag.BoolVarP(&version, "version", "", false, "Show version info and quit")
+	flag.BoolVarP(&onefs, "single-filesystem", "x", false, "Don't cross mount points")
+	flag.BoolVarP(&human, "human-size", "h", false, "Show size in human readable form")
+	flag.StringSliceVarP(&excludes, "exclude", "", nil, "Exclude entries matching glob `G`")
+	flag.StringSliceVarP(&includes, "include", "", nil, "Re-include entries matching glob `G` that were excluded")
+	flag.BoolVarP(&fold, "ignore-case", "", false, "Match --exclude and --include globs ignoring case")
+	flag.BoolVarP(&jsonErrs, "json-errors", "", false, "Write errors to stderr as JSON records")
+
+	flag.Usage = func() {
+		fmt.Printf(
+			`%s - hardlink mapper (parallel edition)
+
+Walks one or more dir trees and prints each set of names that share
+the same inode - the largest sets first. A set is marked with the
+number of links found and the link count of the inode; when fewer are
+found, the other names are outside the dirs walked.
+
+The summary shows the apparent size (each name counted) and the unique
+size (each inode counted once) of all the files walked.
+
+The sets are printed even if some entries couldn't be read; errors
+exit with 2 if a file vanished, 3 on I/O errors and 4 if permission was
+denied; the largest applies. Bad options and arguments exit with 64.
+With --json-errors, each error is a JSON record: {"prog", "kind", "op",
+"path", "error"}.
+
+Usage: %s [options] dir [dir...]
+
+Options:
+`, Z, Z)
+		flag.PrintDefaults()
+		os.Stdout.Sync()
+		os.Exit(0)
+	}
+
+	flag.Parse()
+	if version {
+		fmt.Printf("%s - %s [%s]\n", Z, ProductVersion, RepoVersion)
+		os.Exit(0)
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		die("Insufficient args. Try %s --help", Z)
+	}
+
+	size := func(z uint64) string {
+		return fmt.Sprintf("%d", z)
+	}
+	if human {
+		size = utils.HumanizeSize
+	}
+
+	opt := walk.Options{
+		OneFS: onefs,
+		Type:  walk.FILE,
+	}
+
+	excl, err := glob.Excludes(excludes, includes, fold)
+	if err != nil {
+		die("%s", err)
+	}
+	opt.Filter = excl.Filter(args)
+
+	ch, ech := walk.Walk(args, opt)
+
+	// harvest errors
+	rep := report.New(os.Args[0], jsonErrs)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		for e := range ech {
+			rep.Error(e)
+		}
+		wg.Done()
+	}()
+
+	var nfiles, apparent, unique uint64
+	sets := make(map[inode]*linkSet)
+	for fi := range ch {
+		sz := uint64(fi.Size())
+		nfiles++
+		apparent += sz
+
+		k := inode{fi.Dev, fi.Ino}
+		s, ok := sets[k]
+		if !ok {
+			unique += sz
+			if fi.Nlink < 2 {
+				continue
+			}
+			s = &linkSet{fi: fi}
+			sets[k] = s
+		}
+		s.names = append(s.names, fi.Path())
+	}
+	wg.Wait()
+
+	v := make([]*linkSet, 0, len(sets))
+	for _, s := range sets {
+		sort.Strings(s.names)
+		v = append(v, s)
+	}
+
+	sort.Slice(v, func(i, j int) bool {
+		a, b := v[i], v[j]
+		if len(a.names) != len(b.names) {
+			return len(a.names) > len(b.names)
+		}
+		return a.names[0] < b.names[0]
+	})
+
+	var nlinked uint64
+	for _, s := range v {
+		fi := s.fi
+		nlinked += uint64(len(s.names))
+		fmt.Printf("\n# %d:%d %s, %d of %d links\n", fi.Dev, fi.Ino, size(uint64(fi.Size())), len(s.names), fi.Nlink)
+		for _, nm := range s.names {
+			fmt.Printf("    %s\n", nm)
+		}
+	}
+
+	fmt.Printf("\n%d files, %d hardlinked in %d sets; %s apparent, %s unique\n",
+		nfiles, nlinked, len(v), size(apparent), size(unique))
+
+	os.Exit(rep.Code())
+}
+
+// This will be filled in by "build"
+var RepoVersion string = "UNDEFINED"
+var ProductVersion string = "UNDEFINED"
@@ -0,0 +1,34 @@
+// die.go -- warn() and die()
+//
+// Author: Sudhi Herle <sudhi@herle.net>
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"go-progs/internal/report"
+)
+
+// die with a usage error
+func die(f string, v ...interface{}) {
+	warn(f, v...)
+	os.Exit(report.ExitUsage)
+}
+
+func warn(f string, v ...interface{}) {
+	z := fmt.Sprintf("%s: %s", os.Args[0], f)
+	s := fmt.Sprintf(z, v...)
+	if n := len(s); s[n-1] != '\n' {
+		s += "\n"
+	}
+
+	os.Stderr.WriteString(s)
+	os.Stderr.Sync()
+}
+
+// vim: ft=go:sw=4:ts=4:noexpandtab:tw=78:
@@ -0,0 +1,292 @@
+// main.go - mount points and their capacity
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go-progs/internal/report"
+
+	"github.com/opencoff/go-utils"
+	flag "github.com/opencoff/pflag"
+)
+
+var Z string = path.Base(os.Args[0])
+
+// errors and the exit code
+var rep *report.Reporter
+
+// a mounted file system
+type mount struct {
+	Source  string   `json:"source"`
+	Path    string   `json:"path"`
+	Type    string   `json:"type"`
+	Options []string `json:"options"`
+
+	// capacity in bytes and inodes; valid only if Stat is true
+	Size   uint64 `json:"size"`
+	Used   uint64 `json:"used"`
+	Avail  uint64 `json:"avail"`
+	Inodes uint64 `json:"inodes"`
+	IFree  uint64 `json:"inodes_free"`
+	Stat   bool   `json:"stat"`
+}
+
+// percentage of the usable space that is used - like df(1)
+func (m *mount) usePct() float64 {
+	if t := m.Used + m.Avail; t > 0 {
+		return 100 * float64(m.Used) / float64(t)
+	}
+	return 0
+}
+
+func main() {
+	var version, all, human, kb, asJSON, jsonErrs bool
+	var types, xtypes []string
+	var timeout time.Duration
+
+	flag.BoolVarP(&version, "version", "", false, "Show version info and quit")
+	flag.BoolVarP(&all, "all", "a", false, "Also show pseudo file systems (zero size)")
+	flag.BoolVarP(&human, "human-size", "h", false, "Show size in human readable form")
+	flag.BoolVarP(&kb, "kilo-byte", "k", false, "Show size in kilo bytes")
+	flag.BoolVarP(&asJSON, "json", "j", false, "Show the mounts as JSON")
+	flag.StringSliceVarP(&types, "type", "t", nil, "Only show file systems of type `T`")
+	flag.StringSliceVarP(&xtypes, "exclude-type", "x", nil, "Don't show file systems of type `T`")
+	flag.DurationVarP(&timeout, "timeout", "", 5*time.Second, "Skip mounts whose capacity isn't known in `T`")
+	flag.BoolVarP(&jsonErrs, "json-errors", "", false, "Write errors to stderr as JSON records")
+
+	flag.Usage = func() {
+		fmt.Printf(
+			`%s - mount points and their capacity
+
+Shows each mount point with its source, file system type, size, used
+and available space and the mount options. With one or more paths,
+only the mounts holding them are shown.
+
+File systems of zero size (proc, sysfs etc.) are hidden unless --all
+is used. A mount that doesn't answer in --timeout (e.g. a wedged
+network mount) is shown without its capacity and is reported as an
+I/O error.
+
+Errors exit with 2 if a path vanished, 3 on I/O errors and 4 if
+permission was denied; the largest applies. Bad options and arguments
+exit with 64. With --json-errors, each error is a JSON record: {"prog",
+"kind", "op", "path", "error"}.
+
+Usage: %s [options] [path...]
+
+Options:
+`, Z, Z)
+		flag.PrintDefaults()
+		os.Stdout.Sync()
+		os.Exit(0)
+	}
+
+	flag.Parse()
+	if version {
+		fmt.Printf("%s - %s [%s]\n", Z, ProductVersion, RepoVersion)
+		os.Exit(0)
+	}
+
+	rep = report.New(os.Args[0], jsonErrs)
+
+	size := func(z uint64) string {
+		return fmt.Sprintf("%d", z)
+	}
+	if human {
+		size = utils.HumanizeSize
+	} else if kb {
+		size = func(z uint64) string {
+			return fmt.Sprintf("%d", z/1024)
+		}
+	}
+
+	mv, err := listMounts()
+	if err != nil {
+		rep.Error(fmt.Errorf("can't list mounts: %w", err))
+		os.Exit(rep.Code())
+	}
+
+	if args := flag.Args(); len(args) > 0 {
+		mv = holding(mv, args)
+	}
+	mv = filterTypes(mv, types, xtypes)
+
+	for _, m := range statAll(mv, timeout) {
+		rep.Error(fmt.Errorf("%s: no answer in %s", m.Path, timeout))
+	}
+
+	// pseudo file systems are only hidden once we know their size
+	if !all {
+		v := mv[:0]
+		for _, m := range mv {
+			if !m.Stat || m.Size > 0 {
+				v = append(v, m)
+			}
+		}
+		mv = v
+	}
+
+	if asJSON {
+		if mv == nil {
+			mv = []*mount{}
+		}
+		b, err := json.MarshalIndent(mv, "", "  ")
+		if err != nil {
+			rep.Error(err)
+			os.Exit(rep.Code())
+		}
+		fmt.Printf("%s\n", b)
+		os.Exit(rep.Code())
+	}
+
+	fmt.Printf("%-20s %-8s %12s %12s %12s %5s %s\n", "Filesystem", "Type", "Size", "Used", "Avail", "Use%", "Mounted on")
+	for _, m := range mv {
+		if !m.Stat {
+			fmt.Printf("%-20s %-8s %12s %12s %12s %5s %s [%s]\n", m.Source, m.Type,
+				"?", "?", "?", "?", m.Path, strings.Join(m.Options, ","))
+			continue
+		}
+		fmt.Printf("%-20s %-8s %12s %12s %12s %4.0f%% %s [%s]\n", m.Source, m.Type,
+			size(m.Size), size(m.Used), size(m.Avail), m.usePct(), m.Path,
+			strings.Join(m.Options, ","))
+	}
+	os.Exit(rep.Code())
+}
+
+// statAll fills in the capacity of each mount concurrently; it returns
+// the mounts that didn't answer in 'tmo'.
+func statAll(mv []*mount, tmo time.Duration) []*mount {
+	type result struct {
+		i   int
+		st  mount
+		err error
+	}
+
+	// late answers go to the buffered chan; they never touch 'mv'
+	ch := make(chan result, len(mv))
+	for i, m := range mv {
+		go func(i int, nm string) {
+			r := result{i: i}
+			r.err = statfs(nm, &r.st)
+			ch <- r
+		}(i, m.Path)
+	}
+
+	done := make([]bool, len(mv))
+	deadline := time.After(tmo)
+	for n := 0; n < len(mv); n++ {
+		select {
+		case r := <-ch:
+			done[r.i] = true
+			if r.err != nil {
+				rep.Error(fmt.Errorf("%s: %w", mv[r.i].Path, r.err))
+				continue
+			}
+			mv[r.i].setCapacity(&r.st)
+
+		case <-deadline:
+			var slow []*mount
+			for i, m := range mv {
+				if !done[i] {
+					slow = append(slow, m)
+				}
+			}
+			return slow
+		}
+	}
+	return nil
+}
+
+// copy the capacity fields of 'st'
+func (m *mount) setCapacity(st *mount) {
+	m.Size, m.Used, m.Avail = st.Size, st.Used, st.Avail
+	m.Inodes, m.IFree = st.Inodes, st.IFree
+	m.Stat = true
+}
+
+// holding returns the mounts that hold each of the paths in 'args'
+func holding(mv []*mount, args []string) []*mount {
+	var v []*mount
+	seen := make(map[*mount]bool)
+	for _, nm := range args {
+		p, err := filepath.Abs(nm)
+		if err == nil {
+			p, err = filepath.EvalSymlinks(p)
+		}
+		if err != nil {
+			rep.Error(err)
+			continue
+		}
+
+		// the last of the longest mount points wins (over-mounts)
+		var best *mount
+		for _, m := range mv {
+			if under(p, m.Path) && (best == nil || len(m.Path) >= len(best.Path)) {
+				best = m
+			}
+		}
+		if best != nil && !seen[best] {
+			seen[best] = true
+			v = append(v, best)
+		}
+	}
+	return v
+}
+
+// filterTypes keeps the mounts of type 'incl' (all if empty) that
+// aren't of type 'excl'
+func filterTypes(mv []*mount, incl, excl []string) []*mount {
+	has := func(v []string, s string) bool {
+		for _, t := range v {
+			if t == s {
+				return true
+			}
+		}
+		return false
+	}
+
+	var v []*mount
+	for _, m := range mv {
+		if len(incl) > 0 && !has(incl, m.Type) {
+			continue
+		}
+		if has(excl, m.Type) {
+			continue
+		}
+		v = append(v, m)
+	}
+	return v
+}
+
+// return true if 'fn' is 'dir' or is below it
+func under(fn, dir string) bool {
+	if fn == dir {
+		return true
+	}
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	return strings.HasPrefix(fn, dir)
+}
+
+// This will be filled in by "build"
+var RepoVersion string = "UNDEFINED"
+var ProductVersion string = "UNDEFINED"
@@ -0,0 +1,90 @@
+// mounts_bsd.go - mounts from getfsstat(2)
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+//go:build darwin || freebsd
+
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// mount flags we show as options
+var mountFlags = []struct {
+	flag uint64
+	name string
+}{
+	{unix.MNT_RDONLY, "ro"},
+	{unix.MNT_NOSUID, "nosuid"},
+	{unix.MNT_NOEXEC, "noexec"},
+	{unix.MNT_SYNCHRONOUS, "sync"},
+	{unix.MNT_ASYNC, "async"},
+	{unix.MNT_LOCAL, "local"},
+}
+
+// listMounts returns the mounted file systems; the capacity is filled
+// in by statfs() - a call to getfsstat(2) with MNT_WAIT can block on
+// a wedged mount.
+func listMounts() ([]*mount, error) {
+	n, err := unix.Getfsstat(nil, unix.MNT_NOWAIT)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]unix.Statfs_t, n)
+	if n, err = unix.Getfsstat(buf, unix.MNT_NOWAIT); err != nil {
+		return nil, err
+	}
+
+	mv := make([]*mount, 0, n)
+	for i := range buf[:n] {
+		st := &buf[i]
+
+		opts := []string{"rw"}
+		for _, f := range mountFlags {
+			if uint64(st.Flags)&f.flag != 0 {
+				if f.name == "ro" {
+					opts[0] = "ro"
+					continue
+				}
+				opts = append(opts, f.name)
+			}
+		}
+
+		m := &mount{
+			Source:  unix.ByteSliceToString(st.Mntfromname[:]),
+			Path:    unix.ByteSliceToString(st.Mntonname[:]),
+			Type:    unix.ByteSliceToString(st.Fstypename[:]),
+			Options: opts,
+		}
+		mv = append(mv, m)
+	}
+	return mv, nil
+}
+
+// statfs fills in the capacity of the file system mounted on 'nm'
+func statfs(nm string, m *mount) error {
+	var st unix.Statfs_t
+
+	if err := unix.Statfs(nm, &st); err != nil {
+		return err
+	}
+
+	bs := uint64(st.Bsize)
+	m.Size = uint64(st.Blocks) * bs
+	m.Used = (uint64(st.Blocks) - uint64(st.Bfree)) * bs
+	m.Avail = uint64(max(st.Bavail, 0)) * bs
+	m.Inodes = uint64(st.Files)
+	m.IFree = uint64(max(st.Ffree, 0))
+	return nil
+}
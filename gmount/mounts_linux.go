@@ -0,0 +1,109 @@
+// mounts_linux.go - mounts from /proc/self/mountinfo
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// listMounts parses mountinfo(5):
+//
+//	36 35 98:0 /mnt1 /mnt/parent rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+//
+// the optional fields end with a '-'; the fs type and source follow.
+func listMounts() ([]*mount, error) {
+	fd, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var mv []*mount
+	rd := bufio.NewScanner(fd)
+	for n := 1; rd.Scan(); n++ {
+		f := strings.Fields(rd.Text())
+
+		sep := -1
+		for i := 6; i < len(f); i++ {
+			if f[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(f) < 6 || sep < 0 || sep+2 >= len(f) {
+			return nil, fmt.Errorf("mountinfo: %d: malformed line", n)
+		}
+
+		m := &mount{
+			Path:    unescape(f[4]),
+			Options: strings.Split(f[5], ","),
+			Type:    f[sep+1],
+			Source:  unescape(f[sep+2]),
+		}
+		mv = append(mv, m)
+	}
+	if err := rd.Err(); err != nil {
+		return nil, fmt.Errorf("mountinfo: %w", err)
+	}
+	return mv, nil
+}
+
+// statfs fills in the capacity of the file system mounted on 'nm'
+func statfs(nm string, m *mount) error {
+	var st unix.Statfs_t
+
+	if err := unix.Statfs(nm, &st); err != nil {
+		return err
+	}
+
+	bs := uint64(st.Frsize)
+	if bs == 0 {
+		bs = uint64(st.Bsize)
+	}
+
+	m.Size = st.Blocks * bs
+	m.Used = (st.Blocks - st.Bfree) * bs
+	m.Avail = st.Bavail * bs
+	m.Inodes = st.Files
+	m.IFree = st.Ffree
+	return nil
+}
+
+// mountinfo escapes space, tab, newline and backslash as octal (\040)
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
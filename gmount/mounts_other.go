@@ -0,0 +1,29 @@
+// mounts_other.go - mounts on unsupported platforms
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+func listMounts() ([]*mount, error) {
+	return nil, errors.New("not supported on " + runtime.GOOS)
+}
+
+func statfs(nm string, m *mount) error {
+	return errors.New("not supported on " + runtime.GOOS)
+}
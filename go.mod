@@ -3,6 +3,7 @@ module go-progs
 go 1.23.4
 
 require (
+	github.com/klauspost/compress v1.18.0
 	github.com/opencoff/go-fio v0.5.9
 	github.com/opencoff/go-mmap v0.1.5
 	github.com/opencoff/go-utils v1.0.2
@@ -10,11 +11,11 @@ require (
 	github.com/puzpuzpuz/xsync/v3 v3.4.0
 	github.com/zeebo/blake3 v0.2.4
 	golang.org/x/crypto v0.32.0
+	golang.org/x/sys v0.29.0
 )
 
 require (
 	github.com/klauspost/cpuid/v2 v2.0.12 // indirect
 	github.com/pkg/xattr v0.4.10 // indirect
-	golang.org/x/sys v0.29.0 // indirect
 	golang.org/x/term v0.28.0 // indirect
 )
@@ -0,0 +1,104 @@
+// archive.go - the expanded size of tar and zip files
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+
+	"go-progs/internal/report"
+
+	"go-progs/internal/vfs"
+)
+
+// an archive, its size and the total size of its members
+type arcSize struct {
+	name     string
+	size     uint64
+	expanded uint64
+}
+
+// archiveSizer sums the sizes of the members of each tar and zip file
+// it's given; from the archive's index - nothing is extracted. The
+// archives are read by a pool of workers.
+type archiveSizer struct {
+	sync.Mutex
+	v []arcSize
+
+	// archives that can't be read are reported here
+	rep *report.Reporter
+
+	ch chan arcSize
+	wg sync.WaitGroup
+}
+
+func newArchiveSizer(rep *report.Reporter) *archiveSizer {
+	a := &archiveSizer{
+		rep: rep,
+		ch:  make(chan arcSize, 128),
+	}
+
+	n := runtime.NumCPU()
+	a.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go a.worker()
+	}
+	return a
+}
+
+// add file 'fn' of 'sz' bytes if it's an archive
+func (a *archiveSizer) add(fn string, sz uint64) {
+	if len(vfs.ArchiveURL(fn)) > 0 {
+		a.ch <- arcSize{name: fn, size: sz}
+	}
+}
+
+// wait for all the queued archives and return them - the largest
+// expanded size first.
+func (a *archiveSizer) wait() []arcSize {
+	close(a.ch)
+	a.wg.Wait()
+
+	sort.Slice(a.v, func(i, j int) bool {
+		x, y := &a.v[i], &a.v[j]
+		if x.expanded != y.expanded {
+			return x.expanded > y.expanded
+		}
+		return x.name < y.name
+	})
+	return a.v
+}
+
+// unreadable archives are skipped with a warning; they're counted as
+// files all the same.
+func (a *archiveSizer) worker() {
+	for r := range a.ch {
+		err := vfs.Walk(vfs.ArchiveURL(r.name), func(e *vfs.Entry) error {
+			if !e.IsLink() {
+				r.expanded += uint64(e.Size)
+			}
+			return nil
+		})
+		if err != nil {
+			a.rep.Error(err)
+			continue
+		}
+
+		a.Lock()
+		a.v = append(a.v, r)
+		a.Unlock()
+	}
+	a.wg.Done()
+}
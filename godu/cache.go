@@ -0,0 +1,228 @@
+// cache.go - persistent per-directory size cache
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/opencoff/go-fio"
+)
+
+// name of the cache file within the cache dir
+const _CacheFile = "godu.cache"
+
+// cacheEntry records the aggregate size of the files directly under a
+// directory and the names of its sub-directories. An entry is valid
+// as long as the directory's mtime is unchanged; ie no entries were
+// added, removed or renamed.
+//
+// NB: a file that changes size in place doesn't alter the mtime of its
+// parent directory; such changes are not detected until the directory
+// itself is modified. And hard links are only counted once within a
+// walk: a file linked from a cached dir and from a dir that's walked
+// is counted twice.
+type cacheEntry struct {
+	Mtime   int64
+	Size    uint64
+	Subdirs []string
+}
+
+// cacheFile is the cache as it's written; 'Opts' is the hash of the
+// walk options (see optsHash()) the sizes were computed with.
+type cacheFile struct {
+	Opts string
+	Dirs map[string]cacheEntry
+}
+
+// dirCache holds the previous and the current generation of a
+// cache. Entries are keyed by "dev:ino".
+type dirCache struct {
+	sync.Mutex
+
+	fn   string
+	opts string
+	old  map[string]cacheEntry
+	cur  map[string]cacheEntry
+
+	// dirs served from the cache during the current walk
+	hits map[string]cacheEntry
+}
+
+func dirKey(fi *fio.Info) string {
+	return fmt.Sprintf("%d:%d", fi.Dev, fi.Ino)
+}
+
+// optsHash returns the hash of the walk options 'v' that decide
+// which files are counted; a cache made with other options is of no
+// use.
+func optsHash(v ...string) string {
+	h := sha256.New()
+	for _, s := range v {
+		fmt.Fprintf(h, "%d:%s,", len(s), s)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// openCache reads the cache in dir 'dn' (creating the dir if needed);
+// it's discarded if it wasn't made with the walk options 'opts'.
+func openCache(dn, opts string) (*dirCache, error) {
+	if err := os.MkdirAll(dn, 0700); err != nil {
+		return nil, err
+	}
+
+	c := &dirCache{
+		fn:   filepath.Join(dn, _CacheFile),
+		opts: opts,
+		old:  make(map[string]cacheEntry),
+		cur:  make(map[string]cacheEntry),
+		hits: make(map[string]cacheEntry),
+	}
+
+	fd, err := os.Open(c.fn)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return c, nil
+		}
+		return nil, err
+	}
+	defer fd.Close()
+
+	// a corrupt cache is not fatal; we just start afresh
+	var cf cacheFile
+	if err := gob.NewDecoder(fd).Decode(&cf); err != nil {
+		warn("%s: ignoring corrupt cache: %s", c.fn, err)
+		return c, nil
+	}
+
+	if cf.Opts != opts {
+		if Verbose {
+			warn("%s: made with other options; starting afresh", c.fn)
+		}
+		return c, nil
+	}
+	if cf.Dirs != nil {
+		c.old = cf.Dirs
+	}
+	return c, nil
+}
+
+// lookup returns true if dir 'fi' is unchanged since the last time
+// we saw it. A hit is carried forward to the current generation and
+// is remembered so the caller can descend its sub-dirs.
+// This function is called concurrently from the walker.
+func (c *dirCache) lookup(fi *fio.Info) bool {
+	key := dirKey(fi)
+
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.old[key]
+	if !ok || e.Mtime != fi.ModTime().UnixNano() {
+		return false
+	}
+
+	c.cur[key] = e
+	c.hits[fi.Path()] = e
+	return true
+}
+
+// store records a freshly computed entry
+func (c *dirCache) store(fi *fio.Info, size uint64, subdirs []string) {
+	c.Lock()
+	c.cur[dirKey(fi)] = cacheEntry{
+		Mtime:   fi.ModTime().UnixNano(),
+		Size:    size,
+		Subdirs: subdirs,
+	}
+	c.Unlock()
+}
+
+// drain returns the dirs served from the cache (keyed by path) since
+// the last call.
+func (c *dirCache) drain() map[string]cacheEntry {
+	c.Lock()
+	defer c.Unlock()
+
+	r := c.hits
+	c.hits = make(map[string]cacheEntry)
+	return r
+}
+
+// save atomically writes out the current generation
+func (c *dirCache) save() error {
+	fd, err := fio.NewSafeFile(c.fn, fio.OPT_OVERWRITE, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer fd.Abort()
+
+	cf := cacheFile{
+		Opts: c.opts,
+		Dirs: c.cur,
+	}
+	if err = gob.NewEncoder(fd).Encode(&cf); err != nil {
+		return fmt.Errorf("%s: %w", c.fn, err)
+	}
+	return fd.Close()
+}
+
+// dirTally accumulates the per-dir sizes of the current walk
+type dirTally map[string]*dirSize
+
+type dirSize struct {
+	fi      *fio.Info
+	size    uint64
+	subdirs []string
+}
+
+func (t dirTally) get(nm string) *dirSize {
+	d, ok := t[nm]
+	if !ok {
+		d = &dirSize{}
+		t[nm] = d
+	}
+	return d
+}
+
+// dir records a directory and links it to its parent
+func (t dirTally) dir(fi *fio.Info) {
+	nm := fi.Path()
+	t.get(nm).fi = fi
+
+	p := t.get(path.Dir(nm))
+	p.subdirs = append(p.subdirs, path.Base(nm))
+}
+
+// file adds the file size to its parent dir
+func (t dirTally) file(nm string, sz uint64) {
+	t.get(path.Dir(nm)).size += sz
+}
+
+// subPath joins a dir and a child name without cleaning the path;
+// this keeps the prefix of the command line args intact.
+func subPath(dn, nm string) string {
+	if dn == "/" {
+		return "/" + nm
+	}
+	return dn + "/" + nm
+}
@@ -0,0 +1,95 @@
+// color.go - color the sizes by magnitude
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/opencoff/go-utils"
+)
+
+const (
+	_DefaultHeat = "100M,1G"
+
+	_Green  = "\x1b[32m"
+	_Yellow = "\x1b[33m"
+	_Red    = "\x1b[1;31m"
+	_Reset  = "\x1b[0m"
+)
+
+// heat colors sizes below 'yellow' green, those below 'red' yellow and
+// the rest red. A nil heat doesn't color.
+type heat struct {
+	yellow uint64
+	red    uint64
+}
+
+// newHeat returns the colors for --color=when; 'tty' is true if the
+// report goes to a terminal. The thresholds are "YELLOW,RED" sizes.
+func newHeat(when, thresh string, tty bool) (*heat, error) {
+	switch when {
+	case "never":
+		return nil, nil
+	case "auto":
+		// https://no-color.org
+		if !tty || len(os.Getenv("NO_COLOR")) > 0 {
+			return nil, nil
+		}
+	case "always":
+	default:
+		return nil, fmt.Errorf("unknown --color '%s'; must be auto, always or never", when)
+	}
+
+	v := strings.Split(thresh, ",")
+	if len(v) != 2 {
+		return nil, fmt.Errorf("invalid color thresholds '%s'; must be YELLOW,RED", thresh)
+	}
+
+	var z [2]uint64
+	for i, s := range v {
+		n, err := utils.ParseSize(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid color threshold '%s': %w", s, err)
+		}
+		z[i] = n
+	}
+	if z[0] > z[1] {
+		return nil, fmt.Errorf("invalid color thresholds '%s': yellow is above red", thresh)
+	}
+	return &heat{z[0], z[1]}, nil
+}
+
+// paint colors the formatted size 's' of 'z' bytes
+func (h *heat) paint(s string, z uint64) string {
+	if h == nil {
+		return s
+	}
+
+	c := _Green
+	switch {
+	case z >= h.red:
+		c = _Red
+	case z >= h.yellow:
+		c = _Yellow
+	}
+	return c + s + _Reset
+}
+
+// return true if 'fd' is a terminal
+func isTTY(fd *os.File) bool {
+	fi, err := fd.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
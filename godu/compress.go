@@ -0,0 +1,190 @@
+// compress.go - estimate how well files would compress
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"compress/flate"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+const (
+	// size of each sample read from a file
+	_SampleSize = 64 * 1024
+
+	// number of samples spread evenly over a file
+	_Samples = 8
+)
+
+type compJob struct {
+	fn string
+	sz uint64
+
+	// true if the file is counted as is (e.g. symlinks)
+	raw bool
+}
+
+// compEstimator estimates the compressed size of files by compressing
+// a few samples of each with a fast compressor (deflate, level 1) and
+// scaling the ratio to the whole file. Files are sampled by a pool of
+// workers; their estimates are accounted to the command line arg they
+// are under and, if 'dirs' is true, to each dir between the two - or
+// to the file itself with --all.
+type compEstimator struct {
+	sync.Mutex
+	args []string
+	all  bool
+	dirs bool
+
+	// estimated compressed size and size of each entry
+	est   map[string]uint64
+	sizes map[string]uint64
+
+	ch chan compJob
+	wg sync.WaitGroup
+}
+
+func newCompEstimator(args []string, all, dirs bool) *compEstimator {
+	c := &compEstimator{
+		args:  args,
+		all:   all,
+		dirs:  dirs,
+		est:   make(map[string]uint64),
+		sizes: make(map[string]uint64),
+		ch:    make(chan compJob, 128),
+	}
+
+	n := runtime.NumCPU()
+	c.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go c.worker()
+	}
+	return c
+}
+
+// queue file 'fn' of 'sz' bytes for estimation; 'raw' files are
+// counted as incompressible.
+func (c *compEstimator) add(fn string, sz uint64, raw bool) {
+	c.ch <- compJob{fn, sz, raw}
+}
+
+// wait for all the queued files and return the estimated compressed
+// size and the size of each entry.
+func (c *compEstimator) wait() (map[string]uint64, map[string]uint64) {
+	close(c.ch)
+	c.wg.Wait()
+	return c.est, c.sizes
+}
+
+// names returns the entries file 'fn' is accounted to
+func (c *compEstimator) names(fn string) []string {
+	if c.all {
+		return []string{fn}
+	}
+
+	for _, nm := range c.args {
+		if !under(fn, nm) {
+			continue
+		}
+
+		// the dirs are named as the walk names them; path.Dir()
+		// would clean a leading "./" away.
+		v := []string{nm}
+		for d := fn; c.dirs; {
+			i := strings.LastIndexByte(d, '/')
+			if i <= 0 {
+				break
+			}
+			if d = d[:i]; d == nm || !under(d, nm) {
+				break
+			}
+			v = append(v, d)
+		}
+		return v
+	}
+	return nil
+}
+
+func (c *compEstimator) worker() {
+	cw, _ := flate.NewWriter(io.Discard, flate.BestSpeed)
+	buf := make([]byte, _SampleSize)
+	for j := range c.ch {
+		z := j.sz
+		if !j.raw {
+			z = estimate(j.fn, j.sz, cw, buf)
+		}
+
+		c.Lock()
+		for _, nm := range c.names(j.fn) {
+			c.est[nm] += z
+			c.sizes[nm] += j.sz
+		}
+		c.Unlock()
+	}
+	c.wg.Done()
+}
+
+// estimate the compressed size of 'fn'; unreadable files are assumed
+// to be incompressible.
+func estimate(fn string, sz uint64, cw *flate.Writer, buf []byte) uint64 {
+	if sz == 0 {
+		return 0
+	}
+
+	fd, err := os.Open(fn)
+	if err != nil {
+		return sz
+	}
+	defer fd.Close()
+
+	// small files are compressed in full
+	n := _Samples
+	step := int64(sz) / _Samples
+	if sz <= _SampleSize*_Samples {
+		n, step = int((sz+_SampleSize-1)/_SampleSize), _SampleSize
+	}
+
+	var cnt countWriter
+	var in uint64
+	cw.Reset(&cnt)
+	for i := 0; i < n; i++ {
+		m, err := fd.ReadAt(buf, int64(i)*step)
+		if m > 0 {
+			cw.Write(buf[:m])
+			in += uint64(m)
+		}
+		if err != nil {
+			break
+		}
+	}
+	cw.Close()
+
+	if in == 0 || cnt.n >= in {
+		return sz
+	}
+	return uint64(float64(sz) * float64(cnt.n) / float64(in))
+}
+
+// countWriter counts the bytes written to it
+type countWriter struct {
+	n uint64
+}
+
+func (w *countWriter) Write(b []byte) (int, error) {
+	w.n += uint64(len(b))
+	return len(b), nil
+}
@@ -0,0 +1,58 @@
+// denied.go - the dirs that couldn't be read for want of permission
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/opencoff/go-fio/walk"
+)
+
+// deniedDirs counts the dirs whose entries couldn't be listed because
+// permission was denied; nothing below them is counted. Their contents
+// are unknown - only the size of the dirs themselves is; so that's the
+// least that's missing from the totals.
+type deniedDirs struct {
+	sync.Mutex
+	n    int
+	size uint64
+}
+
+// add 'err' if it's a dir that couldn't be read for want of permission
+func (d *deniedDirs) add(err error) {
+	var we *walk.Error
+	if !errors.As(err, &we) || we.Op != "readdir" || !errors.Is(err, fs.ErrPermission) {
+		return
+	}
+
+	var sz uint64
+	if fi, err := os.Lstat(we.Name); err == nil {
+		sz = uint64(fi.Size())
+	}
+
+	d.Lock()
+	d.n++
+	d.size += sz
+	d.Unlock()
+}
+
+// count returns the number of dirs that weren't read and their size
+func (d *deniedDirs) count() (int, uint64) {
+	d.Lock()
+	defer d.Unlock()
+	return d.n, d.size
+}
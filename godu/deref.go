@@ -0,0 +1,77 @@
+// deref.go - follow symlinks given on the command line (-D)
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// an arg that is a symlink and the path it resolves to
+type argAlias struct {
+	arg  string
+	targ string
+}
+
+// argAliases maps the paths under the targets of symlinked args back
+// to the args; so the walker can walk the targets while the entries
+// are reported (and accounted) under the args as given.
+type argAliases []argAlias
+
+// derefArgs returns the roots to walk - the targets of the args that
+// are symlinks and the rest as is - and the aliases to map the
+// walked paths back to the args.
+func derefArgs(args []string) ([]string, argAliases) {
+	var al argAliases
+
+	roots := make([]string, 0, len(args))
+	for _, nm := range args {
+		fi, err := os.Lstat(nm)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			roots = append(roots, nm)
+			continue
+		}
+
+		targ, err := filepath.EvalSymlinks(nm)
+		if err != nil {
+			// dangling links are left to the walker
+			roots = append(roots, nm)
+			continue
+		}
+		roots = append(roots, targ)
+		al = append(al, argAlias{nm, targ})
+	}
+
+	// the longest target must match first
+	sort.Slice(al, func(i, j int) bool {
+		return len(al[i].targ) > len(al[j].targ)
+	})
+	return roots, al
+}
+
+// path returns 'fn' relative to the arg whose target it is under
+func (al argAliases) path(fn string) string {
+	for i := range al {
+		a := &al[i]
+		if fn == a.targ {
+			return a.arg
+		}
+		if under(fn, a.targ) {
+			return a.arg + fn[len(strings.TrimSuffix(a.targ, "/")):]
+		}
+	}
+	return fn
+}
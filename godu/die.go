@@ -10,12 +10,25 @@ package main
 import (
 	"fmt"
 	"os"
+
+	"go-progs/internal/report"
 )
 
-// die with error
+var atExits []func()
+
+// errors are reported here
+var rep *report.Reporter
+
+// die with a usage error
 func die(f string, v ...interface{}) {
 	warn(f, v...)
-	os.Exit(1)
+	exit(report.ExitUsage)
+}
+
+// fatal reports 'err' and exits with the exit code of its kind
+func fatal(err error) {
+	rep.Error(err)
+	exit(rep.Code())
 }
 
 func warn(f string, v ...interface{}) {
@@ -29,4 +42,17 @@ func warn(f string, v ...interface{}) {
 	os.Stderr.Sync()
 }
 
+// atExit registers a function to be called before the program exits
+func atExit(f func()) {
+	atExits = append(atExits, f)
+}
+
+// exit runs the registered at-exit functions and exits with code 'v'
+func exit(v int) {
+	for _, f := range atExits {
+		f()
+	}
+	os.Exit(v)
+}
+
 // vim: ft=go:sw=4:ts=4:noexpandtab:tw=78:
@@ -0,0 +1,122 @@
+// dump.go - dump and load the per-file records of a walk as CSV
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/opencoff/go-fio"
+)
+
+// the columns of a dump
+var dumpHeader = []string{"path", "size", "mtime", "uid"}
+
+// fileDump writes a CSV record for each file accounted in a walk: its
+// path, the size counted, mtime (unix seconds) and uid. The dump is
+// committed only by close().
+type fileDump struct {
+	fd *fio.SafeFile
+	bw *bufio.Writer
+	wr *csv.Writer
+}
+
+func newFileDump(fn string, force bool) (*fileDump, error) {
+	var opt uint32
+	if force {
+		opt |= fio.OPT_OVERWRITE
+	}
+	fd, err := fio.NewSafeFile(fn, opt, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	bw := bufio.NewWriterSize(fd, 256*1024)
+	d := &fileDump{
+		fd: fd,
+		bw: bw,
+		wr: csv.NewWriter(bw),
+	}
+	d.wr.Write(dumpHeader)
+	return d, nil
+}
+
+// add a record for 'fn' counted as 'sz' bytes
+func (d *fileDump) add(fn string, fi *fio.Info, sz uint64) {
+	d.wr.Write([]string{
+		fn,
+		strconv.FormatUint(sz, 10),
+		strconv.FormatInt(fi.ModTime().Unix(), 10),
+		strconv.FormatUint(uint64(fi.Uid), 10),
+	})
+}
+
+// close flushes and commits the dump
+func (d *fileDump) close() error {
+	d.wr.Flush()
+	if err := d.wr.Error(); err != nil {
+		return err
+	}
+	if err := d.bw.Flush(); err != nil {
+		return err
+	}
+	return d.fd.Close()
+}
+
+func (d *fileDump) abort() {
+	d.fd.Abort()
+}
+
+// loadDump calls 'fp' with the path and size of each record in the
+// dump 'fn'; the other columns are ignored.
+func loadDump(fn string, fp func(nm string, sz uint64)) error {
+	fd, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	rd := csv.NewReader(bufio.NewReaderSize(fd, 256*1024))
+	rd.ReuseRecord = true
+
+	hdr, err := rd.Read()
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+	if len(hdr) < 2 || hdr[0] != dumpHeader[0] || hdr[1] != dumpHeader[1] {
+		return fmt.Errorf("%s: not a godu dump", fn)
+	}
+
+	for {
+		v, err := rd.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", fn, err)
+		}
+
+		sz, err := strconv.ParseUint(v[1], 10, 64)
+		if err != nil {
+			line, _ := rd.FieldPos(1)
+			return fmt.Errorf("%s: %d: invalid size '%s'", fn, line, v[1])
+		}
+		fp(v[0], sz)
+	}
+}
@@ -14,13 +14,22 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"os"
 	"path"
+	"runtime"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
+	"go-progs/internal/cachetag"
+	"go-progs/internal/glob"
+	"go-progs/internal/report"
+	"go-progs/internal/vfs"
+
+	"github.com/opencoff/go-fio"
 	"github.com/opencoff/go-fio/walk"
 	"github.com/opencoff/go-utils"
 	flag "github.com/opencoff/pflag"
@@ -32,6 +41,34 @@ var Verbose bool
 type result struct {
 	name string
 	size uint64
+
+	// bytes allocated on disk (with --sparse)
+	alloc uint64
+
+	// estimated compressed size (with --estimate-compressed)
+	comp uint64
+}
+
+// bytes saved by holes in a sparse file or dir
+func (r *result) saved() uint64 {
+	if r.alloc < r.size {
+		return r.size - r.alloc
+	}
+	return 0
+}
+
+type bySaved []result
+
+func (r bySaved) Len() int {
+	return len(r)
+}
+
+func (r bySaved) Swap(i, j int) {
+	r[i], r[j] = r[j], r[i]
+}
+
+func (r bySaved) Less(i, j int) bool {
+	return r[i].saved() > r[j].saved()
 }
 
 type bySize []result
@@ -55,21 +92,68 @@ func main() {
 	var kb bool
 	var byts bool
 	var total bool
-	var symlinks bool
+	var symlinks, derefs bool
 	var onefs bool
 	var all bool
-	var excludes []string
+	var excludes, includes []string
+	var fold bool
+	var cacheDir string
+	var export string
+	var linkPolicy string
+	var timeout time.Duration
+	var output string
+	var force bool
+	var threshold string
+	var summarize, children bool
+	var jsonErrs bool
+	var sparse, sparseOnly bool
+	var estComp bool
+	var arcContents bool
+	var dumpFile, loadFile string
+	var color, colorHeat string
+	var percent bool
+	var noCaches bool
+	var strict bool
+	var staleDays int
+	var staleBy string
 
 	flag.BoolVarP(&version, "version", "", false, "Show version info and quit")
 	flag.BoolVarP(&Verbose, "verbose", "v", false, "Show verbose output")
 	flag.BoolVarP(&symlinks, "follow-symlinks", "L", false, "Follow symlinks")
+	flag.BoolVarP(&derefs, "dereference-args", "D", false, "Follow symlinks given on the command line only")
 	flag.BoolVarP(&onefs, "single-filesystem", "x", false, "Don't cross mount points")
 	flag.BoolVarP(&all, "all", "a", false, "Show all files & dirs")
+	flag.BoolVarP(&summarize, "summarize", "s", false, "Show only a total for each argument (default)")
+	flag.BoolVarP(&children, "children", "", false, "Show a total for each immediate child of each dir")
 	flag.BoolVarP(&human, "human-size", "h", false, "Show size in human readable form")
 	flag.BoolVarP(&kb, "kilo-byte", "k", false, "Show size in kilo bytes")
 	flag.BoolVarP(&byts, "byte", "b", false, "Show size in bytes")
 	flag.BoolVarP(&total, "total", "t", false, "Show total size")
-	flag.StringSliceVarP(&excludes, "exclude", "", nil, "Exclude names starting with `N`")
+	flag.StringSliceVarP(&excludes, "exclude", "", nil, "Exclude entries matching glob `G`")
+	flag.StringSliceVarP(&includes, "include", "", nil, "Re-include entries matching glob `G` that were excluded")
+	flag.BoolVarP(&noCaches, "exclude-caches", "", false, "Skip dirs tagged with a CACHEDIR.TAG file")
+	flag.BoolVarP(&fold, "ignore-case", "", false, "Match --exclude and --include globs ignoring case")
+	flag.StringVarP(&cacheDir, "cache", "", "", "Cache per-dir sizes in dir `D` to speed up re-scans")
+	flag.StringVarP(&linkPolicy, "symlink-size", "", "", "Count symlinks as `P`: zero, link or target")
+	flag.DurationVarP(&timeout, "timeout", "", 0, "Skip mounts that don't answer in `T` and give up if the scan stalls for T")
+	flag.StringVarP(&output, "output", "o", "", "Write the report to file `F` instead of stdout")
+	flag.BoolVarP(&force, "force", "f", false, "Overwrite the output file if it exists")
+	flag.StringVarP(&threshold, "threshold", "", "", "Hide entries smaller than `SIZE` (or larger, if negative)")
+	flag.BoolVarP(&sparse, "sparse", "", false, "Also show the allocated size and the bytes saved by holes")
+	flag.BoolVarP(&sparseOnly, "sparse-only", "", false, "Only list sparse files, the biggest savings first")
+	flag.BoolVarP(&estComp, "estimate-compressed", "", false, "Also show the estimated compressed size and savings")
+	flag.BoolVarP(&arcContents, "archive-contents", "", false, "Also list each tar and zip file with the total size of its members")
+	flag.IntVarP(&staleDays, "stale-days", "", 0, "Only count the files not modified (see --stale-time) in the last `N` days")
+	flag.StringVarP(&staleBy, "stale-time", "", staleMtime, "Take a file's `T` as its last use for --stale-days (mtime, atime, both)")
+	flag.StringVarP(&dumpFile, "dump-files", "", "", "Write a CSV record of each file seen to `F`")
+	flag.StringVarP(&loadFile, "load-files", "", "", "Report on the files in the dump `F` instead of walking")
+	flag.BoolVarP(&percent, "percent", "", false, "Also show each entry's share of the total (and of its parent)")
+	flag.StringVarP(&color, "color", "", "never", "Color the sizes by magnitude: `WHEN` is auto, always or never")
+	flag.StringVarP(&colorHeat, "color-thresholds", "", _DefaultHeat, "Sizes from `Y,R` are colored yellow and red")
+	flag.Lookup("color").NoOptDefVal = "auto"
+	flag.BoolVarP(&strict, "strict", "", false, "Exit with a non-zero status if there were errors")
+	flag.BoolVarP(&jsonErrs, "json-errors", "", false, "Write errors to stderr as JSON records")
+	flag.StringVarP(&export, "export", "", "", "Export the size tree to `F` (.json, .svg or .html treemap)")
 
 	flag.Usage = func() {
 		fmt.Printf(
@@ -77,6 +161,109 @@ func main() {
 
 Usage: %s [options] dir [dir...]
 
+The --exclude and --include globs follow gitignore(5): a glob without a
+'/' matches names at any depth, others are anchored to the argument
+they're under; '**' matches any number of dirs and a trailing '/' only
+matches dirs. The last matching glob wins.
+
+With --exclude-caches, dirs with a CACHEDIR.TAG file (the Cache
+Directory Tagging Standard, https://bford.info/cachedir/) are skipped
+- like GNU tar and borg do; so browser and build caches can be left
+out.
+
+By default, each argument is summarized in one line (-s). With
+--children, each dir argument is replaced by its immediate children;
+so 'godu --children D' is 'du -s D/*' in one parallel walk.
+
+Symlinks are not counted by default (--symlink-size=zero). With
+--symlink-size=link, each symlink counts as its own size; with
+--symlink-size=target, a symlink to a file counts as the size of that
+file - but each file is counted only once no matter how many links point
+to it. -L follows all symlinks (incl. dirs) and implies 'target'.
+-D follows just the symlinks given as args - like du -D; their
+contents are shown under the names given.
+
+With --cache=D, the size of the files right under each dir is kept in
+D; a dir whose mtime is unchanged isn't read again in the next run. A
+file that changes size in place isn't seen until its dir changes. The
+cache is discarded if -x, --symlink-size, --exclude-caches or the
+globs (and, with globs, the args) differ from the run that made it.
+Hard links are counted once per walk: a file linked from a cached dir
+and a dir that's read is counted twice.
+
+On network mounts, --timeout=T (e.g. 30s) probes the first dir of every
+file system; a mount that doesn't answer in T is skipped with a warning.
+If no entries arrive for T, the scan is abandoned and the partial
+results are shown. Either case exits with 3 (an I/O error).
+
+With --sparse, each line shows the logical size, the size allocated
+on disk and the bytes saved by holes (sparse files, e.g. VM images).
+--sparse-only lists just the sparse files in the same format - the
+biggest savings first.
+
+With --estimate-compressed, a few samples of each file are compressed
+with a fast compressor (deflate, level 1) and the ratio is scaled to
+the whole file. Each line shows the size, the estimated compressed size
+and the savings; a planning aid before enabling file system
+compression. Each dir below the args is listed too (with the files
+below it) unless -s is given. It reads file contents and is much
+slower than a scan. A walk that stalls has no estimates; they're
+shown as '?'.
+
+With --archive-contents, each tar (optionally gzip or bzip2 compressed)
+and zip file counted is also listed after the report: its size, the
+total size of its members and the ratio of the two - the largest
+expanded size first. The sizes come from the archive's index; nothing
+is extracted (a compressed tar is still read in full). So the space an
+extracted tree would take is seen without extracting it. It can't be
+used with --cache or --load-files.
+
+With --stale-days=N, only the files that weren't modified in the last
+N days are counted; so the report shows what archiving stale data
+would reclaim. --stale-time=atime looks at the last access instead (not
+reliable on file systems mounted with noatime) and 'both' at the later
+of the two. The report ends with the size of the stale files, the size
+of all the files and the number of stale files. It can't be used with
+URLs, --cache or --load-files.
+
+With --dump-files=F, each file counted in the walk is written to F as
+a CSV record: path, size, mtime (unix seconds) and uid - for analysis
+in other tools. --load-files=F reports on such a dump - for the args
+(paths in the dump) - without walking again.
+
+With --percent, each line also shows its share of the grand total;
+with --all and --children, followed by its share of the argument it's
+under (its parent).
+
+The args can be URLs of object stores instead of dirs: 'godu
+s3://BUCKET/PREFIX' sums the sizes of the objects under PREFIX; the
+prefixes right below each arg are listed in parallel. "Dirs" are the
+'/' separated parts of the object names; --all, --children, --export,
+--dump-files and the globs work as they do for dirs. URLs and local
+dirs can't be mixed. S3 is configured by the usual AWS_ACCESS_KEY_ID,
+AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN and AWS_REGION; set
+AWS_ENDPOINT_URL for compatible stores (e.g. minio). The other URLs are
+tar://FILE.tar, zip://FILE (a dir in them is FILE::DIR),
+sftp://[USER@]HOST/PATH and file://PATH.
+
+With --color (or --color=auto), the sizes shown on a terminal are
+colored by magnitude: green, yellow from 100M and red from 1G. The
+thresholds are set with --color-thresholds=Y,R; --color=always also
+colors a redirected report. NO_COLOR in the environment disables
+--color=auto.
+
+Errors are written to stderr as they occur and the report of what
+could be read is always shown on stdout; but it's partial: --cache and
+--dump-files aren't updated. With --strict, errors exit with 2 if a
+file vanished, 3 on I/O errors and 4 if permission was denied; the
+largest applies. An error that stops the scan (e.g. --output can't be
+written) exits the same way, even without --strict; bad options and
+arguments exit with 64. The dirs that couldn't be read for want of
+permission are summed up at the end: "at least X not counted
+(permission denied in N dirs)"; X is the size of those dirs
+themselves, as what's in them is unknown. With --json-errors, each
+error is a JSON record: {"prog", "kind", "op", "path", "error"}.
+
 Options:
 `, Z, Z)
 		flag.PrintDefaults()
@@ -85,6 +272,8 @@ Options:
 	}
 
 	flag.Parse()
+
+	rep = report.New(os.Args[0], jsonErrs)
 	if version {
 		fmt.Printf("%s - %s [%s]\n", Z, ProductVersion, RepoVersion)
 		os.Exit(0)
@@ -95,6 +284,31 @@ Options:
 		die("Insufficient args. Try %s --help", Z)
 	}
 
+	remote, err := urlArgs(args)
+	if err != nil {
+		die("%s", err)
+	}
+	if remote {
+		if len(cacheDir) > 0 || len(loadFile) > 0 || symlinks || derefs || onefs || len(linkPolicy) > 0 || sparse || sparseOnly || estComp || arcContents || noCaches || timeout > 0 {
+			die("URLs can't be used with --cache, --load-files, -L, -D, -x, --symlink-size, --sparse, --sparse-only, --estimate-compressed, --archive-contents, --exclude-caches or --timeout")
+		}
+	}
+
+	// like du(1), a negative threshold shows only the smaller entries
+	var minSize, maxSize uint64
+	if len(threshold) > 0 {
+		neg := strings.HasPrefix(threshold, "-")
+		z, err := utils.ParseSize(strings.TrimPrefix(threshold, "-"))
+		if err != nil {
+			die("invalid threshold '%s': %s", threshold, err)
+		}
+		if neg {
+			maxSize = z
+		} else {
+			minSize = z
+		}
+	}
+
 	var size func(uint64) string
 
 	if human {
@@ -110,74 +324,663 @@ Options:
 		}
 	}
 
+	if all && (summarize || children) {
+		die("--all can't be used with --summarize or --children")
+	}
+
+	if estComp && sparse {
+		die("--estimate-compressed can't be used with --sparse")
+	}
+
+	if len(loadFile) > 0 {
+		if len(cacheDir) > 0 || len(dumpFile) > 0 || children || symlinks || derefs || sparse || sparseOnly || estComp || arcContents || noCaches || timeout > 0 {
+			die("--load-files can't be used with --cache, --dump-files, --children, -L, -D, --sparse, --sparse-only, --estimate-compressed, --archive-contents, --exclude-caches or --timeout")
+		}
+	}
+
+	var stale *staleFilter
+	if staleDays != 0 || flag.Lookup("stale-time").Changed {
+		if remote || len(cacheDir) > 0 || len(loadFile) > 0 {
+			die("--stale-days can't be used with URLs, --cache or --load-files")
+		}
+		if stale, err = newStaleFilter(staleDays, staleBy); err != nil {
+			die("%s", err)
+		}
+	}
+
+	if sparseOnly {
+		if all || children {
+			die("--sparse-only can't be used with --all or --children")
+		}
+		sparse = true
+	}
+
+	// a report written to a file is never colored by --color=auto
+	tty := (len(output) == 0 || output == "-") && isTTY(os.Stdout)
+	hot, err := newHeat(color, colorHeat, tty)
+	if err != nil {
+		die("%s", err)
+	}
+
+	// globs are relative to the args as given - even with --children
+	excl, err := glob.Excludes(excludes, includes, fold)
+	if err != nil {
+		die("%s", err)
+	}
+
+	// with -D, the globs are also relative to the targets of the
+	// symlinked args
+	derefs = derefs && !symlinks
+	globRoots := args
+	if derefs {
+		targs, _ := derefArgs(args)
+		globRoots = append(targs, args...)
+	}
+	exclude := excl.Filter(globRoots)
+
+	var parents map[string]string
+	if children {
+		if remote {
+			args, parents = urlChildren(args)
+		} else {
+			args, parents = childrenOf(args)
+		}
+		if len(args) == 0 {
+			die("no entries to report")
+		}
+	}
+
 	// sort the args in decreasing length so our prefix matching always
 	// finds the longest match
 	sort.Sort(byLen(args))
 
+	// -D walks the targets of the symlinked args; but their entries
+	// are shown under the args.
+	roots := args
+	var aliases argAliases
+	if derefs {
+		roots, aliases = derefArgs(args)
+	}
+
+	if len(linkPolicy) == 0 {
+		linkPolicy = linkZero
+		if symlinks {
+			linkPolicy = linkTarget
+		}
+	} else if symlinks && linkPolicy != linkTarget {
+		die("--follow-symlinks only works with --symlink-size=target")
+	}
+
+	links, err := newLinkAccount(linkPolicy)
+	if err != nil {
+		die("%s", err)
+	}
+
 	opt := walk.Options{
 		FollowSymlinks: symlinks,
 		OneFS:          onefs,
 		Type:           walk.FILE,
-		Excludes:       excludes,
 
 		// We want to count file sizes only once. So, we'll ignore
 		// hardlinked files.
 		IgnoreDuplicateInode: true,
 	}
 
-	ch, ech := walk.Walk(args, opt)
+	// the walker follows symlinks for -L; otherwise we do the accounting
+	if !symlinks && linkPolicy != linkZero {
+		opt.Type |= walk.SYMLINK
+	}
+
+	var cache *dirCache
+	var dirs dirTally
 
-	// harvest errors
-	errs := make([]string, 0, 8)
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		for e := range ech {
-			errs = append(errs, fmt.Sprintf("%s", e))
+	if len(cacheDir) > 0 {
+		if all || symlinks || derefs || sparse || estComp || arcContents {
+			die("--cache can't be used with --all, --follow-symlinks, --dereference-args, --sparse, --estimate-compressed or --archive-contents")
 		}
-		wg.Done()
-	}()
 
-	// now harvest results - we know we will only get files and their info.
-	res := make([]result, 0, 1024)
+		// the sizes in the cache depend on what's counted; the
+		// anchored globs also depend on the args.
+		var roots string
+		if len(excludes) > 0 || len(includes) > 0 {
+			roots = strings.Join(args, "\x00")
+		}
+		opts := optsHash(fmt.Sprintf("x=%v caches=%v fold=%v links=%s", onefs, noCaches, fold, linkPolicy),
+			strings.Join(excludes, "\x00"), strings.Join(includes, "\x00"), roots)
+		c, err := openCache(cacheDir, opts)
+		if err != nil {
+			fatal(err)
+		}
+
+		cache = c
+		dirs = make(dirTally)
+		opt.Type |= walk.DIR
+
+		// unchanged dirs are not descended; we account for them
+		// from the cache and walk their sub-dirs in the next round.
+		opt.Filter = func(fi *fio.Info) (bool, error) {
+			return fi.IsDir() && cache.lookup(fi), nil
+		}
+	}
+
+	var probe *mountProbe
+	if timeout > 0 {
+		probe = newMountProbe(timeout)
+		opt.Type |= walk.DIR
+
+		filter := opt.Filter
+		opt.Filter = func(fi *fio.Info) (bool, error) {
+			if probe.filter(fi) {
+				return true, nil
+			}
+			if filter != nil {
+				return filter(fi)
+			}
+			return false, nil
+		}
+	}
+
+	// excluded entries are never looked up in the cache or probed
+	if !excl.Empty() || noCaches {
+		isCache := cachetag.Filter()
+		filter := opt.Filter
+		opt.Filter = func(fi *fio.Info) (bool, error) {
+			if skip, _ := exclude(fi); skip {
+				return true, nil
+			}
+			if noCaches {
+				if skip, _ := isCache(fi); skip {
+					return true, nil
+				}
+			}
+			if filter != nil {
+				return filter(fi)
+			}
+			return false, nil
+		}
+	}
+
+	// the report is committed only after it is fully written
+	var wfd *fio.SafeFile
+	if len(output) > 0 && output != "-" {
+		var fopt uint32
+		if force {
+			fopt |= fio.OPT_OVERWRITE
+		}
+		fd, err := fio.NewSafeFile(output, fopt, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		if err != nil {
+			fatal(err)
+		}
+		wfd = fd
+		atExit(wfd.Abort)
+	}
+
+	var tree *sizeTree
+	if len(export) > 0 {
+		tree = newSizeTree()
+	}
+
+	// account 'sz' bytes of 'fn' to its command line arg; 'ent' is
+	// the entry in the size tree that holds these bytes.
 	sizes := make(map[string]uint64)
-	for fi := range ch {
-		fn := fi.Path()
-		sz := uint64(fi.Size())
+	allocs := make(map[string]uint64)
+	tally := func(fn, ent string, sz, asz uint64) {
 		for i := range args {
 			nm := args[i]
-			if strings.HasPrefix(fn, nm) {
+			if under(fn, nm) {
 				sizes[nm] += sz
+				allocs[nm] += asz
+				if tree != nil {
+					tree.add(nm, ent, sz)
+				}
 				break
 			}
 		}
-		if all {
-			res = append(res, result{fn, sz})
+	}
+
+	var dump *fileDump
+	if len(dumpFile) > 0 {
+		d, err := newFileDump(dumpFile, force)
+		if err != nil {
+			fatal(err)
 		}
+		dump = d
+		atExit(dump.abort)
+	}
+
+	var comp *compEstimator
+	if estComp {
+		comp = newCompEstimator(args, all, !flag.Lookup("summarize").Changed)
 	}
 
-	wg.Wait()
-	if len(errs) > 0 {
-		die("%s", strings.Join(errs, "\n"))
+	var arcs *archiveSizer
+	if arcContents {
+		arcs = newArchiveSizer(rep)
+	}
+
+	var denied deniedDirs
+	res := make([]result, 0, 1024)
+	var holes []result
+
+	// a dump is accounted just like the walk below
+	if len(loadFile) > 0 {
+		err := loadDump(loadFile, func(fn string, sz uint64) {
+			if !underAny(args, fn) {
+				return
+			}
+			ent := fn
+			if !all && !isArg(args, fn) {
+				ent = path.Dir(fn)
+			}
+			tally(fn, ent, sz, sz)
+			if all {
+				res = append(res, result{fn, sz, sz, 0})
+			}
+		})
+		if err != nil {
+			fatal(err)
+		}
+		roots = nil
+	}
+
+	// errors seen so far; a walk with errors is partial
+	var errs int
+
+	// objects are listed rather than walked
+	if remote {
+		walkURLs(args, globRoots, excl, rep, func(e *vfs.Entry) {
+			fn := e.Name
+			sz := uint64(e.Size)
+			ent := fn
+			if !all && !isArg(args, fn) {
+				ent = parentURL(fn)
+			}
+			tally(fn, ent, sz, sz)
+			if dump != nil {
+				dump.add(fn, entryInfo(e), sz)
+			}
+			if all {
+				res = append(res, result{fn, sz, sz, 0})
+			}
+		})
+		errs = rep.Errors()
+		roots = nil
+	}
+
+	stalled := false
+	for len(roots) > 0 && !stalled {
+		// the walker emits the roots that aren't dirs (and their
+		// errors) before we start reading; so its queues must be
+		// able to hold all of them.
+		opt.Concurrency = max(runtime.NumCPU(), len(roots))
+		ch, ech := walk.Walk(roots, opt)
+
+		// harvest errors
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			for e := range ech {
+				denied.add(e)
+				rep.Error(e)
+			}
+			wg.Done()
+		}()
+
+		// if the walk makes no progress for 'timeout', we stop waiting
+		// for it and report what we have.
+		var tick <-chan time.Time
+		var timer *time.Timer
+		if timeout > 0 {
+			timer = time.NewTimer(timeout)
+			tick = timer.C
+		}
+
+		// now harvest results - we know we will only get files and their info
+		// (and dirs if we're caching).
+	harvest:
+		for {
+			var fi *fio.Info
+			var ok bool
+
+			select {
+			case fi, ok = <-ch:
+				if !ok {
+					break harvest
+				}
+			case <-tick:
+				warn("no progress in %s; showing partial results", timeout)
+				stalled = true
+				break harvest
+			}
+
+			if timer != nil {
+				timer.Reset(timeout)
+			}
+
+			fn := aliases.path(fi.Path())
+			if fi.IsDir() {
+				if dirs != nil {
+					dirs.dir(fi)
+				}
+				continue
+			}
+
+			// files are shown in the tree only with --all
+			sz := uint64(fi.Size())
+			if isSymlink(fi) {
+				sz = links.size(fi)
+			} else if !symlinks && links.counted(fi) {
+				// -L does its own inode dedup
+				continue
+			}
+			if stale != nil && !stale.keep(fi, sz) {
+				continue
+			}
+			asz := sz
+			if sparse && fi.Mode().IsRegular() {
+				if a, ok := allocSize(fn); ok {
+					asz = a
+				}
+				if sparseOnly && asz < sz {
+					holes = append(holes, result{fn, sz, asz, 0})
+				}
+			}
+
+			ent := fn
+			if !all && !isArg(args, fn) {
+				ent = path.Dir(fn)
+			}
+			tally(fn, ent, sz, asz)
+			if dump != nil {
+				dump.add(fn, fi, sz)
+			}
+			if comp != nil {
+				comp.add(fn, sz, isSymlink(fi))
+			}
+			if arcs != nil && fi.Mode().IsRegular() {
+				arcs.add(fn, sz)
+			}
+			if cache != nil {
+				dirs.file(fn, sz)
+			}
+			if all {
+				res = append(res, result{fn, sz, asz, 0})
+			}
+		}
+
+		// the walker is still blocked; its errors will never be drained.
+		if stalled {
+			break
+		}
+		wg.Wait()
+		errs = rep.Errors()
+
+		roots = nil
+		if cache != nil {
+			for nm, e := range cache.drain() {
+				tally(nm, nm, e.Size, e.Size)
+				for _, sub := range e.Subdirs {
+					roots = append(roots, subPath(nm, sub))
+				}
+			}
+		}
+	}
+
+	// a stalled walker may still be reporting errors; the results
+	// are partial either way.
+	if stalled {
+		errs = rep.Errors()
+	}
+	partial := stalled || errs > 0
+
+	// partial results must never be cached
+	if cache != nil && !partial {
+		for _, d := range dirs {
+			if d.fi != nil {
+				cache.store(d.fi, d.size, d.subdirs)
+			}
+		}
+		if err := cache.save(); err != nil {
+			warn("%s", err)
+		}
+	}
+
+	if tree != nil {
+		if err := tree.export(export); err != nil {
+			warn("%s", err)
+		}
 	}
 
 	if !all {
 		for k, v := range sizes {
-			res = append(res, result{k, v})
+			res = append(res, result{k, v, allocs[k], 0})
 		}
 
 	}
 
-	var tot uint64
+	// comp is only drained once the walk is done; the dirs below the
+	// args are listed after the shares of the total are known.
+	var subdirs []result
+	if comp != nil && !stalled {
+		est, sizes := comp.wait()
+		for i := range res {
+			res[i].comp = est[res[i].name]
+		}
+		if !all {
+			for nm, sz := range sizes {
+				if !isArg(args, nm) {
+					subdirs = append(subdirs, result{nm, sz, sz, est[nm]})
+				}
+			}
+		}
+	}
+
+	wr := bufio.NewWriter(os.Stdout)
+	if wfd != nil {
+		wr.Reset(wfd)
+	}
+
+	var pct *shares
+	if percent {
+		pct = newShares(res)
+		switch {
+		case all:
+			// the args are sorted longest first
+			pct.byParent(res, func(nm string) (string, bool) {
+				for _, a := range args {
+					if under(nm, a) {
+						return a, true
+					}
+				}
+				return "", false
+			})
+		case children:
+			pct.byParent(res, func(nm string) (string, bool) {
+				p, ok := parents[nm]
+				return p, ok
+			})
+		}
+	}
+
+	// the size is padded before it's colored
+	col := func(z uint64) string {
+		return hot.paint(fmt.Sprintf("%12s", size(z)), z)
+	}
+
+	line := func(r *result) {
+		nm := r.name
+		if pct != nil {
+			nm = pct.String(r) + " " + nm
+		}
+
+		if comp != nil && stalled {
+			// the estimates of a stalled walk are unknown
+			fmt.Fprintf(wr, "%s %12s %6s %s\n", col(r.size), "?", "?", nm)
+		} else if comp != nil {
+			var saved float64
+			if r.size > 0 {
+				saved = 100 * float64(r.size-min(r.comp, r.size)) / float64(r.size)
+			}
+			fmt.Fprintf(wr, "%s %s %5.1f%% %s\n", col(r.size), col(r.comp), saved, nm)
+		} else if sparse {
+			fmt.Fprintf(wr, "%s %s %s %s\n", col(r.size), col(r.alloc), col(r.saved()), nm)
+		} else {
+			fmt.Fprintf(wr, "%s %s\n", col(r.size), nm)
+		}
+	}
+
+	// the total is of everything - not just the entries shown; the
+	// dirs are already in the args they're below.
+	tot := result{name: "TOTAL"}
+	for i := range res {
+		r := &res[i]
+		tot.size += r.size
+		tot.alloc += r.alloc
+		tot.comp += r.comp
+	}
+
+	res = append(res, subdirs...)
 	sort.Sort(bySize(res))
 	for i := range res {
-		r := res[i]
-		tot += r.size
-		fmt.Printf("%12s %s\n", size(r.size), r.name)
+		r := &res[i]
+		if sparseOnly {
+			continue
+		}
+		if r.size < minSize || (maxSize > 0 && r.size > maxSize) {
+			continue
+		}
+		line(r)
+	}
+
+	if sparseOnly {
+		sort.Sort(bySaved(holes))
+		for i := range holes {
+			r := &holes[i]
+			if r.size < minSize || (maxSize > 0 && r.size > maxSize) {
+				continue
+			}
+			line(r)
+		}
 	}
 	if total {
-		fmt.Printf("%12s TOTAL\n", size(tot))
+		line(&tot)
+	}
+
+	// arcs is only drained once the walk is done
+	if arcs != nil && !stalled {
+		v := arcs.wait()
+		errs = rep.Errors()
+		if len(v) > 0 {
+			fmt.Fprintf(wr, "\n# archives: size, expanded size, expansion\n")
+		}
+		for _, a := range v {
+			var x float64
+			if a.size > 0 {
+				x = float64(a.expanded) / float64(a.size)
+			}
+			fmt.Fprintf(wr, "%s %s %6.1fx %s\n", col(a.size), col(a.expanded), x, a.name)
+		}
+	}
+
+	if stale != nil && !stalled {
+		fmt.Fprintf(wr, "\n# stale: %s of %s in %d files %s\n",
+			strings.TrimSpace(size(stale.size)), strings.TrimSpace(size(stale.total)), stale.files, stale)
+	}
+
+	if err := wr.Flush(); err != nil {
+		fatal(fmt.Errorf("can't write report: %w", err))
+	}
+	if wfd != nil {
+		if err := wfd.Close(); err != nil {
+			fatal(fmt.Errorf("%s: %w", output, err))
+		}
+	}
+
+	// so the totals can be told apart from what root would see
+	if n, sz := denied.count(); n > 0 {
+		warn("at least %s not counted (permission denied in %d dirs)", size(sz), n)
+	}
+
+	// a partial dump is never committed; an archive that couldn't be
+	// read makes it partial too.
+	if dump != nil {
+		if !partial && errs == 0 {
+			if err := dump.close(); err != nil {
+				fatal(fmt.Errorf("%s: %w", dumpFile, err))
+			}
+		} else {
+			warn("%s: not written; the walk is incomplete", dumpFile)
+			dump.abort()
+		}
+	}
+
+	if strict && errs > 0 {
+		exit(rep.Code())
+	}
+	// like a mount that doesn't answer in gmount
+	if stalled || (probe != nil && probe.skipped() > 0) {
+		exit(report.ExitIO)
+	}
+}
+
+// return true if 'fn' is 'dir' or is below it
+func under(fn, dir string) bool {
+	if fn == dir {
+		return true
+	}
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	return strings.HasPrefix(fn, dir)
+}
+
+// return true if 'fn' is one of the args or is below it
+func underAny(args []string, fn string) bool {
+	for _, nm := range args {
+		if under(fn, nm) {
+			return true
+		}
+	}
+	return false
+}
+
+// childrenOf replaces each dir in 'args' by its immediate children; it
+// also returns the dir each child was listed from.
+func childrenOf(args []string) ([]string, map[string]string) {
+	var v []string
+	up := make(map[string]string)
+	for _, nm := range args {
+		fi, err := os.Lstat(nm)
+		if err != nil || !fi.IsDir() {
+			v = append(v, nm)
+			continue
+		}
+
+		ents, err := os.ReadDir(nm)
+		if err != nil {
+			warn("%s", err)
+			continue
+		}
+
+		// "/" becomes "" so that its children are "/x"
+		dn := strings.TrimSuffix(nm, "/")
+		for _, de := range ents {
+			c := dn + "/" + de.Name()
+			up[c] = nm
+			v = append(v, c)
+		}
+	}
+	return v, up
+}
+
+// return true if 'fn' is one of the command line args
+func isArg(args []string, fn string) bool {
+	for _, nm := range args {
+		if nm == fn {
+			return true
+		}
 	}
+	return false
 }
 
 type byLen []string
@@ -0,0 +1,72 @@
+// percent.go - each entry's share of the total and of its parent
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"fmt"
+)
+
+// shares holds the grand total and - with --all and --children - the
+// size of the parent of each entry: the arg it's under.
+type shares struct {
+	total  uint64
+	parent map[string]uint64
+}
+
+func newShares(res []result) *shares {
+	s := &shares{}
+	for i := range res {
+		s.total += res[i].size
+	}
+	return s
+}
+
+// byParent records the parent of each entry in 'res'; up() returns
+// the parent of an entry and ok=false if it has none.
+func (s *shares) byParent(res []result, up func(nm string) (string, bool)) {
+	tot := make(map[string]uint64)
+	of := make(map[string]string)
+	for i := range res {
+		r := &res[i]
+		if p, ok := up(r.name); ok {
+			tot[p] += r.size
+			of[r.name] = p
+		}
+	}
+
+	s.parent = make(map[string]uint64)
+	for nm, p := range of {
+		s.parent[nm] = tot[p]
+	}
+}
+
+// String returns the columns for 'r'; entries without a parent have
+// a blank parent column.
+func (s *shares) String(r *result) string {
+	str := fmt.Sprintf("%6.1f%%", pctOf(r.size, s.total))
+	if s.parent == nil {
+		return str
+	}
+	if p, ok := s.parent[r.name]; ok {
+		return str + fmt.Sprintf(" %6.1f%%", pctOf(r.size, p))
+	}
+	return str + "        "
+}
+
+func pctOf(z, tot uint64) float64 {
+	if tot == 0 {
+		return 0
+	}
+	return 100 * float64(z) / float64(tot)
+}
@@ -0,0 +1,192 @@
+// remote.go - size the objects named by URLs (e.g. s3://bucket/prefix)
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+
+	"go-progs/internal/glob"
+	"go-progs/internal/report"
+	"go-progs/internal/vfs"
+
+	"github.com/opencoff/go-fio"
+)
+
+// urlArgs returns true if every name in 'args' is a URL; URLs and local
+// names can't be mixed. The trailing '/' of each URL is removed.
+func urlArgs(args []string) (bool, error) {
+	var n int
+	for i, nm := range args {
+		if vfs.IsURL(nm) {
+			args[i] = strings.TrimSuffix(nm, "/")
+			n++
+		}
+	}
+
+	if n > 0 && n != len(args) {
+		return false, fmt.Errorf("can't mix URLs and local names")
+	}
+	return n > 0, nil
+}
+
+// walkURLs calls 'fp' for each object at or below the URLs in 'args'
+// that isn't excluded by 'excl'; the globs are relative to the longest
+// of 'roots' that holds an object. The prefixes ("dirs") right below
+// each arg are listed in parallel.
+func walkURLs(args, roots []string, excl *glob.Matcher, rep *report.Reporter, fp func(e *vfs.Entry)) {
+	ch := make(chan *vfs.Entry, 1024)
+	keep := func(e *vfs.Entry) {
+		// symlinks count as nothing; like --symlink-size=zero
+		if e.IsLink() {
+			return
+		}
+		if rel := relURL(roots, e.Name); len(rel) == 0 || !excl.MatchPath(rel) {
+			ch <- e
+		}
+	}
+
+	go func() {
+		var mu sync.Mutex
+		var walks []string
+
+		eachURL(args, func(nm string) {
+			files, dirs, err := vfs.ReadDir(nm)
+			if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+				rep.Error(err)
+				return
+			}
+
+			for _, e := range files {
+				keep(e)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			// an object or a backend that can't list dirs
+			if len(files)+len(dirs) == 0 {
+				walks = append(walks, nm)
+				return
+			}
+			for _, d := range dirs {
+				if rel := relURL(roots, d); len(rel) == 0 || !excl.Match(rel, true) {
+					walks = append(walks, d)
+				}
+			}
+		})
+
+		eachURL(walks, func(nm string) {
+			err := vfs.Walk(nm, func(e *vfs.Entry) error {
+				keep(e)
+				return nil
+			})
+			if err != nil {
+				rep.Error(err)
+			}
+		})
+		close(ch)
+	}()
+
+	for e := range ch {
+		fp(e)
+	}
+}
+
+// eachURL calls 'fp' for each of 'names' on a few workers at once
+func eachURL(names []string, fp func(nm string)) {
+	nw := min(len(names), runtime.NumCPU()*2)
+	ch := make(chan string, nw)
+
+	var wg sync.WaitGroup
+	wg.Add(nw)
+	for i := 0; i < nw; i++ {
+		go func() {
+			for nm := range ch {
+				fp(nm)
+			}
+			wg.Done()
+		}()
+	}
+
+	for _, nm := range names {
+		ch <- nm
+	}
+	close(ch)
+	wg.Wait()
+}
+
+// urlChildren is childrenOf for URLs
+func urlChildren(args []string) ([]string, map[string]string) {
+	var v []string
+	up := make(map[string]string)
+	for _, nm := range args {
+		files, dirs, err := vfs.ReadDir(nm)
+		if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+			warn("%s", err)
+			continue
+		}
+
+		if len(files)+len(dirs) == 0 {
+			v = append(v, nm)
+			continue
+		}
+
+		for _, e := range files {
+			dirs = append(dirs, e.Name)
+		}
+		for _, c := range dirs {
+			up[c] = nm
+			v = append(v, c)
+		}
+	}
+	return v, up
+}
+
+// return the name of 'nm' relative to the longest of 'roots' that holds
+// it; "" if none does.
+func relURL(roots []string, nm string) string {
+	var rel string
+	best := -1
+	for _, r := range roots {
+		if len(r) > best && strings.HasPrefix(nm, r+"/") {
+			rel, best = nm[len(r)+1:], len(r)
+		}
+	}
+	return rel
+}
+
+// return the parent of the URL 'nm'; path.Dir would collapse the '//'
+// after the scheme.
+func parentURL(nm string) string {
+	if i := strings.LastIndexByte(nm, '/'); i > 0 {
+		return nm[:i]
+	}
+	return nm
+}
+
+// entryInfo returns the remote object 'e' as a file
+func entryInfo(e *vfs.Entry) *fio.Info {
+	fi := &fio.Info{
+		Siz:  e.Size,
+		Mod:  0644,
+		Mtim: e.Mtime,
+		Ctim: e.Mtime,
+	}
+	fi.SetPath(e.Name)
+	return fi
+}
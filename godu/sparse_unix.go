@@ -0,0 +1,31 @@
+// sparse_unix.go - allocated size of a file
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+//go:build unix
+
+package main
+
+import (
+	"syscall"
+)
+
+// allocSize returns the bytes allocated on disk for file 'fn'; st_blocks
+// is always in units of 512 bytes.
+func allocSize(fn string) (uint64, bool) {
+	var st syscall.Stat_t
+
+	if err := syscall.Stat(fn, &st); err != nil {
+		return 0, false
+	}
+	return uint64(st.Blocks) * 512, true
+}
@@ -0,0 +1,96 @@
+// stale.go - count just the files that haven't been used in a while
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/opencoff/go-fio"
+)
+
+// the time of a file that --stale-days looks at
+const (
+	staleMtime = "mtime"
+	staleAtime = "atime"
+	staleBoth  = "both"
+)
+
+// staleFilter keeps the files that weren't modified (or accessed) in
+// the last 'days' days; it sums the sizes of all the files it sees -
+// so the report can tell how much of the total could be archived.
+type staleFilter struct {
+	days   int
+	by     string
+	cutoff time.Time
+
+	// stale files and their size; and the size of all the files
+	files uint64
+	size  uint64
+	total uint64
+}
+
+func newStaleFilter(days int, by string) (*staleFilter, error) {
+	switch by {
+	case staleMtime, staleAtime, staleBoth:
+	default:
+		return nil, fmt.Errorf("unknown stale time '%s'; try one of: mtime, atime, both", by)
+	}
+	if days <= 0 {
+		return nil, fmt.Errorf("--stale-days must be positive")
+	}
+
+	s := &staleFilter{
+		days:   days,
+		by:     by,
+		cutoff: time.Now().AddDate(0, 0, -days),
+	}
+	return s, nil
+}
+
+// keep returns true if 'fi' (counted as 'sz' bytes) is stale
+func (s *staleFilter) keep(fi *fio.Info, sz uint64) bool {
+	s.total += sz
+
+	var t time.Time
+	switch s.by {
+	case staleMtime:
+		t = fi.Mtim
+	case staleAtime:
+		t = fi.Atim
+	case staleBoth:
+		t = fi.Mtim
+		if fi.Atim.After(t) {
+			t = fi.Atim
+		}
+	}
+
+	if t.After(s.cutoff) {
+		return false
+	}
+	s.files++
+	s.size += sz
+	return true
+}
+
+// what the stale files are
+func (s *staleFilter) String() string {
+	switch s.by {
+	case staleAtime:
+		return fmt.Sprintf("not accessed in %d days", s.days)
+	case staleBoth:
+		return fmt.Sprintf("not modified or accessed in %d days", s.days)
+	}
+	return fmt.Sprintf("not modified in %d days", s.days)
+}
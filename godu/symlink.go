@@ -0,0 +1,90 @@
+// symlink.go - symlink size accounting
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+
+	"github.com/opencoff/go-fio"
+)
+
+// how symlinks are accounted
+const (
+	linkZero   = "zero"   // symlinks are not counted
+	linkSelf   = "link"   // the size of the symlink itself
+	linkTarget = "target" // the size of the file it points to
+)
+
+// linkAccount implements the symlink accounting policy. With the
+// "target" policy, every inode is counted once; so a file reachable
+// via its own name and via one or more symlinks is counted just once.
+type linkAccount struct {
+	policy string
+	seen   map[string]bool
+}
+
+func newLinkAccount(policy string) (*linkAccount, error) {
+	switch policy {
+	case linkZero, linkSelf, linkTarget:
+	default:
+		return nil, fmt.Errorf("unknown symlink policy '%s'; try one of: %s, %s, %s",
+			policy, linkZero, linkSelf, linkTarget)
+	}
+
+	a := &linkAccount{
+		policy: policy,
+		seen:   make(map[string]bool),
+	}
+	return a, nil
+}
+
+func isSymlink(fi *fio.Info) bool {
+	return fi.Mode()&fs.ModeSymlink > 0
+}
+
+// size returns the number of bytes a symlink contributes
+func (a *linkAccount) size(fi *fio.Info) uint64 {
+	switch a.policy {
+	case linkSelf:
+		return uint64(fi.Size())
+
+	case linkTarget:
+		// links to dirs or dead links don't count
+		ti, err := fio.Stat(fi.Path())
+		if err != nil || !ti.Mode().IsRegular() {
+			return 0
+		}
+		if a.counted(ti) {
+			return 0
+		}
+		return uint64(ti.Size())
+	}
+	return 0
+}
+
+// counted returns true if the inode of 'fi' was already counted and
+// marks it as counted otherwise. Only meaningful for the target policy.
+func (a *linkAccount) counted(fi *fio.Info) bool {
+	if a.policy != linkTarget {
+		return false
+	}
+
+	key := fmt.Sprintf("%d:%d", fi.Dev, fi.Ino)
+	if a.seen[key] {
+		return true
+	}
+	a.seen[key] = true
+	return false
+}
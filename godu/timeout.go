@@ -0,0 +1,109 @@
+// timeout.go - don't let a wedged network mount stall the scan
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/opencoff/go-fio"
+)
+
+// mountProbe checks that the first dir seen on every device answers
+// within a timeout. Dirs on a device that doesn't answer are skipped.
+//
+// The walker does its own syscalls and they can't be interrupted; so
+// a probe that times out is abandoned (its goroutine stays blocked in
+// the kernel until the mount recovers or we exit).
+type mountProbe struct {
+	tmo time.Duration
+
+	sync.Mutex
+	live   map[uint64]bool
+	wedged map[uint64]string
+}
+
+func newMountProbe(tmo time.Duration) *mountProbe {
+	p := &mountProbe{
+		tmo:    tmo,
+		live:   make(map[uint64]bool),
+		wedged: make(map[uint64]string),
+	}
+	return p
+}
+
+// filter returns true if 'fi' lives on a wedged device and must be
+// skipped.
+func (p *mountProbe) filter(fi *fio.Info) bool {
+	if !fi.IsDir() {
+		return false
+	}
+
+	dev := fi.Dev
+	p.Lock()
+	_, bad := p.wedged[dev]
+	ok := p.live[dev]
+	p.Unlock()
+
+	switch {
+	case bad:
+		return true
+	case ok:
+		return false
+	}
+
+	nm := fi.Path()
+	if p.probe(nm) {
+		p.Lock()
+		p.live[dev] = true
+		p.Unlock()
+		return false
+	}
+
+	p.Lock()
+	if _, bad = p.wedged[dev]; !bad {
+		p.wedged[dev] = nm
+		warn("%s: no response in %s; skipping", nm, p.tmo)
+	}
+	p.Unlock()
+	return true
+}
+
+// probe reads one entry of dir 'nm' and returns false if it didn't
+// complete in time. Errors are left for the walker to report.
+func (p *mountProbe) probe(nm string) bool {
+	done := make(chan bool, 1)
+	go func() {
+		if fd, err := os.Open(nm); err == nil {
+			fd.Readdirnames(1)
+			fd.Close()
+		}
+		done <- true
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(p.tmo):
+		return false
+	}
+}
+
+// skipped returns the number of devices that were skipped
+func (p *mountProbe) skipped() int {
+	p.Lock()
+	defer p.Unlock()
+	return len(p.wedged)
+}
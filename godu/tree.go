@@ -0,0 +1,184 @@
+// tree.go - hierarchical size tree and treemap export
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/opencoff/go-fio"
+	"github.com/opencoff/go-utils"
+)
+
+// node is one dir (or file) in the size tree. Size is the aggregate
+// of everything at and below this node.
+type node struct {
+	Name     string  `json:"name"`
+	Size     uint64  `json:"size"`
+	Children []*node `json:"children,omitempty"`
+
+	kids map[string]*node
+}
+
+func newNode(nm string) *node {
+	return &node{
+		Name: nm,
+		kids: make(map[string]*node),
+	}
+}
+
+// add 'sz' bytes to the entry at 'comps' below n; every node along the
+// way gets the size added to its aggregate.
+func (n *node) add(comps []string, sz uint64) {
+	n.Size += sz
+	for _, c := range comps {
+		k, ok := n.kids[c]
+		if !ok {
+			k = newNode(c)
+			n.kids[c] = k
+		}
+		k.Size += sz
+		n = k
+	}
+}
+
+// finish converts the child maps into sorted slices (largest first)
+func (n *node) finish() {
+	n.Children = make([]*node, 0, len(n.kids))
+	for _, k := range n.kids {
+		k.finish()
+		n.Children = append(n.Children, k)
+	}
+	sort.Slice(n.Children, func(i, j int) bool {
+		return n.Children[i].Size > n.Children[j].Size
+	})
+}
+
+// sizeTree tracks one tree per command line arg
+type sizeTree struct {
+	root *node
+}
+
+func newSizeTree() *sizeTree {
+	return &sizeTree{root: newNode("")}
+}
+
+// add the size of entry 'fn' that lives under the command line arg 'arg'
+func (t *sizeTree) add(arg, fn string, sz uint64) {
+	comps := []string{arg}
+	base := strings.TrimSuffix(arg, "/")
+	if rel := strings.Trim(strings.TrimPrefix(fn, base), "/"); len(rel) > 0 {
+		comps = append(comps, strings.Split(rel, "/")...)
+	}
+	t.root.add(comps, sz)
+}
+
+// export writes the tree to 'fn'; the format is chosen by the file
+// extension: .svg and .html produce a treemap, everything else is JSON.
+func (t *sizeTree) export(fn string) error {
+	t.root.finish()
+
+	fd, err := fio.NewSafeFile(fn, fio.OPT_OVERWRITE, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer fd.Abort()
+
+	wr := bufio.NewWriter(fd)
+	switch strings.ToLower(filepath.Ext(fn)) {
+	case ".svg":
+		err = t.svg(wr)
+	case ".html", ".htm":
+		fmt.Fprintf(wr, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>%s</title></head><body>\n", Z)
+		if err = t.svg(wr); err == nil {
+			_, err = wr.WriteString("</body></html>\n")
+		}
+	default:
+		enc := json.NewEncoder(wr)
+		enc.SetIndent("", " ")
+		err = enc.Encode(t.root)
+	}
+
+	if err == nil {
+		err = wr.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+	return fd.Close()
+}
+
+const (
+	_SvgWidth  = 1200
+	_SvgHeight = 800
+
+	// don't draw boxes smaller than this (in px x px)
+	_SvgMinArea = 16
+)
+
+// svg renders a slice-and-dice treemap; alternate levels are split
+// horizontally and vertically.
+func (t *sizeTree) svg(w io.Writer) error {
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="monospace" font-size="10">`+"\n",
+		_SvgWidth, _SvgHeight)
+
+	var draw func(n *node, nm string, x, y, dx, dy float64, depth int)
+	draw = func(n *node, nm string, x, y, dx, dy float64, depth int) {
+		if dx*dy < _SvgMinArea || n.Size == 0 {
+			return
+		}
+
+		// colors cycle by depth
+		hue := (depth * 47) % 360
+		fmt.Fprintf(w, `<g><title>%s %s</title><rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="hsl(%d,60%%,%d%%)" stroke="#333" stroke-width="0.5"/>`,
+			html.EscapeString(nm), utils.HumanizeSize(n.Size), x, y, dx, dy, hue, 80-min(depth*5, 40))
+		if dx > 60 && dy > 12 {
+			fmt.Fprintf(w, `<text x="%.1f" y="%.1f">%s</text>`, x+2, y+10, html.EscapeString(n.Name))
+		}
+		fmt.Fprintf(w, "</g>\n")
+
+		off := 0.0
+		for _, k := range n.Children {
+			f := float64(k.Size) / float64(n.Size)
+			knm := nm + "/" + k.Name
+			if depth%2 == 0 {
+				draw(k, knm, x+off, y, dx*f, dy, depth+1)
+				off += dx * f
+			} else {
+				draw(k, knm, x, y+off, dx, dy*f, depth+1)
+				off += dy * f
+			}
+		}
+	}
+
+	off := 0.0
+	for _, k := range t.root.Children {
+		if t.root.Size == 0 {
+			break
+		}
+		f := float64(k.Size) / float64(t.root.Size)
+		draw(k, k.Name, 0, off, _SvgWidth, _SvgHeight*f, 0)
+		off += _SvgHeight * f
+	}
+
+	_, err := fmt.Fprintf(w, "</svg>\n")
+	return err
+}
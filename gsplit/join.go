@@ -0,0 +1,198 @@
+// join.go - verify and join the parts listed in a manifest
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go-progs/internal/report"
+
+	"github.com/opencoff/go-fio"
+)
+
+// doJoin verifies and concatenates the parts listed in manifest 'mfn'
+// into 'out'; the output is committed only if every part matches.
+func doJoin(mfn, out string, jobs int, force bool) error {
+	parts, err := readManifest(mfn)
+	if err != nil {
+		return err
+	}
+
+	if len(out) == 0 {
+		out = strings.TrimSuffix(mfn, _ManifestSuffix)
+		if out == mfn {
+			return fmt.Errorf("%s: no %s suffix; use --output", mfn, _ManifestSuffix)
+		}
+	}
+
+	var fopt uint32
+	if force {
+		fopt |= fio.OPT_OVERWRITE
+	}
+
+	wfd, err := fio.NewSafeFile(out, fopt, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	AtExit(wfd.Abort)
+	defer wfd.Abort()
+
+	last := &parts[len(parts)-1]
+	if err := wfd.Truncate(last.off + last.size); err != nil {
+		return fmt.Errorf("%s: %w", out, err)
+	}
+
+	var mu sync.Mutex
+	var errs []error
+
+	ch := make(chan *part, jobs)
+	var wg sync.WaitGroup
+	wg.Add(jobs)
+	for i := 0; i < jobs; i++ {
+		go func() {
+			for p := range ch {
+				if err := joinPart(wfd.File, p); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}
+			wg.Done()
+		}()
+	}
+
+	for i := range parts {
+		ch <- &parts[i]
+	}
+	close(ch)
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	return wfd.Close()
+}
+
+// joinPart copies part 'p' to its offset in 'dst' and verifies it
+func joinPart(dst *os.File, p *part) error {
+	fd, err := os.Open(p.name)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	st, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	if st.Size() != p.size {
+		return report.Mismatch(fmt.Errorf("%s: size mismatch: exp %d, saw %d", p.name, p.size, st.Size()))
+	}
+
+	h := hasher()
+	off := p.off
+	err = mapRange(fd, 0, p.size, func(b []byte) error {
+		h.Write(b)
+		if _, err := dst.WriteAt(b, off); err != nil {
+			return err
+		}
+		off += int64(len(b))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", p.name, err)
+	}
+
+	if !bytes.Equal(h.Sum(nil), p.sum) {
+		return report.Mismatch(fmt.Errorf("%s: part modified", p.name))
+	}
+	return nil
+}
+
+// readManifest returns the parts - in order - listed in manifest 'fn'
+func readManifest(fn string) ([]part, error) {
+	fd, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	rd := bufio.NewScanner(fd)
+	if !rd.Scan() {
+		return nil, report.Mismatch(fmt.Errorf("%s: empty manifest", fn))
+	}
+
+	hdr := strings.Fields(rd.Text())
+	if len(hdr) < 3 || hdr[0]+" "+hdr[1] != _ManifestHdr {
+		return nil, report.Mismatch(fmt.Errorf("%s: not a %s manifest", fn, _ManifestHdr))
+	}
+	if len(hdr) > 3 {
+		return nil, report.Mismatch(fmt.Errorf("%s: unsupported manifest options %s", fn, strings.Join(hdr[3:], " ")))
+	}
+
+	dir := filepath.Dir(fn)
+	var parts []part
+	var off int64
+	for n := 2; rd.Scan(); n++ {
+		v := strings.SplitN(rd.Text(), "|", 3)
+		if len(v) != 3 {
+			return nil, report.Mismatch(fmt.Errorf("%s: %d: malformed line", fn, n))
+		}
+
+		sum, err := hex.DecodeString(v[0])
+		if err != nil {
+			return nil, report.Mismatch(fmt.Errorf("%s: %d: malformed checksum", fn, n))
+		}
+
+		sz, err := strconv.ParseInt(v[1], 10, 64)
+		if err != nil || sz < 0 {
+			return nil, report.Mismatch(fmt.Errorf("%s: %d: malformed size", fn, n))
+		}
+
+		nm := v[2]
+		if len(nm) > 0 && nm[0] == '"' {
+			if nm, err = strconv.Unquote(nm); err != nil {
+				return nil, report.Mismatch(fmt.Errorf("%s: %d: malformed name", fn, n))
+			}
+		}
+		if !filepath.IsAbs(nm) {
+			nm = filepath.Join(dir, nm)
+		}
+
+		parts = append(parts, part{
+			off:  off,
+			size: sz,
+			name: nm,
+			sum:  sum,
+		})
+		off += sz
+	}
+
+	if err := rd.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	if len(parts) == 0 {
+		return nil, report.Mismatch(fmt.Errorf("%s: no parts", fn))
+	}
+	return parts, nil
+}
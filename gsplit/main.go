@@ -0,0 +1,141 @@
+// main.go - split files into parts and join them with integrity
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"fmt"
+	"hash"
+	"os"
+	"path"
+	"runtime"
+
+	"go-progs/internal/report"
+
+	"github.com/opencoff/go-utils"
+	flag "github.com/opencoff/pflag"
+	"github.com/zeebo/blake3"
+)
+
+var Z string = path.Base(os.Args[0])
+
+// suffix of the manifest of parts
+const _ManifestSuffix = ".ghash"
+
+// Our checksum is blake3 with an all-zero key - the same as ghash's
+// "blake3"; so 'ghash -v' can verify the parts.
+const _ManifestHdr = "#!ghash blake3"
+
+// we map files in windows of this size
+const _MmapWindow int64 = 256 * 1024 * 1024
+
+func main() {
+	var version, force, jsonErrs bool
+	var partSize, prefix, join, output string
+	var jobs int
+
+	flag.BoolVarP(&version, "version", "", false, "Show version info and quit")
+	flag.StringVarP(&partSize, "size", "s", "", "Split into parts of `SIZE` bytes (e.g. 4G)")
+	flag.StringVarP(&prefix, "prefix", "p", "", "Name the parts `P`.000, P.001 .. [input file]")
+	flag.StringVarP(&join, "join", "J", "", "Join the parts listed in manifest `M`")
+	flag.StringVarP(&output, "output", "o", "", "Write the joined file to `F` [manifest without .ghash]")
+	flag.IntVarP(&jobs, "jobs", "j", runtime.NumCPU(), "Split or join up to `N` parts in parallel")
+	flag.BoolVarP(&force, "force", "f", false, "Overwrite existing parts or output file")
+	flag.BoolVarP(&jsonErrs, "json-errors", "", false, "Write errors to stderr as JSON records")
+
+	flag.Usage = func() {
+		fmt.Printf(
+			`%s - split files into parts and join them with integrity
+
+Usage: %s [options] -s SIZE file
+       %s [options] --join MANIFEST
+
+The first form splits the file into parts of SIZE bytes - P.000, P.001
+and so on - in parallel, and writes their blake3 digests to the
+manifest P.ghash. The manifest is in ghash(1) format; so 'ghash -v'
+verifies the parts (from the dir they're in).
+
+The second form joins the parts listed in MANIFEST (relative to the
+dir it's in); each part is verified as it's copied and the output is
+only created if every part matches.
+
+A part or manifest that doesn't match exits with 1; other errors exit
+with 2 if a file vanished, 3 on I/O errors and 4 if permission was
+denied; the largest applies. Bad options and arguments exit with 64.
+With --json-errors, each error is a JSON record: {"prog", "kind", "op",
+"path", "error"}.
+
+Options:
+`, Z, Z, Z)
+		flag.PrintDefaults()
+		os.Stdout.Sync()
+		os.Exit(0)
+	}
+
+	flag.Parse()
+	if version {
+		fmt.Printf("%s - %s [%s]\n", Z, ProductVersion, RepoVersion)
+		os.Exit(0)
+	}
+
+	rep := report.New(os.Args[0], jsonErrs)
+
+	if jobs <= 0 {
+		Die("--jobs must be positive")
+	}
+
+	if len(join) > 0 {
+		if len(partSize) > 0 || len(prefix) > 0 {
+			Die("--join can't be used with --size or --prefix")
+		}
+		rep.Error(doJoin(join, output, jobs, force))
+		Exit(rep.Code())
+	}
+
+	args := flag.Args()
+	if len(args) != 1 {
+		Die("Need exactly one file to split. Try %s --help", Z)
+	}
+
+	if len(partSize) == 0 {
+		Die("--size is needed to split")
+	}
+
+	sz, err := utils.ParseSize(partSize)
+	if err != nil || sz == 0 {
+		Die("invalid part size '%s'", partSize)
+	}
+
+	fn := args[0]
+	if len(prefix) == 0 {
+		prefix = fn
+	}
+
+	rep.Error(doSplit(fn, prefix, int64(sz), jobs, force))
+	Exit(rep.Code())
+}
+
+// create a new cryptographic hash func
+func hasher() hash.Hash {
+	var zeroes [32]byte
+
+	h, err := blake3.NewKeyed(zeroes[:])
+	if err != nil {
+		panic(fmt.Sprintf("blake3: %s", err))
+	}
+	return h
+}
+
+// This will be filled in by "build"
+var RepoVersion string = "UNDEFINED"
+var ProductVersion string = "UNDEFINED"
@@ -0,0 +1,195 @@
+// split.go - split a file into parts in parallel
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/opencoff/go-fio"
+	"github.com/opencoff/go-mmap"
+)
+
+// a part of the input at 'off'
+type part struct {
+	off  int64
+	size int64
+	name string
+	sum  []byte
+}
+
+// doSplit splits 'fn' into parts of 'psz' bytes named 'prefix'.NNN
+// and writes the manifest 'prefix'.ghash. Parts are only left behind
+// if all of them and the manifest are written.
+func doSplit(fn, prefix string, psz int64, jobs int, force bool) error {
+	fd, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	st, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	if !st.Mode().IsRegular() {
+		return fmt.Errorf("%s: not a file", fn)
+	}
+
+	fsz := st.Size()
+	n := int((fsz + psz - 1) / psz)
+	if n == 0 {
+		return fmt.Errorf("%s: empty file", fn)
+	}
+
+	// at least 3 digits in the part suffix
+	width := max(len(fmt.Sprintf("%d", n-1)), 3)
+	parts := make([]part, n)
+	for i := range parts {
+		off := int64(i) * psz
+		parts[i] = part{
+			off:  off,
+			size: min(psz, fsz-off),
+			name: fmt.Sprintf("%s.%0*d", prefix, width, i),
+		}
+	}
+
+	var fopt uint32
+	if force {
+		fopt |= fio.OPT_OVERWRITE
+	}
+
+	// the manifest is created first so that we don't write any part
+	// if it already exists.
+	mfn := prefix + _ManifestSuffix
+	mf, err := fio.NewSafeFile(mfn, fopt, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	AtExit(mf.Abort)
+	defer mf.Abort()
+
+	var done []string
+	var mu sync.Mutex
+	var errs []error
+
+	ch := make(chan *part, jobs)
+	var wg sync.WaitGroup
+	wg.Add(jobs)
+	for i := 0; i < jobs; i++ {
+		go func() {
+			for p := range ch {
+				err := writePart(fd, p, fopt, st.Mode().Perm())
+
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, err)
+				} else {
+					done = append(done, p.name)
+				}
+				mu.Unlock()
+			}
+			wg.Done()
+		}()
+	}
+
+	for i := range parts {
+		ch <- &parts[i]
+	}
+	close(ch)
+	wg.Wait()
+
+	if err = errors.Join(errs...); err == nil {
+		err = writeManifest(mf, parts)
+	}
+
+	if err != nil {
+		for _, nm := range done {
+			os.Remove(nm)
+		}
+		return err
+	}
+	return nil
+}
+
+// writePart copies part 'p' of 'src' to its file (with mode 'perm')
+// and computes the digest
+func writePart(src *os.File, p *part, fopt uint32, perm os.FileMode) error {
+	wfd, err := fio.NewSafeFile(p.name, fopt, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	defer wfd.Abort()
+
+	h := hasher()
+	wr := io.MultiWriter(wfd, h)
+	if err := mapRange(src, p.off, p.size, func(b []byte) error {
+		_, err := wr.Write(b)
+		return err
+	}); err != nil {
+		return fmt.Errorf("%s: %w", p.name, err)
+	}
+
+	p.sum = h.Sum(nil)
+	return wfd.Close()
+}
+
+// write the manifest of parts; the names are relative to its dir
+func writeManifest(mf *fio.SafeFile, parts []part) error {
+	wr := bufio.NewWriter(mf)
+	fmt.Fprintf(wr, "%s %s\n", _ManifestHdr, ProductVersion)
+	for i := range parts {
+		p := &parts[i]
+		fmt.Fprintf(wr, "%x|%d|%s\n", p.sum, p.size, filepath.Base(p.name))
+	}
+	if err := wr.Flush(); err != nil {
+		return err
+	}
+	return mf.Close()
+}
+
+// mapRange calls 'fp' for successive mmap windows of 'n' bytes of 'fd'
+// starting at 'off'
+func mapRange(fd *os.File, off, n int64, fp func(b []byte) error) error {
+	pgsz := int64(os.Getpagesize())
+	mm := mmap.New(fd)
+
+	for n > 0 {
+		// mmap offsets must be page aligned
+		aoff := off &^ (pgsz - 1)
+		delta := off - aoff
+		sz := min(n+delta, _MmapWindow)
+
+		p, err := mm.Map(sz, aoff, mmap.PROT_READ, mmap.F_READAHEAD)
+		if err != nil {
+			return err
+		}
+
+		err = fp(p.Bytes()[delta:])
+		p.Unmap()
+		if err != nil {
+			return err
+		}
+
+		z := sz - delta
+		off += z
+		n -= z
+	}
+	return nil
+}
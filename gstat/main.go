@@ -0,0 +1,268 @@
+// main.go - parallel stat aggregator
+//
+// (c) 2016 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"math/bits"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-progs/internal/glob"
+	"go-progs/internal/report"
+
+	"github.com/opencoff/go-fio"
+	"github.com/opencoff/go-fio/walk"
+	"github.com/opencoff/go-utils"
+	flag "github.com/opencoff/pflag"
+)
+
+var Z string = path.Base(os.Args[0])
+
+// file types we count
+var typeNames = []string{"file", "dir", "symlink", "device", "fifo", "socket", "other"}
+
+// stats aggregates everything we learn from the walk
+type stats struct {
+	types map[string]uint64
+	bytes uint64
+
+	// permission anomalies
+	worldWritable []string
+	setuid        []string
+	setgid        []string
+
+	// oldest and newest files by mtime
+	oldest, newest *fio.Info
+
+	// size histogram of regular files; bucket i holds files
+	// with size in [2^(i-1), 2^i)
+	hist [65]uint64
+}
+
+func newStats() *stats {
+	return &stats{
+		types: make(map[string]uint64),
+	}
+}
+
+func (s *stats) add(fi *fio.Info) {
+	m := fi.Mode()
+	s.types[typeOf(m)]++
+
+	switch {
+	case m.IsRegular():
+		sz := uint64(fi.Size())
+		s.bytes += sz
+		s.hist[bits.Len64(sz)]++
+
+		t := fi.ModTime()
+		if s.oldest == nil || t.Before(s.oldest.ModTime()) {
+			s.oldest = fi
+		}
+		if s.newest == nil || t.After(s.newest.ModTime()) {
+			s.newest = fi
+		}
+
+	case m&fs.ModeSymlink > 0:
+		// symlink perms are meaningless
+		return
+	}
+
+	// world writable dirs with the sticky bit (/tmp) are fine
+	if m.Perm()&0002 > 0 && !(m.IsDir() && m&fs.ModeSticky > 0) {
+		s.worldWritable = append(s.worldWritable, fi.Path())
+	}
+	if m&fs.ModeSetuid > 0 {
+		s.setuid = append(s.setuid, fi.Path())
+	}
+	if m&fs.ModeSetgid > 0 && !m.IsDir() {
+		s.setgid = append(s.setgid, fi.Path())
+	}
+}
+
+func typeOf(m fs.FileMode) string {
+	switch {
+	case m.IsRegular():
+		return "file"
+	case m.IsDir():
+		return "dir"
+	case m&fs.ModeSymlink > 0:
+		return "symlink"
+	case m&fs.ModeDevice > 0:
+		return "device"
+	case m&fs.ModeNamedPipe > 0:
+		return "fifo"
+	case m&fs.ModeSocket > 0:
+		return "socket"
+	}
+	return "other"
+}
+
+func main() {
+	var version, symlinks, onefs, human bool
+	var nlist int
+	var excludes, includes []string
+	var fold, jsonErrs bool
+
+	flag.BoolVarP(&version, "version", "", false, "Show version info and quit")
+	flag.BoolVarP(&symlinks, "follow-symlinks", "L", false, "Follow symlinks")
+	flag.BoolVarP(&onefs, "single-filesystem", "x", false, "Don't cross mount points")
+	flag.BoolVarP(&human, "human-size", "h", false, "Show size in human readable form")
+	flag.IntVarP(&nlist, "list", "n", 10, "List at most `N` names for each permission anomaly (-1 for all)")
+	flag.StringSliceVarP(&excludes, "exclude", "", nil, "Exclude entries matching glob `G`")
+	flag.StringSliceVarP(&includes, "include", "", nil, "Re-include entries matching glob `G` that were excluded")
+	flag.BoolVarP(&fold, "ignore-case", "", false, "Match --exclude and --include globs ignoring case")
+	flag.BoolVarP(&jsonErrs, "json-errors", "", false, "Write errors to stderr as JSON records")
+
+	flag.Usage = func() {
+		fmt.Printf(
+			`%s - file system metadata statistics (parallel edition)
+
+Walks one or more dir trees and prints the counts by file type, the
+permission anomalies (world writable, setuid, setgid), the oldest and
+newest files and a histogram of file sizes.
+
+The stats are printed even if some entries couldn't be read; errors
+exit with 2 if a file vanished, 3 on I/O errors and 4 if permission was
+denied; the largest applies. Bad options and arguments exit with 64.
+With --json-errors, each error is a JSON record: {"prog", "kind", "op",
+"path", "error"}.
+
+Usage: %s [options] dir [dir...]
+
+Options:
+`, Z, Z)
+		flag.PrintDefaults()
+		os.Stdout.Sync()
+		os.Exit(0)
+	}
+
+	flag.Parse()
+	if version {
+		fmt.Printf("%s - %s [%s]\n", Z, ProductVersion, RepoVersion)
+		os.Exit(0)
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		die("Insufficient args. Try %s --help", Z)
+	}
+
+	size := func(z uint64) string {
+		return fmt.Sprintf("%d", z)
+	}
+	if human {
+		size = utils.HumanizeSize
+	}
+
+	opt := walk.Options{
+		FollowSymlinks: symlinks,
+		OneFS:          onefs,
+		Type:           walk.ALL,
+	}
+
+	excl, err := glob.Excludes(excludes, includes, fold)
+	if err != nil {
+		die("%s", err)
+	}
+	opt.Filter = excl.Filter(args)
+
+	ch, ech := walk.Walk(args, opt)
+
+	// harvest errors
+	rep := report.New(os.Args[0], jsonErrs)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		for e := range ech {
+			rep.Error(e)
+		}
+		wg.Done()
+	}()
+
+	st := newStats()
+	for fi := range ch {
+		st.add(fi)
+	}
+	wg.Wait()
+
+	st.print(size, nlist)
+	os.Exit(rep.Code())
+}
+
+func (s *stats) print(size func(uint64) string, nlist int) {
+	fmt.Printf("Types:\n")
+	for _, t := range typeNames {
+		if n := s.types[t]; n > 0 {
+			fmt.Printf("  %-10s %12d\n", t, n)
+		}
+	}
+	fmt.Printf("  %-10s %12s\n", "bytes", size(s.bytes))
+
+	if s.oldest != nil {
+		fmt.Printf("\nOldest: %s %s\nNewest: %s %s\n",
+			s.oldest.ModTime().Format(time.RFC3339), s.oldest.Path(),
+			s.newest.ModTime().Format(time.RFC3339), s.newest.Path())
+	}
+
+	anomaly("World writable", s.worldWritable, nlist)
+	anomaly("Setuid", s.setuid, nlist)
+	anomaly("Setgid", s.setgid, nlist)
+
+	lo, hi := 0, len(s.hist)-1
+	for lo < len(s.hist) && s.hist[lo] == 0 {
+		lo++
+	}
+	for hi >= 0 && s.hist[hi] == 0 {
+		hi--
+	}
+	if lo > hi {
+		return
+	}
+
+	fmt.Printf("\nFile sizes:\n")
+	for i := lo; i <= hi; i++ {
+		var from, to uint64
+		if i > 0 {
+			from = 1 << (i - 1)
+			to = (1 << i) - 1
+		}
+		fmt.Printf("  %12s - %-12s %12d\n", size(from), size(to), s.hist[i])
+	}
+}
+
+func anomaly(title string, v []string, nlist int) {
+	if len(v) == 0 {
+		return
+	}
+
+	sort.Strings(v)
+	fmt.Printf("\n%s: %d\n", title, len(v))
+	if nlist >= 0 && len(v) > nlist {
+		v = v[:nlist]
+	}
+	if len(v) > 0 {
+		fmt.Printf("  %s\n", strings.Join(v, "\n  "))
+	}
+}
+
+// This will be filled in by "build"
+var RepoVersion string = "UNDEFINED"
+var ProductVersion string = "UNDEFINED"
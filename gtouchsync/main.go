@@ -0,0 +1,354 @@
+// main.go -- apply file metadata recorded in a ghash manifest
+//
+// (c) 2023 Sudhi Herle <sudhi@herle.net>
+//
+// Licensing Terms: GPLv2
+//
+// If you need a commercial license for this work, please contact
+// the author.
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-progs/internal/manifest"
+	"go-progs/internal/report"
+
+	flag "github.com/opencoff/pflag"
+)
+
+// ghash output file magic
+const MAGIC = "#!ghash"
+
+// header token denoting that each entry has a metadata field
+const _MetaOpt = "meta"
+
+// basename of argv[0]
+var Z string = path.Base(os.Args[0])
+
+// options controlling what is applied
+type options struct {
+	root    string
+	strip   string
+	dryRun  bool
+	verbose bool
+	force   bool
+	owner   bool
+	mode    bool
+	mtime   bool
+}
+
+// entry is one manifest line
+type entry struct {
+	name  string
+	size  int64
+	mode  uint32
+	uid   int
+	gid   int
+	mtime time.Time
+
+	// true for special files (fifos, sockets, devices)
+	special bool
+}
+
+func main() {
+	var ver, help, zeroTerm, jsonErrs bool
+	var noOwner, noMode, noMtime bool
+	var opt options
+
+	mf := flag.NewFlagSet(Z, flag.ExitOnError)
+	mf.BoolVarP(&ver, "version", "V", false, "Show version info and exit")
+	mf.BoolVarP(&help, "help", "h", false, "Show help info exit")
+	mf.BoolVarP(&opt.dryRun, "dry-run", "n", false, "Show what would be done")
+	mf.BoolVarP(&opt.verbose, "verbose", "v", false, "Show each change")
+	mf.BoolVarP(&opt.force, "force", "f", false, "Apply even if the file size differs from the manifest")
+	mf.BoolVarP(&noOwner, "no-owner", "", false, "Don't change file ownership")
+	mf.BoolVarP(&noMode, "no-mode", "", false, "Don't change file mode")
+	mf.BoolVarP(&noMtime, "no-mtime", "", false, "Don't change file mtime")
+	mf.StringVarP(&opt.root, "root", "C", "", "Apply to files relative to dir 'D'")
+	mf.StringVarP(&opt.strip, "strip-prefix", "p", "", "Strip prefix 'P' from manifest names")
+	mf.BoolVarP(&zeroTerm, "zero-terminated", "z", false, "Manifest records end in NUL instead of newline")
+	mf.BoolVarP(&jsonErrs, "json-errors", "", false, "Write errors to stderr as JSON records")
+	mf.Parse(os.Args[1:])
+
+	if ver {
+		fmt.Printf("%s - %s [%s]\n", Z, ProductVersion, RepoVersion)
+		Exit(0)
+	}
+
+	if help {
+		usage(0)
+	}
+
+	args := mf.Args()
+	if len(args) < 1 {
+		Die("Insufficient arguments. Try '%s -h'", Z)
+	}
+
+	opt.owner = !noOwner
+	opt.mode = !noMode
+	opt.mtime = !noMtime
+
+	rep := report.New(os.Args[0], jsonErrs)
+	Exit(apply(args[0], zeroTerm, &opt, rep))
+}
+
+// apply the metadata in manifest 'nm' and return the exit code; its
+// records end in NUL if 'zeroTerm' is true.
+func apply(nm string, zeroTerm bool, opt *options, rep *report.Reporter) int {
+	var fd io.ReadCloser = os.Stdin
+	if nm != "-" {
+		fx, err := os.Open(nm)
+		if err != nil {
+			rep.Error(fmt.Errorf("can't open '%s': %w", nm, err))
+			return rep.Code()
+		}
+		fd = fx
+	}
+	defer fd.Close()
+
+	in, err := manifest.Decompress(fd)
+	if err != nil {
+		rep.Error(fmt.Errorf("%s: %w", nm, err))
+		return rep.Code()
+	}
+
+	rd := bufio.NewScanner(in)
+	if zeroTerm {
+		rd.Split(manifest.SplitNul)
+	}
+	if ok := rd.Scan(); !ok {
+		rep.Error(report.Mismatch(fmt.Errorf("%s: possibly corrupt; can't read first line", nm)))
+		return rep.Code()
+	}
+
+	// the header of the other kind of manifest is the whole file
+	if hdr := rd.Text(); strings.ContainsAny(hdr, "\n\x00") {
+		if zeroTerm {
+			Die("%s: records end in newline; try without -z", nm)
+		}
+		Die("%s: records end in NUL; try with -z", nm)
+	}
+
+	subs := strings.Fields(rd.Text())
+	if len(subs) < 3 || subs[0] != MAGIC {
+		rep.Error(report.Mismatch(fmt.Errorf("%s: Not a ghash file", nm)))
+		return rep.Code()
+	}
+
+	var meta bool
+	for _, o := range subs[3:] {
+		if o == _MetaOpt {
+			meta = true
+		}
+	}
+	if !meta {
+		Die("%s: no metadata; generate the manifest with 'ghash --with-metadata'", nm)
+	}
+
+	for num := 2; rd.Scan(); num++ {
+		line := rd.Text()
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		e, err := parseLine(line)
+		if err != nil {
+			rep.Error(report.Mismatch(fmt.Errorf("%s: %d: %w", nm, num, err)))
+			continue
+		}
+
+		rep.Error(e.apply(opt))
+	}
+
+	// e.g. a truncated compressed manifest
+	if err := rd.Err(); err != nil {
+		rep.Error(report.Mismatch(fmt.Errorf("%s: %w", nm, err)))
+	}
+	return rep.Code()
+}
+
+// parse a line of the form: csum|size|mode:uid:gid:mtime|name
+func parseLine(line string) (*entry, error) {
+	f := strings.SplitN(strings.TrimSpace(line), "|", 4)
+	if len(f) != 4 {
+		return nil, fmt.Errorf("malformed line")
+	}
+
+	sz, err := strconv.ParseInt(f[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed size: %w", err)
+	}
+
+	m := strings.Split(f[2], ":")
+	if len(m) != 4 {
+		return nil, fmt.Errorf("malformed metadata '%s'", f[2])
+	}
+
+	mode, err := strconv.ParseUint(m[0], 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("malformed mode: %w", err)
+	}
+	uid, err := strconv.Atoi(m[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed uid: %w", err)
+	}
+	gid, err := strconv.Atoi(m[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed gid: %w", err)
+	}
+	ns, err := strconv.ParseInt(m[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed mtime: %w", err)
+	}
+
+	fn := f[3]
+	if len(fn) == 0 {
+		return nil, fmt.Errorf("missing filename")
+	}
+	if fn[0] == '"' {
+		if fn, err = strconv.Unquote(fn); err != nil {
+			return nil, fmt.Errorf("malformed filename: %w", err)
+		}
+	}
+
+	e := &entry{
+		name:  fn,
+		size:  sz,
+		mode:  uint32(mode),
+		uid:   uid,
+		gid:   gid,
+		mtime: time.Unix(0, ns),
+
+		// special files have a type marker instead of a checksum
+		special: strings.HasPrefix(f[0], "@"),
+	}
+	return e, nil
+}
+
+// apply the metadata of this entry to the restored file
+func (e *entry) apply(opt *options) error {
+	fn := e.name
+	if len(opt.strip) > 0 {
+		fn = strings.TrimPrefix(fn, opt.strip)
+	}
+	if len(opt.root) > 0 {
+		fn = filepath.Join(opt.root, fn)
+	}
+
+	fi, err := os.Lstat(fn)
+	if err != nil {
+		return err
+	}
+
+	if e.special {
+		if fi.Mode().IsRegular() || fi.IsDir() || fi.Mode()&fs.ModeSymlink > 0 {
+			return report.Mismatch(fmt.Errorf("%s: not a special file", fn))
+		}
+	} else if !fi.Mode().IsRegular() {
+		return report.Mismatch(fmt.Errorf("%s: not a file", fn))
+	} else if fi.Size() != e.size && !opt.force {
+		return report.Mismatch(fmt.Errorf("%s: size mismatch: exp %d, saw %d; skipping ..", fn, e.size, fi.Size()))
+	}
+
+	if opt.verbose || opt.dryRun {
+		fmt.Printf("%s: mode %o, owner %d:%d, mtime %s\n", fn, e.mode, e.uid, e.gid,
+			e.mtime.Format(time.RFC3339Nano))
+	}
+
+	if opt.dryRun {
+		return nil
+	}
+
+	// chown can clear the setuid/setgid bits; so it must go first.
+	if opt.owner {
+		if err = os.Lchown(fn, e.uid, e.gid); err != nil {
+			return err
+		}
+	}
+
+	if opt.mode {
+		if err = os.Chmod(fn, goMode(e.mode)); err != nil {
+			return err
+		}
+	}
+
+	// a zero atime is left unchanged
+	if opt.mtime {
+		if err = os.Chtimes(fn, time.Time{}, e.mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// goMode converts a unix mode to go's FileMode
+func goMode(v uint32) fs.FileMode {
+	m := fs.FileMode(v & 0777)
+	if v&04000 > 0 {
+		m |= fs.ModeSetuid
+	}
+	if v&02000 > 0 {
+		m |= fs.ModeSetgid
+	}
+	if v&01000 > 0 {
+		m |= fs.ModeSticky
+	}
+	return m
+}
+
+func usage(c int) {
+	x := fmt.Sprintf(`%s applies the file metadata recorded in a ghash manifest
+
+The manifest must be generated with 'ghash --with-metadata'. The recorded
+mode, ownership and mtime are applied to each file named in the manifest
+(typically a restored copy of the original tree). Files whose size differs
+from the manifest are skipped unless --force is given.
+
+Usage: %s [options] manifest
+
+Options:
+  -h, --help             Show help and exit
+  -V, --version          Show version info and exit
+  -n, --dry-run          Show what would be done
+  -v, --verbose          Show each change
+  -f, --force            Apply even if the file size differs
+  -C, --root=D           Apply to files relative to dir 'D'
+  -p, --strip-prefix=P   Strip prefix 'P' from manifest names
+  --no-owner             Don't change file ownership
+  --no-mode              Don't change file mode
+  --no-mtime             Don't change file mtime
+  -z, --zero-terminated  Manifest records end in NUL instead of newline
+                         (as written by 'ghash -z')
+  --json-errors          Write errors to stderr as JSON records
+
+The manifest can be compressed with gzip or zstd (ghash --compress).
+
+A file or record that doesn't match the manifest exits with 1; other
+errors exit with 2 if a file vanished, 3 on I/O errors and 4 if
+permission was denied; the largest applies. Bad options and arguments
+exit with 64. With --json-errors, each error is a JSON record: {"prog",
+"kind", "op", "path", "error"}.
+`, Z, Z)
+
+	os.Stdout.Write([]byte(x))
+	Exit(c)
+}
+
+// This will be filled in by "build"
+var RepoVersion string = "UNDEFINED"
+var ProductVersion string = "UNDEFINED"
@@ -0,0 +1,105 @@
+// charset.go - decode the text column of a hexdump
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// a textLane appends the text column for the bytes of one hexdump
+// line 'b' to 'p'; unprintable bytes or characters are shown as '.'.
+type textLane func(p, b []byte) []byte
+
+var charsets = map[string]textLane{
+	"ascii":   asciiLane,
+	"latin1":  latin1Lane,
+	"utf8":    utf8Lane,
+	"utf16le": utf16leLane,
+}
+
+func parseCharset(s string) (textLane, error) {
+	if l, ok := charsets[strings.ReplaceAll(strings.ToLower(s), "-", "")]; ok {
+		return l, nil
+	}
+	return nil, fmt.Errorf("unknown charset '%s'; try one of: ascii, latin1, utf8, utf16le", s)
+}
+
+// one column per byte; only printable ASCII is shown
+func asciiLane(p, b []byte) []byte {
+	for _, c := range b {
+		if c < 32 || c > 126 {
+			c = '.'
+		}
+		p = append(p, c)
+	}
+	return p
+}
+
+// one column per byte; ISO 8859-1 maps each byte to the code point
+// of the same value.
+func latin1Lane(p, b []byte) []byte {
+	for _, c := range b {
+		p = appendRune(p, rune(c))
+	}
+	return p
+}
+
+// one column per byte: a character is shown in the column of its first
+// byte and the rest of its bytes are blank. Characters split across
+// two lines are shown as '.' on both.
+func utf8Lane(p, b []byte) []byte {
+	for len(b) > 0 {
+		r, n := utf8.DecodeRune(b)
+		if r == utf8.RuneError && n <= 1 {
+			p = append(p, '.')
+			b = b[1:]
+			continue
+		}
+
+		p = appendRune(p, r)
+		p = append(p, "   "[:n-1]...)
+		b = b[n:]
+	}
+	return p
+}
+
+// one column per 16-bit code unit; a surrogate pair is shown in the
+// column of its first unit and the second is blank.
+func utf16leLane(p, b []byte) []byte {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		u = append(u, uint16(b[i])|uint16(b[i+1])<<8)
+	}
+
+	for i := 0; i < len(u); i++ {
+		r := rune(u[i])
+		if utf16.IsSurrogate(r) && i+1 < len(u) {
+			if rr := utf16.DecodeRune(r, rune(u[i+1])); rr != unicode.ReplacementChar {
+				p = appendRune(p, rr)
+				p = append(p, ' ')
+				i++
+				continue
+			}
+		}
+		p = appendRune(p, r)
+	}
+
+	// an odd trailing byte
+	if len(b)%2 != 0 {
+		p = append(p, '.')
+	}
+	return p
+}
+
+func appendRune(p []byte, r rune) []byte {
+	if !unicode.IsPrint(r) || utf16.IsSurrogate(r) {
+		return append(p, '.')
+	}
+	return utf8.AppendRune(p, r)
+}
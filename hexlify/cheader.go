@@ -0,0 +1,119 @@
+// cheader.go - a C header and declarations for the C array output
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencoff/go-fio"
+)
+
+// cDecl describes the array the C output defines and the header that
+// declares it; with it, the output is a complete .c file.
+type cDecl struct {
+	name   string
+	header string
+	guard  string
+}
+
+// newCDecl returns the declarations of array 'name' (derived from the
+// input if empty) in header 'hdr'; the include guard is derived from
+// the header name unless given.
+func newCDecl(name, hdr, guard, input string) (*cDecl, error) {
+	if len(name) == 0 {
+		name = cIdent(strings.TrimSuffix(filepath.Base(input), filepath.Ext(input)))
+	} else if cIdent(name) != name {
+		return nil, fmt.Errorf("'%s' isn't a valid C identifier", name)
+	}
+
+	if len(guard) == 0 {
+		guard = strings.ToUpper(cIdent(filepath.Base(hdr))) + "_"
+	} else if cIdent(guard) != guard {
+		return nil, fmt.Errorf("'%s' isn't a valid header guard", guard)
+	}
+
+	d := &cDecl{
+		name:   name,
+		header: hdr,
+		guard:  guard,
+	}
+	return d, nil
+}
+
+// cIdent turns 's' into a C identifier
+func cIdent(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 || (s[0] >= '0' && s[0] <= '9') {
+		return "data_" + string(b)
+	}
+	return string(b)
+}
+
+// the name of the length macro
+func (d *cDecl) lenMacro() string {
+	return strings.ToUpper(d.name) + "_LEN"
+}
+
+// prologue of the .c file
+func (d *cDecl) prologue(input string) string {
+	return fmt.Sprintf("/* generated by %s from %s; don't edit */\n#include \"%s\"\n\nconst unsigned char %s[%s] = ",
+		Z, input, filepath.Base(d.header), d.name, d.lenMacro())
+}
+
+// epilogue of the .c file
+func (d *cDecl) epilogue() string {
+	return fmt.Sprintf(";\n\nconst size_t %s_size = sizeof(%s);\n", d.name, d.name)
+}
+
+// empty is the .c file of an empty input; C has no zero-length arrays,
+// so the array is one byte long but its length and size are 0.
+func (d *cDecl) empty(input string) string {
+	return fmt.Sprintf("/* generated by %s from %s; don't edit */\n#include \"%s\"\n\n/* the input is empty; %s and %s_size are 0 */\nconst unsigned char %s[1] = {\n\t  0\n};\n\nconst size_t %s_size = 0;\n",
+		Z, input, filepath.Base(d.header), d.lenMacro(), d.name, d.name, d.name)
+}
+
+// writeHeader writes the header for an array of 'n' bytes
+func (d *cDecl) writeHeader(input string, n int64) error {
+	fd, err := fio.NewSafeFile(d.header, 0, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("can't create %s: %w", d.header, err)
+	}
+	defer fd.Abort()
+
+	// the array of an empty input is one byte long; see empty()
+	size := d.lenMacro()
+	if n == 0 {
+		size = "1"
+	}
+
+	_, err = fmt.Fprintf(fd, `/* generated by %s from %s; don't edit */
+#ifndef %s
+#define %s
+
+#include <stddef.h>
+
+#define %s %d
+
+extern const unsigned char %s[%s];
+extern const size_t %s_size;
+
+#endif /* %s */
+`, Z, input, d.guard, d.guard, d.lenMacro(), n, d.name, size, d.name, d.guard)
+	if err != nil {
+		return fmt.Errorf("%s: %w", d.header, err)
+	}
+	return fd.Close()
+}
@@ -0,0 +1,331 @@
+// delta.go - a byte patch between two files and applying it
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"go-progs/internal/report"
+)
+
+// The patch is a text file; after the header and the sizes of the old
+// and new file, each line is a record:
+//
+//	OFFSET OLD NEW
+//
+// OLD are the hex bytes at OFFSET of the old file and NEW are the bytes
+// that replace them; '-' is none. OLD and NEW are the same length
+// except at the end: a longer new file appends (OLD is '-' and OFFSET
+// is the size of the old file) and a shorter one truncates (NEW is
+// '-'). The records are in order of their offsets.
+const _DeltaHdr = "#!hexlify-delta 1"
+
+const (
+	// differences fewer than this many bytes apart are one record
+	_DeltaGap = 8
+
+	// most bytes in a record
+	_DeltaRun = 32
+)
+
+// a run of changed bytes
+type change struct {
+	off      int64
+	old, new []byte
+}
+
+func (c *change) String() string {
+	return fmt.Sprintf("0x%08x %s %s", c.off, hexOrNone(c.old), hexOrNone(c.new))
+}
+
+// deltaWriter collects the differing bytes into records
+type deltaWriter struct {
+	wr  *bufio.Writer
+	cur *change
+
+	// identical bytes since the last difference in 'cur'
+	same []byte
+}
+
+// doDelta writes the patch that turns file 'oldfn' into 'newfn' to 'wr'
+func doDelta(wr io.Writer, oldfn, newfn string) error {
+	a, asz, err := openSized(oldfn)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	b, bsz, err := openSized(newfn)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	d := &deltaWriter{wr: bufio.NewWriterSize(wr, _BUFSZ)}
+	fmt.Fprintf(d.wr, "%s\nsize %d %d\n", _DeltaHdr, asz, bsz)
+
+	ra := bufio.NewReaderSize(a, _BUFSZ)
+	rb := bufio.NewReaderSize(b, _BUFSZ)
+	both := min(asz, bsz)
+	for off := int64(0); off < both; off++ {
+		x, err := ra.ReadByte()
+		if err != nil {
+			return fmt.Errorf("%s: %w", oldfn, err)
+		}
+		y, err := rb.ReadByte()
+		if err != nil {
+			return fmt.Errorf("%s: %w", newfn, err)
+		}
+		d.step(off, x, y)
+	}
+	d.flush()
+
+	// the tail of the longer file
+	tail, fn := ra, oldfn
+	if bsz > asz {
+		tail, fn = rb, newfn
+	}
+
+	buf := make([]byte, _DeltaRun)
+	for off, end := both, max(asz, bsz); off < end; {
+		n, err := io.ReadFull(tail, buf[:min(int64(len(buf)), end-off)])
+		if err != nil {
+			return fmt.Errorf("%s: %w", fn, err)
+		}
+
+		c := &change{off: off, old: buf[:n]}
+		if bsz > asz {
+			c.off, c.old, c.new = asz, nil, buf[:n]
+		}
+		fmt.Fprintf(d.wr, "%s\n", c)
+		off += int64(n)
+	}
+
+	if err := d.wr.Flush(); err != nil {
+		return fmt.Errorf("can't write patch: %w", err)
+	}
+	return nil
+}
+
+// step compares the byte at 'off' of the old (x) and new (y) file
+func (d *deltaWriter) step(off int64, x, y byte) {
+	c := d.cur
+	if x == y {
+		if c != nil {
+			if d.same = append(d.same, x); len(d.same) >= _DeltaGap {
+				d.flush()
+			}
+		}
+		return
+	}
+
+	if c != nil && len(c.old)+len(d.same) >= _DeltaRun {
+		d.flush()
+		c = nil
+	}
+
+	if c == nil {
+		c = &change{off: off}
+		d.cur = c
+	} else {
+		c.old = append(c.old, d.same...)
+		c.new = append(c.new, d.same...)
+	}
+	c.old = append(c.old, x)
+	c.new = append(c.new, y)
+	d.same = d.same[:0]
+}
+
+// flush writes the current record; write errors are caught when the
+// writer is flushed.
+func (d *deltaWriter) flush() {
+	if d.cur != nil {
+		fmt.Fprintf(d.wr, "%s\n", d.cur)
+		d.cur = nil
+	}
+	d.same = d.same[:0]
+}
+
+// doApply applies the patch in file 'patch' to 'src' (named 'fn') and
+// writes the new file to 'wr'. The old bytes of each record must match.
+func doApply(wr io.Writer, patch string, src io.Reader, fn string) error {
+	pf, err := os.Open(patch)
+	if err != nil {
+		return err
+	}
+	defer pf.Close()
+
+	pr := bufio.NewScanner(pf)
+	pr.Buffer(make([]byte, 0, 4096), 1024*1024)
+	if !pr.Scan() || pr.Text() != _DeltaHdr {
+		return fmt.Errorf("%s: not a hexlify delta", patch)
+	}
+
+	var asz, bsz int64
+	if !pr.Scan() {
+		return fmt.Errorf("%s: missing sizes", patch)
+	}
+	if _, err := fmt.Sscanf(pr.Text(), "size %d %d", &asz, &bsz); err != nil {
+		return fmt.Errorf("%s: 2: malformed sizes: %w", patch, err)
+	}
+
+	// don't write what we can't finish
+	if fd, ok := src.(*os.File); ok {
+		if st, err := fd.Stat(); err == nil && st.Mode().IsRegular() && st.Size() != asz {
+			return report.Mismatch(fmt.Errorf("%s: is %d bytes; the patch is for %d", fn, st.Size(), asz))
+		}
+	}
+
+	in := bufio.NewReaderSize(src, _BUFSZ)
+	out := &countWriter{w: bufio.NewWriterSize(wr, _BUFSZ)}
+
+	var pos int64
+	for n := 3; pr.Scan(); n++ {
+		c, err := parseChange(pr.Text())
+		if err != nil {
+			return fmt.Errorf("%s: %d: %w", patch, n, err)
+		}
+		if c.off < pos {
+			return fmt.Errorf("%s: %d: record at 0x%x is out of order", patch, n, c.off)
+		}
+
+		// the bytes up to the record are the same
+		if k, err := io.CopyN(out, in, c.off-pos); err != nil {
+			if err == io.EOF {
+				return report.Mismatch(fmt.Errorf("%s: is %d bytes; the patch is for %d", fn, pos+k, asz))
+			}
+			return fmt.Errorf("%s: %w", fn, err)
+		}
+
+		old := make([]byte, len(c.old))
+		if _, err := io.ReadFull(in, old); err != nil {
+			return fmt.Errorf("%s: 0x%x: %w; the patch is for %d bytes", fn, c.off, err, asz)
+		}
+		if !bytes.Equal(old, c.old) {
+			return report.Mismatch(fmt.Errorf("%s: 0x%x: bytes don't match the patch; not the old file?", fn, c.off))
+		}
+		if _, err := out.Write(c.new); err != nil {
+			return err
+		}
+		pos = c.off + int64(len(c.old))
+	}
+	if err := pr.Err(); err != nil {
+		return fmt.Errorf("%s: %w", patch, err)
+	}
+
+	k, err := io.Copy(out, in)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+	if pos+k != asz {
+		return report.Mismatch(fmt.Errorf("%s: is %d bytes; the patch is for %d", fn, pos+k, asz))
+	}
+	if out.n != bsz {
+		return report.Mismatch(fmt.Errorf("%s: patch made %d bytes instead of %d; corrupt patch?", patch, out.n, bsz))
+	}
+	return out.w.Flush()
+}
+
+// parseChange parses a patch record
+func parseChange(s string) (*change, error) {
+	v := strings.Fields(s)
+	if len(v) != 3 {
+		return nil, errors.New("malformed record")
+	}
+
+	off, err := strconv.ParseInt(v[0], 0, 64)
+	if err != nil || off < 0 {
+		return nil, fmt.Errorf("malformed offset '%s'", v[0])
+	}
+
+	c := &change{off: off}
+	if c.old, err = noneOrHex(v[1]); err != nil {
+		return nil, err
+	}
+	if c.new, err = noneOrHex(v[2]); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func hexOrNone(b []byte) string {
+	if len(b) == 0 {
+		return "-"
+	}
+	return hex.EncodeToString(b)
+}
+
+func noneOrHex(s string) ([]byte, error) {
+	if s == "-" {
+		return nil, nil
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("malformed bytes '%s': %w", s, err)
+	}
+	return b, nil
+}
+
+// openSized opens 'fn' and returns its size
+func openSized(fn string) (*os.File, int64, error) {
+	fd, err := os.Open(fn)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	st, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return nil, 0, err
+	}
+	if !st.Mode().IsRegular() {
+		fd.Close()
+		return nil, 0, fmt.Errorf("%s: not a file", fn)
+	}
+	return fd, st.Size(), nil
+}
+
+// countWriter counts the bytes written to 'w'
+type countWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+func (c *countWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// doPatch runs the delta or apply command with 'args'
+func doPatch(wr io.Writer, mode string, args []string) error {
+	if mode == "delta" {
+		if len(args) != 2 {
+			return errors.New("delta needs the old and new file")
+		}
+		return doDelta(wr, args[0], args[1])
+	}
+
+	switch len(args) {
+	case 1:
+		return doApply(wr, args[0], os.Stdin, "<stdin>")
+	case 2:
+		fd, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		return doApply(wr, args[0], fd, args[1])
+	}
+	return errors.New("apply needs a patch and at most one input")
+}
@@ -0,0 +1,26 @@
+// devsize_linux.go - size of a block device
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// devSize returns the size of the block device 'fd' in bytes
+func devSize(fd *os.File) (int64, error) {
+	var sz uint64
+
+	_, _, e := unix.Syscall(unix.SYS_IOCTL, fd.Fd(), unix.BLKGETSIZE64, uintptr(unsafe.Pointer(&sz)))
+	if e != 0 {
+		return 0, e
+	}
+	return int64(sz), nil
+}
@@ -0,0 +1,27 @@
+// devsize_other.go - size of a block device
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build !linux
+
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// devSize returns the size of the block device 'fd' in bytes; on
+// the BSDs and macOS, seeking to the end of a disk device yields its
+// size.
+func devSize(fd *os.File) (int64, error) {
+	sz, err := fd.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = fd.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return sz, nil
+}
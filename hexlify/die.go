@@ -16,16 +16,26 @@ package main
 import (
 	"fmt"
 	"os"
+
+	"go-progs/internal/report"
 )
 
 var atExit []func()
 
 // Die prints an error message to stderr
 // and exits the program after calling all the registered
-// at-exit functions.
+// at-exit functions. It's for usage errors: bad options,
+// arguments etc.
 func Die(f string, v ...interface{}) {
 	Warn(f, v...)
-	Exit(1)
+	Exit(report.ExitUsage)
+}
+
+// Fatal reports 'err' and exits the program with the exit code of its
+// kind (and that of the errors before it).
+func Fatal(err error) {
+	rep.Error(err)
+	Exit(rep.Code())
 }
 
 // Warn prints an error message to stderr
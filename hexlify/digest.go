@@ -0,0 +1,50 @@
+// digest.go - hash the input while it's dumped
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"fmt"
+	"hash"
+	"strings"
+
+	"go-progs/internal/hashes"
+)
+
+// digest hashes the input bytes as they're fed to the dumper; so the
+// checksum of a large input doesn't need a second pass.
+type digest struct {
+	algo string
+	h    hash.Hash
+}
+
+func newDigest(algo string) (*digest, error) {
+	hgen, ok := hashes.Table[algo]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash algorithm '%s'; try one of: %s",
+			algo, strings.Join(hashes.Names(), ", "))
+	}
+	return &digest{algo: algo, h: hgen()}, nil
+}
+
+// input wraps 'd' so that all its input is hashed
+func (g *digest) input(d dumper) dumper {
+	return &digestDumper{d, g}
+}
+
+// line returns the digest of input 'fn' in the BSD tagged format:
+// ALGO (NAME) = HEX
+func (g *digest) line(fn string) string {
+	return fmt.Sprintf("%s (%s) = %x", g.algo, fn, g.h.Sum(nil))
+}
+
+type digestDumper struct {
+	dumper
+	g *digest
+}
+
+func (t *digestDumper) Write(b []byte) error {
+	t.g.h.Write(b)
+	return t.dumper.Write(b)
+}
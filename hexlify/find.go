@@ -0,0 +1,329 @@
+// find.go - search the input for a byte pattern, string or regex
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// regex matches that span two input windows are found only if they're
+// at most this long.
+const _MaxReMatch = 4096
+
+// a matcher returns the offsets of the first match in 'b'; (-1, -1) if
+// there's none.
+type matcher func(b []byte) (int, int)
+
+// parsePattern parses a find pattern:
+//
+//	hex:DE AD ?? EF  hex bytes; '??' matches any byte
+//	re:REGEX         a go regexp
+//	anything else    a string; '?' matches any byte, '\?' is a '?'
+//
+// It returns the matcher and the longest match that can span windows.
+func parsePattern(s string) (matcher, int, error) {
+	switch {
+	case strings.HasPrefix(s, "re:"):
+		re, err := regexp.Compile(s[3:])
+		if err != nil {
+			return nil, 0, err
+		}
+		return func(b []byte) (int, int) {
+			if m := re.FindIndex(b); m != nil {
+				return m[0], m[1]
+			}
+			return -1, -1
+		}, _MaxReMatch, nil
+
+	case strings.HasPrefix(s, "hex:"):
+		w, err := parseHexPattern(s[4:])
+		if err != nil {
+			return nil, 0, err
+		}
+		return w.match, len(w.pat), nil
+	}
+
+	w, err := parseStrPattern(s)
+	if err != nil {
+		return nil, 0, err
+	}
+	return w.match, len(w.pat), nil
+}
+
+// a byte pattern with wildcards
+type wildPattern struct {
+	pat  []byte
+	any  []bool
+	lead int // first byte that isn't a wildcard
+}
+
+func parseHexPattern(s string) (*wildPattern, error) {
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', ':', ',':
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.TrimPrefix(strings.ToLower(s), "0x")
+
+	if len(s)%2 != 0 {
+		return nil, errors.New("odd number of hex digits in pattern")
+	}
+
+	w := &wildPattern{}
+	for i := 0; i < len(s); i += 2 {
+		if s[i:i+2] == "??" {
+			w.pat = append(w.pat, 0)
+			w.any = append(w.any, true)
+			continue
+		}
+
+		b, err := hex.DecodeString(s[i : i+2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex pattern: %w", err)
+		}
+		w.pat = append(w.pat, b[0])
+		w.any = append(w.any, false)
+	}
+	return w.finish()
+}
+
+func parseStrPattern(s string) (*wildPattern, error) {
+	w := &wildPattern{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			w.pat = append(w.pat, s[i])
+			w.any = append(w.any, false)
+		case c == '?':
+			w.pat = append(w.pat, 0)
+			w.any = append(w.any, true)
+		default:
+			w.pat = append(w.pat, c)
+			w.any = append(w.any, false)
+		}
+	}
+	return w.finish()
+}
+
+func (w *wildPattern) finish() (*wildPattern, error) {
+	w.lead = -1
+	for i, a := range w.any {
+		if !a {
+			w.lead = i
+			break
+		}
+	}
+	if w.lead < 0 {
+		return nil, errors.New("pattern must have at least one byte that isn't a wildcard")
+	}
+	return w, nil
+}
+
+func (w *wildPattern) match(b []byte) (int, int) {
+	n := len(w.pat)
+	for p := 0; p+n <= len(b); {
+		i := bytes.IndexByte(b[p+w.lead:len(b)-n+w.lead+1], w.pat[w.lead])
+		if i < 0 {
+			break
+		}
+
+		s := p + i
+		if w.matchAt(b[s : s+n]) {
+			return s, s + n
+		}
+		p = s + 1
+	}
+	return -1, -1
+}
+
+func (w *wildPattern) matchAt(b []byte) bool {
+	for i, c := range b {
+		if !w.any[i] && c != w.pat[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// a match waiting for the bytes after it
+type hit struct {
+	// input offset of the match and its length
+	off int64
+	n   int
+
+	// context bytes seen so far and where they start
+	ctx   []byte
+	start int64
+	need  int
+}
+
+// finder is a dumper that searches its input; each match is printed
+// with its offset and a hexdump of 'ctx' bytes on either side.
+type finder struct {
+	wr    *bufio.Writer
+	fn    string
+	match matcher
+
+	// bytes of the previous writes kept to find matches that span
+	// writes (and for the context before a match)
+	span int
+	ctx  int
+	tail []byte
+
+	// input offset of the next write; 'addr' is added to offsets
+	off  int64
+	addr uint64
+
+	pend    []*hit
+	matches int
+
+	// text column of the context hexdump
+	lane textLane
+}
+
+var _ dumper = &finder{}
+
+func newFinder(wr io.Writer, fn string, m matcher, span, ctx int, off int64, addr uint64, lane textLane) *finder {
+	f := &finder{
+		wr:    bufio.NewWriterSize(wr, _BUFSZ),
+		fn:    fn,
+		match: m,
+		span:  span,
+		ctx:   ctx,
+		off:   off,
+		addr:  addr,
+		lane:  lane,
+	}
+	return f
+}
+
+func (f *finder) Write(b []byte) error {
+	// feed the pending matches their context first
+	f.feed(b)
+
+	// matches that start in the tail and end in 'b'
+	if k := min(f.span-1, len(f.tail)); k > 0 && len(b) > 0 {
+		j := append(f.tail[len(f.tail)-k:len(f.tail):len(f.tail)], b[:min(len(b), f.span-1)]...)
+		for p := 0; p < k; {
+			s, e := f.match(j[p:])
+			if s < 0 || p+s >= k {
+				break
+			}
+			if p+e > k {
+				f.found(f.off-int64(k-p-s), e-s, b)
+			}
+			p += max(e, s+1)
+		}
+	}
+
+	for p := 0; p < len(b); {
+		s, e := f.match(b[p:])
+		if s < 0 {
+			break
+		}
+		f.found(f.off+int64(p+s), e-s, b)
+		p += max(e, s+1)
+	}
+
+	// keep enough for the next window
+	keep := f.span - 1 + f.ctx
+	if len(b) >= keep {
+		f.tail = append(f.tail[:0], b[len(b)-keep:]...)
+	} else {
+		f.tail = append(f.tail, b...)
+		if len(f.tail) > keep {
+			f.tail = f.tail[len(f.tail)-keep:]
+		}
+	}
+	f.off += int64(len(b))
+	return f.flush()
+}
+
+// found records a match at input offset 'off' of 'n' bytes; 'b' is the
+// current write.
+func (f *finder) found(off int64, n int, b []byte) {
+	f.matches++
+
+	start := max(off-int64(f.ctx), f.off-int64(len(f.tail)), 0)
+	end := off + int64(n) + int64(f.ctx)
+
+	h := &hit{
+		off:   off,
+		n:     n,
+		start: start,
+	}
+
+	// the bytes from the tail and then 'b'
+	if start < f.off {
+		h.ctx = append(h.ctx, f.tail[len(f.tail)-int(f.off-start):]...)
+	}
+	from := max(start-f.off, 0)
+	to := min(end-f.off, int64(len(b)))
+	h.ctx = append(h.ctx, b[from:to]...)
+	h.need = int(end - start - int64(len(h.ctx)))
+	f.pend = append(f.pend, h)
+}
+
+// feed the start of 'b' to the matches still waiting for context
+func (f *finder) feed(b []byte) {
+	for _, h := range f.pend {
+		m := min(h.need, len(b))
+		h.ctx = append(h.ctx, b[:m]...)
+		h.need -= m
+	}
+}
+
+// flush prints the matches whose context is complete - in order of
+// their offsets.
+func (f *finder) flush() error {
+	i := 0
+	for ; i < len(f.pend) && f.pend[i].need == 0; i++ {
+		if err := f.print(f.pend[i]); err != nil {
+			return err
+		}
+	}
+	f.pend = f.pend[i:]
+	return nil
+}
+
+func (f *finder) print(h *hit) error {
+	if _, err := fmt.Fprintf(f.wr, "0x%08x: %d bytes\n", f.addr+uint64(h.off), h.n); err != nil {
+		return fmt.Errorf("%s: %s", f.fn, err)
+	}
+	if f.ctx == 0 {
+		return nil
+	}
+
+	d := NewHexDumper(f.wr, f.fn, f.addr+uint64(h.start), false, f.lane)
+	if err := d.Write(h.ctx); err != nil {
+		return err
+	}
+	return d.Close()
+}
+
+// Close prints the matches near the end of the input
+func (f *finder) Close() error {
+	for _, h := range f.pend {
+		h.need = 0
+	}
+	if err := f.flush(); err != nil {
+		return err
+	}
+	if err := f.wr.Flush(); err != nil {
+		return fmt.Errorf("%s: %s", f.fn, err)
+	}
+	return nil
+}
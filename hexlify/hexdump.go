@@ -0,0 +1,127 @@
+// hexdump.go - hexdump(1) style output at arbitrary addresses
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// hexDumper emits the same format as encoding/hex.Dumper; but the
+// offset column starts at an arbitrary address. Like hexdump(1), runs
+// of identical lines are squeezed into a single '*' unless disabled.
+type hexDumper struct {
+	fn   string
+	bio  *bufio.Writer
+	addr uint64
+	lane textLane
+
+	// partial line
+	line [16]byte
+	n    int
+
+	// previous full line and whether we're in a squeezed run
+	squeeze bool
+	prev    [16]byte
+	started bool
+	skipped bool
+}
+
+var _ dumper = &hexDumper{}
+
+func NewHexDumper(wr io.Writer, fn string, addr uint64, squeeze bool, lane textLane) dumper {
+	d := &hexDumper{
+		fn:      fn,
+		bio:     bufio.NewWriterSize(wr, _BUFSZ),
+		addr:    addr,
+		lane:    lane,
+		squeeze: squeeze,
+	}
+	return d
+}
+
+func (d *hexDumper) Write(b []byte) error {
+	for len(b) > 0 {
+		m := copy(d.line[d.n:], b)
+		d.n += m
+		b = b[m:]
+
+		if d.n == len(d.line) {
+			if err := d.emit(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *hexDumper) Close() error {
+	if d.n > 0 {
+		if err := d.emit(); err != nil {
+			return err
+		}
+	} else if d.skipped {
+		// show where the squeezed run ended
+		if _, err := fmt.Fprintf(d.bio, "%08x\n", d.addr); err != nil {
+			return fmt.Errorf("%s: %s", d.fn, err)
+		}
+	}
+
+	if err := d.bio.Flush(); err != nil {
+		return fmt.Errorf("%s: %s", d.fn, err)
+	}
+	return nil
+}
+
+// emit the current (possibly partial) line
+func (d *hexDumper) emit() error {
+	const xdigits = "0123456789abcdef"
+
+	// offset + 16 * "xx " + 2 spaces + |16 chars|
+	var buf [96]byte
+
+	if d.squeeze && d.n == len(d.line) {
+		if d.started && d.line == d.prev {
+			if !d.skipped {
+				if _, err := d.bio.WriteString("*\n"); err != nil {
+					return fmt.Errorf("%s: %s", d.fn, err)
+				}
+				d.skipped = true
+			}
+			d.addr += uint64(d.n)
+			d.n = 0
+			return nil
+		}
+		d.prev = d.line
+		d.started = true
+	}
+	d.skipped = false
+
+	p := fmt.Appendf(buf[:0], "%08x  ", d.addr)
+	for i := range d.line {
+		if i < d.n {
+			c := d.line[i]
+			p = append(p, xdigits[c>>4], xdigits[c&0xf], ' ')
+		} else {
+			p = append(p, ' ', ' ', ' ')
+		}
+		if i == 7 {
+			p = append(p, ' ')
+		}
+	}
+
+	p = append(p, ' ', '|')
+	p = d.lane(p, d.line[:d.n])
+	p = append(p, '|', '\n')
+
+	if _, err := d.bio.Write(p); err != nil {
+		return fmt.Errorf("%s: %s", d.fn, err)
+	}
+
+	d.addr += uint64(d.n)
+	d.n = 0
+	return nil
+}
@@ -12,10 +12,17 @@ import (
 	"io"
 	"os"
 	"path"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"go-progs/internal/hashes"
+	"go-progs/internal/report"
 
 	"github.com/opencoff/go-fio"
-	"github.com/opencoff/go-mmap"
+	"github.com/opencoff/go-utils"
 	flag "github.com/opencoff/pflag"
 )
 
@@ -23,20 +30,54 @@ var Z string = path.Base(os.Args[0])
 
 const _BUFSZ int = 65536
 
+// errors and the exit code
+var rep *report.Reporter
+
 func main() {
 	var version bool
-	var count uint
+	var count, skip uint64
 	var out string
+	var jobs int
+	var base string
+	var noSqueeze bool
+	var roundtrip bool
+	var jsonErrs bool
+	var context int
+	var cName, cHeader, cGuard string
+	var charset string
+	var splitSize string
+	var digestAlgo string
+	var format string
+	var refresh time.Duration
 
 	flag.BoolVarP(&version, "version", "", false, "Show version info and quit")
-	flag.UintVarP(&count, "count", "n", 0, "Read `N` bytes of each input (0 implies 'till EOF')")
+	flag.Uint64VarP(&count, "count", "n", 0, "Read `N` bytes of each input (0 implies 'till EOF')")
+	flag.Uint64VarP(&skip, "skip", "s", 0, "Skip `N` bytes from the start of each input")
+	flag.IntVarP(&jobs, "jobs", "j", runtime.NumCPU(), "Use `N` concurrent encoders for hex and b64")
+	flag.StringVarP(&base, "base-address", "", "", "Show hexdump offsets relative to address `A` (e.g. 0x8000)")
+	flag.BoolVarP(&noSqueeze, "no-squeeze", "", false, "Show repeated hexdump lines instead of a '*'")
+	flag.BoolVarP(&roundtrip, "verify-roundtrip", "", false, "Decode the output and exit non-zero if it doesn't match the input")
+	flag.StringVarP(&charset, "charset", "", "ascii", "Decode the hexdump text column as `C` (ascii, latin1, utf8, utf16le)")
+	flag.IntVarP(&context, "context", "C", 16, "Show `N` bytes around each match in find mode")
+	flag.StringVarP(&cName, "name", "", "", "Name the C array `N` (default: from the input name)")
+	flag.StringVarP(&cHeader, "emit-header", "", "", "Write a C header declaring the array to file `F`")
+	flag.StringVarP(&cGuard, "header-guard", "", "", "Use include guard `G` in the --emit-header file")
+	flag.StringVarP(&digestAlgo, "digest", "", "", "Also hash the input with `ALGO` (as in ghash) and print the digest on stderr")
+	flag.StringVarP(&format, "format", "", "text", "Write the b64, hex and hexdump output in format `F` (text, json)")
+	flag.DurationVarP(&refresh, "refresh", "", 0, "Dump the memory again every `T` in mem mode (e.g. 2s)")
 	flag.StringVarP(&out, "outfile", "o", "-", "Write output to file `F`")
+	flag.StringVarP(&splitSize, "split-size", "", "", "Write the output to files F.000, F.001 .. of at most `N` bytes each")
+	flag.BoolVarP(&jsonErrs, "json-errors", "", false, "Write errors to stderr as JSON records")
 
 	flag.Usage = func() {
 		fmt.Printf(
 			`%s - dump input into b64, hex or 'C'
 
 Usage: %s [options] mode [input]
+       %s [options] find PATTERN [input]
+       %s [options] delta OLD NEW
+       %s [options] apply PATCH [input]
+       %s [options] mem PID [REGION]
 
 Where mode is one of:
 
@@ -44,9 +85,99 @@ Where mode is one of:
 	hex, x:           output in "raw" hex
 	hexdump, dump, d: mimic hexdump(1) output
 	C, struct:        output C like array definition
+	find:             search for PATTERN - a binary grep
+	delta:            write a patch that turns file OLD into NEW
+	apply:            apply PATCH to the input
+	mem:              hexdump the memory of the running process PID
+
+The input can be a file, a block device or stdin. Character devices
+(e.g. /dev/urandom) need an explicit --count.
+
+The hexdump offsets are input offsets (including --skip); with
+--base-address, they are the load address A plus the input offset.
+Like hexdump(1), a run of identical lines is shown as a single '*';
+use --no-squeeze to see every line.
+
+--charset sets how the text column on the right is decoded: ascii
+(default), latin1 (ISO 8859-1), utf8 or utf16le (e.g. Windows
+strings); characters that aren't printable are shown as '.'. The
+column is one cell per byte - per 16 bit unit for utf16le - and the
+rest of a multi-byte character is blank. A UTF-8 character (or UTF-16
+surrogate pair) split across two lines is shown as '.'.
+
+find prints the offset of each match of PATTERN and a hexdump of
+--context bytes on either side; it exits with 1 if nothing matched.
+PATTERN is one of:
+
+	hex:DE AD ?? EF   hex bytes; '??' matches any byte
+	re:REGEX          a go regexp (matches spanning input windows
+	                  are found only if they're at most %d bytes)
+	STRING            the bytes of STRING; '?' matches any byte and
+	                  '\?' is a literal '?'
+
+delta compares OLD and NEW byte by byte and writes a text patch: a
+'size OLD NEW' line and one 'OFFSET OLDBYTES NEWBYTES' line (in hex)
+per run of changed bytes; '-' is no bytes, e.g. the appended tail of a
+longer NEW. apply writes the input with the patch applied to --outfile;
+it fails if the input isn't the size of OLD or its bytes at a record
+don't match OLDBYTES. Bytes aren't inserted or deleted in the middle;
+it suits patched firmware and binaries rather than edited text.
+
+mem (on linux) reads /proc/PID/mem and hexdumps REGION with its
+addresses as the offsets; REGION is one of:
+
+	ADDR              from ADDR to the end of the mapping that holds it
+	ADDR-END          the bytes from ADDR up to END (e.g. 0x1000-0x2000)
+	NAME              the mapping(s) of NAME in /proc/PID/maps; e.g.
+	                  [stack], [heap] or libc.so.6
+
+It's the first mapping if REGION is omitted; --skip and --count select
+the bytes within REGION. Reading another process needs ptrace access
+to it: e.g. the same user and a kernel.yama.ptrace_scope of 0 - or
+root. With --refresh=T, the region is read and dumped again every T
+(the screen is cleared first on a terminal) until the process exits
+or hexlify is interrupted. --format=json applies without --refresh;
+--base-address, --split-size, --digest and --verify-roundtrip don't.
+
+In C mode, --emit-header=F.h also writes a header F.h that defines
+the length macro NAME_LEN and declares the array NAME and its size
+NAME_size; the C output then is a complete .c file that includes F.h.
+NAME is set by --name (from the input file name by default) and the
+include guard by --header-guard (from the header name by default).
+C has no zero-length arrays; so an empty input is the array { 0 } -
+and with --emit-header, its NAME_LEN and NAME_size are 0.
+
+With --split-size=N, the output goes to the files F.000, F.001 ... (F
+is the --outfile) each at most N bytes (e.g. 64k). Lines aren't split
+across parts; a line longer than N (e.g. the b64 and hex output is one
+line) is split into chunks whose size is a multiple of 4 bytes - so
+each part decodes on its own.
+
+With --verify-roundtrip, the b64, hex and C output is decoded as it's
+written and compared with the input; a mismatch is an error and the
+output file (if any) is not created.
+
+With --format=json, the b64, hex and hexdump output is a JSON array
+of records, one per line: {"offset", "bytes", "ascii"} for each 16
+bytes in hex and hexdump mode - the bytes in hex and the text column
+as decoded by --charset - and {"offset", "base64"} for each 48 bytes
+in b64 mode. The offsets are those of the hexdump; so --base-address
+applies. Repeated records aren't squeezed. It can't be used with
+--split-size or --verify-roundtrip.
+
+With --digest=ALGO, the bytes dumped (after --skip and --count) are
+also hashed with ALGO as they're read and the digest is printed on
+stderr once the output is written: 'ALGO (NAME) = HEX'. So a large
+input needn't be read twice to get its checksum. ALGO is one of: %s.
+
+A failed --verify-roundtrip or an input that doesn't match the delta
+patch exits with 1; other errors exit with 2 if a file vanished, 3 on
+I/O errors and 4 if permission was denied; the largest applies. Bad
+options and arguments exit with 64. With --json-errors, each error is
+a JSON record: {"prog", "kind", "op", "path", "error"}.
 
 Options:
-`, Z, Z)
+`, Z, Z, Z, Z, Z, Z, _MaxReMatch, strings.Join(hashes.Names(), ", "))
 		flag.PrintDefaults()
 		os.Stdout.Sync()
 		os.Exit(0)
@@ -58,103 +189,254 @@ Options:
 		os.Exit(0)
 	}
 
+	rep = report.New(os.Args[0], jsonErrs)
+
 	args := flag.Args()
 	if len(args) == 0 {
 		Die("Insufficient arguments. Try '%s --help'", Z)
 	}
 
+	lane, err := parseCharset(charset)
+	if err != nil {
+		Die("%s", err)
+	}
+
+	var addr uint64
+	if len(base) > 0 {
+		a, err := strconv.ParseUint(base, 0, 64)
+		if err != nil {
+			Die("invalid base address '%s': %s", base, err)
+		}
+		addr = a
+	}
+
 	var wr io.WriteCloser = os.Stdout
 
-	if len(out) > 0 && out != "-" {
+	if len(splitSize) > 0 {
+		if len(out) == 0 || out == "-" {
+			Die("--split-size needs --outfile")
+		}
+		n, err := utils.ParseSize(splitSize)
+		if err != nil || n == 0 {
+			Die("invalid split size '%s'", splitSize)
+		}
+		sw := newSplitWriter(out, int64(n))
+		wr = sw
+		AtExit(sw.Abort)
+		defer sw.Abort()
+	} else if len(out) > 0 && out != "-" {
 		wfd, err := fio.NewSafeFile(out, 0, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
 		if err != nil {
-			Die("can't create %s: %s", out, err)
+			Fatal(fmt.Errorf("can't create %s: %w", out, err))
 		}
 		wr = wfd
 		AtExit(wfd.Abort)
 		defer wfd.Abort()
 	}
 
-	var mkdump func(wr io.Writer, fn string) dumper
 	mode := strings.ToLower(args[0])
+	if mode == "delta" || mode == "apply" {
+		if skip > 0 || count > 0 || len(splitSize) > 0 || len(digestAlgo) > 0 || format != "text" {
+			Die("--skip, --count, --split-size, --digest and --format don't apply to %s", mode)
+		}
+		if err := doPatch(wr, mode, args[1:]); err != nil {
+			Fatal(err)
+		}
+		if err := wr.Close(); err != nil {
+			Fatal(err)
+		}
+		Exit(0)
+	}
+
+	if mode == "mem" {
+		if len(base) > 0 || len(splitSize) > 0 || len(digestAlgo) > 0 || roundtrip {
+			Die("--base-address, --split-size, --digest and --verify-roundtrip don't apply to mem")
+		}
+		if refresh < 0 {
+			Die("--refresh must not be negative")
+		}
+		if refresh > 0 && len(out) > 0 && out != "-" {
+			Die("--refresh can't be used with --outfile")
+		}
+		if refresh > 0 && format == "json" {
+			Die("--refresh can't be used with --format=json")
+		}
+
+		o := &memOpts{
+			skip:    skip,
+			count:   count,
+			refresh: refresh,
+			clear:   isTTY(os.Stdout),
+		}
+		switch format {
+		case "text":
+			o.mkdump = func(w io.Writer, fn string, addr uint64) dumper {
+				return NewHexDumper(w, fn, addr, !noSqueeze, lane)
+			}
+		case "json":
+			o.mkdump = func(w io.Writer, fn string, addr uint64) dumper {
+				return NewJsonDumper(w, fn, addr, false, lane)
+			}
+		default:
+			Die("unknown format '%s'; try one of: text, json", format)
+		}
+
+		if err := doMem(wr, args[1:], o); err != nil {
+			Fatal(err)
+		}
+		if err := wr.Close(); err != nil {
+			Fatal(err)
+		}
+		Exit(0)
+	}
+	if refresh != 0 {
+		Die("--refresh only applies to mem")
+	}
+
+	var mkdump func(wr io.Writer, fn string) dumper
+	var hexdump bool
+	var fd *finder
+	var cd *cDumper
+	var ty enctype
 	switch mode {
 	case "b64", "base64":
 		mkdump = func(w io.Writer, fn string) dumper {
-			return NewFlexDumper(w, fn, encB64)
+			return NewFlexDumper(w, fn, encB64, jobs)
 		}
+		ty = encB64
 
 	case "c", "struct":
-		mkdump = NewCdumper
+		mkdump = func(w io.Writer, fn string) dumper {
+			cd = NewCdumper(w, fn).(*cDumper)
+			return cd
+		}
+		ty = encC
 
 	case "hex", "x":
 		mkdump = func(w io.Writer, fn string) dumper {
-			return NewFlexDumper(w, fn, encRawhex)
+			return NewFlexDumper(w, fn, encRawhex, jobs)
 		}
+		ty = encRawhex
+
+	case "find":
+		if len(args) < 2 {
+			Die("find needs a pattern. Try '%s --help'", Z)
+		}
+		m, span, err := parsePattern(args[1])
+		if err != nil {
+			Die("invalid pattern '%s': %s", args[1], err)
+		}
+		if context < 0 {
+			Die("--context must not be negative")
+		}
+		mkdump = func(w io.Writer, fn string) dumper {
+			fd = newFinder(w, fn, m, span, context, int64(skip), addr, lane)
+			return fd
+		}
+		args = args[1:]
 
 	case "dump", "d", "hexdump":
-		mkdump = NewHexDumper
+		mkdump = func(w io.Writer, fn string) dumper {
+			return NewHexDumper(w, fn, addr+skip, !noSqueeze, lane)
+		}
+		hexdump = true
 
 	default:
 		Die("unknown encoding type '%s'", mode)
 	}
 
-	hexlate := func(wr io.Writer, src io.Reader, fn string) {
-		dd := mkdump(wr, fn)
-		defer func(d dumper) {
-			err := d.Close()
-			if err != nil {
-				Warn("%s", err)
-			}
-		}(dd)
-
-		if fd, ok := src.(*os.File); ok && mmapable(fd) {
-			if count > 0 {
-				mm := mmap.New(fd)
-				m, err := mm.Map(int64(count), 0, mmap.PROT_READ, 0)
-				if err != nil {
-					Warn("%s: %s", fd.Name(), err)
-					return
-				}
-				defer m.Unmap()
-				b := m.Bytes()
-
-				if err = dd.Write(b); err != nil {
-					Warn("%s", err)
-				}
-				return
-			}
+	var jsonHex bool
+	switch format {
+	case "text":
+	case "json":
+		if ty == encC || mode == "find" {
+			Die("--format=json only applies to b64, hex and hexdump")
+		}
+		if len(splitSize) > 0 || roundtrip {
+			Die("--format=json can't be used with --split-size or --verify-roundtrip")
+		}
+		b64 := ty == encB64 && !hexdump
+		mkdump = func(w io.Writer, fn string) dumper {
+			return NewJsonDumper(w, fn, addr+skip, b64, lane)
+		}
+		jsonHex = !b64
+	default:
+		Die("unknown format '%s'; try one of: text, json", format)
+	}
 
-			_, err := mmap.Reader(fd, func(b []byte) error {
-				return dd.Write(b)
-			})
+	if len(base) > 0 && !hexdump && !jsonHex && mode != "find" {
+		Die("--base-address only applies to hexdump, find and json hex")
+	}
+	if flag.Lookup("charset").Changed && !hexdump && !jsonHex && mode != "find" {
+		Die("--charset only applies to hexdump, find and json hex")
+	}
 
-			if err != nil {
-				Warn("%s", err)
-			}
+	inName := "<stdin>"
+	if len(args) > 1 {
+		inName = args[1]
+	}
 
-			return
+	var decl *cDecl
+	if len(cHeader) > 0 {
+		if ty != encC || hexdump || mode == "find" {
+			Die("--emit-header only applies to C mode")
 		}
+		d, err := newCDecl(cName, cHeader, cGuard, inName)
+		if err != nil {
+			Die("%s", err)
+		}
+		decl = d
+	} else if len(cName) > 0 || len(cGuard) > 0 {
+		Die("--name and --header-guard need --emit-header")
+	}
 
-		if count > 0 {
-			src = io.LimitReader(src, int64(count))
+	var dg *digest
+	if len(digestAlgo) > 0 {
+		if mode == "find" {
+			Die("--digest doesn't apply to find")
+		}
+		if dg, err = newDigest(digestAlgo); err != nil {
+			Die("%s", err)
 		}
+	}
 
-		buf := make([]byte, _BUFSZ)
-		for {
-			m, err := src.Read(buf)
-			if m == 0 || err == io.EOF {
-				return
-			}
+	var rt *roundTrip
+	if roundtrip {
+		if hexdump || mode == "find" {
+			Die("--verify-roundtrip doesn't apply to hexdump or find")
+		}
+		rt = newRoundTrip(ty)
+	}
 
-			if err != nil {
-				Warn("%s: %s", fn, err)
-				return
-			}
+	// true if all of the input was dumped
+	var dumped bool
+	hexlate := func(wr io.Writer, src io.Reader, fn string) {
+		var dd dumper
+		if rt != nil {
+			dd = rt.input(mkdump(rt.output(wr), fn))
+		} else {
+			dd = mkdump(wr, fn)
+		}
+		if dg != nil {
+			dd = dg.input(dd)
+		}
+		if cd != nil {
+			cd.decl = decl
+		}
+		defer func(d dumper) {
+			rep.Error(d.Close())
+		}(dd)
 
-			if err = dd.Write(buf[:m]); err != nil {
-				Warn("%s", err)
-			}
+		in := &input{
+			fn:    fn,
+			skip:  int64(skip),
+			count: int64(count),
 		}
+		if err := in.dump(src, dd); err != nil {
+			rep.Error(err)
+			return
+		}
+		dumped = true
 	}
 
 	// Now process the input
@@ -163,7 +445,7 @@ Options:
 		fn := args[0]
 		fd, err := os.Open(fn)
 		if err != nil {
-			Die("%s", err)
+			Fatal(err)
 		}
 		hexlate(wr, fd, fn)
 		fd.Close()
@@ -171,18 +453,37 @@ Options:
 		hexlate(wr, os.Stdin, "<stdin>")
 	}
 
+	if rt != nil {
+		if err := rt.verify(); err != nil {
+			Fatal(err)
+		}
+	}
+
+	if decl != nil {
+		if err := decl.writeHeader(inName, cd.n); err != nil {
+			Fatal(err)
+		}
+	}
+
 	// without this - the output file will be deleted on exit.
-	wr.Close()
-}
+	if err := wr.Close(); err != nil {
+		Fatal(err)
+	}
 
-// return true if an open file can be memory mapped
-func mmapable(fd *os.File) bool {
-	st, err := fd.Stat()
-	if err != nil {
-		return false
+	// a partial input has no digest
+	if dg != nil {
+		if !dumped {
+			Fatal(fmt.Errorf("%s: no digest; the input wasn't read in full", inName))
+		}
+		fmt.Fprintln(os.Stderr, dg.line(inName))
 	}
 
-	return st.Mode().IsRegular() && st.Size() > 0
+	if c := rep.Code(); c > 0 {
+		Exit(c)
+	}
+	if fd != nil && fd.matches == 0 {
+		Exit(1)
+	}
 }
 
 type dumper interface {
@@ -190,38 +491,15 @@ type dumper interface {
 	Close() error
 }
 
-type hexDumper struct {
-	wr io.Writer
-	fn string
-	hd io.WriteCloser
-}
-
-func NewHexDumper(wr io.Writer, fn string) dumper {
-	hd := hex.Dumper(wr)
-	d := &hexDumper{
-		wr: wr,
-		fn: fn,
-		hd: hd,
-	}
-	return d
-}
-
-func (d *hexDumper) Write(b []byte) error {
-	return write(d.fn, d.hd, b)
-}
-
-func (d *hexDumper) Close() error {
-	if err := d.hd.Close(); err != nil {
-		return fmt.Errorf("%s: %s", d.fn, err)
-	}
-	return nil
-}
-
 type cDumper struct {
 	wr      io.Writer
 	fn      string
 	bio     *bufio.Writer
 	started bool
+
+	// declarations of a complete .c file and the bytes dumped
+	decl *cDecl
+	n    int64
 }
 
 var _ dumper = &cDumper{}
@@ -242,10 +520,14 @@ func (d *cDumper) Write(b []byte) error {
 
 	bio := d.bio
 	n := len(b)
+	d.n += int64(n)
 
 	// handle the first byte separately
 	if !d.started {
 		s := fmt.Sprintf("{\n\t  %#2.2x", b[0])
+		if d.decl != nil {
+			s = d.decl.prologue(d.fn) + s
+		}
 		if _, err := bio.WriteString(s); err != nil {
 			return fmt.Errorf("%s: %s", d.fn, err)
 		}
@@ -289,9 +571,17 @@ func (d *cDumper) writeLine(b []byte) error {
 }
 
 func (d *cDumper) Close() error {
-	const s string = "\n}\n"
-	b := []byte(s)
-	return write(d.fn, d.wr, b)
+	s := "\n}\n"
+	switch {
+	case !d.started && d.decl != nil:
+		s = d.decl.empty(d.fn)
+	case !d.started:
+		// C has no empty initializers
+		s = "{\n\t  0\n}\n"
+	case d.decl != nil:
+		s = "\n}" + d.decl.epilogue()
+	}
+	return write(d.fn, d.wr, []byte(s))
 }
 
 type enctype int
@@ -299,13 +589,26 @@ type enctype int
 const (
 	encB64 enctype = iota
 	encRawhex
+	encC
 )
 
+// encoding chunk size; must be a multiple of 3 so that base64 encoded
+// chunks can be concatenated without padding.
+const _ChunkSize int = 3 * 4 * _BUFSZ
+
 // Dump b64 or raw-hex
 type flexdump struct {
-	wr  io.Writer
-	fn  string
-	buf []byte
+	wr io.Writer
+	fn string
+
+	// number of concurrent encoders and their output buffers
+	jobs int
+	bufs [][]byte
+
+	// input granularity of the encoder (3 for b64) and any
+	// leftover bytes from the previous Write()
+	gran int
+	pend []byte
 
 	enc    func(dst, src []byte)
 	enclen func(int) int
@@ -313,18 +616,19 @@ type flexdump struct {
 
 var _ dumper = &flexdump{}
 
-func NewFlexDumper(wr io.Writer, fn string, ty enctype) dumper {
-	buf := make([]byte, 3*_BUFSZ)
+func NewFlexDumper(wr io.Writer, fn string, ty enctype, jobs int) dumper {
 	d := &flexdump{
-		wr:  wr,
-		fn:  fn,
-		buf: buf,
+		wr:   wr,
+		fn:   fn,
+		jobs: max(jobs, 1),
+		gran: 1,
 	}
 
 	switch ty {
 	case encB64:
 		d.enc = base64.StdEncoding.Encode
 		d.enclen = base64.StdEncoding.EncodedLen
+		d.gran = 3
 
 	case encRawhex:
 		d.enc = func(d, s []byte) { hex.Encode(d, s) }
@@ -334,28 +638,76 @@ func NewFlexDumper(wr io.Writer, fn string, ty enctype) dumper {
 		panic("unknown encoding mode")
 	}
 
+	d.bufs = make([][]byte, d.jobs)
+	for i := range d.bufs {
+		d.bufs[i] = make([]byte, d.enclen(_ChunkSize))
+	}
 	return d
 }
 
 func (d *flexdump) Write(b []byte) error {
-	n := len(b)
-	for n > 0 {
-		m := min(n, _BUFSZ)
-		z := d.enclen(m)
-		d.enc(d.buf, b[:m])
-		err := write(d.fn, d.wr, d.buf[:z])
-		if err != nil {
+	// complete the leftover from the last call
+	if len(d.pend) > 0 {
+		m := min(d.gran-len(d.pend), len(b))
+		d.pend = append(d.pend, b[:m]...)
+		b = b[m:]
+		if len(d.pend) < d.gran {
+			return nil
+		}
+		if err := d.encode(d.pend); err != nil {
 			return err
 		}
-		n -= m
-		b = b[m:]
+		d.pend = d.pend[:0]
+	}
+
+	n := len(b) - (len(b) % d.gran)
+	if r := b[n:]; len(r) > 0 {
+		d.pend = append(d.pend, r...)
+	}
+	return d.encode(b[:n])
+}
+
+// encode 'b' in chunks; each batch of chunks is encoded concurrently
+// and written out in order.
+func (d *flexdump) encode(b []byte) error {
+	var wg sync.WaitGroup
+
+	for len(b) > 0 {
+		var lens []int
+		for i := 0; i < d.jobs && len(b) > 0; i++ {
+			m := min(len(b), _ChunkSize)
+			lens = append(lens, d.enclen(m))
+
+			if d.jobs == 1 {
+				d.enc(d.bufs[i], b[:m])
+			} else {
+				wg.Add(1)
+				go func(dst, src []byte) {
+					d.enc(dst, src)
+					wg.Done()
+				}(d.bufs[i], b[:m])
+			}
+			b = b[m:]
+		}
+		wg.Wait()
+
+		for i, z := range lens {
+			if err := write(d.fn, d.wr, d.bufs[i][:z]); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
 func (d *flexdump) Close() error {
-	fmt.Fprintf(d.wr, "\n")
-	return nil
+	if len(d.pend) > 0 {
+		if err := d.encode(d.pend); err != nil {
+			return err
+		}
+		d.pend = d.pend[:0]
+	}
+	return write(d.fn, d.wr, []byte("\n"))
 }
 
 func write(fn string, wr io.Writer, b []byte) error {
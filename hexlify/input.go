@@ -0,0 +1,150 @@
+// input.go - read regular files, devices and streams
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/opencoff/go-mmap"
+)
+
+// we map regular files in windows of this size
+const _MmapWindow int64 = 256 * 1024 * 1024
+
+// input describes the region of a source that must be dumped
+type input struct {
+	fn    string
+	skip  int64
+	count int64
+}
+
+// dump feeds the selected region of 'src' to the dumper
+func (in *input) dump(src io.Reader, dd dumper) error {
+	fd, ok := src.(*os.File)
+	if !ok {
+		return in.stream(src, dd)
+	}
+
+	st, err := fd.Stat()
+	if err != nil {
+		return fmt.Errorf("%s: %w", in.fn, err)
+	}
+
+	m := st.Mode()
+	switch {
+	case m.IsRegular() && st.Size() > 0:
+		return in.mmap(fd, st.Size(), dd)
+
+	case m&os.ModeDevice > 0 && m&os.ModeCharDevice == 0:
+		sz, err := devSize(fd)
+		if err != nil {
+			return fmt.Errorf("%s: can't get device size: %w", in.fn, err)
+		}
+		return in.blockdev(fd, sz, dd)
+
+	case m&os.ModeCharDevice > 0 && fd != os.Stdin:
+		// char devices are often infinite (/dev/zero, /dev/urandom)
+		if in.count == 0 {
+			return fmt.Errorf("%s: char device needs an explicit --count", in.fn)
+		}
+	}
+	return in.stream(fd, dd)
+}
+
+// clamp skip & count to a source of 'sz' bytes; returns the number of
+// bytes to dump
+func (in *input) clamp(sz int64) int64 {
+	if in.skip >= sz {
+		return 0
+	}
+
+	n := sz - in.skip
+	if in.count > 0 {
+		n = min(n, in.count)
+	}
+	return n
+}
+
+// mmap successive windows of a regular file
+func (in *input) mmap(fd *os.File, fsz int64, dd dumper) error {
+	pgsz := int64(os.Getpagesize())
+	n := in.clamp(fsz)
+	off := in.skip
+	mm := mmap.New(fd)
+
+	for n > 0 {
+		// mmap offsets must be page aligned
+		aoff := off &^ (pgsz - 1)
+		delta := off - aoff
+		sz := min(n+delta, _MmapWindow)
+
+		p, err := mm.Map(sz, aoff, mmap.PROT_READ, mmap.F_READAHEAD)
+		if err != nil {
+			return fmt.Errorf("%s: %w", in.fn, err)
+		}
+
+		err = dd.Write(p.Bytes()[delta:])
+		p.Unmap()
+		if err != nil {
+			return err
+		}
+
+		z := sz - delta
+		off += z
+		n -= z
+	}
+	return nil
+}
+
+// read a block device with positional reads
+func (in *input) blockdev(fd *os.File, sz int64, dd dumper) error {
+	n := in.clamp(sz)
+	return in.copy(io.NewSectionReader(fd, in.skip, n), dd)
+}
+
+// read pipes, ttys, char devices etc.; skip is done by reading and
+// discarding the data.
+func (in *input) stream(src io.Reader, dd dumper) error {
+	if in.skip > 0 {
+		if _, err := io.CopyN(io.Discard, src, in.skip); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("%s: skip: %w", in.fn, err)
+		}
+	}
+
+	if in.count > 0 {
+		src = io.LimitReader(src, in.count)
+	}
+	return in.copy(src, dd)
+}
+
+func (in *input) copy(src io.Reader, dd dumper) error {
+	buf := make([]byte, _BUFSZ)
+	for {
+		m, err := src.Read(buf)
+		if m > 0 {
+			if err := dd.Write(buf[:m]); err != nil {
+				return err
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", in.fn, err)
+		}
+	}
+}
+
+// return true if 'fd' is a terminal
+func isTTY(fd *os.File) bool {
+	fi, err := fd.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
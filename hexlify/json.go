@@ -0,0 +1,138 @@
+// json.go - dump the input as an array of JSON records
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// bytes per record: a hexdump line; and a base64 line of 64 chars
+const (
+	_JsonHexLen = 16
+	_JsonB64Len = 48
+)
+
+// a record of the hex modes
+type hexRecord struct {
+	Offset uint64 `json:"offset"`
+	Bytes  string `json:"bytes"`
+	ASCII  string `json:"ascii"`
+}
+
+// a record of the b64 mode
+type b64Record struct {
+	Offset uint64 `json:"offset"`
+	Base64 string `json:"base64"`
+}
+
+// jsonDumper writes the input as a JSON array of records - one per
+// line - as it's read; so a large input needn't be held in memory.
+type jsonDumper struct {
+	fn   string
+	bio  *bufio.Writer
+	addr uint64
+	b64  bool
+	lane textLane
+
+	// partial record
+	buf []byte
+	n   int
+
+	started bool
+}
+
+var _ dumper = &jsonDumper{}
+
+// NewJsonDumper returns a dumper of hex records - or base64 ones if
+// 'b64' is true; offsets start at 'addr'.
+func NewJsonDumper(wr io.Writer, fn string, addr uint64, b64 bool, lane textLane) dumper {
+	sz := _JsonHexLen
+	if b64 {
+		sz = _JsonB64Len
+	}
+
+	d := &jsonDumper{
+		fn:   fn,
+		bio:  bufio.NewWriterSize(wr, _BUFSZ),
+		addr: addr,
+		b64:  b64,
+		lane: lane,
+		buf:  make([]byte, sz),
+	}
+	return d
+}
+
+func (d *jsonDumper) Write(b []byte) error {
+	for len(b) > 0 {
+		m := copy(d.buf[d.n:], b)
+		d.n += m
+		b = b[m:]
+
+		if d.n == len(d.buf) {
+			if err := d.emit(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *jsonDumper) Close() error {
+	if d.n > 0 {
+		if err := d.emit(); err != nil {
+			return err
+		}
+	}
+
+	s := "\n]\n"
+	if !d.started {
+		s = "[]\n"
+	}
+	if _, err := d.bio.WriteString(s); err != nil {
+		return fmt.Errorf("%s: %s", d.fn, err)
+	}
+	if err := d.bio.Flush(); err != nil {
+		return fmt.Errorf("%s: %s", d.fn, err)
+	}
+	return nil
+}
+
+// emit the current (possibly partial) record
+func (d *jsonDumper) emit() error {
+	b := d.buf[:d.n]
+
+	var r any
+	if d.b64 {
+		r = &b64Record{d.addr, base64.StdEncoding.EncodeToString(b)}
+	} else {
+		r = &hexRecord{d.addr, hex.EncodeToString(b), string(d.lane(nil, b))}
+	}
+
+	j, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("%s: %s", d.fn, err)
+	}
+
+	sep := ",\n"
+	if !d.started {
+		sep = "[\n"
+		d.started = true
+	}
+	if _, err := d.bio.WriteString(sep); err != nil {
+		return fmt.Errorf("%s: %s", d.fn, err)
+	}
+	if _, err := d.bio.Write(j); err != nil {
+		return fmt.Errorf("%s: %s", d.fn, err)
+	}
+
+	d.addr += uint64(d.n)
+	d.n = 0
+	return nil
+}
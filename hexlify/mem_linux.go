@@ -0,0 +1,244 @@
+// mem_linux.go - hexdump the memory of a running process
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// memOpts are the options of the mem mode
+type memOpts struct {
+	skip, count uint64
+
+	// re-read and dump the region every 'refresh'; 0 is once
+	refresh time.Duration
+
+	// clear the screen before each refresh
+	clear bool
+
+	mkdump func(w io.Writer, fn string, addr uint64) dumper
+}
+
+// a mapped range of the process address space: [start, end)
+type memRegion struct {
+	start, end uint64
+	name       string
+}
+
+// doMem dumps the memory of the process in args[0] at the region
+// args[1] (the whole of its first mapping by default); the region is
+// one of:
+//
+//	ADDR       from ADDR to the end of the mapping that holds it
+//	ADDR-END   the bytes from ADDR up to END
+//	NAME       the mapping - or contiguous mappings - of NAME in
+//	           /proc/PID/maps; e.g. [stack], [heap] or libc.so.6
+//
+// --skip and --count select the bytes within the region.
+func doMem(wr io.Writer, args []string, o *memOpts) error {
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("mem needs a pid and an optional region. Try '%s --help'", Z)
+	}
+
+	pid, err := strconv.Atoi(args[0])
+	if err != nil || pid <= 0 {
+		return fmt.Errorf("mem: invalid pid '%s'", args[0])
+	}
+
+	where := ""
+	if len(args) > 1 {
+		where = args[1]
+	}
+
+	r, err := findRegion(pid, where)
+	if err != nil {
+		return err
+	}
+
+	start := r.start + o.skip
+	if start < r.start || start >= r.end {
+		return fmt.Errorf("mem: skip %d is past the end of %s", o.skip, r)
+	}
+	n := r.end - start
+	if o.count > 0 {
+		n = min(n, o.count)
+	}
+	if start+n > math.MaxInt64 {
+		return fmt.Errorf("mem: %s is out of range", r)
+	}
+
+	fn := fmt.Sprintf("/proc/%d/mem", pid)
+	fd, err := os.Open(fn)
+	if err != nil {
+		return memErr(pid, err)
+	}
+	defer fd.Close()
+
+	dump := func() error {
+		dd := o.mkdump(wr, fn, start)
+		src := io.NewSectionReader(fd, int64(start), int64(n))
+
+		// what was read before an error is still shown
+		in := &input{fn: fn}
+		if err := in.copy(src, dd); err != nil {
+			dd.Close()
+			return memErr(pid, err)
+		}
+		return dd.Close()
+	}
+
+	if o.refresh == 0 {
+		return dump()
+	}
+
+	for {
+		hdr := fmt.Sprintf("# pid %d %#x-%#x %s %s\n", pid, start, start+n,
+			r.name, time.Now().Format(time.TimeOnly))
+		if o.clear {
+			hdr = "\033[H\033[2J" + hdr
+		}
+		if _, err := io.WriteString(wr, hdr); err != nil {
+			return err
+		}
+		if err := dump(); err != nil {
+			return err
+		}
+
+		time.Sleep(o.refresh)
+
+		// a process that's gone (or a zombie) has no mappings
+		if m, err := readMaps(pid); err != nil || len(m) == 0 {
+			Warn("mem: pid %d has exited", pid)
+			return nil
+		}
+	}
+}
+
+// findRegion returns the region of the process 'pid' described by 'where'
+func findRegion(pid int, where string) (memRegion, error) {
+	maps, err := readMaps(pid)
+	if err != nil {
+		return memRegion{}, err
+	}
+	if len(maps) == 0 {
+		return memRegion{}, fmt.Errorf("mem: pid %d has no mappings", pid)
+	}
+
+	if len(where) == 0 {
+		return maps[0], nil
+	}
+
+	if a, b, ok := strings.Cut(where, "-"); ok {
+		start, err1 := strconv.ParseUint(a, 0, 64)
+		end, err2 := strconv.ParseUint(b, 0, 64)
+		if err1 == nil && err2 == nil {
+			if end <= start {
+				return memRegion{}, fmt.Errorf("mem: empty region '%s'", where)
+			}
+			return memRegion{start, end, nameOf(maps, start)}, nil
+		}
+	}
+
+	if a, err := strconv.ParseUint(where, 0, 64); err == nil {
+		for _, r := range maps {
+			if a >= r.start && a < r.end {
+				r.start = a
+				return r, nil
+			}
+		}
+		return memRegion{}, fmt.Errorf("mem: %#x isn't mapped in pid %d", a, pid)
+	}
+
+	// the first mapping of that name and the ones right after it
+	for i, r := range maps {
+		if r.name != where && filepath.Base(r.name) != where {
+			continue
+		}
+		for _, x := range maps[i+1:] {
+			if x.name != r.name || x.start != r.end {
+				break
+			}
+			r.end = x.end
+		}
+		return r, nil
+	}
+	return memRegion{}, fmt.Errorf("mem: no mapping '%s' in pid %d", where, pid)
+}
+
+// readMaps returns the mappings in /proc/PID/maps; each line is:
+//
+//	START-END PERMS OFFSET DEV INODE [NAME]
+func readMaps(pid int) ([]memRegion, error) {
+	fd, err := os.Open(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return nil, memErr(pid, err)
+	}
+	defer fd.Close()
+
+	var v []memRegion
+	rd := bufio.NewScanner(fd)
+	for rd.Scan() {
+		f := strings.Fields(rd.Text())
+		if len(f) < 5 {
+			continue
+		}
+
+		a, b, _ := strings.Cut(f[0], "-")
+		start, err1 := strconv.ParseUint(a, 16, 64)
+		end, err2 := strconv.ParseUint(b, 16, 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("mem: pid %d: malformed map '%s'", pid, f[0])
+		}
+
+		v = append(v, memRegion{start, end, strings.Join(f[5:], " ")})
+	}
+	if err := rd.Err(); err != nil {
+		return nil, memErr(pid, err)
+	}
+	return v, nil
+}
+
+// name of the mapping that holds 'a'
+func nameOf(maps []memRegion, a uint64) string {
+	for _, r := range maps {
+		if a >= r.start && a < r.end {
+			return r.name
+		}
+	}
+	return ""
+}
+
+func (r memRegion) String() string {
+	if len(r.name) > 0 {
+		return fmt.Sprintf("%#x-%#x (%s)", r.start, r.end, r.name)
+	}
+	return fmt.Sprintf("%#x-%#x", r.start, r.end)
+}
+
+// memErr explains the usual ways reading another process fails
+func memErr(pid int, err error) error {
+	switch {
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, syscall.ESRCH):
+		return fmt.Errorf("mem: pid %d: no such process: %w", pid, err)
+	case errors.Is(err, os.ErrPermission):
+		return fmt.Errorf("mem: pid %d: %w; it needs ptrace access (see ptrace(2) and /proc/sys/kernel/yama/ptrace_scope)", pid, err)
+	case errors.Is(err, syscall.EIO):
+		return fmt.Errorf("mem: pid %d: part of the region isn't mapped", pid)
+	}
+	return fmt.Errorf("mem: pid %d: %w", pid, err)
+}
@@ -0,0 +1,28 @@
+// mem_other.go - hexdump the memory of a running process
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// memOpts are the options of the mem mode
+type memOpts struct {
+	skip, count uint64
+	refresh     time.Duration
+	clear       bool
+
+	mkdump func(w io.Writer, fn string, addr uint64) dumper
+}
+
+// doMem needs /proc/PID/mem; only linux has it.
+func doMem(wr io.Writer, args []string, o *memOpts) error {
+	return fmt.Errorf("mem mode is only supported on linux")
+}
@@ -0,0 +1,273 @@
+// roundtrip.go - decode our output and compare it with the input
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"go-progs/internal/report"
+)
+
+// roundTrip hashes the input bytes as they're fed to the dumper and
+// the decoded output as it's written; the two must be identical.
+type roundTrip struct {
+	in  hash.Hash
+	out hash.Hash
+	dec decoder
+	n   int64
+
+	// first decoding error
+	err error
+}
+
+// a decoder is written the encoded output; it decodes what it can and
+// holds the remainder for the next write.
+type decoder interface {
+	io.Writer
+	flush() error
+}
+
+func newRoundTrip(ty enctype) *roundTrip {
+	r := &roundTrip{
+		in:  sha256.New(),
+		out: sha256.New(),
+	}
+
+	switch ty {
+	case encB64:
+		r.dec = &b64Decoder{out: r.out}
+	case encRawhex:
+		r.dec = &hexDecoder{out: r.out}
+	case encC:
+		r.dec = &cDecoder{hexDecoder: hexDecoder{out: r.out}}
+	default:
+		panic("unknown encoding mode")
+	}
+	return r
+}
+
+// output returns a writer that tees the encoded output to 'wr' and
+// the decoder; decoding errors are reported by verify().
+func (r *roundTrip) output(wr io.Writer) io.Writer {
+	return io.MultiWriter(wr, r)
+}
+
+func (r *roundTrip) Write(b []byte) (int, error) {
+	if r.err == nil {
+		_, r.err = r.dec.Write(b)
+	}
+	return len(b), nil
+}
+
+// input wraps 'd' so that all its input is hashed
+func (r *roundTrip) input(d dumper) dumper {
+	return &teeDumper{d, r}
+}
+
+// verify returns an error if the decoded output differs from the input
+func (r *roundTrip) verify() error {
+	if r.err != nil {
+		return report.Mismatch(fmt.Errorf("round-trip: %w", r.err))
+	}
+	if err := r.dec.flush(); err != nil {
+		return report.Mismatch(fmt.Errorf("round-trip: %w", err))
+	}
+	if !bytes.Equal(r.in.Sum(nil), r.out.Sum(nil)) {
+		return report.Mismatch(fmt.Errorf("round-trip: decoded output doesn't match %d bytes of input", r.n))
+	}
+	return nil
+}
+
+type teeDumper struct {
+	dumper
+	r *roundTrip
+}
+
+func (t *teeDumper) Write(b []byte) error {
+	t.r.in.Write(b)
+	t.r.n += int64(len(b))
+	return t.dumper.Write(b)
+}
+
+var errBadEncoding = errors.New("invalid character in output")
+
+// hex digits; whitespace is ignored
+type hexDecoder struct {
+	out  io.Writer
+	nib  byte
+	half bool
+}
+
+func (h *hexDecoder) Write(b []byte) (int, error) {
+	for _, c := range b {
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		if err := h.digit(c); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (h *hexDecoder) digit(c byte) error {
+	var v byte
+	switch {
+	case c >= '0' && c <= '9':
+		v = c - '0'
+	case c >= 'a' && c <= 'f':
+		v = c - 'a' + 10
+	case c >= 'A' && c <= 'F':
+		v = c - 'A' + 10
+	default:
+		return errBadEncoding
+	}
+
+	if !h.half {
+		h.nib, h.half = v, true
+		return nil
+	}
+	h.half = false
+	h.out.Write([]byte{h.nib<<4 | v})
+	return nil
+}
+
+func (h *hexDecoder) flush() error {
+	if h.half {
+		return errors.New("odd number of hex digits")
+	}
+	return nil
+}
+
+// where a cDecoder is in the C output
+const (
+	cPrologue = iota
+	cComment
+	cString
+	cArray
+	cEpilogue
+)
+
+// C arrays: the two hex digits after each '0x' in the braces of the
+// initializer. The comments and strings before it (the --emit-header
+// prologue has the input and header names) are skipped.
+type cDecoder struct {
+	hexDecoder
+
+	where int
+	prev  byte
+
+	// 0: looking for '0', 1: saw '0', 2,3: in the digits
+	state int
+}
+
+func (d *cDecoder) Write(b []byte) (int, error) {
+	for _, c := range b {
+		switch d.where {
+		case cPrologue:
+			switch {
+			case c == '{':
+				d.where = cArray
+			case c == '"':
+				d.where = cString
+			case c == '*' && d.prev == '/':
+				// so that "/*/" doesn't end the comment
+				d.where, c = cComment, 0
+			}
+		case cComment:
+			if c == '/' && d.prev == '*' {
+				d.where = cPrologue
+			}
+		case cString:
+			if c == '"' {
+				d.where = cPrologue
+			}
+		case cArray:
+			if c == '}' && d.state == 0 {
+				d.where = cEpilogue
+				break
+			}
+			if err := d.array(c); err != nil {
+				return 0, err
+			}
+		}
+		d.prev = c
+	}
+	return len(b), nil
+}
+
+// array decodes byte 'c' of the initializer
+func (d *cDecoder) array(c byte) error {
+	switch d.state {
+	case 0:
+		if c == '0' {
+			d.state = 1
+		}
+	case 1:
+		d.state = 0
+		if c == 'x' {
+			d.state = 2
+		}
+	default:
+		if err := d.digit(c); err != nil {
+			return err
+		}
+		d.state++
+		if d.state > 3 {
+			d.state = 0
+		}
+	}
+	return nil
+}
+
+func (d *cDecoder) flush() error {
+	if d.where != cEpilogue {
+		return errors.New("truncated C array")
+	}
+	return d.hexDecoder.flush()
+}
+
+// base64 in groups of 4; whitespace is ignored
+type b64Decoder struct {
+	out  io.Writer
+	pend []byte
+	buf  [3]byte
+}
+
+func (d *b64Decoder) Write(b []byte) (int, error) {
+	for _, c := range b {
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+
+		d.pend = append(d.pend, c)
+		if len(d.pend) < 4 {
+			continue
+		}
+
+		n, err := base64.StdEncoding.Decode(d.buf[:], d.pend)
+		if err != nil {
+			return 0, errBadEncoding
+		}
+		d.out.Write(d.buf[:n])
+		d.pend = d.pend[:0]
+	}
+	return len(b), nil
+}
+
+func (d *b64Decoder) flush() error {
+	if len(d.pend) > 0 {
+		return errors.New("truncated base64")
+	}
+	return nil
+}
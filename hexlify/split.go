@@ -0,0 +1,167 @@
+// split.go - write the output as a series of size limited files
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/opencoff/go-fio"
+)
+
+// splitWriter writes whole lines to the parts base.000, base.001 ...
+// each at most 'max' bytes. A line longer than that (e.g. the b64 and
+// hex output is one line) is split into chunks that are a multiple of 4
+// bytes; so that each part decodes on its own. A part is committed once it's
+// full; if the output is aborted, the parts written so far are removed
+// too.
+type splitWriter struct {
+	base string
+	max  int64
+
+	cur  *fio.SafeFile
+	name string
+	size int64
+	done []string
+
+	// partial line from the last write
+	line []byte
+
+	// true once all the parts are committed
+	closed bool
+}
+
+func newSplitWriter(base string, max int64) *splitWriter {
+	s := &splitWriter{
+		base: base,
+		max:  max,
+	}
+	return s
+}
+
+// the size of the parts holding a long line; the newline that ends it
+// still fits in the last part.
+func (s *splitWriter) chunk() int {
+	return int(max((s.max-1)&^3, 1))
+}
+
+func (s *splitWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			s.line = append(s.line, b...)
+
+			// a long line is written as it grows
+			if k := s.chunk(); len(s.line) > k {
+				n := len(s.line) - len(s.line)%k
+				if n == len(s.line) {
+					n -= k
+				}
+				if err := s.put(s.line[:n]); err != nil {
+					return 0, err
+				}
+				s.line = append(s.line[:0], s.line[n:]...)
+			}
+			break
+		}
+
+		s.line = append(s.line, b[:i+1]...)
+		b = b[i+1:]
+		if err := s.put(s.line); err != nil {
+			return 0, err
+		}
+		s.line = s.line[:0]
+	}
+	return n, nil
+}
+
+// put writes a line to the current part - or to the next one if it
+// doesn't fit. Lines that don't fit in a part are written in chunks.
+func (s *splitWriter) put(l []byte) error {
+	if int64(len(l)) > s.max {
+		for k := s.chunk(); len(l) > 0; {
+			n := min(k, len(l))
+			if err := s.put(l[:n]); err != nil {
+				return err
+			}
+			l = l[n:]
+		}
+		return nil
+	}
+
+	if s.cur == nil || s.size+int64(len(l)) > s.max {
+		if err := s.next(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.cur.Write(l); err != nil {
+		return fmt.Errorf("split: %w", err)
+	}
+	s.size += int64(len(l))
+	return nil
+}
+
+// next commits the current part and starts the next
+func (s *splitWriter) next() error {
+	if s.cur != nil {
+		if err := s.cur.Close(); err != nil {
+			return err
+		}
+		s.done = append(s.done, s.name)
+	}
+
+	fn := fmt.Sprintf("%s.%03d", s.base, len(s.done))
+	fd, err := fio.NewSafeFile(fn, 0, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	s.cur = fd
+	s.name = fn
+	s.size = 0
+	return nil
+}
+
+// Close writes the last (partial) line and commits the last part
+func (s *splitWriter) Close() error {
+	if len(s.line) > 0 {
+		if err := s.put(s.line); err != nil {
+			return err
+		}
+		s.line = s.line[:0]
+	}
+
+	// an empty output is still one (empty) part
+	if s.cur == nil {
+		if err := s.next(); err != nil {
+			return err
+		}
+	}
+
+	err := s.cur.Close()
+	if err == nil {
+		s.done = append(s.done, s.name)
+		s.closed = true
+	}
+	s.cur = nil
+	return err
+}
+
+// Abort removes the parts written so far; it does nothing after a
+// successful Close.
+func (s *splitWriter) Abort() {
+	if s.closed {
+		return
+	}
+	if s.cur != nil {
+		s.cur.Abort()
+		s.cur = nil
+	}
+	for _, fn := range s.done {
+		os.Remove(fn)
+	}
+	s.done = nil
+}
@@ -0,0 +1,49 @@
+// alias.go - interface descriptions (ifalias)
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// show the alias of each interface; set by --alias
+var Alias bool
+
+// shownAlias returns the alias of interface 'nm' if --alias is given
+func shownAlias(nm string) string {
+	if !Alias {
+		return ""
+	}
+	return ifAlias(nm)
+}
+
+// doSetAlias labels the interfaces in 'specs' (NAME=TEXT); an empty
+// TEXT clears the alias. Returns the exit code.
+func doSetAlias(specs []string) int {
+	for _, s := range specs {
+		nm, text, ok := strings.Cut(s, "=")
+		if !ok || len(nm) == 0 {
+			die("invalid alias '%s'; must be NAME=TEXT", s)
+		}
+		if _, err := net.InterfaceByName(nm); err != nil {
+			die("can't find interface %s", nm)
+		}
+		if strings.ContainsAny(text, "\n\x00") {
+			die("%s: alias can't have a newline or a NUL", nm)
+		}
+		if err := setAlias(nm, text); err != nil {
+			rep.Error(fmt.Errorf("%s: can't set alias: %w", nm, err))
+		}
+	}
+	return rep.Code()
+}
+
+// return the shell-quoted form of 's'
+func shQuote(s string) string {
+	return fmt.Sprintf("'%s'", strings.ReplaceAll(s, "'", `'\''`))
+}
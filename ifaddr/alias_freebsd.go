@@ -0,0 +1,91 @@
+// alias_freebsd.go - interface descriptions via SIOCGIFDESCR
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build freebsd
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// longest description the kernel takes by default (net.ifdescr_maxlen)
+const _IfDescrMax = 1024
+
+// struct ifreq with the ifr_buffer member of its union; the union is at
+// least as large as a sockaddr - the padding keeps ours as large.
+type ifreqBuffer struct {
+	name   [unix.IFNAMSIZ]byte
+	length uintptr
+	buffer unsafe.Pointer
+	_      [16]byte
+}
+
+// ifAlias returns the description of interface 'nm'; empty if it has
+// none
+func ifAlias(nm string) string {
+	buf := make([]byte, _IfDescrMax)
+	for i := 0; i < 2; i++ {
+		ifr, err := ifDescr(unix.SIOCGIFDESCR, nm, buf)
+		if err != nil {
+			return ""
+		}
+
+		// a short buffer comes back NULL with the length needed
+		if ifr.buffer == nil {
+			if int(ifr.length) <= len(buf) {
+				return ""
+			}
+			buf = make([]byte, ifr.length)
+			continue
+		}
+
+		if n := bytes.IndexByte(buf, 0); n >= 0 {
+			buf = buf[:n]
+		}
+		return string(buf)
+	}
+	return ""
+}
+
+// setAlias sets the description of 'nm' to 'text'; it needs root
+func setAlias(nm, text string) error {
+	// the length includes the NUL; zero clears the description
+	var buf []byte
+	if len(text) > 0 {
+		buf = append([]byte(text), 0)
+	}
+	_, err := ifDescr(unix.SIOCSIFDESCR, nm, buf)
+	return err
+}
+
+// ifDescr issues the description ioctl 'req' on 'nm' with 'buf'
+func ifDescr(req uintptr, nm string, buf []byte) (*ifreqBuffer, error) {
+	var ifr ifreqBuffer
+	if len(nm) >= len(ifr.name) {
+		return nil, errors.New("interface name too long")
+	}
+	copy(ifr.name[:], nm)
+	ifr.length = uintptr(len(buf))
+	if len(buf) > 0 {
+		ifr.buffer = unsafe.Pointer(&buf[0])
+	}
+
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+
+	_, _, e := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), req, uintptr(unsafe.Pointer(&ifr)))
+	if e != 0 {
+		return nil, e
+	}
+	return &ifr, nil
+}
@@ -0,0 +1,37 @@
+// alias_linux.go - interface descriptions via sysfs
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// ifAlias returns the alias of interface 'nm'; empty if it has none
+func ifAlias(nm string) string {
+	b, err := os.ReadFile("/sys/class/net/" + nm + "/ifalias")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(string(b), "\n")
+}
+
+// setAlias sets the alias of 'nm' to 'text'; it needs CAP_NET_ADMIN
+func setAlias(nm, text string) error {
+	fd, err := os.OpenFile("/sys/class/net/"+nm+"/ifalias", os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	// the kernel clears the alias on a lone newline
+	_, err = fd.WriteString(text + "\n")
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
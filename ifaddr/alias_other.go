@@ -0,0 +1,21 @@
+// alias_other.go - interface descriptions
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build !linux && !freebsd
+
+package main
+
+import (
+	"errors"
+)
+
+// ifAlias is not implemented on this platform
+func ifAlias(nm string) string {
+	return ""
+}
+
+func setAlias(nm, text string) error {
+	return errors.New("interface aliases are not supported on this platform")
+}
@@ -0,0 +1,123 @@
+// expect.go - compare interface addresses against an expected config
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+)
+
+// doExpect reads the JSON file 'fn' - an object mapping interface
+// names to a list of CIDRs:
+//
+//	{ "eth0": ["192.168.1.10/24", "2001:db8::10/64"] }
+//
+// and reports addresses that are missing or extra. IPv6 link-local
+// addresses are ignored unless the interface lists one. Returns the
+// exit code.
+func doExpect(fn string) int {
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		fatal(err)
+	}
+
+	var exp map[string][]string
+	if err := json.Unmarshal(b, &exp); err != nil {
+		die("%s: %s", fn, err)
+	}
+
+	names := make([]string, 0, len(exp))
+	for nm := range exp {
+		names = append(names, nm)
+	}
+	sort.Strings(names)
+
+	drift := false
+	for _, nm := range names {
+		want := make(map[string]bool)
+		wantLL := false
+		for _, s := range exp[nm] {
+			ip, ipn, err := net.ParseCIDR(s)
+			if err != nil {
+				die("%s: %s: %s", fn, nm, err)
+			}
+			if ip.To4() == nil && ip.IsLinkLocalUnicast() {
+				wantLL = true
+			}
+			want[cidr(ip, ipn.Mask)] = true
+		}
+
+		ii, err := net.InterfaceByName(nm)
+		if err != nil {
+			fmt.Printf("%s: missing interface\n", nm)
+			drift = true
+			continue
+		}
+
+		av, err := ii.Addrs()
+		if err != nil {
+			fatal(fmt.Errorf("can't get address for %s: %w", nm, err))
+		}
+
+		var extra []string
+		for _, a := range av {
+			ifa, ok := a.(*net.IPNet)
+			if !ok || ifa.IP.IsMulticast() {
+				continue
+			}
+
+			ip := ifa.IP
+			if ip.To4() == nil && ip.IsLinkLocalUnicast() && !wantLL {
+				continue
+			}
+
+			s := cidr(ip, ifa.Mask)
+			if want[s] {
+				delete(want, s)
+				continue
+			}
+			extra = append(extra, s)
+		}
+
+		missing := make([]string, 0, len(want))
+		for s := range want {
+			missing = append(missing, s)
+		}
+		sort.Strings(missing)
+		sort.Strings(extra)
+
+		for _, s := range missing {
+			fmt.Printf("%s: missing %s\n", nm, s)
+		}
+		for _, s := range extra {
+			fmt.Printf("%s: extra %s\n", nm, s)
+		}
+
+		if len(missing) > 0 || len(extra) > 0 {
+			drift = true
+		}
+	}
+
+	if drift {
+		return exitDrift
+	}
+	return exitOK
+}
+
+// canonical form of an interface address
+func cidr(ip net.IP, mask net.IPMask) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		if len(mask) == net.IPv6len {
+			mask = mask[12:]
+		}
+	}
+	n := &net.IPNet{IP: ip, Mask: mask}
+	return n.String()
+}
@@ -11,23 +11,145 @@ import (
 	"net"
 	"os"
 	"path"
+	"strconv"
 	"strings"
+	"time"
+
+	"go-progs/internal/report"
 )
 
 var V6, HW, Sh, All bool
 
+// IPv6 annotations and filters
+var V6Info, NoTemp, NoDepr, Bindable bool
+var V6tab v6table
+
+// exit codes besides those of the errors in 'rep'
+const (
+	exitOK     = report.ExitOK
+	exitDrift  = report.ExitMismatch
+	exitNoAddr = report.ExitNotFound
+	exitIO     = report.ExitIO
+	exitUsage  = report.ExitUsage
+)
+
+// errors and the exit code
+var rep *report.Reporter
+
 func main() {
 	var version bool
+	var waitFor string
+	var expect string
+	var listen bool
+	var metrics bool
+	var metricsAddr string
+	var topo, tree bool
+	var tmpl string
+	var setAliases []string
+	var natCheck bool
+	var stunServers []string
+	var stunTimeout time.Duration
+	var inventory bool
+	var jsonErrs bool
 
 	flag.BoolVarP(&version, "version", "", false, "Show version info and quit")
 	flag.BoolVarP(&V6, "ipv6", "6", false, "Show IPv6 address")
 	flag.BoolVarP(&HW, "mac", "m", false, "Show MAC address")
 	flag.BoolVarP(&Sh, "shell", "s", false, "Export shell vars (sh/ksh/bash)")
 	flag.BoolVarP(&All, "all", "a", false, "Also show loopback interface")
+	flag.BoolVarP(&V6Info, "v6-info", "I", false, "Annotate IPv6 addresses with scope, flags and lifetimes")
+	flag.BoolVarP(&NoTemp, "no-temporary", "", false, "Don't show temporary (privacy) IPv6 addresses")
+	flag.BoolVarP(&NoDepr, "no-deprecated", "", false, "Don't show deprecated IPv6 addresses")
+	flag.BoolVarP(&Bindable, "bindable", "", false, "Show only the addresses bind() will succeed on (no tentative, dadfailed or deprecated IPv6 addresses)")
+	flag.BoolVarP(&Alias, "alias", "d", false, "Show the alias (description) of each interface")
+	flag.StringArrayVarP(&setAliases, "set-alias", "", nil, "Set the alias of an interface to `NAME=TEXT`")
+	flag.BoolVarP(&topo, "topology", "t", false, "Show the kind, bridge/bond master and VLAN parent of each interface")
+	flag.BoolVarP(&tree, "tree", "", false, "Show the interfaces as a tree of masters, members and VLANs")
+	flag.StringVarP(&tmpl, "template", "", "", "Format each interface with the go template `T`")
+	flag.BoolVarP(&listen, "listen", "", false, "Show listening TCP and bound UDP sockets per interface")
+	flag.BoolVarP(&metrics, "metrics", "", false, "Show interface metrics in the prometheus text format")
+	flag.StringVarP(&metricsAddr, "metrics-listen", "", "", "Serve --metrics to one scrape on `ADDR` (e.g. :9500) and exit")
+	flag.BoolVarP(&natCheck, "nat-check", "", false, "Compare the local address with the one seen by STUN servers and guess the NAT type")
+	flag.StringSliceVarP(&stunServers, "stun-server", "", nil, "Use STUN server `H:P` for --nat-check")
+	flag.DurationVarP(&stunTimeout, "stun-timeout", "", 3*time.Second, "Wait at most `T` for each STUN server")
+	flag.BoolVarP(&inventory, "inventory", "", false, "Print the interfaces, default routes and DNS config as one JSON document")
+	flag.StringVarP(&expect, "expect", "", "", "Report drift from the addresses in JSON file `F`")
+	flag.StringVarP(&waitFor, "wait-for", "w", "", "Wait until interface `I[:TIMEOUT]` has a usable address")
+	flag.BoolVarP(&jsonErrs, "json-errors", "", false, "Write errors to stderr as JSON records")
 
 	usage := fmt.Sprintf("%s [options] [interface..]", os.Args[0])
 	flag.Usage = func() {
 		fmt.Printf("%s - Show one or more interface's addresses\nUsage: %s\n", os.Args[0], usage)
+		fmt.Printf(`
+With --wait-for, block until the interface has a non link-local address
+(IPv6 too when -6 is given) and print it. TIMEOUT is a duration (e.g. 30s)
+or plain seconds; without it, wait forever.
+
+With --expect, compare each interface named in the JSON file F against
+its list of addresses, e.g. {"eth0": ["192.168.1.10/24"]}, and print
+the missing and extra ones. IPv6 link-local addresses are ignored
+unless the interface lists one.
+
+With --listen, show the listening TCP and bound UDP sockets (and their
+processes, where permitted) grouped by the interface that owns the
+local address; '*' denotes a wildcard bind.
+
+With --metrics, print the link state, MTU, address counts and (on
+Linux) traffic counters of every interface in the prometheus text
+format. With --metrics-listen, serve them on http://ADDR/metrics to
+the first scrape and exit.
+
+With --topology, each interface is annotated with its kind (bridge,
+bond, vlan etc.), the bridge or bond it's a member of, its members and
+the parent and ID of a VLAN (Linux only). --tree shows the interfaces
+as a tree instead: the members of a bridge or bond below it and VLANs
+below their parent; interfaces without addresses are shown too.
+
+With --alias, the alias (description) of each interface is shown in
+quotes (/sys/class/net/NAME/ifalias on Linux and the interface
+description on FreeBSD); with --shell, as
+ALIAS_NAME. --set-alias NAME=TEXT labels an interface (as root); an
+empty TEXT clears the alias. It can be given more than once.
+
+With --template, each interface is formatted with the go template T
+(text/template), e.g. '{{.Name}} {{.IPv4}}'. The fields are Name,
+Index, MTU, MAC, Alias, Flags, Up, IPv4, IPv6, Addrs (both families), Kind,
+Master, Parent and VLAN; the address lists print space separated and
+can be ranged over. 'join .Addrs ","' and 'json .' are available too.
+IPv6 addresses are always in the fields; -6 isn't needed.
+
+With --nat-check, one UDP socket asks each STUN server for the address
+it's seen as; the answers are compared with the local address to guess
+the NAT type: none, cone (every server sees the same address and port;
+UDP hole punching should work) or symmetric (each server sees a
+different port; P2P needs a relay). The proxy variables in the
+environment are shown too. The default servers are:
+  %s
+
+With --inventory, the network facts of the machine are printed as one
+JSON document: the hostname, every interface (incl. loopback) with its
+MAC, flags, topology and addresses, the default routes (Linux only) and
+the resolver config from /etc/resolv.conf - with the upstream servers
+of systemd-resolved if its stub is the only nameserver. It's meant to
+be collected as is by inventory agents; parts that can't be read are
+left out with a warning and the exit code of the error.
+
+With --bindable, the IPv6 addresses that a service can't (or shouldn't)
+bind to are left out: those still doing duplicate address detection
+(tentative, unless optimistic), those that failed it (dadfailed) and
+deprecated ones - per the flags the kernel reports (Linux only). IPv4
+addresses are always bindable. It applies to --wait-for too: it waits
+for a bindable address.
+
+Exit codes: 0 on success, 1 if --expect found drift, 2 if a named
+interface has no address, 3 on I/O errors (incl. a --wait-for that
+timed out and a --nat-check that no STUN server answered) and 4 if
+permission was denied (e.g. --set-alias as a user); the largest
+applies. Invalid options and arguments (incl. a malformed --expect
+file) exit with 64. With --json-errors, each error is a JSON record:
+{"prog", "kind", "op", "path", "error"}.
+
+`, strings.Join(defaultStun, "\n  "))
 		flag.PrintDefaults()
 	}
 
@@ -39,7 +161,93 @@ func main() {
 		os.Exit(0)
 	}
 
+	rep = report.New(os.Args[0], jsonErrs)
+
+	if len(setAliases) > 0 {
+		if len(flag.Args()) > 0 {
+			die("--set-alias takes no args")
+		}
+		os.Exit(doSetAlias(setAliases))
+	}
+
+	if inventory {
+		if len(flag.Args()) > 0 {
+			die("--inventory shows all interfaces; no args allowed")
+		}
+		os.Exit(doInventory())
+	}
+
+	if V6Info || NoTemp || NoDepr {
+		V6 = true
+		t, err := v6attrs()
+		if err != nil {
+			fatal(fmt.Errorf("can't get IPv6 address attributes: %w", err))
+		}
+		V6tab = t
+	} else if Bindable {
+		t, err := v6attrs()
+		if err != nil {
+			fatal(fmt.Errorf("can't get IPv6 address attributes: %w", err))
+		}
+		V6tab = t
+	}
+
+	if len(tmpl) > 0 {
+		if Sh || tree {
+			die("--template can't be used with --shell or --tree")
+		}
+		t, err := parseTemplate(tmpl)
+		if err != nil {
+			die("%s", err)
+		}
+		Tmpl = t
+	}
+
+	if topo || tree || Tmpl != nil {
+		if Sh {
+			die("--topology and --tree can't be used with --shell")
+		}
+		t, err := topology()
+		if err != nil {
+			fatal(fmt.Errorf("can't get the interface topology: %w", err))
+		}
+		Topo = t
+	}
+
+	if len(waitFor) > 0 {
+		os.Exit(doWait(waitFor))
+	}
+
+	if natCheck {
+		os.Exit(doNatCheck(stunServers, stunTimeout))
+	}
+
+	if len(expect) > 0 {
+		os.Exit(doExpect(expect))
+	}
+
+	if listen {
+		os.Exit(doListen())
+	}
+
+	if metrics || len(metricsAddr) > 0 {
+		os.Exit(doMetrics(metricsAddr))
+	}
+
+	exit := exitOK
 	args := flag.Args()
+	if tree {
+		if len(args) > 0 {
+			die("--tree shows all interfaces; no args allowed")
+		}
+		iv, err := net.Interfaces()
+		if err != nil {
+			fatal(fmt.Errorf("can't get interface address: %w", err))
+		}
+		printTree(iv)
+		os.Exit(rep.Code())
+	}
+
 	if len(args) > 0 {
 		for _, nm := range args {
 			ii, err := net.InterfaceByName(nm)
@@ -50,12 +258,14 @@ func main() {
 			// If loopback is explicitly asked, we print it.
 			if printIf(ii) {
 				ifs = append(ifs, ii.Name)
+			} else {
+				exit = exitNoAddr
 			}
 		}
 	} else {
 		iv, err := net.Interfaces()
 		if err != nil {
-			die("can't get interface address: %s", err)
+			fatal(fmt.Errorf("can't get interface address: %w", err))
 		}
 
 		for i := range iv {
@@ -69,41 +279,107 @@ func main() {
 	if Sh {
 		fmt.Printf("IFACES='%s'\n", strings.Join(ifs, " "))
 	}
+	os.Exit(max(exit, rep.Code()))
 }
 
-// Return true if we actually printed something, false otherwise
-func printIf(ii *net.Interface) bool {
+// poll interval for --wait-for
+const _WaitPoll = 250 * time.Millisecond
+
+// doWait blocks until the interface in 'spec' (NAME[:TIMEOUT]) has a usable
+// address, prints it and returns the exit code.
+func doWait(spec string) int {
+	nm := spec
+	var tmo time.Duration
+
+	if i := strings.LastIndexByte(spec, ':'); i > 0 {
+		if d, err := parseTimeout(spec[i+1:]); err == nil {
+			nm, tmo = spec[:i], d
+		}
+	}
+
+	var deadline <-chan time.Time
+	if tmo > 0 {
+		deadline = time.After(tmo)
+	}
+
+	tick := time.NewTicker(_WaitPoll)
+	defer tick.Stop()
+
+	for {
+		// the interface may not even exist yet (hotplug, wifi)
+		if ii, err := net.InterfaceByName(nm); err == nil && hasUsableAddr(ii) {
+			// the interface was explicitly asked for; print it
+			// even if it is a loopback.
+			All = true
+			printIf(ii)
+			return exitOK
+		}
+
+		select {
+		case <-deadline:
+			rep.Error(fmt.Errorf("timed out waiting for %s after %s", nm, tmo))
+			return rep.Code()
+		case <-tick.C:
+		}
+
+		// DAD may have completed since
+		if Bindable {
+			if t, err := v6attrs(); err == nil {
+				V6tab = t
+			}
+		}
+	}
+}
+
+// timeouts are go durations or plain seconds
+func parseTimeout(s string) (time.Duration, error) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// return true if the interface has a non link-local address
+func hasUsableAddr(ii *net.Interface) bool {
 	av, err := ii.Addrs()
 	if err != nil {
-		die("can't get address for %s: %s", ii.Name, err)
+		return false
 	}
 
-	var addrs []string
-	var v6v []string
 	for _, a := range av {
 		ifa, ok := a.(*net.IPNet)
 		if !ok {
 			continue
 		}
 
-		if ifa.IP.IsLoopback() && !All {
-			return false
-		}
-
 		ip := ifa.IP
-		if ip.IsMulticast() {
+		if ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.IsUnspecified() {
 			continue
 		}
+		if ip.To4() != nil {
+			return true
+		}
+		if V6 && !(Bindable && !isBindable(ii, ip)) {
+			return true
+		}
+	}
+	return false
+}
 
-		if ip.To4() == nil {
-			v6v = append(v6v, fmt.Sprintf("%s", ifa))
-		} else {
-			addrs = append(addrs, fmt.Sprintf("%s", ifa))
+// Return true if we actually printed something, false otherwise
+func printIf(ii *net.Interface) bool {
+	if Tmpl != nil {
+		v4, v6, ok := ifAddrSets(ii)
+		if !ok || (len(v4)+len(v6) == 0 && !All) {
+			return false
 		}
+		printTemplate(ii, v4, v6)
+		return true
 	}
 
-	if V6 {
-		addrs = append(addrs, v6v...)
+	addrs, ok := ifAddrs(ii)
+	if !ok {
+		return false
 	}
 
 	if len(addrs) == 0 && !All {
@@ -117,6 +393,9 @@ func printIf(ii *net.Interface) bool {
 		if HW && len(ii.HardwareAddr) > 0 {
 			fmt.Printf("MACADDR_%s='%s'\n", nm, ii.HardwareAddr)
 		}
+		if a := shownAlias(nm); len(a) > 0 {
+			fmt.Printf("ALIAS_%s=%s\n", nm, shQuote(a))
+		}
 		return true
 	}
 
@@ -124,14 +403,86 @@ func printIf(ii *net.Interface) bool {
 	if HW {
 		fmt.Printf(" [%s]", ii.HardwareAddr)
 	}
+	if a := shownAlias(ii.Name); len(a) > 0 {
+		fmt.Printf(" %q", a)
+	}
+	if z := topoString(ii.Index); len(z) > 0 {
+		fmt.Printf(" <%s>", z)
+	}
 	fmt.Printf("\n")
 	return true
 }
 
-// die with error
+// ifAddrs returns the addresses of 'ii' to show; it returns false if
+// the interface is a loopback that must not be shown.
+func ifAddrs(ii *net.Interface) ([]string, bool) {
+	addrs, v6v, ok := ifAddrSets(ii)
+	if V6 {
+		addrs = append(addrs, v6v...)
+	}
+	return addrs, ok
+}
+
+// ifAddrSets is ifAddrs with the IPv4 and the IPv6 addresses apart
+func ifAddrSets(ii *net.Interface) ([]string, []string, bool) {
+	av, err := ii.Addrs()
+	if err != nil {
+		fatal(fmt.Errorf("can't get address for %s: %w", ii.Name, err))
+	}
+
+	var addrs []string
+	var v6v []string
+	for _, a := range av {
+		ifa, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		if ifa.IP.IsLoopback() && !All {
+			return nil, nil, false
+		}
+
+		ip := ifa.IP
+		if ip.IsMulticast() {
+			continue
+		}
+
+		if ip.To4() == nil {
+			a, ok := V6tab.lookup(ii, ip)
+			if ok && ((NoTemp && a.flags&v6Temporary > 0) || (NoDepr && a.flags&v6Deprecated > 0)) {
+				continue
+			}
+			if Bindable && !isBindable(ii, ip) {
+				continue
+			}
+
+			s := ifa.String()
+			if V6Info {
+				ann := v6scope(ip)
+				if z := a.String(); ok && len(z) > 0 {
+					ann += "," + z
+				}
+				s = fmt.Sprintf("%s(%s)", s, ann)
+			}
+			v6v = append(v6v, s)
+		} else {
+			addrs = append(addrs, fmt.Sprintf("%s", ifa))
+		}
+	}
+
+	return addrs, v6v, true
+}
+
+// die with a usage error
 func die(f string, v ...interface{}) {
 	warn(f, v...)
-	os.Exit(1)
+	os.Exit(exitUsage)
+}
+
+// fatal reports 'err' and exits with the exit code of its kind
+func fatal(err error) {
+	rep.Error(err)
+	os.Exit(rep.Code())
 }
 
 func warn(f string, v ...interface{}) {
@@ -0,0 +1,261 @@
+// inventory.go - the network facts of this machine as one JSON document
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// the resolver config; and the one systemd-resolved forwards to when
+// the first only names its stub
+const (
+	_ResolvConf   = "/etc/resolv.conf"
+	_ResolvedConf = "/run/systemd/resolve/resolv.conf"
+	_ResolvedStub = "127.0.0.53"
+)
+
+// inventory is what --inventory prints
+type inventory struct {
+	Hostname   string      `json:"hostname"`
+	Time       string      `json:"time"`
+	Interfaces []invIface  `json:"interfaces"`
+	Routes     []invRoute  `json:"default_routes"`
+	DNS        invResolver `json:"dns"`
+}
+
+type invIface struct {
+	Name  string   `json:"name"`
+	Index int      `json:"index"`
+	MTU   int      `json:"mtu"`
+	MAC   string   `json:"mac,omitempty"`
+	Flags []string `json:"flags"`
+	Up    bool     `json:"up"`
+	Alias string   `json:"alias,omitempty"`
+
+	// the topology of the interface (Linux only); see --topology
+	Kind   string `json:"kind,omitempty"`
+	Master string `json:"master,omitempty"`
+	Parent string `json:"parent,omitempty"`
+	VLAN   int    `json:"vlan,omitempty"`
+
+	Addrs []invAddr `json:"addrs"`
+}
+
+type invAddr struct {
+	CIDR   string `json:"cidr"`
+	Family string `json:"family"`
+	Scope  string `json:"scope"`
+
+	// IPv6 flags and lifetimes as shown by -I
+	Flags string `json:"flags,omitempty"`
+}
+
+// a default route
+type invRoute struct {
+	Family    string `json:"family"`
+	Gateway   string `json:"gateway"`
+	Interface string `json:"interface"`
+	Metric    uint32 `json:"metric"`
+}
+
+type invResolver struct {
+	Source      string   `json:"source"`
+	Nameservers []string `json:"nameservers"`
+	Search      []string `json:"search"`
+	Options     []string `json:"options"`
+
+	// the servers systemd-resolved forwards to if its stub is the
+	// only nameserver
+	Upstream []string `json:"upstream,omitempty"`
+}
+
+// doInventory prints the hostname, every interface and its addresses,
+// the default routes and the resolver config as one JSON document.
+// Whatever can't be read is left out and reported in 'rep'.
+func doInventory() int {
+	inv := inventory{
+		Time:       time.Now().UTC().Format(time.RFC3339),
+		Interfaces: []invIface{},
+		Routes:     []invRoute{},
+	}
+
+	var err error
+	if inv.Hostname, err = os.Hostname(); err != nil {
+		rep.Error(fmt.Errorf("can't get the hostname: %w", err))
+	}
+
+	if Topo, err = topology(); err != nil {
+		rep.Error(fmt.Errorf("can't get the interface topology: %w", err))
+	}
+	if V6tab, err = v6attrs(); err != nil {
+		rep.Error(fmt.Errorf("can't get IPv6 address attributes: %w", err))
+	}
+
+	iv, err := net.Interfaces()
+	if err != nil {
+		fatal(fmt.Errorf("can't get interface address: %w", err))
+	}
+	for i := range iv {
+		d, err := invInterface(&iv[i])
+		if err != nil {
+			rep.Error(fmt.Errorf("can't get address for %s: %w", iv[i].Name, err))
+		}
+		inv.Interfaces = append(inv.Interfaces, d)
+	}
+
+	rv, err := defaultRoutes()
+	if err != nil {
+		rep.Error(fmt.Errorf("can't get the default routes: %w", err))
+	}
+	sort.SliceStable(rv, func(i, j int) bool {
+		return rv[i].Metric < rv[j].Metric
+	})
+	inv.Routes = append(inv.Routes, rv...)
+
+	if inv.DNS, err = resolver(); err != nil {
+		rep.Error(err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(&inv); err != nil {
+		fatal(err)
+	}
+	return rep.Code()
+}
+
+// invInterface describes 'ii'; loopback and link-local addresses are
+// listed too, multicast groups aren't.
+func invInterface(ii *net.Interface) (invIface, error) {
+	t := newIfData(ii, nil, nil)
+	d := invIface{
+		Name:   t.Name,
+		Index:  t.Index,
+		MTU:    t.MTU,
+		MAC:    t.MAC,
+		Flags:  strings.Split(t.Flags, "|"),
+		Up:     t.Up,
+		Alias:  t.Alias,
+		Kind:   t.Kind,
+		Master: t.Master,
+		Parent: t.Parent,
+		VLAN:   t.VLAN,
+		Addrs:  []invAddr{},
+	}
+	if ii.Flags == 0 {
+		d.Flags = []string{}
+	}
+
+	av, err := ii.Addrs()
+	if err != nil {
+		return d, err
+	}
+
+	for _, a := range av {
+		ifa, ok := a.(*net.IPNet)
+		if !ok || ifa.IP.IsMulticast() {
+			continue
+		}
+
+		ip := ifa.IP
+		if ip.To4() != nil {
+			d.Addrs = append(d.Addrs, invAddr{ifa.String(), "ipv4", v4scope(ip), ""})
+			continue
+		}
+
+		e := invAddr{ifa.String(), "ipv6", v6scope(ip), ""}
+		if at, ok := V6tab.lookup(ii, ip); ok {
+			e.Flags = at.String()
+		}
+		d.Addrs = append(d.Addrs, e)
+	}
+	return d, nil
+}
+
+// scope of an IPv4 address
+func v4scope(ip net.IP) string {
+	switch {
+	case ip.IsLoopback():
+		return "host"
+	case ip.IsLinkLocalUnicast():
+		return "link"
+	case ip.IsPrivate():
+		return "private"
+	case ip.IsGlobalUnicast():
+		return "global"
+	}
+	return "unknown"
+}
+
+// resolver returns the resolver config; a missing resolv.conf is an
+// empty config.
+func resolver() (invResolver, error) {
+	r, err := readResolvConf(_ResolvConf)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return r, err
+	}
+
+	if len(r.Nameservers) == 1 && r.Nameservers[0] == _ResolvedStub {
+		if u, err := readResolvConf(_ResolvedConf); err == nil {
+			r.Upstream = u.Nameservers
+		}
+	}
+	return r, nil
+}
+
+// readResolvConf parses the resolv.conf(5) file 'fn'
+func readResolvConf(fn string) (invResolver, error) {
+	r := invResolver{
+		Source:      fn,
+		Nameservers: []string{},
+		Search:      []string{},
+		Options:     []string{},
+	}
+
+	fd, err := os.Open(fn)
+	if err != nil {
+		return r, err
+	}
+	defer fd.Close()
+
+	rd := bufio.NewScanner(fd)
+	for rd.Scan() {
+		line := rd.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+
+		f := strings.Fields(line)
+		if len(f) < 2 {
+			continue
+		}
+
+		// the last of 'domain' and 'search' wins
+		switch f[0] {
+		case "nameserver":
+			r.Nameservers = append(r.Nameservers, f[1])
+		case "domain":
+			r.Search = f[1:2]
+		case "search":
+			r.Search = f[1:]
+		case "options":
+			r.Options = append(r.Options, f[1:]...)
+		}
+	}
+	return r, rd.Err()
+}
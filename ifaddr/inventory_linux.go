@@ -0,0 +1,98 @@
+// inventory_linux.go - default routes from /proc/net
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// route flags in /proc/net/{route,ipv6_route}
+const (
+	_RTF_UP      = 0x0001
+	_RTF_GATEWAY = 0x0002
+	_RTF_REJECT  = 0x0200
+)
+
+// defaultRoutes returns the IPv4 and IPv6 default routes
+func defaultRoutes() ([]invRoute, error) {
+	v4, err := procRoutes("/proc/net/route", 1, func(f []string) (invRoute, bool) {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask ..
+		if len(f) < 8 || f[1] != "00000000" || f[7] != "00000000" {
+			return invRoute{}, false
+		}
+		gw, err := hex.DecodeString(f[2])
+		if err != nil || len(gw) != net.IPv4len {
+			return invRoute{}, false
+		}
+
+		// the address is in host byte order
+		ip := make(net.IP, net.IPv4len)
+		binary.NativeEndian.PutUint32(ip, binary.BigEndian.Uint32(gw))
+		return invRoute{"ipv4", ip.String(), f[0], hexU32(f[6])}, routeFlags(f[3])
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	v6, err := procRoutes("/proc/net/ipv6_route", 0, func(f []string) (invRoute, bool) {
+		// dst dst_len src src_len nexthop metric refcnt use flags iface
+		if len(f) < 10 || f[1] != "00" || strings.Trim(f[0], "0") != "" {
+			return invRoute{}, false
+		}
+		gw, err := hex.DecodeString(f[4])
+		if err != nil || len(gw) != net.IPv6len {
+			return invRoute{}, false
+		}
+		return invRoute{"ipv6", net.IP(gw).String(), f[9], hexU32(f[5])}, routeFlags(f[8])
+	})
+
+	// IPv6 may be disabled
+	if err != nil && !os.IsNotExist(err) {
+		return v4, err
+	}
+	return append(v4, v6...), nil
+}
+
+// procRoutes returns the routes in the /proc file 'fn' that 'match'
+// picks; the first 'skip' lines are headers.
+func procRoutes(fn string, skip int, match func(f []string) (invRoute, bool)) ([]invRoute, error) {
+	fd, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var rv []invRoute
+	rd := bufio.NewScanner(fd)
+	for n := 0; rd.Scan(); n++ {
+		if n < skip {
+			continue
+		}
+		if r, ok := match(strings.Fields(rd.Text())); ok {
+			rv = append(rv, r)
+		}
+	}
+	return rv, rd.Err()
+}
+
+// return true if the hex route flags 's' are of a usable gateway route
+func routeFlags(s string) bool {
+	fl := hexU32(s)
+	return fl&(_RTF_UP|_RTF_GATEWAY) == _RTF_UP|_RTF_GATEWAY && fl&_RTF_REJECT == 0
+}
+
+func hexU32(s string) uint32 {
+	n, _ := strconv.ParseUint(s, 16, 32)
+	return uint32(n)
+}
@@ -0,0 +1,14 @@
+// inventory_other.go - default routes
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build !linux
+
+package main
+
+// defaultRoutes is not implemented on this platform; the inventory
+// has no routes.
+func defaultRoutes() ([]invRoute, error) {
+	return nil, nil
+}
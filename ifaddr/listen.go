@@ -0,0 +1,92 @@
+// listen.go - show listening sockets per interface
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+)
+
+// a listening TCP socket or a bound (unconnected) UDP socket
+type sock struct {
+	proto string
+	ip    net.IP
+	port  int
+	inode uint64
+
+	// owning process(es); empty if we can't see them
+	procs []string
+}
+
+// doListen prints the listening sockets grouped by the interface
+// that owns the local address; wildcard binds are shown as '*'.
+// Returns the exit code.
+func doListen() int {
+	socks, err := listeners()
+	if err != nil {
+		fatal(fmt.Errorf("can't list sockets: %w", err))
+	}
+
+	// map local addresses to their interface
+	owner := make(map[string]string)
+	iv, err := net.Interfaces()
+	if err != nil {
+		fatal(fmt.Errorf("can't get interface address: %w", err))
+	}
+	for i := range iv {
+		ii := &iv[i]
+		av, err := ii.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range av {
+			if ifa, ok := a.(*net.IPNet); ok {
+				owner[ifa.IP.String()] = ii.Name
+			}
+		}
+	}
+
+	type entry struct {
+		ifname string
+		s      *sock
+	}
+
+	ev := make([]entry, 0, len(socks))
+	for i := range socks {
+		s := &socks[i]
+		nm := "*"
+		if !s.ip.IsUnspecified() {
+			if nm = owner[s.ip.String()]; len(nm) == 0 {
+				nm = "?"
+			}
+		}
+		ev = append(ev, entry{nm, s})
+	}
+
+	sort.Slice(ev, func(i, j int) bool {
+		a, b := &ev[i], &ev[j]
+		if a.ifname != b.ifname {
+			return a.ifname < b.ifname
+		}
+		if a.s.proto != b.s.proto {
+			return a.s.proto < b.s.proto
+		}
+		return a.s.port < b.s.port
+	})
+
+	for i := range ev {
+		e := &ev[i]
+		s := e.s
+		fmt.Printf("%s: %s %s", e.ifname, s.proto, net.JoinHostPort(s.ip.String(), strconv.Itoa(s.port)))
+		for _, p := range s.procs {
+			fmt.Printf(" %s", p)
+		}
+		fmt.Printf("\n")
+	}
+	return exitOK
+}
@@ -0,0 +1,156 @@
+// listen_linux.go - listening sockets from /proc/net
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// socket states in /proc/net/{tcp,udp}
+const (
+	_TCP_LISTEN = "0A"
+	_UDP_CLOSE  = "07"
+)
+
+func listeners() ([]sock, error) {
+	var socks []sock
+
+	tab := []struct {
+		fn, proto, state string
+	}{
+		{"/proc/net/tcp", "tcp", _TCP_LISTEN},
+		{"/proc/net/tcp6", "tcp6", _TCP_LISTEN},
+		{"/proc/net/udp", "udp", _UDP_CLOSE},
+		{"/proc/net/udp6", "udp6", _UDP_CLOSE},
+	}
+
+	for _, t := range tab {
+		v, err := procNet(t.fn, t.proto, t.state)
+		if err != nil {
+			// no ipv6 is not an error
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		socks = append(socks, v...)
+	}
+
+	// find the owners; we'll only see our own processes unless
+	// we're privileged.
+	procs := sockOwners()
+	for i := range socks {
+		s := &socks[i]
+		s.procs = procs[s.inode]
+	}
+	return socks, nil
+}
+
+// parse one of the /proc/net socket tables
+func procNet(fn, proto, state string) ([]sock, error) {
+	fd, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var socks []sock
+
+	rd := bufio.NewScanner(fd)
+	rd.Scan() // header
+	for rd.Scan() {
+		f := strings.Fields(rd.Text())
+		if len(f) < 10 || f[3] != state {
+			continue
+		}
+
+		ip, port, err := procAddr(f[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn, err)
+		}
+
+		ino, err := strconv.ParseUint(f[9], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: inode %w", fn, err)
+		}
+		socks = append(socks, sock{proto: proto, ip: ip, port: port, inode: ino})
+	}
+	return socks, rd.Err()
+}
+
+// decode "HEXADDR:HEXPORT"; the address is a sequence of 32-bit
+// words in host byte order.
+func procAddr(s string) (net.IP, int, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return nil, 0, fmt.Errorf("malformed address '%s'", s)
+	}
+
+	b, err := hex.DecodeString(s[:i])
+	if err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("malformed address '%s'", s)
+	}
+
+	port, err := strconv.ParseUint(s[i+1:], 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("malformed port '%s'", s)
+	}
+
+	ip := make(net.IP, len(b))
+	for j := 0; j < len(b); j += 4 {
+		binary.NativeEndian.PutUint32(ip[j:], binary.BigEndian.Uint32(b[j:]))
+	}
+	return ip, int(port), nil
+}
+
+// map socket inodes to "comm[pid]" of the processes holding them
+func sockOwners() map[uint64][]string {
+	m := make(map[uint64][]string)
+
+	fds, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	comm := make(map[string]string)
+	for _, fn := range fds {
+		targ, err := os.Readlink(fn)
+		if err != nil || !strings.HasPrefix(targ, "socket:[") {
+			continue
+		}
+
+		ino, err := strconv.ParseUint(strings.TrimSuffix(targ[8:], "]"), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		// "/proc/PID/fd/N"
+		pid := strings.Split(fn, "/")[2]
+		c, ok := comm[pid]
+		if !ok {
+			b, _ := os.ReadFile("/proc/" + pid + "/comm")
+			c = fmt.Sprintf("%s[%s]", strings.TrimSpace(string(b)), pid)
+			comm[pid] = c
+		}
+		m[ino] = appendUniq(m[ino], c)
+	}
+	return m
+}
+
+func appendUniq(v []string, s string) []string {
+	for _, x := range v {
+		if x == s {
+			return v
+		}
+	}
+	return append(v, s)
+}
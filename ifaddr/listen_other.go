@@ -0,0 +1,16 @@
+// listen_other.go - listening sockets
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+func listeners() ([]sock, error) {
+	return nil, errors.New("not supported on this platform")
+}
@@ -0,0 +1,158 @@
+// metrics.go - interface facts in the prometheus text format
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+)
+
+// metric families in the order they're written
+var metricHelp = []struct {
+	name, typ, help string
+}{
+	{"ifaddr_up", "gauge", "1 if the interface is administratively up"},
+	{"ifaddr_running", "gauge", "1 if the interface is operationally up"},
+	{"ifaddr_mtu_bytes", "gauge", "MTU of the interface"},
+	{"ifaddr_addresses", "gauge", "Number of unicast addresses by family"},
+	{"ifaddr_receive_bytes_total", "counter", "Bytes received"},
+	{"ifaddr_transmit_bytes_total", "counter", "Bytes transmitted"},
+	{"ifaddr_receive_packets_total", "counter", "Packets received"},
+	{"ifaddr_transmit_packets_total", "counter", "Packets transmitted"},
+	{"ifaddr_receive_errors_total", "counter", "Receive errors"},
+	{"ifaddr_transmit_errors_total", "counter", "Transmit errors"},
+}
+
+// doMetrics writes the metrics to stdout - or if 'addr' is given,
+// serves them to the first scrape on http://addr/metrics and exits.
+// Returns the exit code.
+func doMetrics(addr string) int {
+	if len(addr) == 0 {
+		if err := writeMetrics(os.Stdout); err != nil {
+			fatal(err)
+		}
+		return exitOK
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal(err)
+	}
+
+	// concurrent scrapes may each be the first to finish
+	var once sync.Once
+	done := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		var b bytes.Buffer
+		if err := writeMetrics(&b); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(b.Bytes())
+		once.Do(func() {
+			close(done)
+		})
+	})
+
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+
+	// let the response of the first scrape finish
+	<-done
+	srv.Shutdown(context.Background())
+	return exitOK
+}
+
+// writeMetrics writes the metrics of all interfaces to 'w'
+func writeMetrics(w io.Writer) error {
+	iv, err := net.Interfaces()
+	if err != nil {
+		return fmt.Errorf("can't get interfaces: %w", err)
+	}
+
+	sort.Slice(iv, func(i, j int) bool {
+		return iv[i].Name < iv[j].Name
+	})
+
+	samples := make(map[string][]string)
+	add := func(name, labels string, v uint64) {
+		samples[name] = append(samples[name], fmt.Sprintf("%s{%s} %d", name, labels, v))
+	}
+
+	for i := range iv {
+		ii := &iv[i]
+		lbl := fmt.Sprintf("iface=%q", ii.Name)
+
+		add("ifaddr_up", lbl, b2u(ii.Flags&net.FlagUp != 0))
+		add("ifaddr_running", lbl, b2u(ii.Flags&net.FlagRunning != 0))
+		add("ifaddr_mtu_bytes", lbl, uint64(ii.MTU))
+
+		var n4, n6 uint64
+		if av, err := ii.Addrs(); err == nil {
+			for _, a := range av {
+				ifa, ok := a.(*net.IPNet)
+				if !ok || ifa.IP.IsMulticast() {
+					continue
+				}
+				if ifa.IP.To4() != nil {
+					n4++
+				} else {
+					n6++
+				}
+			}
+		}
+		add("ifaddr_addresses", lbl+`,family="ipv4"`, n4)
+		add("ifaddr_addresses", lbl+`,family="ipv6"`, n6)
+
+		if c, ok := ifCounters(ii.Name); ok {
+			add("ifaddr_receive_bytes_total", lbl, c.rxBytes)
+			add("ifaddr_transmit_bytes_total", lbl, c.txBytes)
+			add("ifaddr_receive_packets_total", lbl, c.rxPackets)
+			add("ifaddr_transmit_packets_total", lbl, c.txPackets)
+			add("ifaddr_receive_errors_total", lbl, c.rxErrors)
+			add("ifaddr_transmit_errors_total", lbl, c.txErrors)
+		}
+	}
+
+	for _, m := range metricHelp {
+		v, ok := samples[m.name]
+		if !ok {
+			continue
+		}
+
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		for _, s := range v {
+			if _, err := fmt.Fprintln(w, s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// interface traffic counters
+type counters struct {
+	rxBytes, txBytes     uint64
+	rxPackets, txPackets uint64
+	rxErrors, txErrors   uint64
+}
+
+func b2u(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,45 @@
+// metrics_linux.go - interface counters from sysfs
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ifCounters reads the traffic counters of interface 'nm'
+func ifCounters(nm string) (counters, bool) {
+	var c counters
+
+	dir := filepath.Join("/sys/class/net", nm, "statistics")
+	for _, f := range []struct {
+		name string
+		v    *uint64
+	}{
+		{"rx_bytes", &c.rxBytes},
+		{"tx_bytes", &c.txBytes},
+		{"rx_packets", &c.rxPackets},
+		{"tx_packets", &c.txPackets},
+		{"rx_errors", &c.rxErrors},
+		{"tx_errors", &c.txErrors},
+	} {
+		b, err := os.ReadFile(filepath.Join(dir, f.name))
+		if err != nil {
+			return c, false
+		}
+
+		n, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+		if err != nil {
+			return c, false
+		}
+		*f.v = n
+	}
+	return c, true
+}
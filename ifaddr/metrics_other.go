@@ -0,0 +1,14 @@
+// metrics_other.go - interface counters
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build !linux
+
+package main
+
+// ifCounters is not implemented on this platform; only the address
+// and link state metrics are shown.
+func ifCounters(nm string) (counters, bool) {
+	return counters{}, false
+}
@@ -0,0 +1,283 @@
+// nat.go - compare the local address with the one seen by STUN servers
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// public STUN servers on different networks; the mapping of one local
+// port is compared across them.
+var defaultStun = []string{
+	"stun.l.google.com:19302",
+	"stun.cloudflare.com:3478",
+}
+
+// STUN (RFC 8489) message types and attributes
+const (
+	_StunCookie  = 0x2112a442
+	_StunBinding = 0x0001
+	_StunSuccess = 0x0101
+
+	_StunMapped    = 0x0001
+	_StunXorMapped = 0x0020
+
+	_StunHdrLen = 20
+
+	// requests are re-sent this often until the timeout
+	_StunRetry = 500 * time.Millisecond
+)
+
+// doNatCheck asks each STUN server in 'servers' for the address it sees
+// our UDP socket as and guesses the type of NAT from the answers.
+// Returns the exit code.
+func doNatCheck(servers []string, tmo time.Duration) int {
+	if len(servers) == 0 {
+		servers = defaultStun
+	}
+
+	for _, v := range proxyEnv() {
+		fmt.Printf("proxy: %s\n", v)
+	}
+
+	// one socket for all the servers; so their answers describe the
+	// same mapping.
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		fatal(err)
+	}
+	defer conn.Close()
+
+	lport := conn.LocalAddr().(*net.UDPAddr).Port
+	local := localAddr(servers[0])
+	if local != nil {
+		fmt.Printf("local: %s\n", net.JoinHostPort(local.String(), fmt.Sprintf("%d", lport)))
+	}
+
+	var mapped []*net.UDPAddr
+	for _, s := range servers {
+		a, err := stunQuery(conn, s, tmo)
+		if err != nil {
+			warn("%s: %s", s, err)
+			continue
+		}
+		fmt.Printf("%s: mapped %s\n", s, a)
+		mapped = append(mapped, a)
+	}
+
+	if len(mapped) == 0 {
+		fmt.Printf("nat: unknown; no STUN server answered (is outbound UDP blocked?)\n")
+		return exitIO
+	}
+	fmt.Printf("nat: %s\n", natType(mapped, lport))
+	return exitOK
+}
+
+// natType guesses the kind of NAT from the mapped addresses of one
+// local port as seen by different servers.
+func natType(mapped []*net.UDPAddr, lport int) string {
+	a := mapped[0]
+	if isLocalIP(a.IP) {
+		if a.Port == lport {
+			return "none; the servers see this host's own address"
+		}
+		return "none; the servers see this host's own address but another port (firewall?)"
+	}
+
+	port := "port preserved"
+	if a.Port != lport {
+		port = "port translated"
+	}
+
+	if len(mapped) == 1 {
+		return fmt.Sprintf("yes (%s); only one server answered - can't tell the mapping behaviour", port)
+	}
+
+	for _, b := range mapped[1:] {
+		if !b.IP.Equal(a.IP) || b.Port != a.Port {
+			return "symmetric (endpoint dependent mapping); hole punching is unlikely to work, use a relay"
+		}
+	}
+	return fmt.Sprintf("cone (endpoint independent mapping, %s): full, restricted or port restricted; hole punching should work", port)
+}
+
+// stunQuery sends a binding request to 'srv' on 'conn' and returns our
+// address as the server sees it.
+func stunQuery(conn *net.UDPConn, srv string, tmo time.Duration) (*net.UDPAddr, error) {
+	to, err := net.ResolveUDPAddr("udp4", srv)
+	if err != nil {
+		return nil, err
+	}
+
+	var txid [12]byte
+	rand.Read(txid[:])
+
+	req := make([]byte, _StunHdrLen)
+	binary.BigEndian.PutUint16(req[0:], _StunBinding)
+	binary.BigEndian.PutUint32(req[4:], _StunCookie)
+	copy(req[8:], txid[:])
+
+	buf := make([]byte, 1500)
+	deadline := time.Now().Add(tmo)
+	for time.Now().Before(deadline) {
+		if _, err := conn.WriteToUDP(req, to); err != nil {
+			return nil, err
+		}
+
+		wait := time.Now().Add(_StunRetry)
+		if wait.After(deadline) {
+			wait = deadline
+		}
+		conn.SetReadDeadline(wait)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				if errors.Is(err, os.ErrDeadlineExceeded) {
+					break
+				}
+				return nil, err
+			}
+
+			// stray answers to an earlier request or another server
+			if !from.IP.Equal(to.IP) || from.Port != to.Port {
+				continue
+			}
+			if a, err := parseStun(buf[:n], txid[:]); err == nil {
+				return a, nil
+			} else if err != errStunOther {
+				return nil, err
+			}
+		}
+	}
+	return nil, fmt.Errorf("no answer in %s", tmo)
+}
+
+// a STUN message that isn't the answer we're waiting for
+var errStunOther = errors.New("not our answer")
+
+// parseStun returns the mapped address in the binding response 'b' to
+// the request 'txid'.
+func parseStun(b, txid []byte) (*net.UDPAddr, error) {
+	if len(b) < _StunHdrLen || binary.BigEndian.Uint32(b[4:]) != _StunCookie || !bytes.Equal(b[8:20], txid) {
+		return nil, errStunOther
+	}
+	if typ := binary.BigEndian.Uint16(b[0:]); typ != _StunSuccess {
+		return nil, fmt.Errorf("binding request failed (type %#04x)", typ)
+	}
+
+	n := int(binary.BigEndian.Uint16(b[2:]))
+	if _StunHdrLen+n > len(b) {
+		return nil, errors.New("truncated answer")
+	}
+
+	// the cookie and txid the XOR'd address is masked with
+	var mask [16]byte
+	copy(mask[:], b[4:20])
+
+	var plain *net.UDPAddr
+	for a := b[_StunHdrLen : _StunHdrLen+n]; len(a) >= 4; {
+		typ := binary.BigEndian.Uint16(a[0:])
+		alen := int(binary.BigEndian.Uint16(a[2:]))
+		if 4+alen > len(a) {
+			return nil, errors.New("truncated attribute")
+		}
+		v := a[4 : 4+alen]
+
+		switch typ {
+		case _StunXorMapped:
+			return stunAddr(v, mask[:])
+		case _StunMapped:
+			if p, err := stunAddr(v, nil); err == nil {
+				plain = p
+			}
+		}
+
+		// attributes are padded to 4 bytes
+		a = a[min(len(a), 4+(alen+3)&^3):]
+	}
+
+	if plain != nil {
+		return plain, nil
+	}
+	return nil, errors.New("no mapped address in the answer")
+}
+
+// stunAddr decodes a (XOR-)MAPPED-ADDRESS; 'mask' is nil if it isn't
+// XOR'd.
+func stunAddr(v, mask []byte) (*net.UDPAddr, error) {
+	if len(v) < 4 {
+		return nil, errors.New("malformed address")
+	}
+
+	var n int
+	switch v[1] {
+	case 1:
+		n = net.IPv4len
+	case 2:
+		n = net.IPv6len
+	default:
+		return nil, fmt.Errorf("unknown address family %d", v[1])
+	}
+	if len(v) < 4+n {
+		return nil, errors.New("malformed address")
+	}
+
+	port := binary.BigEndian.Uint16(v[2:])
+	ip := make(net.IP, n)
+	copy(ip, v[4:4+n])
+	if mask != nil {
+		port ^= binary.BigEndian.Uint16(mask)
+		for i := range ip {
+			ip[i] ^= mask[i]
+		}
+	}
+	return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// localAddr returns the source address of the route to 'srv'
+func localAddr(srv string) net.IP {
+	c, err := net.Dial("udp4", srv)
+	if err != nil {
+		return nil
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).IP
+}
+
+// return true if 'ip' is an address of one of our interfaces
+func isLocalIP(ip net.IP) bool {
+	av, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range av {
+		if ifa, ok := a.(*net.IPNet); ok && ifa.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyEnv returns the proxy settings in the environment
+func proxyEnv() []string {
+	var v []string
+	for _, k := range []string{"http_proxy", "https_proxy", "all_proxy", "no_proxy"} {
+		for _, nm := range []string{k, strings.ToUpper(k)} {
+			if s := os.Getenv(nm); len(s) > 0 {
+				v = append(v, nm+"="+s)
+			}
+		}
+	}
+	return v
+}
@@ -0,0 +1,104 @@
+// template.go - format each interface with a go template
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// addrList prints as space separated addresses; templates can also
+// range over it.
+type addrList []string
+
+func (a addrList) String() string {
+	return strings.Join(a, " ")
+}
+
+// ifData is what a --template is executed with
+type ifData struct {
+	Name  string
+	Index int
+	MTU   int
+	MAC   string
+	Alias string
+	Flags string
+	Up    bool
+
+	// IPv6 addresses are shown even without -6
+	IPv4  addrList
+	IPv6  addrList
+	Addrs addrList
+
+	// the topology of the interface (Linux only); see --topology
+	Kind   string
+	Master string
+	Parent string
+	VLAN   int
+}
+
+// the --template to format each interface with; nil if not given
+var Tmpl *template.Template
+
+func parseTemplate(s string) (*template.Template, error) {
+	fn := template.FuncMap{
+		"join": func(v addrList, sep string) string {
+			return strings.Join(v, sep)
+		},
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}
+
+	// like docker, each interface is on a line of its own
+	if !strings.HasSuffix(s, "\n") {
+		s += "\n"
+	}
+	return template.New("ifaddr").Funcs(fn).Parse(s)
+}
+
+func newIfData(ii *net.Interface, v4, v6 []string) *ifData {
+	d := &ifData{
+		Name:  ii.Name,
+		Index: ii.Index,
+		MTU:   ii.MTU,
+		MAC:   ii.HardwareAddr.String(),
+		Alias: ifAlias(ii.Name),
+		Flags: ii.Flags.String(),
+		Up:    ii.Flags&net.FlagUp > 0,
+		IPv4:  addrList(v4),
+		IPv6:  addrList(v6),
+		Addrs: append(addrList(v4), v6...),
+	}
+
+	t, ok := Topo[ii.Index]
+	if !ok {
+		return d
+	}
+
+	d.Kind = t.kind
+	if t.master > 0 {
+		d.Master = ifName(t.master)
+	}
+	if p := t.lower(ii.Index); p > 0 {
+		d.Parent = ifName(p)
+	}
+	if t.kind == "vlan" {
+		d.VLAN = t.vlanID
+	}
+	return d
+}
+
+// printTemplate formats 'ii' with the --template
+func printTemplate(ii *net.Interface, v4, v6 []string) {
+	if err := Tmpl.Execute(os.Stdout, newIfData(ii, v4, v6)); err != nil {
+		fatal(err)
+	}
+}
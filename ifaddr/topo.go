@@ -0,0 +1,153 @@
+// topo.go - bridge, bond and VLAN relationships of interfaces
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// the place of an interface in the virtual network topology
+type ifTopo struct {
+	// link kind: bridge, bond, vlan, veth etc.; empty for physical
+	// interfaces
+	kind string
+
+	// ifindex of the bridge or bond this is enslaved to
+	master int
+
+	// ifindex of the lower interface of a VLAN (or macvlan etc.)
+	parent int
+	vlanID int
+}
+
+// kinds of links stacked on a lower interface; for others (e.g. veth)
+// the link may be an interface in another namespace.
+var stackedKinds = map[string]bool{
+	"vlan":    true,
+	"macvlan": true,
+	"macvtap": true,
+	"ipvlan":  true,
+}
+
+// lower returns the ifindex of the interface 't' is stacked on; zero
+// if it isn't.
+func (t *ifTopo) lower(idx int) int {
+	if stackedKinds[t.kind] && t.parent != idx {
+		return t.parent
+	}
+	return 0
+}
+
+// topology of every interface by ifindex; nil unless -t or --tree
+var Topo map[int]ifTopo
+
+// ifName returns the name of interface 'idx'
+func ifName(idx int) string {
+	if ii, err := net.InterfaceByIndex(idx); err == nil {
+		return ii.Name
+	}
+	return fmt.Sprintf("if%d", idx)
+}
+
+// topoString annotates interface 'idx' with its kind and relations,
+// e.g. "vlan 10 on eth0, bridge br0"
+func topoString(idx int) string {
+	t, ok := Topo[idx]
+	if !ok {
+		return ""
+	}
+
+	var v []string
+	switch p := t.lower(idx); {
+	case t.kind == "vlan" && p > 0:
+		v = append(v, fmt.Sprintf("vlan %d on %s", t.vlanID, ifName(p)))
+	case p > 0:
+		v = append(v, fmt.Sprintf("%s on %s", t.kind, ifName(p)))
+	case len(t.kind) > 0:
+		v = append(v, t.kind)
+	}
+
+	if t.master > 0 {
+		mk := Topo[t.master].kind
+		if len(mk) == 0 {
+			mk = "master"
+		}
+		v = append(v, fmt.Sprintf("%s %s", mk, ifName(t.master)))
+	}
+
+	// masters list their members
+	var members []string
+	for i, c := range Topo {
+		if c.master == idx {
+			members = append(members, ifName(i))
+		}
+	}
+	if len(members) > 0 {
+		sort.Strings(members)
+		v = append(v, "members "+strings.Join(members, " "))
+	}
+	return strings.Join(v, ", ")
+}
+
+// printTree prints the interfaces in 'iv' as a tree: the members of a
+// bridge or bond are below it and VLANs are below their parent.
+func printTree(iv []net.Interface) {
+	up := func(idx int) int {
+		t := Topo[idx]
+		if t.master > 0 {
+			return t.master
+		}
+		return t.lower(idx)
+	}
+
+	byIdx := make(map[int]*net.Interface)
+	kids := make(map[int][]int)
+	var roots []int
+	for i := range iv {
+		ii := &iv[i]
+		byIdx[ii.Index] = ii
+	}
+	for i := range iv {
+		idx := iv[i].Index
+		if p := up(idx); p > 0 && byIdx[p] != nil {
+			kids[p] = append(kids[p], idx)
+		} else {
+			roots = append(roots, idx)
+		}
+	}
+
+	var walk func(idx int, depth int)
+	walk = func(idx int, depth int) {
+		ii := byIdx[idx]
+		addrs, ok := ifAddrs(ii)
+		if !ok {
+			return
+		}
+
+		fmt.Printf("%s%s", strings.Repeat("    ", depth), ii.Name)
+		if k := Topo[idx].kind; len(k) > 0 {
+			fmt.Printf(" (%s)", k)
+		}
+		if len(addrs) > 0 {
+			fmt.Printf(": %s", strings.Join(addrs, ", "))
+		}
+		if HW {
+			fmt.Printf(" [%s]", ii.HardwareAddr)
+		}
+		fmt.Printf("\n")
+
+		for _, k := range kids[idx] {
+			walk(k, depth+1)
+		}
+	}
+
+	for _, idx := range roots {
+		walk(idx, 0)
+	}
+}
@@ -0,0 +1,121 @@
+// topo_linux.go - interface topology via rtnetlink
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// not defined in the syscall pkg
+const (
+	_IFLA_INFO_KIND = 0x1
+	_IFLA_INFO_DATA = 0x2
+	_IFLA_VLAN_ID   = 0x1
+)
+
+// topology queries the kernel for the kind, master and lower
+// interface of every link.
+func topology() (map[int]ifTopo, error) {
+	rib, err := syscall.NetlinkRIB(syscall.RTM_GETLINK, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, err
+	}
+
+	topo := make(map[int]ifTopo)
+	for i := range msgs {
+		m := &msgs[i]
+		if m.Header.Type != syscall.RTM_NEWLINK || len(m.Data) < syscall.SizeofIfInfomsg {
+			continue
+		}
+
+		ifim := (*syscall.IfInfomsg)(unsafe.Pointer(&m.Data[0]))
+		attrs, err := syscall.ParseNetlinkRouteAttr(m)
+		if err != nil {
+			continue
+		}
+
+		var t ifTopo
+		for _, ra := range attrs {
+			switch ra.Attr.Type {
+			case syscall.IFLA_MASTER:
+				t.master = int(u32(ra.Value))
+
+			case syscall.IFLA_LINK:
+				t.parent = int(u32(ra.Value))
+
+			case syscall.IFLA_LINKINFO:
+				for _, a := range nestedAttrs(ra.Value) {
+					switch a.Attr.Type {
+					case _IFLA_INFO_KIND:
+						t.kind = strings.TrimRight(string(a.Value), "\x00")
+					case _IFLA_INFO_DATA:
+						// the kind precedes its data
+						if t.kind == "vlan" {
+							t.vlanID = vlanID(a.Value)
+						}
+					}
+				}
+			}
+		}
+		topo[int(ifim.Index)] = t
+	}
+	return topo, nil
+}
+
+// vlanID returns the VLAN ID in the IFLA_INFO_DATA of a vlan
+func vlanID(b []byte) int {
+	for _, a := range nestedAttrs(b) {
+		if a.Attr.Type == _IFLA_VLAN_ID && len(a.Value) >= 2 {
+			return int(binary.NativeEndian.Uint16(a.Value))
+		}
+	}
+	return 0
+}
+
+func u32(b []byte) uint32 {
+	if len(b) < 4 {
+		return 0
+	}
+	return binary.NativeEndian.Uint32(b)
+}
+
+// nestedAttrs parses the rtattrs nested in 'b'
+func nestedAttrs(b []byte) []syscall.NetlinkRouteAttr {
+	var v []syscall.NetlinkRouteAttr
+	for len(b) >= syscall.SizeofRtAttr {
+		n := int(binary.NativeEndian.Uint16(b[0:2]))
+		if n < syscall.SizeofRtAttr || n > len(b) {
+			break
+		}
+
+		a := syscall.NetlinkRouteAttr{
+			Attr: syscall.RtAttr{
+				Len:  uint16(n),
+				Type: binary.NativeEndian.Uint16(b[2:4]) & 0x3fff,
+			},
+			Value: b[syscall.SizeofRtAttr:n],
+		}
+		v = append(v, a)
+
+		// attrs are 4 byte aligned
+		n = (n + 3) &^ 3
+		if n > len(b) {
+			break
+		}
+		b = b[n:]
+	}
+	return v
+}
@@ -0,0 +1,14 @@
+// topo_other.go - interface topology
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build !linux
+
+package main
+
+// topology is not implemented on this platform; every interface is
+// shown on its own.
+func topology() (map[int]ifTopo, error) {
+	return map[int]ifTopo{}, nil
+}
@@ -0,0 +1,112 @@
+// v6info.go - IPv6 address attributes
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// IPv6 address flags (same values as linux IFA_F_xxx)
+const (
+	v6Temporary  uint32 = 0x01
+	v6NoDAD      uint32 = 0x02
+	v6Optimistic uint32 = 0x04
+	v6DadFailed  uint32 = 0x08
+	v6Home       uint32 = 0x10
+	v6Deprecated uint32 = 0x20
+	v6Tentative  uint32 = 0x40
+	v6Permanent  uint32 = 0x80
+)
+
+// infinite lifetime
+const v6Forever uint32 = 0xffffffff
+
+var v6FlagNames = []struct {
+	f  uint32
+	nm string
+}{
+	{v6Temporary, "temporary"},
+	{v6NoDAD, "nodad"},
+	{v6Optimistic, "optimistic"},
+	{v6DadFailed, "dadfailed"},
+	{v6Home, "home"},
+	{v6Deprecated, "deprecated"},
+	{v6Tentative, "tentative"},
+	{v6Permanent, "permanent"},
+}
+
+// v6attr describes the OS provided attributes of an IPv6 address
+type v6attr struct {
+	flags     uint32
+	preferred uint32 // seconds
+	valid     uint32 // seconds
+}
+
+// v6table maps "ifindex/ip" to the address attributes
+type v6table map[string]v6attr
+
+func v6key(ifindex int, ip net.IP) string {
+	return fmt.Sprintf("%d/%s", ifindex, ip)
+}
+
+// lookup returns the attributes of an address and true if the OS
+// told us about it
+func (t v6table) lookup(ii *net.Interface, ip net.IP) (v6attr, bool) {
+	a, ok := t[v6key(ii.Index, ip)]
+	return a, ok
+}
+
+// bindable returns true if bind(2) will succeed on the address and it's
+// fit for new connections: DAD is done (or it's optimistic) and it isn't
+// deprecated.
+func (a v6attr) bindable() bool {
+	if a.flags&(v6DadFailed|v6Deprecated) > 0 {
+		return false
+	}
+	return a.flags&v6Tentative == 0 || a.flags&v6Optimistic > 0
+}
+
+// isBindable returns true if the IPv6 address 'ip' of 'ii' is bindable;
+// addresses the OS didn't tell us about are assumed to be.
+func isBindable(ii *net.Interface, ip net.IP) bool {
+	a, ok := V6tab.lookup(ii, ip)
+	return !ok || a.bindable()
+}
+
+// scope of an IPv6 address
+func v6scope(ip net.IP) string {
+	switch {
+	case ip.IsLoopback():
+		return "host"
+	case ip.IsLinkLocalUnicast():
+		return "link"
+	case ip[0] == 0xfc || ip[0] == 0xfd:
+		return "ula"
+	case ip.IsGlobalUnicast():
+		return "global"
+	}
+	return "unknown"
+}
+
+// String returns the annotation for an address
+func (a v6attr) String() string {
+	var v []string
+	for _, fl := range v6FlagNames {
+		if a.flags&fl.f > 0 {
+			v = append(v, fl.nm)
+		}
+	}
+
+	if a.preferred != v6Forever {
+		v = append(v, fmt.Sprintf("pref=%ds", a.preferred))
+	}
+	if a.valid != v6Forever {
+		v = append(v, fmt.Sprintf("valid=%ds", a.valid))
+	}
+	return strings.Join(v, ",")
+}
@@ -0,0 +1,75 @@
+// v6info_linux.go - IPv6 address attributes via rtnetlink
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// not defined in the syscall pkg
+const _IFA_FLAGS = 0x8
+
+// v6attrs queries the kernel for the attributes of all IPv6 addresses
+func v6attrs() (v6table, error) {
+	rib, err := syscall.NetlinkRIB(syscall.RTM_GETADDR, syscall.AF_INET6)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, err
+	}
+
+	t := make(v6table)
+	for i := range msgs {
+		m := &msgs[i]
+		if m.Header.Type != syscall.RTM_NEWADDR || len(m.Data) < syscall.SizeofIfAddrmsg {
+			continue
+		}
+
+		ifam := (*syscall.IfAddrmsg)(unsafe.Pointer(&m.Data[0]))
+		attrs, err := syscall.ParseNetlinkRouteAttr(m)
+		if err != nil {
+			continue
+		}
+
+		a := v6attr{
+			flags:     uint32(ifam.Flags),
+			preferred: v6Forever,
+			valid:     v6Forever,
+		}
+
+		var ip net.IP
+		for _, ra := range attrs {
+			switch ra.Attr.Type {
+			case syscall.IFA_ADDRESS:
+				ip = net.IP(ra.Value)
+
+			case syscall.IFA_CACHEINFO:
+				if len(ra.Value) >= 8 {
+					a.preferred = binary.NativeEndian.Uint32(ra.Value[0:4])
+					a.valid = binary.NativeEndian.Uint32(ra.Value[4:8])
+				}
+
+			case _IFA_FLAGS:
+				if len(ra.Value) >= 4 {
+					a.flags = binary.NativeEndian.Uint32(ra.Value)
+				}
+			}
+		}
+
+		if ip != nil {
+			t[v6key(int(ifam.Index), ip)] = a
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,14 @@
+// v6info_other.go - IPv6 address attributes
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+//go:build !linux
+
+package main
+
+// v6attrs is not implemented on this platform; addresses are shown
+// with just their scope.
+func v6attrs() (v6table, error) {
+	return v6table{}, nil
+}
@@ -0,0 +1,47 @@
+// cachetag.go - the Cache Directory Tagging Standard
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+// Package cachetag recognizes cache dirs tagged per the Cache Directory
+// Tagging Standard (https://bford.info/cachedir/): a dir is a cache if
+// it has a file named CACHEDIR.TAG that starts with the standard
+// signature. Like GNU tar and borg, the tools skip such dirs on request.
+package cachetag
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/opencoff/go-fio"
+)
+
+// Name of the tag file
+const Name = "CACHEDIR.TAG"
+
+// Signature is the first line of a valid tag
+const Signature = "Signature: 8a477f597d28d172789f06886806bc55"
+
+// IsCache returns true if 'dir' has a valid cache dir tag
+func IsCache(dir string) bool {
+	fd, err := os.Open(filepath.Join(dir, Name))
+	if err != nil {
+		return false
+	}
+	defer fd.Close()
+
+	var b [len(Signature)]byte
+	if _, err := io.ReadFull(fd, b[:]); err != nil {
+		return false
+	}
+	return bytes.Equal(b[:], []byte(Signature))
+}
+
+// Filter returns a walk filter that skips the cache dirs
+func Filter() func(fi *fio.Info) (bool, error) {
+	return func(fi *fio.Info) (bool, error) {
+		return fi.IsDir() && IsCache(fi.Path()), nil
+	}
+}
@@ -0,0 +1,235 @@
+// glob.go - include/exclude patterns shared by all the tools
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+// Package glob implements gitignore(5) like include/exclude patterns:
+//
+//   - a pattern without a '/' matches the basename at any depth
+//   - a pattern with a '/' is anchored to the root of the walk; a
+//     leading '/' anchors a pattern that has no other '/'
+//   - '*' and '?' don't match '/'; '**' matches any number of dirs
+//   - a trailing '/' only matches dirs
+//   - a leading '!' negates the pattern: it re-includes what earlier
+//     patterns excluded; the last matching pattern wins
+//
+// A file can't be re-included if one of its parent dirs is excluded:
+// the walker never descends excluded dirs.
+package glob
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/opencoff/go-fio"
+)
+
+type pattern struct {
+	src string
+	neg bool
+	dir bool
+
+	// true if the pattern matches just the basename
+	base bool
+	re   *regexp.Regexp
+}
+
+// Matcher holds an ordered list of patterns
+type Matcher struct {
+	pats []pattern
+}
+
+// New compiles 'pats'; if 'fold' is true, matches are case insensitive.
+func New(pats []string, fold bool) (*Matcher, error) {
+	m := &Matcher{
+		pats: make([]pattern, 0, len(pats)),
+	}
+
+	for _, s := range pats {
+		p, err := compile(s, fold)
+		if err != nil {
+			return nil, err
+		}
+		m.pats = append(m.pats, p)
+	}
+	return m, nil
+}
+
+// Excludes builds a matcher from the exclude and include patterns
+// given on the command line; includes are negated excludes that
+// follow all the excludes.
+func Excludes(excl, incl []string, fold bool) (*Matcher, error) {
+	pats := make([]string, 0, len(excl)+len(incl))
+	pats = append(pats, excl...)
+	for _, s := range incl {
+		pats = append(pats, "!"+s)
+	}
+	return New(pats, fold)
+}
+
+// Empty returns true if there are no patterns
+func (m *Matcher) Empty() bool {
+	return len(m.pats) == 0
+}
+
+// Match returns true if the path 'rel' (relative to the root of the
+// walk, '/' separated) is excluded.
+func (m *Matcher) Match(rel string, isDir bool) bool {
+	base := rel
+	if i := strings.LastIndexByte(rel, '/'); i >= 0 {
+		base = rel[i+1:]
+	}
+
+	excl := false
+	for i := range m.pats {
+		p := &m.pats[i]
+		if p.dir && !isDir {
+			continue
+		}
+
+		nm := rel
+		if p.base {
+			nm = base
+		}
+		if p.re.MatchString(nm) {
+			excl = !p.neg
+		}
+	}
+	return excl
+}
+
+// MatchPath returns true if the file 'rel' or one of its dirs is
+// excluded; for trees that are listed rather than walked (e.g. object
+// stores).
+func (m *Matcher) MatchPath(rel string) bool {
+	for i := 0; i < len(rel); i++ {
+		if rel[i] == '/' && m.Match(rel[:i], true) {
+			return true
+		}
+	}
+	return m.Match(rel, false)
+}
+
+// Filter returns a function suitable for walk.Options.Filter; entries
+// are matched relative to the longest of 'roots' that holds them. The
+// roots themselves are never excluded.
+func (m *Matcher) Filter(roots []string) func(fi *fio.Info) (bool, error) {
+	rv := make([]string, 0, len(roots))
+	for _, r := range roots {
+		// "/" becomes ""; so that "/x" is below it
+		rv = append(rv, strings.TrimSuffix(r, "/"))
+	}
+
+	sort.Slice(rv, func(i, j int) bool {
+		return len(rv[i]) > len(rv[j])
+	})
+
+	return func(fi *fio.Info) (bool, error) {
+		if len(m.pats) == 0 {
+			return false, nil
+		}
+
+		nm := fi.Path()
+		for _, r := range rv {
+			if strings.HasPrefix(nm, r+"/") {
+				return m.Match(nm[len(r)+1:], fi.IsDir()), nil
+			}
+		}
+		return false, nil
+	}
+}
+
+func compile(s string, fold bool) (pattern, error) {
+	p := pattern{src: s}
+
+	if strings.HasPrefix(s, "!") {
+		p.neg = true
+		s = s[1:]
+	}
+	if strings.HasSuffix(s, "/") {
+		p.dir = true
+		s = strings.TrimRight(s, "/")
+	}
+
+	if len(s) == 0 {
+		return p, fmt.Errorf("glob: empty pattern '%s'", p.src)
+	}
+
+	if strings.HasPrefix(s, "/") {
+		s = strings.TrimLeft(s, "/")
+	} else if !strings.Contains(s, "/") {
+		p.base = true
+	}
+
+	re, err := translate(s)
+	if err != nil {
+		return p, fmt.Errorf("glob: '%s': %w", p.src, err)
+	}
+
+	if fold {
+		re = "(?i)" + re
+	}
+
+	if p.re, err = regexp.Compile(re); err != nil {
+		return p, fmt.Errorf("glob: '%s': %w", p.src, err)
+	}
+	return p, nil
+}
+
+// translate a glob into an anchored regexp
+func translate(s string) (string, error) {
+	var b strings.Builder
+
+	b.WriteString("^")
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '*':
+			if i+1 < len(s) && s[i+1] == '*' {
+				i++
+				switch {
+				// "**/" - zero or more dirs
+				case i+1 < len(s) && s[i+1] == '/':
+					i++
+					b.WriteString("(?:.*/)?")
+				default:
+					b.WriteString(".*")
+				}
+				continue
+			}
+			b.WriteString("[^/]*")
+
+		case '?':
+			b.WriteString("[^/]")
+
+		case '[':
+			j := strings.IndexByte(s[i+1:], ']')
+			if j < 0 {
+				return "", fmt.Errorf("unterminated '['")
+			}
+			cls := s[i+1 : i+1+j]
+			if strings.HasPrefix(cls, "!") {
+				cls = "^" + cls[1:]
+			}
+			if len(cls) == 0 || cls == "^" {
+				return "", fmt.Errorf("empty character class")
+			}
+			b.WriteString("[" + strings.ReplaceAll(cls, `\`, `\\`) + "]")
+			i += j + 1
+
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				c = s[i]
+			}
+			b.WriteString(regexp.QuoteMeta(string(c)))
+
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String(), nil
+}
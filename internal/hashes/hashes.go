@@ -0,0 +1,68 @@
+// hashes.go - the hash algorithms known to the tools
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+// Package hashes is the table of hash algorithms by name; ghash
+// records these names in its manifests and the other tools accept the
+// same names.
+package hashes
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"sort"
+
+	"github.com/zeebo/blake3"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/sha3"
+)
+
+// Table maps an algorithm name to its constructor; the keyed hashes
+// use an all zero key.
+var Table = map[string]func() hash.Hash{
+	"sha256":   func() hash.Hash { return sha256.New() },
+	"sha512":   func() hash.Hash { return sha512.New() },
+	"sha3":     func() hash.Hash { return sha3.New512() },
+	"sha3-256": func() hash.Hash { return sha3.New256() },
+	"sha3-512": func() hash.Hash { return sha3.New512() },
+	"blake2s":  func() hash.Hash { return keyedHashGen1(blake2s.New256) },
+
+	"sha512-256":  func() hash.Hash { return sha512.New512_256() },
+	"blake2b":     func() hash.Hash { return keyedHashGen1(blake2b.New512) },
+	"blake2b-256": func() hash.Hash { return keyedHashGen1(blake2b.New256) },
+	"blake2b-512": func() hash.Hash { return keyedHashGen1(blake2b.New512) },
+
+	"blake3": func() hash.Hash { return keyedHashGen2(blake3.NewKeyed) },
+}
+
+// Names returns the sorted names of the algorithms
+func Names() []string {
+	v := make([]string, 0, len(Table))
+	for k := range Table {
+		v = append(v, k)
+	}
+	sort.Strings(v)
+	return v
+}
+
+func keyedHashGen1(hg func(key []byte) (hash.Hash, error)) hash.Hash {
+	var zeroes [32]byte
+	h, err := hg(zeroes[:])
+	if err != nil {
+		panic(fmt.Sprintf("keyed hash: %s", err))
+	}
+	return h
+}
+
+func keyedHashGen2(hg func(key []byte) (*blake3.Hasher, error)) hash.Hash {
+	var zeroes [32]byte
+	h, err := hg(zeroes[:])
+	if err != nil {
+		panic(fmt.Sprintf("keyed hash: %s", err))
+	}
+	return h
+}
@@ -0,0 +1,53 @@
+// manifest.go - read ghash manifests
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+// Package manifest has what the tools that read ghash manifests share:
+// decompressing them and splitting NUL terminated records.
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// leading bytes of compressed streams
+var (
+	GzipMagic = []byte{0x1f, 0x8b}
+	ZstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Decompress returns a reader of the manifest in 'fd' - decompressing
+// it if it's compressed with gzip or zstd.
+func Decompress(fd io.Reader) (io.Reader, error) {
+	rd := bufio.NewReader(fd)
+	b, _ := rd.Peek(len(ZstdMagic))
+
+	switch {
+	case bytes.HasPrefix(b, GzipMagic):
+		return gzip.NewReader(rd)
+	case bytes.HasPrefix(b, ZstdMagic):
+		zr, err := zstd.NewReader(rd)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return rd, nil
+}
+
+// SplitNul is a bufio.SplitFunc for NUL terminated records (ghash -z)
+func SplitNul(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,204 @@
+// report.go - error categories, exit codes and machine readable errors
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+// Package report classifies errors into a small set of categories that
+// map to exit codes shared by all the tools. Errors are written to
+// stderr either as text or as JSON records (one per line) so that
+// wrappers can tell "file changed" apart from "disk unreadable".
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/opencoff/go-fio/walk"
+)
+
+// Error categories
+const (
+	KindMismatch = "mismatch"
+	KindNotFound = "not-found"
+	KindIO       = "io"
+	KindPerm     = "permission"
+)
+
+// Exit codes; when several kinds of errors occur, the tools exit with
+// the largest code.
+const (
+	ExitOK       = 0
+	ExitMismatch = 1
+	ExitNotFound = 2
+	ExitIO       = 3
+	ExitPerm     = 4
+	ExitUsage    = 64
+)
+
+var codes = map[string]int{
+	KindMismatch: ExitMismatch,
+	KindNotFound: ExitNotFound,
+	KindIO:       ExitIO,
+	KindPerm:     ExitPerm,
+}
+
+// ErrMismatch denotes content that doesn't match its expected state:
+// a changed file, a modified manifest etc.
+var ErrMismatch = errors.New("integrity mismatch")
+
+type mismatchError struct {
+	error
+}
+
+func (e *mismatchError) Is(t error) bool {
+	return t == ErrMismatch
+}
+
+func (e *mismatchError) Unwrap() error {
+	return e.error
+}
+
+// Mismatch marks 'err' as an integrity mismatch; its text is unchanged.
+func Mismatch(err error) error {
+	return &mismatchError{err}
+}
+
+// Kind returns the category of 'err'
+func Kind(err error) string {
+	switch {
+	case errors.Is(err, ErrMismatch):
+		return KindMismatch
+	case errors.Is(err, fs.ErrNotExist):
+		return KindNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return KindPerm
+	}
+	return KindIO
+}
+
+// Record is the JSON form of an error
+type Record struct {
+	Prog  string `json:"prog"`
+	Kind  string `json:"kind"`
+	Op    string `json:"op,omitempty"`
+	Path  string `json:"path,omitempty"`
+	Error string `json:"error"`
+}
+
+// Reporter prints errors and tracks the exit code. It is safe for
+// concurrent use.
+type Reporter struct {
+	sync.Mutex
+
+	prog string
+	json bool
+	w    io.Writer
+
+	n    int
+	code int
+
+	// the first 'keep' errors are kept as records
+	keep int
+	kept []Record
+}
+
+// New creates a reporter for program 'prog' (usually os.Args[0]);
+// errors are written as JSON records if 'asJSON' is true.
+func New(prog string, asJSON bool) *Reporter {
+	r := &Reporter{
+		prog: prog,
+		json: asJSON,
+		w:    os.Stderr,
+	}
+	return r
+}
+
+// Error reports 'err'; errors joined with errors.Join() are
+// reported individually.
+func (r *Reporter) Error(err error) {
+	if err == nil {
+		return
+	}
+
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range j.Unwrap() {
+			r.Error(e)
+		}
+		return
+	}
+
+	k := Kind(err)
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.n++
+	r.code = max(r.code, codes[k])
+
+	if len(r.kept) < r.keep {
+		r.kept = append(r.kept, r.record(err, k))
+	}
+
+	if !r.json {
+		fmt.Fprintf(r.w, "%s: %s\n", r.prog, err)
+		return
+	}
+
+	rec := r.record(err, k)
+	b, _ := json.Marshal(&rec)
+	b = append(b, '\n')
+	r.w.Write(b)
+}
+
+// record returns 'err' of kind 'k' as a Record
+func (r *Reporter) record(err error, k string) Record {
+	rec := Record{
+		Prog:  filepath.Base(r.prog),
+		Kind:  k,
+		Error: err.Error(),
+	}
+
+	var pe *fs.PathError
+	var we *walk.Error
+	switch {
+	case errors.As(err, &we):
+		rec.Op, rec.Path = we.Op, we.Name
+	case errors.As(err, &pe):
+		rec.Op, rec.Path = pe.Op, pe.Path
+	}
+	return rec
+}
+
+// Keep keeps the first 'n' errors reported from now on; see Records().
+func (r *Reporter) Keep(n int) {
+	r.Lock()
+	defer r.Unlock()
+	r.keep = n
+}
+
+// Records returns the errors kept so far
+func (r *Reporter) Records() []Record {
+	r.Lock()
+	defer r.Unlock()
+	return append([]Record{}, r.kept...)
+}
+
+// Errors returns the number of errors reported so far
+func (r *Reporter) Errors() int {
+	r.Lock()
+	defer r.Unlock()
+	return r.n
+}
+
+// Code returns the exit code for the errors reported so far
+func (r *Reporter) Code() int {
+	r.Lock()
+	defer r.Unlock()
+	return r.code
+}
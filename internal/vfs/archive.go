@@ -0,0 +1,456 @@
+// archive.go - the members of an archive as a read-only tree
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An archive is named as SCHEME://ARCHIVE[::DIR]: ARCHIVE is a local
+// path and DIR a dir (or member) inside it; e.g. tar:///tmp/x.tgz::etc.
+// The members are indexed when the archive is first used; each is named
+// SCHEME://ARCHIVE::MEMBER.
+const _ArchiveSep = "::"
+
+// most symlinks resolved for one name; like the kernel's limit
+const _MaxLinks = 40
+
+// most archives indexed at a time by a backend; the least recently
+// used is closed once its open members are closed.
+const _MaxArchives = 64
+
+// archive suffixes and the scheme that reads them
+var archiveTypes = []struct {
+	suffix string
+	scheme string
+}{
+	{".tar", "tar"},
+	{".tar.gz", "tar"},
+	{".tgz", "tar"},
+	{".tar.bz2", "tar"},
+	{".tbz2", "tar"},
+	{".tbz", "tar"},
+	{".zip", "zip"},
+}
+
+// ArchiveURL returns the URL of the archive file 'fn' if its suffix
+// is that of an archive (e.g. x.tgz is tar://x.tgz); "" otherwise.
+func ArchiveURL(fn string) string {
+	lc := strings.ToLower(fn)
+	for _, t := range archiveTypes {
+		if strings.HasSuffix(lc, t.suffix) {
+			return t.scheme + "://" + fn
+		}
+	}
+	return ""
+}
+
+// a file or symlink in an archive
+type member struct {
+	name  string
+	size  int64
+	mtime time.Time
+
+	// target of a symlink
+	link string
+
+	// where the archive format finds the contents (e.g. an offset)
+	data any
+}
+
+// archive is the index of one archive file
+type archive struct {
+	scheme string
+	path   string
+
+	members map[string]*member
+
+	// every dir that's named or implied by a member
+	dirs map[string]bool
+
+	// sorted names of the members
+	names []string
+
+	open  func(m *member) (io.ReadCloser, error)
+	close func() error
+
+	sync.Mutex
+
+	// #members being read; and set if the archive is closed once
+	// they're done
+	refs    int
+	evicted bool
+
+	// when it was last used by its backend
+	used uint64
+}
+
+func newArchive(scheme, fn string) *archive {
+	a := &archive{
+		scheme:  scheme,
+		path:    fn,
+		members: make(map[string]*member),
+		dirs:    map[string]bool{"": true},
+	}
+	return a
+}
+
+// add member 'm'; names are cleaned and relative to the archive root
+func (a *archive) add(m *member) {
+	m.name = cleanMember(m.name)
+	if len(m.name) == 0 {
+		return
+	}
+	a.members[m.name] = m
+	a.addDir(path.Dir(m.name))
+}
+
+// addDir adds 'dn' and the dirs above it
+func (a *archive) addDir(dn string) {
+	for dn = cleanMember(dn); len(dn) > 0 && !a.dirs[dn]; dn = cleanMember(path.Dir(dn)) {
+		a.dirs[dn] = true
+	}
+}
+
+// done finishes the index once all the members are added
+func (a *archive) done() {
+	a.names = make([]string, 0, len(a.members))
+	for nm := range a.members {
+		a.names = append(a.names, nm)
+	}
+	sort.Strings(a.names)
+}
+
+func (a *archive) entry(m *member) *Entry {
+	return &Entry{
+		Name:  a.url(m.name),
+		Size:  m.size,
+		Mtime: m.mtime,
+		Link:  m.link,
+	}
+}
+
+func (a *archive) walk(dir string, fp func(e *Entry) error) error {
+	// symlinks in the dirs above 'dir' are followed
+	if up, base := path.Split(dir); len(up) > 0 {
+		m, ud, err := a.resolve(up)
+		if err != nil {
+			return err
+		}
+		if m != nil {
+			return fmt.Errorf("%s: %w", a.url(dir), fs.ErrNotExist)
+		}
+		dir = path.Join(ud, base)
+	}
+
+	if m, ok := a.members[dir]; ok {
+		return fp(a.entry(m))
+	}
+	if !a.dirs[dir] {
+		return fmt.Errorf("%s: %w", a.url(dir), fs.ErrNotExist)
+	}
+
+	// the names below 'dir' are contiguous in the sorted list
+	pref := dir + "/"
+	if len(dir) == 0 {
+		pref = ""
+	}
+	i := sort.SearchStrings(a.names, pref)
+	for _, nm := range a.names[i:] {
+		if !strings.HasPrefix(nm, pref) {
+			break
+		}
+		if err := fp(a.entry(a.members[nm])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *archive) readDir(dir string) ([]*Entry, []string, error) {
+	if !a.dirs[dir] {
+		if _, ok := a.members[dir]; ok {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("%s: %w", a.url(dir), fs.ErrNotExist)
+	}
+
+	pref := dir + "/"
+	if len(dir) == 0 {
+		pref = ""
+	}
+
+	var files []*Entry
+	var dirs []string
+	for nm := range a.dirs {
+		if len(nm) > 0 && cleanMember(path.Dir(nm)) == dir {
+			dirs = append(dirs, a.url(nm))
+		}
+	}
+	i := sort.SearchStrings(a.names, pref)
+	for _, nm := range a.names[i:] {
+		if !strings.HasPrefix(nm, pref) {
+			break
+		}
+		if !strings.Contains(nm[len(pref):], "/") {
+			files = append(files, a.entry(a.members[nm]))
+		}
+	}
+	sort.Strings(dirs)
+	return files, dirs, nil
+}
+
+func (a *archive) stat(nm string) (*Entry, error) {
+	m, dir, err := a.resolve(nm)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return &Entry{Name: a.url(dir), Dir: true}, nil
+	}
+
+	e := a.entry(m)
+	e.Name = a.url(nm)
+	return e, nil
+}
+
+func (a *archive) openMember(nm string) (io.ReadCloser, error) {
+	m, _, err := a.resolve(nm)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, fmt.Errorf("%s: is a dir", a.url(nm))
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	if a.evicted && a.refs == 0 {
+		return nil, errEvicted
+	}
+
+	rd, err := a.open(m)
+	if err != nil {
+		return nil, err
+	}
+	a.refs++
+	return &memberReader{ReadCloser: rd, a: a}, nil
+}
+
+// errEvicted is returned when a member of an archive that's just been
+// closed is opened; the archive is indexed again.
+var errEvicted = errors.New("archive closed")
+
+// evict closes the archive once none of its members are being read
+func (a *archive) evict() {
+	a.Lock()
+	defer a.Unlock()
+	a.evicted = true
+	if a.refs == 0 && a.close != nil {
+		a.close()
+	}
+}
+
+// memberReader reads an archive member; closing the last one of an
+// evicted archive closes it.
+type memberReader struct {
+	io.ReadCloser
+	a    *archive
+	done bool
+}
+
+func (r *memberReader) Close() error {
+	if r.done {
+		return r.ReadCloser.Close()
+	}
+	r.done = true
+
+	err := r.ReadCloser.Close()
+
+	a := r.a
+	a.Lock()
+	defer a.Unlock()
+	if a.refs--; a.refs == 0 && a.evicted && a.close != nil {
+		a.close()
+	}
+	return err
+}
+
+// resolve follows the symlinks in 'nm' and returns the member it names;
+// or the dir if it's a dir.
+func (a *archive) resolve(nm string) (*member, string, error) {
+	comps := splitMember(nm)
+	var cur string
+	for hops := 0; len(comps) > 0; {
+		if comps[0] == ".." {
+			cur = cleanMember(path.Dir(cur))
+			comps = comps[1:]
+			continue
+		}
+
+		next := path.Join(cur, comps[0])
+		m, ok := a.members[next]
+		if ok && m.link != "" {
+			if hops++; hops > _MaxLinks {
+				return nil, "", fmt.Errorf("%s: too many levels of symlinks", a.url(nm))
+			}
+
+			// absolute targets are relative to the archive root
+			if strings.HasPrefix(m.link, "/") {
+				cur = ""
+			}
+			comps = append(splitMember(m.link), comps[1:]...)
+			continue
+		}
+
+		switch {
+		case ok && len(comps) == 1:
+			return m, "", nil
+		case a.dirs[next]:
+			cur = next
+			comps = comps[1:]
+		default:
+			return nil, "", fmt.Errorf("%s: %w", a.url(nm), fs.ErrNotExist)
+		}
+	}
+	return nil, cur, nil
+}
+
+func (a *archive) url(nm string) string {
+	if len(nm) == 0 {
+		return a.scheme + "://" + a.path
+	}
+	return a.scheme + "://" + a.path + _ArchiveSep + nm
+}
+
+// splitMember splits 'nm' into its components; "." and "" are dropped
+func splitMember(nm string) []string {
+	var v []string
+	for _, c := range strings.Split(nm, "/") {
+		if len(c) > 0 && c != "." {
+			v = append(v, c)
+		}
+	}
+	return v
+}
+
+// cleanMember returns 'nm' without the leading "/" or "./" and "."
+// for the root
+func cleanMember(nm string) string {
+	return path.Clean("/" + nm)[1:]
+}
+
+// arcfs is a backend for one archive format; each archive is indexed
+// when it's first used and kept until _MaxArchives newer ones are used.
+type arcfs struct {
+	sync.Mutex
+	scheme string
+	load   func(a *archive) error
+
+	arcs map[string]*archive
+	tick uint64
+}
+
+var _ DirFS = &arcfs{}
+
+func newArcFS(scheme string, load func(a *archive) error) *arcfs {
+	f := &arcfs{
+		scheme: scheme,
+		load:   load,
+		arcs:   make(map[string]*archive),
+	}
+	return f
+}
+
+// find returns the archive and the member or dir named by 'nm'
+func (f *arcfs) find(nm string) (*archive, string, error) {
+	s, ok := strings.CutPrefix(nm, f.scheme+"://")
+	if !ok {
+		return nil, "", fmt.Errorf("%s: not a %s URL", nm, f.scheme)
+	}
+
+	fn, dir, _ := strings.Cut(s, _ArchiveSep)
+	if len(fn) == 0 {
+		return nil, "", fmt.Errorf("%s: missing archive name", nm)
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	a, ok := f.arcs[fn]
+	if !ok {
+		a = newArchive(f.scheme, fn)
+		if err := f.load(a); err != nil {
+			return nil, "", err
+		}
+		a.done()
+		if len(f.arcs) >= _MaxArchives {
+			f.evictOldest()
+		}
+		f.arcs[fn] = a
+	}
+	f.tick++
+	a.used = f.tick
+	return a, cleanMember(dir), nil
+}
+
+// evictOldest drops the least recently used archive; f must be locked
+func (f *arcfs) evictOldest() {
+	var old *archive
+	for _, a := range f.arcs {
+		if old == nil || a.used < old.used {
+			old = a
+		}
+	}
+	delete(f.arcs, old.path)
+	old.evict()
+}
+
+func (f *arcfs) Walk(nm string, fp func(e *Entry) error) error {
+	a, dir, err := f.find(nm)
+	if err != nil {
+		return err
+	}
+	return a.walk(dir, fp)
+}
+
+func (f *arcfs) ReadDir(nm string) ([]*Entry, []string, error) {
+	a, dir, err := f.find(nm)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a.readDir(dir)
+}
+
+func (f *arcfs) Stat(nm string) (*Entry, error) {
+	a, dir, err := f.find(nm)
+	if err != nil {
+		return nil, err
+	}
+	return a.stat(dir)
+}
+
+func (f *arcfs) Open(nm string) (io.ReadCloser, error) {
+	for {
+		a, dir, err := f.find(nm)
+		if err != nil {
+			return nil, err
+		}
+
+		rd, err := a.openMember(dir)
+		if err != errEvicted {
+			return rd, err
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// local.go - local files as a VFS backend
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package vfs
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The file backend names local files as file://PATH (file:///etc is
+// /etc); so a local tree can be given wherever a URL is expected.
+func init() {
+	Register("file", func() (FS, error) {
+		return &localfs{}, nil
+	})
+}
+
+type localfs struct{}
+
+var _ DirFS = &localfs{}
+
+func (l *localfs) Walk(nm string, fp func(e *Entry) error) error {
+	p, err := localPath(nm)
+	if err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(p, func(fn string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		m := de.Type()
+		if !m.IsRegular() && m&fs.ModeSymlink == 0 {
+			return nil
+		}
+
+		fi, err := de.Info()
+		if err != nil {
+			return err
+		}
+		e, err := localEntry(fn, fi)
+		if err != nil {
+			return err
+		}
+		return fp(e)
+	})
+}
+
+func (l *localfs) Stat(nm string) (*Entry, error) {
+	p, err := localPath(nm)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	return localEntry(p, fi)
+}
+
+func (l *localfs) Open(nm string) (io.ReadCloser, error) {
+	p, err := localPath(nm)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (l *localfs) ReadDir(nm string) ([]*Entry, []string, error) {
+	p, err := localPath(nm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// a file has nothing to list
+	if fi, err := os.Lstat(p); err != nil || !fi.IsDir() {
+		return nil, nil, err
+	}
+
+	des, err := os.ReadDir(p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var files []*Entry
+	var dirs []string
+	for _, de := range des {
+		fn := filepath.Join(p, de.Name())
+		m := de.Type()
+		switch {
+		case m.IsDir():
+			dirs = append(dirs, "file://"+filepath.ToSlash(fn))
+
+		case m.IsRegular(), m&fs.ModeSymlink != 0:
+			fi, err := de.Info()
+			if err != nil {
+				return nil, nil, err
+			}
+			e, err := localEntry(fn, fi)
+			if err != nil {
+				return nil, nil, err
+			}
+			files = append(files, e)
+		}
+	}
+	return files, dirs, nil
+}
+
+// localEntry returns the entry of the file 'fn' whose info is 'fi'
+func localEntry(fn string, fi fs.FileInfo) (*Entry, error) {
+	e := &Entry{
+		Name:  "file://" + filepath.ToSlash(fn),
+		Size:  fi.Size(),
+		Mtime: fi.ModTime(),
+		Dir:   fi.IsDir(),
+	}
+
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		targ, err := os.Readlink(fn)
+		if err != nil {
+			return nil, err
+		}
+		e.Link = targ
+	}
+	return e, nil
+}
+
+// localPath returns the path in the URL file://PATH
+func localPath(nm string) (string, error) {
+	p, ok := strings.CutPrefix(nm, "file://")
+	if !ok || len(p) == 0 {
+		return "", fmt.Errorf("%s: not a file URL", nm)
+	}
+	return filepath.FromSlash(p), nil
+}
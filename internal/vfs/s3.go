@@ -0,0 +1,382 @@
+// s3.go - S3 (and compatible) object stores as a VFS backend
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package vfs
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The s3 backend names objects as s3://BUCKET/KEY; a URL names the
+// object KEY and every object under the "dir" KEY/. It's configured by
+// the usual AWS environment variables:
+//
+//	AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY  credentials; anonymous
+//	                                          requests if unset
+//	AWS_SESSION_TOKEN                         temporary credentials
+//	AWS_REGION, AWS_DEFAULT_REGION            region [us-east-1]
+//	AWS_ENDPOINT_URL_S3, AWS_ENDPOINT_URL     a compatible store (e.g.
+//	                                          http://localhost:9000)
+//
+// The ETag of an object is its S3 ETag: the MD5 of objects that
+// weren't uploaded in parts or encrypted with KMS.
+func init() {
+	Register("s3", newS3)
+}
+
+type s3fs struct {
+	creds
+
+	// endpoint; path style requests if 'pathStyle' is true, else the
+	// bucket is part of the host name.
+	endpoint  *url.URL
+	pathStyle bool
+
+	hc *http.Client
+}
+
+var _ DirFS = &s3fs{}
+
+// time allowed for a server to start answering a request; the body of a
+// large object can take longer.
+const _S3ResponseTimeout = 60 * time.Second
+
+// newS3Client returns a client that doesn't wait forever on a server
+// that's gone quiet; the default transport has the connect and TLS
+// timeouts.
+func newS3Client() *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.ResponseHeaderTimeout = _S3ResponseTimeout
+	return &http.Client{Transport: tr}
+}
+
+func newS3() (FS, error) {
+	s := &s3fs{
+		creds: creds{
+			key:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			secret: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			token:  os.Getenv("AWS_SESSION_TOKEN"),
+			region: firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		},
+		hc: newS3Client(),
+	}
+
+	if len(s.region) == 0 {
+		s.region = "us-east-1"
+	}
+	if len(s.key) > 0 && len(s.secret) == 0 {
+		return nil, errors.New("s3: AWS_SECRET_ACCESS_KEY isn't set")
+	}
+
+	ep := firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL")
+	if len(ep) == 0 {
+		ep = fmt.Sprintf("https://s3.%s.amazonaws.com", s.region)
+	} else {
+		s.pathStyle = true
+	}
+
+	u, err := url.Parse(ep)
+	if err != nil || len(u.Host) == 0 {
+		return nil, fmt.Errorf("s3: invalid endpoint '%s'", ep)
+	}
+	s.endpoint = u
+	return s, nil
+}
+
+func (s *s3fs) Walk(nm string, fp func(e *Entry) error) error {
+	bucket, key, err := s3Path(nm)
+	if err != nil {
+		return err
+	}
+
+	// KEY is an object or a dir; so list KEY and pick KEY and KEY/...
+	var found bool
+	err = s.list(nm, bucket, key, "", func(r *listResult) error {
+		for _, o := range r.Contents {
+			if !under(o.Key, key) || strings.HasSuffix(o.Key, "/") {
+				continue
+			}
+
+			found = true
+			if err := fp(o.entry(bucket)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !found && len(key) > 0 {
+		return fmt.Errorf("%s: %w", nm, fs.ErrNotExist)
+	}
+	return nil
+}
+
+func (s *s3fs) ReadDir(nm string) ([]*Entry, []string, error) {
+	bucket, key, err := s3Path(nm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pref := key
+	if len(pref) > 0 {
+		pref += "/"
+	}
+
+	var files []*Entry
+	var dirs []string
+	err = s.list(nm, bucket, pref, "/", func(r *listResult) error {
+		for _, o := range r.Contents {
+			if o.Key != pref {
+				files = append(files, o.entry(bucket))
+			}
+		}
+		for _, p := range r.CommonPrefixes {
+			dirs = append(dirs, "s3://"+bucket+"/"+strings.TrimSuffix(p.Prefix, "/"))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return files, dirs, nil
+}
+
+// return true if there are objects under the "dir" 'key'
+func (s *s3fs) isPrefix(bucket, key string) bool {
+	q := url.Values{}
+	q.Set("list-type", "2")
+	q.Set("prefix", key+"/")
+	q.Set("max-keys", "1")
+
+	resp, err := s.do("GET", bucket, "", q)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	var r listResult
+	return xml.NewDecoder(resp.Body).Decode(&r) == nil && len(r.Contents) > 0
+}
+
+// list calls 'fp' for each page of the listing of the keys that start
+// with 'pref' in 'bucket'; keys with 'delim' after 'pref' are rolled up
+// into common prefixes.
+func (s *s3fs) list(nm, bucket, pref, delim string, fp func(r *listResult) error) error {
+	var token string
+	for {
+		q := url.Values{}
+		q.Set("list-type", "2")
+		if len(pref) > 0 {
+			q.Set("prefix", pref)
+		}
+		if len(delim) > 0 {
+			q.Set("delimiter", delim)
+		}
+		if len(token) > 0 {
+			q.Set("continuation-token", token)
+		}
+
+		resp, err := s.do("GET", bucket, "", q)
+		if err != nil {
+			return fmt.Errorf("%s: %w", nm, err)
+		}
+
+		var r listResult
+		err = xml.NewDecoder(resp.Body).Decode(&r)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("%s: malformed listing: %w", nm, err)
+		}
+
+		if err := fp(&r); err != nil {
+			return err
+		}
+
+		if !r.IsTruncated || len(r.NextContinuationToken) == 0 {
+			return nil
+		}
+		token = r.NextContinuationToken
+	}
+}
+
+func (s *s3fs) Stat(nm string) (*Entry, error) {
+	bucket, key, err := s3Path(nm)
+	if err != nil {
+		return nil, err
+	}
+
+	// the bucket or a prefix are dirs
+	if len(key) == 0 {
+		return &Entry{Name: nm, Dir: true}, nil
+	}
+
+	resp, err := s.do("HEAD", bucket, key, nil)
+	if errors.Is(err, fs.ErrNotExist) && s.isPrefix(bucket, key) {
+		return &Entry{Name: nm, Dir: true}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", nm, err)
+	}
+	resp.Body.Close()
+
+	e := &Entry{
+		Name: nm,
+		ETag: strings.Trim(resp.Header.Get("ETag"), `"`),
+	}
+	if e.Size, err = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); err != nil {
+		return nil, fmt.Errorf("%s: malformed size: %w", nm, err)
+	}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		e.Mtime = t
+	}
+	return e, nil
+}
+
+func (s *s3fs) Open(nm string) (io.ReadCloser, error) {
+	bucket, key, err := s3Path(nm)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("%s: not an object", nm)
+	}
+
+	resp, err := s.do("GET", bucket, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", nm, err)
+	}
+	return resp.Body, nil
+}
+
+// do makes a signed request for 'key' in 'bucket'; non 2xx answers are
+// errors.
+func (s *s3fs) do(method, bucket, key string, q url.Values) (*http.Response, error) {
+	u := *s.endpoint
+
+	// dotted bucket names don't match the wildcard certificate
+	path := "/" + key
+	if s.pathStyle || strings.Contains(bucket, ".") {
+		path = "/" + bucket + path
+	} else {
+		u.Host = bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawPath = uriEncode(u.Path, false)
+
+	r, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	// sent as it's signed
+	r.URL.RawQuery = encodeQuery(q)
+
+	if len(s.key) > 0 {
+		s.sign(r, time.Now())
+	}
+
+	resp, err := s.hc.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, s3Error(resp)
+	}
+	return resp, nil
+}
+
+// s3Error returns the error in a failed answer
+func s3Error(resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return fs.ErrNotExist
+	}
+
+	var e struct {
+		Code    string
+		Message string
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if xml.Unmarshal(b, &e) != nil || len(e.Code) == 0 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+
+	err := fmt.Errorf("%s: %s", e.Code, e.Message)
+	switch resp.StatusCode {
+	case http.StatusForbidden:
+		err = fmt.Errorf("%w (%s)", fs.ErrPermission, err)
+	case http.StatusMovedPermanently:
+		if r := resp.Header.Get("x-amz-bucket-region"); len(r) > 0 {
+			err = fmt.Errorf("%w; set AWS_REGION=%s", err, r)
+		}
+	}
+	return err
+}
+
+// the answer of ListObjectsV2
+type listResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []object
+	CommonPrefixes        []struct {
+		Prefix string
+	}
+}
+
+type object struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+func (o *object) entry(bucket string) *Entry {
+	return &Entry{
+		Name:  "s3://" + bucket + "/" + o.Key,
+		Size:  o.Size,
+		Mtime: o.LastModified,
+		ETag:  strings.Trim(o.ETag, `"`),
+	}
+}
+
+// s3Path splits s3://BUCKET/KEY
+func s3Path(nm string) (string, string, error) {
+	s, ok := strings.CutPrefix(nm, "s3://")
+	if !ok {
+		return "", "", fmt.Errorf("%s: not an s3 URL", nm)
+	}
+
+	bucket, key, _ := strings.Cut(s, "/")
+	if len(bucket) == 0 {
+		return "", "", fmt.Errorf("%s: missing bucket", nm)
+	}
+	return bucket, strings.TrimSuffix(key, "/"), nil
+}
+
+// return true if 'key' is 'dir' or is below it
+func under(key, dir string) bool {
+	return len(dir) == 0 || key == dir || strings.HasPrefix(key, dir+"/")
+}
+
+func firstEnv(names ...string) string {
+	for _, nm := range names {
+		if s := os.Getenv(nm); len(s) > 0 {
+			return s
+		}
+	}
+	return ""
+}
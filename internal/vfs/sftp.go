@@ -0,0 +1,831 @@
+// sftp.go - files on SFTP servers as a VFS backend
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package vfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// The sftp backend names files as sftp://[USER@]HOST[:PORT]/PATH; PATH
+// is absolute and /~/PATH is relative to the login dir. It logs in with
+// the keys in ssh-agent(1) and the unencrypted ~/.ssh/id_* keys; the
+// host key must be in ~/.ssh/known_hosts. USER is $USER by default.
+//
+// It's a minimal client of version 3 of the SFTP protocol
+// (draft-ietf-secsh-filexfer-02) that can just read.
+func init() {
+	Register("sftp", newSftp)
+}
+
+type sftpfs struct {
+	sync.Mutex
+	auth  []ssh.AuthMethod
+	hosts ssh.HostKeyCallback
+
+	// a connection per USER@HOST:PORT
+	conns map[string]*sftpDial
+}
+
+// a connection is dialed once; the others that need it wait for it
+type sftpDial struct {
+	once sync.Once
+	c    *sftpConn
+	err  error
+}
+
+var _ DirFS = &sftpfs{}
+
+func newSftp() (FS, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("sftp: %w", err)
+	}
+
+	kh := filepath.Join(home, ".ssh", "known_hosts")
+	hosts, err := knownhosts.New(kh)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: %w; connect once with ssh(1) to add the host keys", err)
+	}
+
+	s := &sftpfs{
+		hosts: hosts,
+		conns: make(map[string]*sftpDial),
+	}
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); len(sock) > 0 {
+		if c, err := net.Dial("unix", sock); err == nil {
+			s.auth = append(s.auth, ssh.PublicKeysCallback(agent.NewClient(c).Signers))
+		}
+	}
+
+	var keys []ssh.Signer
+	for _, nm := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		b, err := os.ReadFile(filepath.Join(home, ".ssh", nm))
+		if err != nil {
+			continue
+		}
+
+		// encrypted keys must be in the agent
+		if k, err := ssh.ParsePrivateKey(b); err == nil {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) > 0 {
+		s.auth = append(s.auth, ssh.PublicKeys(keys...))
+	}
+
+	if len(s.auth) == 0 {
+		return nil, errors.New("sftp: no ssh-agent and no unencrypted keys in ~/.ssh")
+	}
+	return s, nil
+}
+
+// conn returns the connection for URL 'nm' and the path in it
+func (s *sftpfs) conn(nm string) (*sftpConn, string, error) {
+	rest, ok := strings.CutPrefix(nm, "sftp://")
+	if !ok {
+		return nil, "", fmt.Errorf("%s: not an sftp URL", nm)
+	}
+
+	auth, p, _ := strings.Cut(rest, "/")
+	p = "/" + p
+	if q, ok := strings.CutPrefix(p, "/~"); ok {
+		p = strings.TrimPrefix(q, "/")
+		if len(p) == 0 {
+			p = "."
+		}
+	}
+
+	// the lock isn't held while dialing; so a slow host doesn't hold
+	// up the others.
+	s.Lock()
+	d, ok := s.conns[auth]
+	if !ok {
+		d = &sftpDial{}
+		s.conns[auth] = d
+	}
+	s.Unlock()
+
+	d.once.Do(func() {
+		d.c, d.err = s.dial(nm, auth)
+	})
+	if d.err != nil {
+		return nil, "", d.err
+	}
+	return d.c, p, nil
+}
+
+// dial logs in to USER@HOST:PORT in 'auth' and starts the sftp subsystem
+func (s *sftpfs) dial(nm, auth string) (*sftpConn, error) {
+	who, host, ok := strings.Cut(auth, "@")
+	if !ok {
+		host = who
+		who = os.Getenv("USER")
+		if u, err := user.Current(); err == nil && len(who) == 0 {
+			who = u.Username
+		}
+	}
+	if len(host) == 0 {
+		return nil, fmt.Errorf("%s: missing host", nm)
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "22")
+	}
+
+	cfg := &ssh.ClientConfig{
+		User:              who,
+		Auth:              s.auth,
+		HostKeyCallback:   s.hosts,
+		HostKeyAlgorithms: s.hostKeyAlgos(host),
+		Timeout:           30 * time.Second,
+	}
+
+	cl, err := ssh.Dial("tcp", host, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: %s: %w", host, err)
+	}
+
+	ses, err := cl.NewSession()
+	if err != nil {
+		cl.Close()
+		return nil, fmt.Errorf("sftp: %s: %w", host, err)
+	}
+
+	w, err := ses.StdinPipe()
+	if err != nil {
+		cl.Close()
+		return nil, err
+	}
+	r, err := ses.StdoutPipe()
+	if err != nil {
+		cl.Close()
+		return nil, err
+	}
+	if err := ses.RequestSubsystem("sftp"); err != nil {
+		cl.Close()
+		return nil, fmt.Errorf("sftp: %s: %w", host, err)
+	}
+
+	c := &sftpConn{
+		w:    w,
+		r:    r,
+		wait: make(map[uint32]chan sftpReply),
+	}
+	if err := c.init(); err != nil {
+		cl.Close()
+		return nil, fmt.Errorf("sftp: %s: %w", host, err)
+	}
+	return c, nil
+}
+
+// hostKeyAlgos returns the algorithms of the keys in known_hosts for
+// 'host'; so the server offers a key we can check rather than its
+// preferred one. It's nil (the defaults) for an unknown host.
+func (s *sftpfs) hostKeyAlgos(host string) []string {
+	// the known keys of a host are in the error of any other key
+	var ke *knownhosts.KeyError
+	err := s.hosts(host, &net.TCPAddr{}, noKey{})
+	if !errors.As(err, &ke) {
+		return nil
+	}
+
+	var v []string
+	seen := make(map[string]bool)
+	for _, k := range ke.Want {
+		algos := []string{k.Key.Type()}
+		if k.Key.Type() == ssh.KeyAlgoRSA {
+			algos = []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+		}
+		for _, a := range algos {
+			if !seen[a] {
+				seen[a] = true
+				v = append(v, a)
+			}
+		}
+	}
+	return v
+}
+
+// noKey is a host key that matches none in known_hosts
+type noKey struct{}
+
+func (noKey) Type() string {
+	return "none"
+}
+
+func (noKey) Marshal() []byte {
+	return []byte("none")
+}
+
+func (noKey) Verify([]byte, *ssh.Signature) error {
+	return errors.New("no key")
+}
+
+func (s *sftpfs) Walk(nm string, fp func(e *Entry) error) error {
+	c, p, err := s.conn(nm)
+	if err != nil {
+		return err
+	}
+
+	a, err := c.stat(_FxpLstat, p)
+	if err != nil {
+		return fmt.Errorf("%s: %w", nm, err)
+	}
+	return s.walk(c, nm, p, a, fp)
+}
+
+// walk calls 'fp' for 'p' (named 'nm') with attributes 'a' or the files
+// under it if it's a dir.
+func (s *sftpfs) walk(c *sftpConn, nm, p string, a *sftpAttr, fp func(e *Entry) error) error {
+	if !a.isDir() {
+		e, err := c.entry(nm, p, a)
+		if err != nil || e == nil {
+			return err
+		}
+		return fp(e)
+	}
+
+	ents, err := c.readDir(p)
+	if err != nil {
+		return fmt.Errorf("%s: %w", nm, err)
+	}
+	for _, d := range ents {
+		if err := s.walk(c, strings.TrimSuffix(nm, "/")+"/"+d.name, path.Join(p, d.name), d.attr, fp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sftpfs) ReadDir(nm string) ([]*Entry, []string, error) {
+	c, p, err := s.conn(nm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// a file has nothing to list
+	a, err := c.stat(_FxpLstat, p)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", nm, err)
+	}
+	if !a.isDir() {
+		return nil, nil, nil
+	}
+
+	ents, err := c.readDir(p)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", nm, err)
+	}
+
+	var files []*Entry
+	var dirs []string
+	for _, d := range ents {
+		cn := strings.TrimSuffix(nm, "/") + "/" + d.name
+		if d.attr.isDir() {
+			dirs = append(dirs, cn)
+			continue
+		}
+
+		e, err := c.entry(cn, path.Join(p, d.name), d.attr)
+		if err != nil {
+			return nil, nil, err
+		}
+		if e != nil {
+			files = append(files, e)
+		}
+	}
+	return files, dirs, nil
+}
+
+func (s *sftpfs) Stat(nm string) (*Entry, error) {
+	c, p, err := s.conn(nm)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := c.stat(_FxpStat, p)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", nm, err)
+	}
+
+	e := &Entry{
+		Name:  nm,
+		Size:  int64(a.size),
+		Mtime: time.Unix(int64(a.mtime), 0),
+		Dir:   a.isDir(),
+	}
+	return e, nil
+}
+
+func (s *sftpfs) Open(nm string) (io.ReadCloser, error) {
+	c, p, err := s.conn(nm)
+	if err != nil {
+		return nil, err
+	}
+
+	b := putString(nil, p)
+	b = binary.BigEndian.AppendUint32(b, _FxfRead)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	h, err := c.handle(_FxpOpen, b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", nm, err)
+	}
+	return &sftpFile{c: c, h: h}, nil
+}
+
+// SFTP packet types, flags and status codes
+const (
+	_FxpInit     = 1
+	_FxpVersion  = 2
+	_FxpOpen     = 3
+	_FxpClose    = 4
+	_FxpRead     = 5
+	_FxpLstat    = 7
+	_FxpOpendir  = 11
+	_FxpReaddir  = 12
+	_FxpStat     = 17
+	_FxpReadlink = 19
+	_FxpStatus   = 101
+	_FxpHandle   = 102
+	_FxpData     = 103
+	_FxpName     = 104
+	_FxpAttrs    = 105
+
+	_FxfRead = 1
+
+	_FxOK           = 0
+	_FxEOF          = 1
+	_FxNoSuchFile   = 2
+	_FxPermDenied   = 3
+	_FxAttrSize     = 0x1
+	_FxAttrUidGid   = 0x2
+	_FxAttrPerms    = 0x4
+	_FxAttrTimes    = 0x8
+	_FxAttrExtended = 0x80000000
+
+	// bytes read per request
+	_SftpChunk = 32 * 1024
+)
+
+// a connection to a server; requests can be made concurrently
+type sftpConn struct {
+	wmu sync.Mutex
+	w   io.WriteCloser
+	r   io.Reader
+
+	sync.Mutex
+	next uint32
+	wait map[uint32]chan sftpReply
+	err  error
+}
+
+type sftpReply struct {
+	typ byte
+	b   sbuf
+	err error
+}
+
+// init negotiates the protocol version and starts the reader
+func (c *sftpConn) init() error {
+	if err := c.send(_FxpInit, binary.BigEndian.AppendUint32(nil, 3)); err != nil {
+		return err
+	}
+
+	typ, _, err := c.recv()
+	if err != nil {
+		return err
+	}
+	if typ != _FxpVersion {
+		return fmt.Errorf("unexpected packet %d", typ)
+	}
+
+	go c.reader()
+	return nil
+}
+
+// reader hands each reply to the request waiting for it
+func (c *sftpConn) reader() {
+	for {
+		typ, b, err := c.recv()
+		if err == nil && len(b) < 4 {
+			err = errors.New("short packet")
+		}
+		if err != nil {
+			c.Lock()
+			c.err = err
+			for id, ch := range c.wait {
+				ch <- sftpReply{err: err}
+				delete(c.wait, id)
+			}
+			c.Unlock()
+			return
+		}
+
+		id := binary.BigEndian.Uint32(b)
+		c.Lock()
+		ch, ok := c.wait[id]
+		delete(c.wait, id)
+		c.Unlock()
+		if ok {
+			ch <- sftpReply{typ: typ, b: sbuf(b[4:])}
+		}
+	}
+}
+
+// call sends the request 'typ' with 'body' and waits for the reply
+func (c *sftpConn) call(typ byte, body []byte) (byte, sbuf, error) {
+	ch := make(chan sftpReply, 1)
+
+	c.Lock()
+	if c.err != nil {
+		c.Unlock()
+		return 0, nil, c.err
+	}
+	id := c.next
+	c.next++
+	c.wait[id] = ch
+	c.Unlock()
+
+	pkt := binary.BigEndian.AppendUint32(nil, id)
+	if err := c.send(typ, append(pkt, body...)); err != nil {
+		return 0, nil, err
+	}
+
+	r := <-ch
+	return r.typ, r.b, r.err
+}
+
+func (c *sftpConn) send(typ byte, b []byte) error {
+	pkt := binary.BigEndian.AppendUint32(nil, uint32(len(b)+1))
+	pkt = append(pkt, typ)
+	pkt = append(pkt, b...)
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.w.Write(pkt)
+	return err
+}
+
+func (c *sftpConn) recv() (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n < 1 || n > 1024*1024 {
+		return 0, nil, fmt.Errorf("bad packet length %d", n)
+	}
+	b := make([]byte, n-1)
+	if _, err := io.ReadFull(c.r, b); err != nil {
+		return 0, nil, err
+	}
+	return hdr[4], b, nil
+}
+
+// handle makes the request 'typ' that returns a handle
+func (c *sftpConn) handle(typ byte, body []byte) (string, error) {
+	rt, b, err := c.call(typ, body)
+	if err != nil {
+		return "", err
+	}
+	switch rt {
+	case _FxpHandle:
+		return b.str()
+	case _FxpStatus:
+		return "", statusError(b)
+	}
+	return "", fmt.Errorf("unexpected packet %d", rt)
+}
+
+func (c *sftpConn) close(h string) error {
+	rt, b, err := c.call(_FxpClose, putString(nil, h))
+	if err != nil {
+		return err
+	}
+	if rt != _FxpStatus {
+		return fmt.Errorf("unexpected packet %d", rt)
+	}
+	return statusError(b)
+}
+
+// stat makes the STAT or LSTAT request 'typ' for 'p'
+func (c *sftpConn) stat(typ byte, p string) (*sftpAttr, error) {
+	rt, b, err := c.call(typ, putString(nil, p))
+	if err != nil {
+		return nil, err
+	}
+	switch rt {
+	case _FxpAttrs:
+		return b.attr()
+	case _FxpStatus:
+		return nil, statusError(b)
+	}
+	return nil, fmt.Errorf("unexpected packet %d", rt)
+}
+
+// a dir entry
+type sftpDirent struct {
+	name string
+	attr *sftpAttr
+}
+
+func (c *sftpConn) readDir(p string) ([]sftpDirent, error) {
+	h, err := c.handle(_FxpOpendir, putString(nil, p))
+	if err != nil {
+		return nil, err
+	}
+	defer c.close(h)
+
+	var v []sftpDirent
+	for {
+		rt, b, err := c.call(_FxpReaddir, putString(nil, h))
+		if err != nil {
+			return nil, err
+		}
+		if rt == _FxpStatus {
+			if err := statusError(b); err != io.EOF {
+				return nil, err
+			}
+			return v, nil
+		}
+		if rt != _FxpName {
+			return nil, fmt.Errorf("unexpected packet %d", rt)
+		}
+
+		ents, err := b.names()
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range ents {
+			if d.name != "." && d.name != ".." {
+				v = append(v, d)
+			}
+		}
+	}
+}
+
+func (c *sftpConn) readLink(p string) (string, error) {
+	rt, b, err := c.call(_FxpReadlink, putString(nil, p))
+	if err != nil {
+		return "", err
+	}
+	switch rt {
+	case _FxpName:
+		v, err := b.names()
+		if err != nil {
+			return "", err
+		}
+		if len(v) != 1 {
+			return "", errors.New("malformed readlink reply")
+		}
+		return v[0].name, nil
+	case _FxpStatus:
+		return "", statusError(b)
+	}
+	return "", fmt.Errorf("unexpected packet %d", rt)
+}
+
+// entry returns the entry of the file or symlink 'p' named 'nm'; nil
+// for other kinds of files.
+func (c *sftpConn) entry(nm, p string, a *sftpAttr) (*Entry, error) {
+	e := &Entry{
+		Name:  nm,
+		Size:  int64(a.size),
+		Mtime: time.Unix(int64(a.mtime), 0),
+	}
+
+	switch a.perm & _S_IFMT {
+	case _S_IFREG:
+	case _S_IFLNK:
+		targ, err := c.readLink(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", nm, err)
+		}
+		e.Link = targ
+	default:
+		return nil, nil
+	}
+	return e, nil
+}
+
+// sftpFile reads an open file
+type sftpFile struct {
+	c   *sftpConn
+	h   string
+	off uint64
+	eof bool
+}
+
+func (f *sftpFile) Read(p []byte) (int, error) {
+	if f.eof {
+		return 0, io.EOF
+	}
+
+	b := putString(nil, f.h)
+	b = binary.BigEndian.AppendUint64(b, f.off)
+	b = binary.BigEndian.AppendUint32(b, uint32(min(len(p), _SftpChunk)))
+	rt, r, err := f.c.call(_FxpRead, b)
+	if err != nil {
+		return 0, err
+	}
+
+	switch rt {
+	case _FxpData:
+		d, err := r.str()
+		if err != nil {
+			return 0, err
+		}
+		n := copy(p, d)
+		f.off += uint64(n)
+		return n, nil
+	case _FxpStatus:
+		err := statusError(r)
+		if err == io.EOF {
+			f.eof = true
+		}
+		return 0, err
+	}
+	return 0, fmt.Errorf("unexpected packet %d", rt)
+}
+
+func (f *sftpFile) Close() error {
+	return f.c.close(f.h)
+}
+
+// file types in sftpAttr.perm
+const (
+	_S_IFMT  = 0170000
+	_S_IFDIR = 0040000
+	_S_IFLNK = 0120000
+	_S_IFREG = 0100000
+)
+
+type sftpAttr struct {
+	size  uint64
+	perm  uint32
+	mtime uint32
+}
+
+func (a *sftpAttr) isDir() bool {
+	return a.perm&_S_IFMT == _S_IFDIR
+}
+
+// statusError returns the error in a STATUS reply; io.EOF for EOF
+func statusError(b sbuf) error {
+	code, err := b.u32()
+	if err != nil {
+		return err
+	}
+	msg, _ := b.str()
+
+	switch code {
+	case _FxOK:
+		return nil
+	case _FxEOF:
+		return io.EOF
+	case _FxNoSuchFile:
+		return fs.ErrNotExist
+	case _FxPermDenied:
+		return fs.ErrPermission
+	}
+	return fmt.Errorf("sftp error %d: %s", code, msg)
+}
+
+func putString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// sbuf decodes the fields of a reply
+type sbuf []byte
+
+var errShort = errors.New("sftp: short reply")
+
+func (b *sbuf) u32() (uint32, error) {
+	if len(*b) < 4 {
+		return 0, errShort
+	}
+	v := binary.BigEndian.Uint32(*b)
+	*b = (*b)[4:]
+	return v, nil
+}
+
+func (b *sbuf) u64() (uint64, error) {
+	if len(*b) < 8 {
+		return 0, errShort
+	}
+	v := binary.BigEndian.Uint64(*b)
+	*b = (*b)[8:]
+	return v, nil
+}
+
+func (b *sbuf) str() (string, error) {
+	n, err := b.u32()
+	if err != nil {
+		return "", err
+	}
+	if uint32(len(*b)) < n {
+		return "", errShort
+	}
+	s := string((*b)[:n])
+	*b = (*b)[n:]
+	return s, nil
+}
+
+func (b *sbuf) attr() (*sftpAttr, error) {
+	var a sftpAttr
+	var u uint32
+	var err error
+
+	flags, err := b.u32()
+	if err != nil {
+		return nil, err
+	}
+	if flags&_FxAttrSize != 0 {
+		if a.size, err = b.u64(); err != nil {
+			return nil, err
+		}
+	}
+	if flags&_FxAttrUidGid != 0 {
+		if _, err = b.u64(); err != nil {
+			return nil, err
+		}
+	}
+	if flags&_FxAttrPerms != 0 {
+		if a.perm, err = b.u32(); err != nil {
+			return nil, err
+		}
+	}
+	if flags&_FxAttrTimes != 0 {
+		if _, err = b.u32(); err != nil {
+			return nil, err
+		}
+		if a.mtime, err = b.u32(); err != nil {
+			return nil, err
+		}
+	}
+	if flags&_FxAttrExtended != 0 {
+		if u, err = b.u32(); err != nil {
+			return nil, err
+		}
+		for ; u > 0; u-- {
+			if _, err = b.str(); err != nil {
+				return nil, err
+			}
+			if _, err = b.str(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &a, nil
+}
+
+// names decodes the entries of a NAME reply
+func (b *sbuf) names() ([]sftpDirent, error) {
+	n, err := b.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	// 'n' is the server's; each entry is at least a name, long name
+	// and attr flags - 12 bytes.
+	v := make([]sftpDirent, 0, min(n, uint32(len(*b)/12)))
+	for ; n > 0; n-- {
+		nm, err := b.str()
+		if err != nil {
+			return nil, err
+		}
+
+		// the ls -l style long name
+		if _, err := b.str(); err != nil {
+			return nil, err
+		}
+
+		a, err := b.attr()
+		if err != nil {
+			return nil, err
+		}
+		v = append(v, sftpDirent{nm, a})
+	}
+	return v, nil
+}
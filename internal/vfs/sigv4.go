@@ -0,0 +1,130 @@
+// sigv4.go - AWS signature version 4 for S3 requests
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package vfs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sha256 of an empty payload; our requests have no body
+const _EmptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// credentials of an S3 account; requests are anonymous if 'key' is
+// empty.
+type creds struct {
+	key, secret, token string
+	region             string
+}
+
+// sign adds the SigV4 authorization to 'r' made at time 't'
+func (c *creds) sign(r *http.Request, t time.Time) {
+	t = t.UTC()
+	date := t.Format("20060102")
+	stamp := t.Format("20060102T150405Z")
+
+	r.Header.Set("x-amz-date", stamp)
+	r.Header.Set("x-amz-content-sha256", _EmptySHA256)
+	if len(c.token) > 0 {
+		r.Header.Set("x-amz-security-token", c.token)
+	}
+
+	// the signed headers: host and the x-amz-* ones (and range)
+	hdrs := map[string]string{"host": r.URL.Host}
+	for k, v := range r.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") || k == "range" {
+			hdrs[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+
+	names := make([]string, 0, len(hdrs))
+	for k := range hdrs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canon strings.Builder
+	for _, k := range names {
+		fmt.Fprintf(&canon, "%s:%s\n", k, hdrs[k])
+	}
+	signed := strings.Join(names, ";")
+
+	req := strings.Join([]string{
+		r.Method,
+		uriEncode(r.URL.Path, false),
+		encodeQuery(r.URL.Query()),
+		canon.String(),
+		signed,
+		_EmptySHA256,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, c.region)
+	sts := strings.Join([]string{"AWS4-HMAC-SHA256", stamp, scope, sha256Hex(req)}, "\n")
+
+	k := hmacSHA256([]byte("AWS4"+c.secret), date)
+	k = hmacSHA256(k, c.region)
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(k, sts))
+
+	r.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.key, scope, signed, sig))
+}
+
+// encodeQuery returns the query 'q' sorted and encoded for signing
+func encodeQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var v []string
+	for _, k := range keys {
+		for _, s := range q[k] {
+			v = append(v, uriEncode(k, true)+"="+uriEncode(s, true))
+		}
+	}
+	return strings.Join(v, "&")
+}
+
+// uriEncode percent-encodes all but the unreserved chars of RFC 3986;
+// '/' too if 'slash' is true.
+func uriEncode(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !slash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+func sha256Hex(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
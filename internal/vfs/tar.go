@@ -0,0 +1,282 @@
+// tar.go - tar files as a VFS backend
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package vfs
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// The tar backend reads plain, gzip and bzip2 compressed tar files.
+// The members of a plain tar file are read in place; a compressed one
+// is read from the start - reading its members in archive order (as
+// Walk lists them) continues from the last member read.
+func init() {
+	Register("tar", func() (FS, error) {
+		return newArcFS("tar", loadTar), nil
+	})
+}
+
+// where a tar member's contents are
+type tarData struct {
+	// ordinal of its header in the archive
+	idx int
+
+	// offset of the contents in a plain tar file; -1 if they can't
+	// be read in place (compressed or sparse)
+	off int64
+}
+
+// a tar file being read
+type tarFile struct {
+	sync.Mutex
+	fn string
+
+	// set if the archive isn't compressed
+	fd *os.File
+
+	// a stream positioned after the last member read
+	parked *tarStream
+}
+
+// a sequential reader of a tar file
+type tarStream struct {
+	fd *os.File
+	tr *tar.Reader
+
+	// ordinal of the next header
+	next int
+}
+
+func loadTar(a *archive) error {
+	t := &tarFile{fn: a.path}
+	s, plain, err := t.stream()
+	if err != nil {
+		return err
+	}
+	defer s.fd.Close()
+
+	// the offset of each member's contents in a plain file
+	var cr *countReader
+	if plain {
+		cr = &countReader{r: s.fd}
+		s.tr = tar.NewReader(cr)
+	}
+
+	for {
+		hdr, err := s.tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", a.path, err)
+		}
+
+		d := &tarData{idx: s.next, off: -1}
+		s.next++
+		if cr != nil && !isSparse(hdr) {
+			d.off = cr.n
+		}
+
+		m := &member{
+			name:  hdr.Name,
+			size:  hdr.Size,
+			mtime: hdr.ModTime,
+			data:  d,
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeGNUSparse:
+		case tar.TypeSymlink:
+			m.size = int64(len(hdr.Linkname))
+			m.link = hdr.Linkname
+		case tar.TypeLink:
+			// a hard link shares the contents of an earlier member
+			lm, ok := a.members[cleanMember(hdr.Linkname)]
+			if !ok || lm.link != "" {
+				continue
+			}
+			m.size, m.data = lm.size, lm.data
+		case tar.TypeDir:
+			a.addDir(hdr.Name)
+			continue
+		default:
+			continue
+		}
+		a.add(m)
+	}
+
+	if plain {
+		fd, err := os.Open(a.path)
+		if err != nil {
+			return err
+		}
+		t.fd = fd
+	}
+	a.open = t.open
+	a.close = t.close
+	return nil
+}
+
+// close the archive and the parked stream
+func (t *tarFile) close() error {
+	t.Lock()
+	s := t.parked
+	t.parked = nil
+	t.Unlock()
+
+	if s != nil {
+		s.fd.Close()
+	}
+	if t.fd != nil {
+		return t.fd.Close()
+	}
+	return nil
+}
+
+func (t *tarFile) open(m *member) (io.ReadCloser, error) {
+	d := m.data.(*tarData)
+	if t.fd != nil && d.off >= 0 {
+		return io.NopCloser(io.NewSectionReader(t.fd, d.off, m.size)), nil
+	}
+
+	// continue from the parked stream if it's before 'm'
+	t.Lock()
+	s := t.parked
+	t.parked = nil
+	t.Unlock()
+
+	if s != nil && s.next > d.idx {
+		s.fd.Close()
+		s = nil
+	}
+	if s == nil {
+		var err error
+		if s, _, err = t.stream(); err != nil {
+			return nil, err
+		}
+	}
+
+	for s.next <= d.idx {
+		if _, err := s.tr.Next(); err != nil {
+			s.fd.Close()
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("%s: %w", t.fn, err)
+		}
+		s.next++
+	}
+
+	r := &tarMember{t: t, s: s}
+	r.Reader = io.LimitReader(s.tr, m.size)
+	return r, nil
+}
+
+// stream opens the archive for sequential reads; returns true if it's
+// not compressed.
+func (t *tarFile) stream() (*tarStream, bool, error) {
+	fd, err := os.Open(t.fn)
+	if err != nil {
+		return nil, false, err
+	}
+
+	br := bufio.NewReader(fd)
+	magic, _ := br.Peek(6)
+
+	var rd io.Reader = br
+	plain := false
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			fd.Close()
+			return nil, false, fmt.Errorf("%s: %w", t.fn, err)
+		}
+		rd = zr
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		rd = bzip2.NewReader(br)
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0}),
+		bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		fd.Close()
+		return nil, false, fmt.Errorf("%s: xz and zstd compressed tar files aren't supported", t.fn)
+	default:
+		// the offsets are counted from the start of the file
+		if _, err := fd.Seek(0, io.SeekStart); err != nil {
+			fd.Close()
+			return nil, false, err
+		}
+		rd = fd
+		plain = true
+	}
+
+	s := &tarStream{
+		fd: fd,
+		tr: tar.NewReader(rd),
+	}
+	return s, plain, nil
+}
+
+// tarMember reads a member from a stream; the stream is parked when
+// it's closed.
+type tarMember struct {
+	io.Reader
+	t *tarFile
+	s *tarStream
+}
+
+func (r *tarMember) Close() error {
+	if r.s == nil {
+		return errors.New("tar: member already closed")
+	}
+
+	t := r.t
+	t.Lock()
+	if t.parked == nil {
+		t.parked, r.s = r.s, nil
+	}
+	t.Unlock()
+
+	if r.s != nil {
+		r.s.fd.Close()
+		r.s = nil
+	}
+	return nil
+}
+
+// return true if the contents of 'h' aren't stored as is
+func isSparse(h *tar.Header) bool {
+	if h.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range h.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// countReader counts the bytes read from 'r'
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,171 @@
+// vfs.go - read-only file trees named by URLs
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+// Package vfs is a read-only view of file trees named by a URL -
+// SCHEME://... Each scheme is a backend that registers itself; the
+// tools walk, stat and read the entries of a URL without knowing where
+// they live:
+//
+//	file:///PATH               a local file or dir
+//	s3://BUCKET/PREFIX         objects in S3 or a compatible store
+//	sftp://[USER@]HOST[:PORT]/PATH
+//	                           a file or dir on an SFTP server
+//	tar://ARCHIVE[::DIR]       the members of a tar file (optionally
+//	                           compressed with gzip or bzip2)
+//	zip://ARCHIVE[::DIR]       the members of a zip file
+//
+// Names that don't start with a registered scheme are local paths and
+// are left to the tools.
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a file in a tree
+type Entry struct {
+	// URL of the entry
+	Name  string
+	Size  int64
+	Mtime time.Time
+
+	// checksum kept by the backend (e.g. an S3 ETag); empty if there's
+	// none. It's opaque and only comparable within a backend.
+	ETag string
+
+	// the target of a symlink as it's stored; empty for other files
+	Link string
+
+	// true for dirs; only Stat returns them
+	Dir bool
+}
+
+// IsLink returns true if 'e' is a symlink
+func (e *Entry) IsLink() bool {
+	return len(e.Link) > 0
+}
+
+// FS is a backend for one URL scheme
+type FS interface {
+	// Walk calls 'fp' for each file and symlink named by 'url' or
+	// under it; in no particular order. Symlinks aren't followed.
+	Walk(url string, fp func(e *Entry) error) error
+
+	// Stat returns the file or dir named by 'url'; symlinks are
+	// followed. Errors wrap fs.ErrNotExist if there's no such file.
+	Stat(url string) (*Entry, error)
+
+	// Open returns a reader for the contents of the file 'url';
+	// symlinks are followed.
+	Open(url string) (io.ReadCloser, error)
+}
+
+// DirFS is a backend that can list a dir without walking the tree
+// below it
+type DirFS interface {
+	FS
+
+	// ReadDir returns the files in the dir 'url' and the URLs of its
+	// sub dirs.
+	ReadDir(url string) ([]*Entry, []string, error)
+}
+
+type backend struct {
+	once sync.Once
+	mk   func() (FS, error)
+	fs   FS
+	err  error
+}
+
+var backends = make(map[string]*backend)
+
+// Register makes the backend made by 'mk' the handler of URLs that
+// start with 'scheme://'. The backend is made when it's first used.
+func Register(scheme string, mk func() (FS, error)) {
+	backends[scheme] = &backend{mk: mk}
+}
+
+// Schemes returns the registered schemes
+func Schemes() []string {
+	v := make([]string, 0, len(backends))
+	for s := range backends {
+		v = append(v, s)
+	}
+	sort.Strings(v)
+	return v
+}
+
+// IsURL returns true if 'nm' is handled by a backend
+func IsURL(nm string) bool {
+	_, ok := backends[scheme(nm)]
+	return ok
+}
+
+// Lookup returns the backend for the URL 'nm'
+func Lookup(nm string) (FS, error) {
+	b, ok := backends[scheme(nm)]
+	if !ok {
+		return nil, fmt.Errorf("%s: not a URL; try one of: %s", nm, strings.Join(Schemes(), ", "))
+	}
+
+	b.once.Do(func() {
+		b.fs, b.err = b.mk()
+	})
+	return b.fs, b.err
+}
+
+// Walk calls 'fp' for each file at or under the URL 'nm'
+func Walk(nm string, fp func(e *Entry) error) error {
+	fs, err := Lookup(nm)
+	if err != nil {
+		return err
+	}
+	return fs.Walk(nm, fp)
+}
+
+// Stat returns the file named by the URL 'nm'
+func Stat(nm string) (*Entry, error) {
+	fs, err := Lookup(nm)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(nm)
+}
+
+// Open returns a reader for the file named by the URL 'nm'
+func Open(nm string) (io.ReadCloser, error) {
+	fs, err := Lookup(nm)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Open(nm)
+}
+
+// ReadDir returns the files and sub dirs of the dir named by the URL
+// 'nm'; errors.ErrUnsupported if its backend can't list dirs.
+func ReadDir(nm string) ([]*Entry, []string, error) {
+	fs, err := Lookup(nm)
+	if err != nil {
+		return nil, nil, err
+	}
+	if d, ok := fs.(DirFS); ok {
+		return d.ReadDir(nm)
+	}
+	return nil, nil, fmt.Errorf("%s: can't list: %w", nm, errors.ErrUnsupported)
+}
+
+// return the scheme of 'nm' if it looks like a URL
+func scheme(nm string) string {
+	if i := strings.Index(nm, "://"); i > 0 {
+		return nm[:i]
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+// zip.go - zip files as a VFS backend
+//
+// Author: Sudhi Herle (sw@herle.net)
+// License: GPLv2
+
+package vfs
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// The zip backend reads the members of zip files in place; symlinks
+// stored by Info-ZIP (the target is the contents) are symlinks.
+func init() {
+	Register("zip", func() (FS, error) {
+		return newArcFS("zip", loadZip), nil
+	})
+}
+
+func loadZip(a *archive) error {
+	zr, err := zip.OpenReader(a.path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", a.path, err)
+	}
+
+	for _, f := range zr.File {
+		fi := f.FileInfo()
+		m := &member{
+			name:  f.Name,
+			size:  fi.Size(),
+			mtime: f.Modified,
+			data:  f,
+		}
+
+		switch mode := fi.Mode(); {
+		case mode.IsDir():
+			a.addDir(f.Name)
+			continue
+		case mode&fs.ModeSymlink != 0:
+			targ, err := readAll(f, 4096)
+			if err != nil {
+				zr.Close()
+				return fmt.Errorf("%s: %s: %w", a.path, f.Name, err)
+			}
+			m.link = string(targ)
+		case !mode.IsRegular():
+			continue
+		}
+		a.add(m)
+	}
+
+	// the reader stays open for the members
+	a.open = func(m *member) (io.ReadCloser, error) {
+		return m.data.(*zip.File).Open()
+	}
+	a.close = zr.Close
+	return nil
+}
+
+// readAll reads at most 'max' bytes of 'f'
+func readAll(f *zip.File, max int64) ([]byte, error) {
+	rd, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	return io.ReadAll(io.LimitReader(rd, max))
+}